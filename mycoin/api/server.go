@@ -1,13 +1,21 @@
 package api
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"mycoin/indexer"
 	"net/http"
+	"strconv"
 	"strings"
 )
 
+// 節點 / 錢包 RPC 的位址，main 會依 -network 改成對應網路的埠口
+var (
+	NodeRPCURL   = "http://localhost:8081/rpc"
+	WalletRPCURL = "http://localhost:8082/wallet"
+)
+
 // StartServer 啟動區塊瀏覽器的 API 伺服器
 func StartServer(port string) {
 	// 🌟 設立兩個不同的路由 (櫃檯)
@@ -153,13 +161,13 @@ func sendTransaction(w http.ResponseWriter, r *http.Request) {
 
 	fmt.Printf("📬 [API] 收到轉帳請求：發送 %v 元到 %s (手續費: %v)\n", txReq.Amount, txReq.To, txReq.Fee)
 
-	// 3. 🚀 呼叫底層的 Wallet RPC (:8082/wallet)
+	// 3. 🚀 呼叫底層的 Wallet RPC (WalletRPCURL)
 	// 完美對接你 server.go 裡面的 sendtoaddress 需要的三個參數！
 	// 🚀 修正點：使用 %.8f 確保小數點精確輸出，且不會變成 1.5e-05
 	rpcBody := fmt.Sprintf(`{"method": "sendtoaddress", "params": ["%s", %.8f, %.8f], "id": 1}`, txReq.To, txReq.Amount, txReq.Fee)
 
 	// 👇 這裡確定用 /wallet 沒錯！
-	resp, err := http.Post("http://localhost:8082/wallet", "application/json", strings.NewReader(rpcBody))
+	resp, err := http.Post(WalletRPCURL, "application/json", strings.NewReader(rpcBody))
 	if err != nil {
 		json.NewEncoder(w).Encode(map[string]interface{}{
 			"error": "無法連線到錢包 RPC (:8082 沒開或連線失敗)",
@@ -197,26 +205,33 @@ func getEstimateFee(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	// 🚀 去敲門問 Wallet RPC
-	rpcBody := `{"method": "estimatefee", "params": [], "id": 1}`
-	resp, err := http.Post("http://localhost:8082/wallet", "application/json", strings.NewReader(rpcBody))
+	// 🚀 去敲門問 Wallet RPC；?vsize=N 時連這筆交易該付的手續費一起算
+	params := []interface{}{}
+	if v := r.URL.Query().Get("vsize"); v != "" {
+		vsize, err := strconv.Atoi(v)
+		if err != nil || vsize <= 0 {
+			http.Error(w, "invalid vsize", http.StatusBadRequest)
+			return
+		}
+		params = append(params, vsize)
+	}
+	rpcBody, _ := json.Marshal(map[string]interface{}{"method": "estimatefee", "params": params, "id": 1})
+	resp, err := http.Post(WalletRPCURL, "application/json", bytes.NewReader(rpcBody))
 
 	if err != nil {
-		// 🚀 修正點：如果錢包沒開，預設回傳 0.01 (1 YiCent)
-		json.NewEncoder(w).Encode(map[string]interface{}{"fee": 0.01})
+		// 🚀 修正點：如果錢包沒開，回傳最低轉發費率 (1 YiCent / 1000 vB)
+		json.NewEncoder(w).Encode(map[string]interface{}{"fee_rate": 0.001})
 		return
 	}
 	defer resp.Body.Close()
 
 	var rpcResp struct {
-		Result float64 `json:"result"`
+		Result map[string]interface{} `json:"result"`
 	}
 	json.NewDecoder(resp.Body).Decode(&rpcResp)
 
-	// 把最精準的報價傳給 Vue
-	json.NewEncoder(w).Encode(map[string]interface{}{
-		"fee": rpcResp.Result,
-	})
+	// 把最精準的報價傳給 Vue (fee_rate：YiCent/vB；有給 vsize 時還有 fee：YIC)
+	json.NewEncoder(w).Encode(rpcResp.Result)
 }
 
 // ⏳ 負責向底層 Node RPC (8081) 獲取 Mempool 的函數
@@ -230,7 +245,7 @@ func getMempool(w http.ResponseWriter, r *http.Request) {
 
 	// 2. 🚀 代替 Vue 去敲底層 Node RPC (8081) 的門
 	rpcBody := `{"method": "getmempool", "params": [], "id": 1}`
-	resp, err := http.Post("http://localhost:8081/rpc", "application/json", strings.NewReader(rpcBody))
+	resp, err := http.Post(NodeRPCURL, "application/json", strings.NewReader(rpcBody))
 
 	if err != nil {
 		fmt.Println("⚠️ [API] 無法連線到 Node RPC (8081 沒開或連線失敗)")
@@ -274,7 +289,7 @@ func getTransactionDetails(w http.ResponseWriter, r *http.Request) {
 	// 請求呼叫我們剛剛寫好的 getwallettransaction (或者你命名為 gettransaction 的那個)
 	rpcBody := fmt.Sprintf(`{"method": "getwallettransaction", "params": ["%s"], "id": 1}`, txID)
 
-	resp, err := http.Post("http://localhost:8082/wallet", "application/json", strings.NewReader(rpcBody))
+	resp, err := http.Post(WalletRPCURL, "application/json", strings.NewReader(rpcBody))
 	if err != nil {
 		fmt.Println("❌ [API] 無法連線到 Wallet RPC (8082):", err)
 		http.Error(w, `{"error": "無法連線到錢包伺服器"}`, http.StatusInternalServerError)
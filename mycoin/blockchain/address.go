@@ -1,25 +1,44 @@
 package blockchain
 
 import (
+	"bytes"
 	"crypto/sha256"
+	"fmt"
 
 	"golang.org/x/crypto/ripemd160"
 
 	"github.com/btcsuite/btcutil/base58"
 )
 
-const mainnetPrefix = byte(0x00) // Bitcoin mainnet
-
+// PubKeyToAddress 目前網路 (ActiveNetParams) 的 P2PKH 地址
 func PubKeyToAddress(pubKey []byte) string {
-	sha := sha256.Sum256(pubKey)
+	return ActiveNetParams.PubKeyToAddress(pubKey)
+}
+
+// PubKeyToAddress 指定網路的 P2PKH 地址
+func (p *Params) PubKeyToAddress(pubKey []byte) string {
+	return encodeAddress(p.PubKeyHashAddrID, Hash160(pubKey))
+}
+
+// ScriptAddress 用腳本的 Hash160 當作索引地址，讓錢包 / 瀏覽器可以查詢託管資金
+// 非 P2PKH 鎖定腳本 (多簽、Hash 鎖、時間鎖) 用 ScriptHashAddrID 前綴
+func ScriptAddress(script []byte) string {
+	return encodeAddress(ActiveNetParams.ScriptHashAddrID, Hash160(script))
+}
+
+// Hash160 = RIPEMD160(SHA256(data))
+func Hash160(data []byte) []byte {
+	sha := sha256.Sum256(data)
 
 	rip := ripemd160.New()
 	_, _ = rip.Write(sha[:]) // ✔ 避免忽略错误
-	pubHash := rip.Sum(nil)
+	return rip.Sum(nil)
+}
 
+func encodeAddress(prefix byte, hash []byte) string {
 	payload := make([]byte, 0, 1+20+4) // ✔ 预先分配容量
-	payload = append(payload, mainnetPrefix)
-	payload = append(payload, pubHash...)
+	payload = append(payload, prefix)
+	payload = append(payload, hash...)
 
 	chk := sha256.Sum256(payload)
 	chk2 := sha256.Sum256(chk[:])
@@ -28,3 +47,21 @@ func PubKeyToAddress(pubKey []byte) string {
 
 	return base58.Encode(payload)
 }
+
+// AddressToPubKeyHash 解出 P2PKH 地址裡的公鑰 Hash (會檢查前綴與校驗碼)
+func AddressToPubKeyHash(addr string) ([]byte, error) {
+	raw := base58.Decode(addr)
+	if len(raw) != 1+20+4 {
+		return nil, fmt.Errorf("invalid address length: %q", addr)
+	}
+	if raw[0] != ActiveNetParams.PubKeyHashAddrID {
+		return nil, fmt.Errorf("not a pubkey-hash address: %q", addr)
+	}
+
+	chk := sha256.Sum256(raw[:21])
+	chk2 := sha256.Sum256(chk[:])
+	if !bytes.Equal(chk2[:4], raw[21:]) {
+		return nil, fmt.Errorf("bad address checksum: %q", addr)
+	}
+	return raw[1:21], nil
+}
@@ -5,7 +5,7 @@ import (
 	"crypto/sha256"
 	"encoding/binary"
 	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
 	"math"
 	"math/big"
@@ -13,8 +13,6 @@ import (
 	"time"
 )
 
-const DifficultyInterval = 10
-
 // --------------------
 // Block Header
 // --------------------
@@ -37,6 +35,8 @@ type Block struct {
 	HashHex string `json:"hash"`
 
 	Bits uint32
+
+	size int // 序列化後的大小，Size 第一次算完 (或從 bytes 解出來時) 記下來
 }
 
 // --------------------
@@ -70,6 +70,9 @@ func NewBlock(
 	// 這一步確保 Target 被正確壓縮存入 Bits
 	b.Bits = utils.BigToCompact(target)
 
+	// 🛡️ 以 Bits 還原的 Target 為準挖礦，避免壓縮誤差讓別人驗不過
+	b.Target = utils.CompactToBig(b.Bits)
+
 	// 計算 Hash (現在會包含 Bits)
 	b.Hash = b.CalcHash()
 
@@ -126,7 +129,34 @@ func (b *Block) Mine(abort func() bool) bool {
 // --------------------
 // PoW 验证
 // --------------------
+// MaxBlockSize 共識上限：區塊序列化後 (區塊頭 + 所有交易) 最多幾個 bytes
+const MaxBlockSize = 1000000
+
+// ErrBlockTooLarge 區塊超過 MaxBlockSize
+var ErrBlockTooLarge = errors.New("block exceeds maximum size")
+
+// Size 區塊序列化後的大小 (bytes)
+// 從 bytes 解出來的區塊直接用收到的長度；自己組的區塊第一次呼叫時序列化一次並記住，
+// 所以只能在區塊內容定案 (挖完) 之後呼叫
+func (b *Block) Size() int {
+	if b.size == 0 {
+		b.size = len(b.Serialize())
+	}
+	return b.size
+}
+
+// CheckSize 區塊大小不能超過 MaxBlockSize
+func (b *Block) CheckSize() error {
+	if size := b.Size(); size > MaxBlockSize {
+		return fmt.Errorf("%w: %d > %d bytes", ErrBlockTooLarge, size, MaxBlockSize)
+	}
+	return nil
+}
+
 func (b *Block) Verify(prev *Block) error {
+	if err := b.CheckSize(); err != nil {
+		return err
+	}
 	if prev != nil {
 		if !bytes.Equal(b.PrevHash, prev.Hash) {
 			return fmt.Errorf("prev hash mismatch")
@@ -142,12 +172,7 @@ func (b *Block) Verify(prev *Block) error {
 		return fmt.Errorf("PoW invalid: hash %x > target %x", hash, b.Target)
 	}
 
-	for _, tx := range b.Transactions {
-		if !tx.Verify() {
-			return fmt.Errorf("invalid transaction")
-		}
-	}
-
+	// 📜 交易的腳本要有 UTXO 才能驗，交給 node.VerifyBlockWithUTXO
 	return nil
 }
 
@@ -193,107 +218,56 @@ func (b *Block) CalcHash() []byte {
 	return h[:]
 }
 
+// CheckProofOfWork 只依賴區塊頭本身：重算 Hash，並以 Bits 還原的共識 Target 驗證
+func (b *Block) CheckProofOfWork() error {
+	target := utils.CompactToBig(b.Bits)
+	if target.Sign() <= 0 {
+		return fmt.Errorf("invalid bits %08x", b.Bits)
+	}
+
+	// 宣稱的 Hash 一定要跟標頭算出來的一樣 (沒給也不行)，不然可以拿假 Hash 跳過 PoW
+	hash := b.CalcHash()
+	if !bytes.Equal(hash, b.Hash) {
+		return fmt.Errorf("header hash mismatch: claimed %x, computed %x", b.Hash, hash)
+	}
+	if !hashMeetsTarget(hash, target) {
+		return fmt.Errorf("PoW invalid: hash %x > target %x", hash, target)
+	}
+	return nil
+}
+
 func hashMeetsTarget(hash []byte, target *big.Int) bool {
 	hashInt := new(big.Int).SetBytes(hash)
 	return hashInt.Cmp(target) <= 0
 }
 
 // --------------------
-// 序列化 (JSON)
+// 序列化 (二進位，格式見 serialize.go)
 // --------------------
 func (b *Block) Serialize() []byte {
-	// 定義臨時結構體，加入 Bits
-	view := struct {
-		Height       uint64        `json:"height"`
-		PrevHash     string        `json:"prev_hash"`
-		Timestamp    int64         `json:"timestamp"`
-		Nonce        uint64        `json:"nonce"`
-		Bits         uint32        `json:"bits"`   // 🔥 寫入 JSON
-		Target       string        `json:"target"` // 為了人類可讀保留
-		MerkleRoot   string        `json:"merkle_root"`
-		Transactions []Transaction `json:"transactions"`
-		Miner        string        `json:"miner"`
-		Reward       int           `json:"reward"`
-		Hash         string        `json:"hash"`
-	}{
-		Height:       b.Height,
-		PrevHash:     hex.EncodeToString(b.PrevHash),
-		Timestamp:    b.Timestamp,
-		Nonce:        b.Nonce,
-		Bits:         b.Bits, // 🔥 賦值
-		Target:       utils.FormatTargetHex(b.Target),
-		MerkleRoot:   hex.EncodeToString(b.MerkleRoot),
-		Transactions: b.Transactions,
-		Miner:        b.Miner,
-		Reward:       b.Reward,
-		Hash:         hex.EncodeToString(b.Hash),
-	}
-
-	data, err := json.Marshal(view)
-	if err != nil {
-		panic(err)
-	}
-	return data
+	var buf bytes.Buffer
+	b.encode(&buf)
+	return buf.Bytes()
 }
 
 func DeserializeBlock(data []byte) (*Block, error) {
-
-	// 定義臨時結構體，加入 Bits
-	var view struct {
-		Height       uint64        `json:"height"`
-		PrevHash     string        `json:"prev_hash"`
-		Timestamp    int64         `json:"timestamp"`
-		Nonce        uint64        `json:"nonce"`
-		Bits         uint32        `json:"bits"` // 🔥 讀取 JSON
-		Target       string        `json:"target"`
-		MerkleRoot   string        `json:"merkle_root"`
-		Transactions []Transaction `json:"transactions"`
-		Miner        string        `json:"miner"`
-		Reward       int           `json:"reward"`
-		Hash         string        `json:"hash"`
-	}
-
-	if err := json.Unmarshal(data, &view); err != nil {
-		return nil, err
-	}
-
-	prevHashBytes, err := hex.DecodeString(view.PrevHash)
-	if err != nil {
-		return nil, err
+	// 🧳 遷移相容：舊版 chain.db 裡的區塊是 JSON
+	if len(data) > 0 && data[0] == '{' {
+		return decodeLegacyBlockJSON(data)
 	}
 
-	merkleBytes, err := hex.DecodeString(view.MerkleRoot)
+	r := bytes.NewReader(data)
+	b, err := decodeBlock(r)
 	if err != nil {
 		return nil, err
 	}
-
-	hashBytes, err := hex.DecodeString(view.Hash)
-	if err != nil {
-		return nil, err
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("trailing %d bytes after block", r.Len())
 	}
-
-	// ---------------------------------------------------------
-	// 🔥 關鍵修復：從 Bits 還原 Target
-	// ---------------------------------------------------------
-	// 我們不再信任 view.Target (字串)，而是根據 Bits (共識規則) 還原
-	// 這樣保證了 VM 收到的 Target 是正確的
-	targetInt := utils.CompactToBig(view.Bits)
-
-	// Build real block
-	b := &Block{
-		Height:       view.Height,
-		PrevHash:     prevHashBytes,
-		Timestamp:    view.Timestamp,
-		Nonce:        view.Nonce,
-		Bits:         view.Bits, // 🔥 賦值
-		Target:       targetInt, // 🔥 使用還原後的 Target
-		MerkleRoot:   merkleBytes,
-		Transactions: view.Transactions,
-		Miner:        view.Miner,
-		Reward:       view.Reward,
-		Hash:         hashBytes,
+	// 舊格式的區塊重新序列化會換成新格式，大小不一樣，留給 Size 重算
+	if data[0] == blockFormatVersion {
+		b.size = len(data)
 	}
-
 	return b, nil
 }
 
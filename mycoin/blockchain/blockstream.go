@@ -0,0 +1,84 @@
+package blockchain
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// ==========================================
+// 📦 區塊流檔 (mycoin export-blocks / import-blocks)
+// ==========================================
+//
+//   [8]     magic "mycblks" + 格式版本
+//   u32     網路 magic (Params.Net)
+//   之後每個區塊：u32 長度 + Block 序列化，依高度由舊到新，一直到檔尾
+//
+// 沒有總數也沒有檔尾雜湊：可以邊寫邊讀，每個區塊匯入時都會重新驗證。
+
+var blockStreamMagic = [8]byte{'m', 'y', 'c', 'b', 'l', 'k', 's', 1}
+
+// maxBlockStreamRecord 單一區塊的長度上限
+const maxBlockStreamRecord = 1 << 25
+
+// ErrBlockStreamFormat 不是區塊流檔，或是別的網路的區塊
+var ErrBlockStreamFormat = errors.New("not a block stream for this network")
+
+// BlockStreamWriter 依序寫出區塊
+type BlockStreamWriter struct {
+	w *bufio.Writer
+}
+
+func NewBlockStreamWriter(w io.Writer, net uint32) (*BlockStreamWriter, error) {
+	bw := bufio.NewWriter(w)
+	bw.Write(blockStreamMagic[:])
+	if err := binary.Write(bw, binary.LittleEndian, net); err != nil {
+		return nil, err
+	}
+	return &BlockStreamWriter{w: bw}, nil
+}
+
+func (s *BlockStreamWriter) WriteBlock(b *Block) error {
+	data := b.Serialize()
+	var n [4]byte
+	binary.LittleEndian.PutUint32(n[:], uint32(len(data)))
+	if _, err := s.w.Write(n[:]); err != nil {
+		return err
+	}
+	_, err := s.w.Write(data)
+	return err
+}
+
+// Flush 寫完最後一個區塊一定要呼叫 (底下有緩衝)
+func (s *BlockStreamWriter) Flush() error {
+	return s.w.Flush()
+}
+
+// BlockStreamReader 依序讀出區塊
+type BlockStreamReader struct {
+	r *bufio.Reader
+}
+
+func NewBlockStreamReader(r io.Reader, net uint32) (*BlockStreamReader, error) {
+	br := bufio.NewReader(r)
+
+	var magic [8]byte
+	var fileNet uint32
+	if _, err := io.ReadFull(br, magic[:]); err != nil || magic != blockStreamMagic {
+		return nil, ErrBlockStreamFormat
+	}
+	if err := binary.Read(br, binary.LittleEndian, &fileNet); err != nil || fileNet != net {
+		return nil, ErrBlockStreamFormat
+	}
+	return &BlockStreamReader{r: br}, nil
+}
+
+// Next 讀下一個區塊；檔案正常結束回傳 io.EOF
+func (s *BlockStreamReader) Next() (*Block, error) {
+	data, err := readFrame(s.r, maxBlockStreamRecord)
+	if err != nil {
+		return nil, err
+	}
+	return DeserializeBlock(data)
+}
@@ -0,0 +1,77 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+)
+
+// DefaultCoinbaseMaturity 挖到的獎勵要再等這麼多個區塊才能花 (避免重組後花掉不存在的錢)
+const DefaultCoinbaseMaturity = 100
+
+// CoinbaseHeight 從 Coinbase 解鎖腳本的第一個 push 讀出承諾的高度
+func (tx *Transaction) CoinbaseHeight() (uint64, error) {
+	if !tx.IsCoinbase || len(tx.Inputs) != 1 {
+		return 0, errors.New("not a coinbase")
+	}
+
+	ops, err := parseScript(tx.Inputs[0].ScriptSig)
+	if err != nil {
+		return 0, err
+	}
+	if len(ops) == 0 {
+		return 0, errors.New("coinbase has no height commitment")
+	}
+
+	var height int64
+	switch op := ops[0]; {
+	case op.code == Op0:
+		height = 0
+	case op.code >= Op1 && op.code <= Op16:
+		height = int64(op.code - Op1 + 1)
+	case isPushOp(op.code):
+		if height, err = decodeScriptNum(op.data, lockTimeNumMaxLen+3); err != nil {
+			return 0, err
+		}
+	default:
+		return 0, errors.New("coinbase height is not a push")
+	}
+	if height < 0 {
+		return 0, errors.New("negative coinbase height")
+	}
+	return uint64(height), nil
+}
+
+// CheckCoinbase 區塊第一筆交易的格式檢查；新版 Coinbase 必須承諾正確的高度
+func (tx *Transaction) CheckCoinbase(height uint64) error {
+	if !tx.IsCoinbase {
+		return errors.New("first transaction is not a coinbase")
+	}
+	if len(tx.Inputs) != 1 || tx.Inputs[0].TxID != "" || tx.Inputs[0].Index != -1 {
+		return errors.New("malformed coinbase input")
+	}
+	if err := tx.CheckInputs(); err != nil {
+		return fmt.Errorf("coinbase: %w", err)
+	}
+	if err := tx.CheckOutputs(); err != nil {
+		return fmt.Errorf("coinbase: %w", err)
+	}
+
+	// 🧳 舊版 Coinbase 沒有高度承諾
+	if tx.Version < TxVersion {
+		return nil
+	}
+
+	got, err := tx.CoinbaseHeight()
+	if err != nil {
+		return err
+	}
+	if got != height {
+		return fmt.Errorf("coinbase commits to height %d, block is %d", got, height)
+	}
+	return nil
+}
+
+// IsMature Coinbase 產生的 UTXO 要等 CoinbaseMaturity 個區塊才能花
+func (u *UTXO) IsMature(ctx SpendContext) bool {
+	return !u.Coinbase || ctx.Height >= u.Height+ctx.CoinbaseMaturity
+}
@@ -0,0 +1,27 @@
+package blockchain
+
+import "fmt"
+
+// FeeRate 手續費率：每 1000 vbytes 付幾 YiCent (整數運算，不會有浮點誤差)
+// 交易的 vbytes 見 Transaction.VSize
+type FeeRate int64
+
+// NewFeeRate 把 fee (YiCent) 攤到 vsize 上換算成費率 (無條件捨去)
+func NewFeeRate(fee, vsize int) FeeRate {
+	if vsize <= 0 {
+		return 0
+	}
+	return FeeRate(int64(fee) * 1000 / int64(vsize))
+}
+
+// FeeFor vsize 大小的交易以這個費率至少要付多少手續費 (無條件進位，費率 > 0 時至少 1)
+func (r FeeRate) FeeFor(vsize int) int {
+	if r <= 0 || vsize <= 0 {
+		return 0
+	}
+	return int((int64(r)*int64(vsize) + 999) / 1000)
+}
+
+func (r FeeRate) String() string {
+	return fmt.Sprintf("%.3f YiCent/vB", float64(r)/1000)
+}
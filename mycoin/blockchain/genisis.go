@@ -7,14 +7,30 @@ import (
 	"mycoin/utils"
 )
 
-func NewGenesisBlock(target *big.Int) *Block {
-	// 🚀 關鍵修復：加上第三個參數！這是一段固定的創世名言
-	// （你可以改成任何你喜歡的句子，但主機和 VM 執行的程式碼裡這句必須完全一樣！）
-	genesisTx := NewCoinbase(
-		"GENESIS",
-		1000000,
-		"The Times 03/Jan/2009 Chancellor on brink of second bailout for banks", // 👈 就是這個固定字串！
-	)
+// GenesisBlock 依參數建出這條鏈的創世區塊 (每個網路都不一樣)
+func (p *Params) GenesisBlock() *Block {
+	return newGenesisBlock(p.PowLimit, p.GenesisTimestamp, p.GenesisMessage, p.Subsidy.GenesisReward)
+}
+
+func newGenesisBlock(target *big.Int, timestamp int64, message string, reward int) *Block {
+	// 🚀 創世名言寫在參數裡
+	// （主機和 VM 執行的程式碼裡這句必須完全一樣！）
+	// 🧳 創世交易維持舊版格式與 ID，創世 Hash 才不會因為換序列化格式而改變
+	genesisTx := &Transaction{
+		Version: TxVersionLegacy,
+		Inputs: []TxInput{{
+			TxID:   "",
+			Index:  -1,
+			Sig:    message, // 👈 就是這個固定字串！
+			PubKey: "Coinbase",
+		}},
+		Outputs: []TxOutput{
+			{Amount: reward, To: "GENESIS"},
+		},
+		IsCoinbase: true,
+	}
+	genesisTx.CalcID()
+
 	// binary prev hash (all zero)
 	prev := make([]byte, 32)
 
@@ -30,14 +46,14 @@ func NewGenesisBlock(target *big.Int) *Block {
 	block := &Block{
 		Height:       0,
 		PrevHash:     prev,
-		Timestamp:    1700000000,
+		Timestamp:    timestamp,
 		Nonce:        0,
 		Transactions: []Transaction{*genesisTx},
 		MerkleRoot:   merkle,
 		Target:       new(big.Int).Set(target), // 複製一份，避免外部修改影響
 		TargetHex:    target.Text(16),
 		Miner:        "GENESIS",
-		Reward:       1000000,
+		Reward:       reward,
 		Bits:         utils.BigToCompact(target),
 	}
 
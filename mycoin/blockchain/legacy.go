@@ -0,0 +1,182 @@
+package blockchain
+
+import (
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"mycoin/utils"
+)
+
+// ==========================================
+// 🧳 舊版 JSON 格式 (只為了讀取遷移前的資料)
+// ==========================================
+// 舊交易的 txid / 簽名摘要都是對 JSON 做 Hash，欄位順序一改就對不上，
+// 所以這裡把當時的結構凍結下來，不要再跟著 Transaction 一起改。
+
+type legacyTxInput struct {
+	TxID   string `json:"txid"`
+	Index  int    `json:"index"`
+	Sig    string `json:"sig"`
+	PubKey string `json:"pubkey"`
+}
+
+type legacyTxOutput struct {
+	Amount int    `json:"amount"`
+	To     string `json:"to"`
+}
+
+type legacyTransaction struct {
+	ID         string           `json:"txid"`
+	Inputs     []legacyTxInput  `json:"vin"`
+	Outputs    []legacyTxOutput `json:"vout"`
+	IsCoinbase bool             `json:"is_coinbase"`
+}
+
+// toTransaction 轉成 Transaction；ID 用 legacyCalcID 重算，跟 JSON 裡存的對不上就拒絕
+func (ltx *legacyTransaction) toTransaction() (Transaction, error) {
+	tx := Transaction{
+		Version:    TxVersionLegacy,
+		Inputs:     make([]TxInput, len(ltx.Inputs)),
+		Outputs:    make([]TxOutput, len(ltx.Outputs)),
+		IsCoinbase: ltx.IsCoinbase,
+	}
+	for i, in := range ltx.Inputs {
+		tx.Inputs[i] = TxInput{TxID: in.TxID, Index: in.Index, Sig: in.Sig, PubKey: in.PubKey}
+	}
+	for i, out := range ltx.Outputs {
+		tx.Outputs[i] = TxOutput{Amount: out.Amount, To: out.To}
+	}
+	tx.ID = legacyCalcID(&tx)
+	if tx.ID != ltx.ID {
+		return tx, fmt.Errorf("legacy txid mismatch: claimed %s, computed %s", ltx.ID, tx.ID)
+	}
+	return tx, nil
+}
+
+// legacyUnsignedJSON 舊版 cloneWithoutSign 的 JSON (清空 ID、簽名、公鑰)
+func legacyUnsignedJSON(tx *Transaction) []byte {
+	tmp := legacyTransaction{
+		Inputs:     make([]legacyTxInput, len(tx.Inputs)),
+		Outputs:    make([]legacyTxOutput, len(tx.Outputs)),
+		IsCoinbase: tx.IsCoinbase,
+	}
+	for i, in := range tx.Inputs {
+		tmp.Inputs[i] = legacyTxInput{TxID: in.TxID, Index: in.Index}
+	}
+	for i, out := range tx.Outputs {
+		tmp.Outputs[i] = legacyTxOutput{Amount: out.Amount, To: out.To}
+	}
+	data, _ := json.Marshal(tmp)
+	return data
+}
+
+func legacyCalcID(tx *Transaction) string {
+	if tx.IsCoinbase {
+		return legacyCoinbaseID(tx)
+	}
+	return HashTxBytes(legacyUnsignedJSON(tx))
+}
+
+// legacySigHash 舊交易的簽名摘要：整筆未簽名 JSON 做兩次 SHA256，不分輸入、沒有 SigHashType
+func legacySigHash(tx *Transaction) []byte {
+	first := sha256.Sum256(legacyUnsignedJSON(tx))
+	second := sha256.Sum256(first[:])
+	return second[:]
+}
+
+// legacyCoinbaseID 舊版 DeterministicID：Coinbase 的 ID 會把 Sig (時間戳 / 創世字串) 算進去
+func legacyCoinbaseID(tx *Transaction) string {
+	h := sha256.New()
+
+	if tx.IsCoinbase {
+		h.Write([]byte{1})
+	} else {
+		h.Write([]byte{0})
+	}
+
+	h.Write([]byte{byte(len(tx.Inputs))})
+	for _, in := range tx.Inputs {
+		h.Write([]byte(in.TxID))
+
+		idx := make([]byte, 8)
+		binary.BigEndian.PutUint64(idx, uint64(in.Index))
+		h.Write(idx)
+
+		h.Write([]byte(in.Sig))
+		h.Write([]byte(in.PubKey))
+	}
+
+	h.Write([]byte{byte(len(tx.Outputs))})
+	for _, out := range tx.Outputs {
+		amt := make([]byte, 8)
+		binary.BigEndian.PutUint64(amt, uint64(out.Amount))
+		h.Write(amt)
+
+		h.Write([]byte(out.To))
+	}
+
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func decodeLegacyTransactionJSON(data []byte) (*Transaction, error) {
+	var ltx legacyTransaction
+	if err := json.Unmarshal(data, &ltx); err != nil {
+		return nil, err
+	}
+	tx, err := ltx.toTransaction()
+	if err != nil {
+		return nil, err
+	}
+	return &tx, nil
+}
+
+// decodeLegacyBlockJSON 讀取舊版 Block.Serialize 寫出的 JSON
+func decodeLegacyBlockJSON(data []byte) (*Block, error) {
+	var view struct {
+		Height       uint64              `json:"height"`
+		PrevHash     string              `json:"prev_hash"`
+		Timestamp    int64               `json:"timestamp"`
+		Nonce        uint64              `json:"nonce"`
+		Bits         uint32              `json:"bits"`
+		MerkleRoot   string              `json:"merkle_root"`
+		Transactions []legacyTransaction `json:"transactions"`
+		Miner        string              `json:"miner"`
+		Reward       int                 `json:"reward"`
+	}
+
+	if err := json.Unmarshal(data, &view); err != nil {
+		return nil, err
+	}
+
+	prevHashBytes, err := hex.DecodeString(view.PrevHash)
+	if err != nil {
+		return nil, err
+	}
+	merkleBytes, err := hex.DecodeString(view.MerkleRoot)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &Block{
+		Height:       view.Height,
+		PrevHash:     prevHashBytes,
+		Timestamp:    view.Timestamp,
+		Nonce:        view.Nonce,
+		Bits:         view.Bits,
+		Target:       utils.CompactToBig(view.Bits),
+		MerkleRoot:   merkleBytes,
+		Transactions: make([]Transaction, len(view.Transactions)),
+		Miner:        view.Miner,
+		Reward:       view.Reward,
+	}
+	for i := range view.Transactions {
+		if b.Transactions[i], err = view.Transactions[i].toTransaction(); err != nil {
+			return nil, fmt.Errorf("tx %d: %w", i, err)
+		}
+	}
+	b.Hash = b.CalcHash()
+
+	return b, nil
+}
@@ -0,0 +1,77 @@
+package blockchain
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ==========================================
+// ⏳ 交易層的時間鎖
+// ==========================================
+// LockTime：整筆交易在指定高度 / 時間之前都不能上鏈 (< LockTimeThreshold 是高度，否則是 Unix 時間)。
+//           所有輸入的 Sequence 都是 SequenceFinal 時，LockTime 失效。
+// Sequence：相對時間鎖 (只對 TxVersion 以上的交易生效)，被花掉的 UTXO 要「放」夠久才能花。
+//           bit31 = 停用、bit22 = 以秒計 (單位 512 秒)、低 16 bits = 區塊數 / 時間單位數。
+
+const (
+	SequenceFinal = 0xffffffff
+
+	SequenceLockTimeDisabled    = 1 << 31
+	SequenceLockTimeIsSeconds   = 1 << 22
+	SequenceLockTimeMask        = 0x0000ffff
+	SequenceLockTimeGranularity = 9 // 2^9 = 512 秒
+)
+
+var ErrTxNotFinal = errors.New("transaction is not final")
+
+// IsFinal 這筆交易能不能被打包進 ctx 描述的區塊；時間型的 LockTime 跟 ctx.Time (MTP) 比
+func (tx *Transaction) IsFinal(ctx SpendContext) bool {
+	if tx.LockTime == 0 {
+		return true
+	}
+
+	if tx.LockTime < LockTimeThreshold {
+		if uint64(tx.LockTime) < ctx.Height {
+			return true
+		}
+	} else if int64(tx.LockTime) < ctx.Time {
+		return true
+	}
+
+	for _, in := range tx.Inputs {
+		if in.Sequence != SequenceFinal {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckSequenceLocks 檢查每個輸入的相對時間鎖；prevs[i] 是第 i 個輸入花掉的 UTXO (要有建立時的高度 / MTP)
+// 時間鎖兩邊都用 MTP：UTXO 所在區塊的父鏈 MTP，跟這個區塊的父鏈 MTP (ctx.Time)
+func (tx *Transaction) CheckSequenceLocks(prevs []UTXO, ctx SpendContext) error {
+	if tx.IsCoinbase || tx.Version < TxVersion {
+		return nil
+	}
+	if len(prevs) != len(tx.Inputs) {
+		return fmt.Errorf("have %d prevouts for %d inputs", len(prevs), len(tx.Inputs))
+	}
+
+	for i, in := range tx.Inputs {
+		if in.Sequence&SequenceLockTimeDisabled != 0 {
+			continue
+		}
+
+		value := int64(in.Sequence & SequenceLockTimeMask)
+		if in.Sequence&SequenceLockTimeIsSeconds != 0 {
+			if ready := prevs[i].Time + value<<SequenceLockTimeGranularity; ctx.Time < ready {
+				return fmt.Errorf("input %d: relative time lock until %d", i, ready)
+			}
+			continue
+		}
+
+		if ready := prevs[i].Height + uint64(value); ctx.Height < ready {
+			return fmt.Errorf("input %d: relative height lock until %d", i, ready)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,179 @@
+package blockchain
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// Params 一條鏈的全部共識與網路參數 (仿 btcd 的 chaincfg)
+// 主網 / 測試網 / 回歸測試網各有自己的創世區塊、Magic 與埠口，彼此不會互相連上
+type Params struct {
+	Name string
+
+	// 🌐 網路
+	Net           uint32 // 每則 P2P 訊息都帶這個 Magic，不同網路的節點互相拒收
+	DefaultPort   string
+	RPCPort       string
+	WalletRPCPort string
+	DNSSeeds      []string
+
+	// 🪐 創世區塊
+	GenesisTimestamp int64
+	GenesisMessage   string
+
+	// ⛏️ 工作量證明與難度調整
+	PowLimit           *big.Int // 最簡單的難度 (也是創世區塊的難度)
+	DifficultyInterval uint64   // 幾個區塊調整一次難度
+	TargetSpacing      int64    // 預期出塊間隔 (秒)
+	NoRetargeting      bool     // regtest：難度永遠停在 PowLimit
+	GenerateSupported  bool     // 允許 generate / generatetoaddress RPC 直接出塊
+
+	// 💰 發行
+	Subsidy          SubsidySchedule
+	CoinbaseMaturity uint64
+
+	// 🧳 舊版交易 (TxVersionLegacy) 最後允許上鏈的高度，之後的區塊與 mempool 一律拒收
+	// 節點啟動時若遷移過舊資料，會提高到舊區塊裡真的有舊交易的最高高度 (meta/legacytxheight)
+	LegacyTxHeight uint64
+
+	// 📸 可以直接載入的 UTXO 快照：快照區塊高度 → (區塊 Hash, 帳本內容雜湊)
+	// 跟著版本發布；不在這裡的快照一律拒收，不接受 RPC 呼叫端自己給的雜湊。
+	// 主網 / 測試網目前沒有釘任何快照，-loadsnapshot 實際上只能在 regtest 用
+	AssumeUTXO map[uint64]AssumeUTXOPin
+
+	// 🔑 地址與私鑰前綴
+	PubKeyHashAddrID byte
+	ScriptHashAddrID byte
+	PrivateKeyID     byte
+}
+
+// AssumeUTXOPin 釘住的快照：高度、區塊與帳本三者要一起對上，只對上帳本雜湊不夠
+// (同樣的帳本雜湊可以掛在同高度的另一條分岔上)
+type AssumeUTXOPin struct {
+	BlockHash string // 快照區塊的 Hash (hex)
+	UTXOHash  string // 快照區塊之後的帳本內容雜湊 (UTXOSetHash)
+}
+
+func hexToBig(s string) *big.Int {
+	n, ok := new(big.Int).SetString(s, 16)
+	if !ok {
+		panic("invalid hex in chain params: " + s)
+	}
+	return n
+}
+
+// MainNetParams 主網，參數跟改版前的寫死值完全一樣 (創世 Hash 不變)
+var MainNetParams = Params{
+	Name:          "mainnet",
+	Net:           0xd9b4c359,
+	DefaultPort:   "9001",
+	RPCPort:       "8081",
+	WalletRPCPort: "8082",
+	DNSSeeds: []string{
+		"seed1.mycoin.org",
+		"seed2.mycoin.org",
+		"seed.mycoin.net",
+	},
+
+	GenesisTimestamp: 1700000000,
+	GenesisMessage:   "The Times 03/Jan/2009 Chancellor on brink of second bailout for banks",
+
+	PowLimit:           hexToBig("00000fffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"),
+	DifficultyInterval: 10,
+	TargetSpacing:      30,
+
+	Subsidy: SubsidySchedule{
+		GenesisReward:   1000000,
+		BaseSubsidy:     500,
+		HalvingInterval: 210000,
+	},
+	CoinbaseMaturity: DefaultCoinbaseMaturity,
+	LegacyTxHeight:   0, // 只有創世區塊；從 JSON 格式升級的節點改用 migrateStorage 找到的高度
+
+	PubKeyHashAddrID: 0x00,
+	ScriptHashAddrID: 0x05,
+	PrivateKeyID:     0x80,
+}
+
+// TestNetParams 公開測試網：規則同主網，但有自己的創世區塊與前綴
+var TestNetParams = Params{
+	Name:          "testnet",
+	Net:           0x0709c359,
+	DefaultPort:   "19001",
+	RPCPort:       "18081",
+	WalletRPCPort: "18082",
+
+	GenesisTimestamp: 1700000001,
+	GenesisMessage:   "mycoin testnet genesis",
+
+	PowLimit:           hexToBig("00000fffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"),
+	DifficultyInterval: 10,
+	TargetSpacing:      30,
+
+	Subsidy: SubsidySchedule{
+		GenesisReward:   1000000,
+		BaseSubsidy:     500,
+		HalvingInterval: 210000,
+	},
+	CoinbaseMaturity: DefaultCoinbaseMaturity,
+	LegacyTxHeight:   0, // 只有創世區塊
+
+	PubKeyHashAddrID: 0x6f,
+	ScriptHashAddrID: 0xc4,
+	PrivateKeyID:     0xef,
+}
+
+// RegTestParams 本地回歸測試 (CI 用)：幾乎沒有難度、不調整難度、150 塊就減半
+var RegTestParams = Params{
+	Name:          "regtest",
+	Net:           0xdab5bffa,
+	DefaultPort:   "29001",
+	RPCPort:       "28081",
+	WalletRPCPort: "28082",
+
+	GenesisTimestamp: 1700000002,
+	GenesisMessage:   "mycoin regtest genesis",
+
+	PowLimit:           hexToBig("7fffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"),
+	DifficultyInterval: 10,
+	TargetSpacing:      30,
+	NoRetargeting:      true,
+	GenerateSupported:  true,
+
+	Subsidy: SubsidySchedule{
+		GenesisReward:   1000000,
+		BaseSubsidy:     500,
+		HalvingInterval: 150,
+	},
+	CoinbaseMaturity: DefaultCoinbaseMaturity,
+	LegacyTxHeight:   0,
+	AssumeUTXO:       map[uint64]AssumeUTXOPin{}, // 每次產生的鏈都不一樣，測試時用 -assumeutxo 自己釘
+
+	PubKeyHashAddrID: 0x6f,
+	ScriptHashAddrID: 0xc4,
+	PrivateKeyID:     0xef,
+}
+
+// ActiveNetParams 地址字串 (TxOutput.To) 屬於交易格式的一部分，
+// 編碼 / 解碼地址時用這組參數；節點啟動時用 SetActiveNetParams 切換
+var ActiveNetParams = &MainNetParams
+
+// SetActiveNetParams 切換整個程式使用的網路 (只在啟動時呼叫一次)
+func SetActiveNetParams(p *Params) {
+	ActiveNetParams = p
+}
+
+// ParamsForNetwork 依 -network 旗標的名字找參數
+func ParamsForNetwork(name string) (*Params, error) {
+	for _, p := range []*Params{&MainNetParams, &TestNetParams, &RegTestParams} {
+		if p.Name == name {
+			return p, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown network %q (mainnet, testnet, regtest)", name)
+}
+
+// TargetTimespan 一個難度週期預期花的秒數
+func (p *Params) TargetTimespan() int64 {
+	return int64(p.DifficultyInterval) * p.TargetSpacing
+}
@@ -0,0 +1,800 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"errors"
+	"fmt"
+
+	"github.com/btcsuite/btcd/btcec/v2"
+	ecdsa "github.com/btcsuite/btcd/btcec/v2/ecdsa"
+)
+
+// ==========================================
+// 📜 鎖定 / 解鎖腳本 (簡化版 Bitcoin Script)
+// ==========================================
+// 輸出帶鎖定腳本 (TxOutput.Script)，輸入帶解鎖腳本 (TxInput.ScriptSig)。
+// 驗證時先執行解鎖腳本 (只能 push 資料)，再用同一個堆疊執行鎖定腳本，
+// 結束時堆疊頂端為 true 才算解鎖成功。
+//
+// 支援：P2PKH、m-of-n 多簽 (CHECKMULTISIG，不吃 Bitcoin 那個多餘的 dummy 元素)、
+// SHA256 Hash 鎖、CHECKLOCKTIMEVERIFY / CHECKSEQUENCEVERIFY 時間鎖，以及 IF / ELSE 分支組合。
+// 時間鎖只檢查交易自己的 LockTime / Sequence，真正擋住上鏈時間的是 IsFinal 與 CheckSequenceLocks。
+
+const (
+	Op0         = 0x00
+	OpPushData1 = 0x4c
+	OpPushData2 = 0x4d
+	Op1Negate   = 0x4f
+	Op1         = 0x51
+	Op16        = 0x60
+
+	OpIf     = 0x63
+	OpNotIf  = 0x64
+	OpElse   = 0x67
+	OpEndIf  = 0x68
+	OpVerify = 0x69
+	OpReturn = 0x6a
+
+	OpDrop = 0x75
+	OpDup  = 0x76
+	OpSize = 0x82
+
+	OpEqual       = 0x87
+	OpEqualVerify = 0x88
+
+	OpSha256  = 0xa8
+	OpHash160 = 0xa9
+
+	OpCheckSig            = 0xac
+	OpCheckSigVerify      = 0xad
+	OpCheckMultiSig       = 0xae
+	OpCheckMultiSigVerify = 0xaf
+
+	OpCheckLockTimeVerify = 0xb1
+	OpCheckSequenceVerify = 0xb2
+)
+
+const (
+	MaxScriptSize      = 10000
+	MaxScriptElement   = 520
+	MaxOpsPerScript    = 201
+	MaxStackSize       = 1000
+	MaxPubKeysPerMulti = 20
+	MaxBlockSigOps     = MaxBlockSize / 50 // 一個區塊花掉的鎖定腳本加起來最多驗幾次簽名
+	scriptNumMaxLen    = 4
+	lockTimeNumMaxLen  = 5
+	LockTimeThreshold  = 500000000 // 小於它是區塊高度，否則是 Unix 時間
+)
+
+var (
+	ErrScriptFalse      = errors.New("script evaluated to false")
+	ErrScriptVerify     = errors.New("VERIFY failed")
+	ErrUnbalancedIf     = errors.New("unbalanced IF/ELSE/ENDIF")
+	ErrStackUnderflow   = errors.New("stack underflow")
+	ErrUnlockNotPush    = errors.New("unlocking script must be push-only")
+	ErrLockTimeNotFinal = errors.New("locktime requirement not satisfied")
+)
+
+// SpendContext 描述「這筆交易要被打包進哪個區塊」，給 IsFinal / CheckSequenceLocks 判斷用
+type SpendContext struct {
+	Height uint64 // 區塊高度 (mempool 驗證時用下一個高度)
+	Time   int64  // 父鏈的 MTP (mempool 驗證時用鏈頭的 MTP)，不是區塊自己的時間戳
+
+	CoinbaseMaturity uint64 // Coinbase 要等幾個區塊才能花
+}
+
+type scriptOp struct {
+	code byte
+	data []byte
+}
+
+// --------------------
+// 建構腳本
+// --------------------
+
+// PushOnlyScript 把資料依序 push，用來組解鎖腳本 (例如簽名、公鑰、preimage)
+func PushOnlyScript(items ...[]byte) []byte {
+	var buf bytes.Buffer
+	for _, item := range items {
+		pushData(&buf, item)
+	}
+	return buf.Bytes()
+}
+
+// PayToPubKeyHashScript: DUP HASH160 <pubKeyHash> EQUALVERIFY CHECKSIG
+func PayToPubKeyHashScript(pubKeyHash []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(OpDup)
+	buf.WriteByte(OpHash160)
+	pushData(&buf, pubKeyHash)
+	buf.WriteByte(OpEqualVerify)
+	buf.WriteByte(OpCheckSig)
+	return buf.Bytes()
+}
+
+// PayToAddressScript 把一般的 Base58 地址轉成 P2PKH 鎖定腳本
+func PayToAddressScript(addr string) ([]byte, error) {
+	hash, err := AddressToPubKeyHash(addr)
+	if err != nil {
+		return nil, err
+	}
+	return PayToPubKeyHashScript(hash), nil
+}
+
+// MultiSigScript: <m> <pub1> ... <pubN> <n> CHECKMULTISIG
+func MultiSigScript(m int, pubKeys [][]byte) ([]byte, error) {
+	n := len(pubKeys)
+	if n == 0 || n > MaxPubKeysPerMulti {
+		return nil, fmt.Errorf("multisig needs 1..%d pubkeys, got %d", MaxPubKeysPerMulti, n)
+	}
+	if m < 1 || m > n {
+		return nil, fmt.Errorf("invalid multisig threshold %d-of-%d", m, n)
+	}
+
+	var buf bytes.Buffer
+	pushInt(&buf, int64(m))
+	for _, pub := range pubKeys {
+		if _, err := btcec.ParsePubKey(pub); err != nil {
+			return nil, fmt.Errorf("invalid pubkey %x: %v", pub, err)
+		}
+		pushData(&buf, pub)
+	}
+	pushInt(&buf, int64(n))
+	buf.WriteByte(OpCheckMultiSig)
+	return buf.Bytes(), nil
+}
+
+// HashLockScript: SHA256 <hash> EQUALVERIFY <next>
+// 先交出 preimage 才能繼續執行 next (通常是 P2PKH 或多簽)
+func HashLockScript(hash []byte, next []byte) []byte {
+	var buf bytes.Buffer
+	buf.WriteByte(OpSha256)
+	pushData(&buf, hash)
+	buf.WriteByte(OpEqualVerify)
+	buf.Write(next)
+	return buf.Bytes()
+}
+
+// TimeLockScript: <lockTime> CHECKLOCKTIMEVERIFY DROP <next>
+// lockTime < LockTimeThreshold 代表區塊高度，否則是 Unix 時間 (花費交易的 LockTime 要設到這個值以上)
+func TimeLockScript(lockTime int64, next []byte) []byte {
+	var buf bytes.Buffer
+	pushInt(&buf, lockTime)
+	buf.WriteByte(OpCheckLockTimeVerify)
+	buf.WriteByte(OpDrop)
+	buf.Write(next)
+	return buf.Bytes()
+}
+
+// RelativeLockScript: <sequence> CHECKSEQUENCEVERIFY DROP <next>
+// sequence 格式同 TxInput.Sequence (例如 144 = 要放 144 個區塊才能花)
+func RelativeLockScript(sequence int64, next []byte) []byte {
+	var buf bytes.Buffer
+	pushInt(&buf, sequence)
+	buf.WriteByte(OpCheckSequenceVerify)
+	buf.WriteByte(OpDrop)
+	buf.Write(next)
+	return buf.Bytes()
+}
+
+// ExtractAddress 鎖定腳本對應的索引地址：P2PKH 還原成一般地址，其他腳本用 ScriptAddress
+func ExtractAddress(script []byte) string {
+	if hash, ok := payToPubKeyHash(script); ok {
+		return encodeAddress(ActiveNetParams.PubKeyHashAddrID, hash)
+	}
+	return ScriptAddress(script)
+}
+
+func payToPubKeyHash(script []byte) ([]byte, bool) {
+	if len(script) == 25 &&
+		script[0] == OpDup && script[1] == OpHash160 && script[2] == 20 &&
+		script[23] == OpEqualVerify && script[24] == OpCheckSig {
+		return script[3:23], true
+	}
+	return nil, false
+}
+
+func pushData(buf *bytes.Buffer, data []byte) {
+	n := len(data)
+	switch {
+	case n < OpPushData1:
+		buf.WriteByte(byte(n))
+	case n <= 0xff:
+		buf.WriteByte(OpPushData1)
+		buf.WriteByte(byte(n))
+	default:
+		buf.WriteByte(OpPushData2)
+		var l [2]byte
+		binary.LittleEndian.PutUint16(l[:], uint16(n))
+		buf.Write(l[:])
+	}
+	buf.Write(data)
+}
+
+func pushInt(buf *bytes.Buffer, v int64) {
+	switch {
+	case v == 0:
+		buf.WriteByte(Op0)
+	case v == -1:
+		buf.WriteByte(Op1Negate)
+	case v >= 1 && v <= 16:
+		buf.WriteByte(byte(Op1 - 1 + v))
+	default:
+		pushData(buf, encodeScriptNum(v))
+	}
+}
+
+// --------------------
+// 數字 (little-endian、最高位元是正負號，同 Bitcoin)
+// --------------------
+
+func encodeScriptNum(v int64) []byte {
+	if v == 0 {
+		return nil
+	}
+	neg := v < 0
+	abs := v
+	if neg {
+		abs = -v
+	}
+
+	var out []byte
+	for abs > 0 {
+		out = append(out, byte(abs&0xff))
+		abs >>= 8
+	}
+	if out[len(out)-1]&0x80 != 0 {
+		if neg {
+			out = append(out, 0x80)
+		} else {
+			out = append(out, 0x00)
+		}
+	} else if neg {
+		out[len(out)-1] |= 0x80
+	}
+	return out
+}
+
+func decodeScriptNum(b []byte, maxLen int) (int64, error) {
+	if len(b) > maxLen {
+		return 0, fmt.Errorf("script number too long: %d bytes", len(b))
+	}
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	var v int64
+	for i, c := range b {
+		v |= int64(c) << (8 * i)
+	}
+	if b[len(b)-1]&0x80 != 0 {
+		v &^= int64(0x80) << (8 * (len(b) - 1))
+		v = -v
+	}
+	return v, nil
+}
+
+func castToBool(b []byte) bool {
+	for i, c := range b {
+		if c != 0 {
+			// 負零 (只有最高位元) 也算 false
+			return !(i == len(b)-1 && c == 0x80)
+		}
+	}
+	return false
+}
+
+// --------------------
+// 解析
+// --------------------
+
+func parseScript(script []byte) ([]scriptOp, error) {
+	if len(script) > MaxScriptSize {
+		return nil, fmt.Errorf("script too large: %d bytes", len(script))
+	}
+
+	var ops []scriptOp
+	for i := 0; i < len(script); {
+		code := script[i]
+		i++
+
+		var n int
+		switch {
+		case code > Op0 && code < OpPushData1:
+			n = int(code)
+		case code == OpPushData1:
+			if i+1 > len(script) {
+				return nil, errors.New("truncated PUSHDATA1")
+			}
+			n = int(script[i])
+			i++
+		case code == OpPushData2:
+			if i+2 > len(script) {
+				return nil, errors.New("truncated PUSHDATA2")
+			}
+			n = int(binary.LittleEndian.Uint16(script[i:]))
+			i += 2
+		default:
+			ops = append(ops, scriptOp{code: code})
+			continue
+		}
+
+		if i+n > len(script) {
+			return nil, fmt.Errorf("push of %d bytes exceeds script", n)
+		}
+		if n > MaxScriptElement {
+			return nil, fmt.Errorf("push of %d bytes exceeds element limit", n)
+		}
+		ops = append(ops, scriptOp{code: code, data: script[i : i+n]})
+		i += n
+	}
+	return ops, nil
+}
+
+func isPushOp(code byte) bool {
+	return code <= Op16 && code != 0x50 // 0x50 是 RESERVED
+}
+
+// --------------------
+// 執行
+// --------------------
+
+type scriptEngine struct {
+	tx      *Transaction
+	idx     int
+	prevOut TxOutput
+	stack   [][]byte
+	opCount int // 這段腳本執行過的 op 數 (CHECKMULTISIG 另外加上公鑰數)
+}
+
+// VerifyScript 用解鎖腳本解開第 idx 個輸入所花費的輸出 (prevOut) 的鎖定腳本
+func VerifyScript(unlock []byte, prevOut TxOutput, tx *Transaction, idx int) error {
+	lock, err := prevOut.LockingScript()
+	if err != nil {
+		return fmt.Errorf("locking script: %w", err)
+	}
+
+	unlockOps, err := parseScript(unlock)
+	if err != nil {
+		return fmt.Errorf("unlocking script: %w", err)
+	}
+	for _, op := range unlockOps {
+		if !isPushOp(op.code) {
+			return ErrUnlockNotPush
+		}
+	}
+
+	lockOps, err := parseScript(lock)
+	if err != nil {
+		return fmt.Errorf("locking script: %w", err)
+	}
+
+	e := &scriptEngine{tx: tx, idx: idx, prevOut: prevOut}
+	if err := e.run(unlockOps); err != nil {
+		return fmt.Errorf("unlocking script: %w", err)
+	}
+	if err := e.run(lockOps); err != nil {
+		return fmt.Errorf("locking script: %w", err)
+	}
+
+	if len(e.stack) == 0 || !castToBool(e.stack[len(e.stack)-1]) {
+		return ErrScriptFalse
+	}
+	return nil
+}
+
+func (e *scriptEngine) run(ops []scriptOp) error {
+	var cond []bool // IF 巢狀：每一層是否在執行
+	e.opCount = 0
+
+	executing := func() bool {
+		for _, c := range cond {
+			if !c {
+				return false
+			}
+		}
+		return true
+	}
+
+	for _, op := range ops {
+		if op.code > Op16 {
+			if err := e.countOps(1); err != nil {
+				return err
+			}
+		}
+
+		switch op.code {
+		case OpIf, OpNotIf:
+			branch := false
+			if executing() {
+				v, err := e.pop()
+				if err != nil {
+					return err
+				}
+				branch = castToBool(v)
+				if op.code == OpNotIf {
+					branch = !branch
+				}
+			}
+			cond = append(cond, branch)
+			continue
+
+		case OpElse:
+			if len(cond) == 0 {
+				return ErrUnbalancedIf
+			}
+			cond[len(cond)-1] = !cond[len(cond)-1]
+			continue
+
+		case OpEndIf:
+			if len(cond) == 0 {
+				return ErrUnbalancedIf
+			}
+			cond = cond[:len(cond)-1]
+			continue
+		}
+
+		if !executing() {
+			continue
+		}
+
+		if err := e.step(op); err != nil {
+			return err
+		}
+		if len(e.stack) > MaxStackSize {
+			return fmt.Errorf("stack too large (> %d)", MaxStackSize)
+		}
+	}
+
+	if len(cond) != 0 {
+		return ErrUnbalancedIf
+	}
+	return nil
+}
+
+func (e *scriptEngine) step(op scriptOp) error {
+	switch {
+	case op.code == Op0:
+		e.push(nil)
+		return nil
+	case op.code < OpPushData1 || op.code == OpPushData1 || op.code == OpPushData2:
+		e.push(op.data)
+		return nil
+	case op.code == Op1Negate:
+		e.push(encodeScriptNum(-1))
+		return nil
+	case op.code >= Op1 && op.code <= Op16:
+		e.push(encodeScriptNum(int64(op.code - Op1 + 1)))
+		return nil
+	}
+
+	switch op.code {
+	case OpVerify:
+		v, err := e.pop()
+		if err != nil {
+			return err
+		}
+		if !castToBool(v) {
+			return ErrScriptVerify
+		}
+
+	case OpReturn:
+		return errors.New("RETURN: output is unspendable")
+
+	case OpDrop:
+		if _, err := e.pop(); err != nil {
+			return err
+		}
+
+	case OpDup:
+		v, err := e.peek()
+		if err != nil {
+			return err
+		}
+		e.push(v)
+
+	case OpSize:
+		v, err := e.peek()
+		if err != nil {
+			return err
+		}
+		e.push(encodeScriptNum(int64(len(v))))
+
+	case OpEqual, OpEqualVerify:
+		a, err := e.pop()
+		if err != nil {
+			return err
+		}
+		b, err := e.pop()
+		if err != nil {
+			return err
+		}
+		equal := bytes.Equal(a, b)
+		if op.code == OpEqualVerify {
+			if !equal {
+				return ErrScriptVerify
+			}
+			return nil
+		}
+		e.pushBool(equal)
+
+	case OpSha256:
+		v, err := e.pop()
+		if err != nil {
+			return err
+		}
+		h := sha256.Sum256(v)
+		e.push(h[:])
+
+	case OpHash160:
+		v, err := e.pop()
+		if err != nil {
+			return err
+		}
+		e.push(Hash160(v))
+
+	case OpCheckSig, OpCheckSigVerify:
+		pub, err := e.pop()
+		if err != nil {
+			return err
+		}
+		sig, err := e.pop()
+		if err != nil {
+			return err
+		}
+		ok := e.checkSig(sig, pub)
+		if op.code == OpCheckSigVerify {
+			if !ok {
+				return ErrScriptVerify
+			}
+			return nil
+		}
+		e.pushBool(ok)
+
+	case OpCheckMultiSig, OpCheckMultiSigVerify:
+		ok, err := e.checkMultiSig()
+		if err != nil {
+			return err
+		}
+		if op.code == OpCheckMultiSigVerify {
+			if !ok {
+				return ErrScriptVerify
+			}
+			return nil
+		}
+		e.pushBool(ok)
+
+	case OpCheckLockTimeVerify:
+		v, err := e.peek()
+		if err != nil {
+			return err
+		}
+		lockTime, err := decodeScriptNum(v, lockTimeNumMaxLen)
+		if err != nil {
+			return err
+		}
+		if err := e.checkLockTime(lockTime); err != nil {
+			return err
+		}
+
+	case OpCheckSequenceVerify:
+		v, err := e.peek()
+		if err != nil {
+			return err
+		}
+		sequence, err := decodeScriptNum(v, lockTimeNumMaxLen)
+		if err != nil {
+			return err
+		}
+		if err := e.checkSequence(sequence); err != nil {
+			return err
+		}
+
+	default:
+		return fmt.Errorf("unknown opcode 0x%02x", op.code)
+	}
+	return nil
+}
+
+// checkLockTime 交易的 LockTime 必須跟腳本要求同一種單位且不小於它 (BIP65)
+func (e *scriptEngine) checkLockTime(lockTime int64) error {
+	if lockTime < 0 {
+		return errors.New("negative locktime")
+	}
+
+	txLockTime := int64(e.tx.LockTime)
+	if (lockTime < LockTimeThreshold) != (txLockTime < LockTimeThreshold) {
+		return ErrLockTimeNotFinal
+	}
+	if lockTime > txLockTime {
+		return ErrLockTimeNotFinal
+	}
+
+	// Sequence 全是 Final 時 LockTime 不生效，必須擋掉
+	if e.tx.Inputs[e.idx].Sequence == SequenceFinal {
+		return ErrLockTimeNotFinal
+	}
+	return nil
+}
+
+// checkSequence 輸入的 Sequence 必須跟腳本要求同一種單位且不小於它 (BIP112)
+func (e *scriptEngine) checkSequence(sequence int64) error {
+	if sequence < 0 {
+		return errors.New("negative sequence")
+	}
+	if sequence&SequenceLockTimeDisabled != 0 {
+		return nil // 腳本自己停用了，當作 NOP
+	}
+	if e.tx.Version < TxVersion {
+		return ErrLockTimeNotFinal
+	}
+
+	txSequence := int64(e.tx.Inputs[e.idx].Sequence)
+	if txSequence&SequenceLockTimeDisabled != 0 {
+		return ErrLockTimeNotFinal
+	}
+
+	const typeMask = SequenceLockTimeIsSeconds | SequenceLockTimeMask
+	if sequence&SequenceLockTimeIsSeconds != txSequence&SequenceLockTimeIsSeconds {
+		return ErrLockTimeNotFinal
+	}
+	if sequence&typeMask > txSequence&typeMask {
+		return ErrLockTimeNotFinal
+	}
+	return nil
+}
+
+// checkMultiSig 堆疊：<sig1> ... <sigM> <m> <pub1> ... <pubN> <n>
+// 簽名必須依照公鑰的順序出現，每個簽名各自帶 SigHashType
+func (e *scriptEngine) checkMultiSig() (bool, error) {
+	n, err := e.popInt()
+	if err != nil {
+		return false, err
+	}
+	if n < 0 || n > MaxPubKeysPerMulti {
+		return false, fmt.Errorf("invalid pubkey count %d", n)
+	}
+	// 每個公鑰都可能要驗一次簽名，跟 Core 一樣算進 op 數
+	if err := e.countOps(n); err != nil {
+		return false, err
+	}
+	pubs := make([][]byte, n)
+	for i := n - 1; i >= 0; i-- {
+		if pubs[i], err = e.pop(); err != nil {
+			return false, err
+		}
+	}
+
+	m, err := e.popInt()
+	if err != nil {
+		return false, err
+	}
+	if m < 0 || m > n {
+		return false, fmt.Errorf("invalid signature count %d", m)
+	}
+	sigs := make([][]byte, m)
+	for i := m - 1; i >= 0; i-- {
+		if sigs[i], err = e.pop(); err != nil {
+			return false, err
+		}
+	}
+
+	p := 0
+	for _, sig := range sigs {
+		for p < len(pubs) && !e.checkSig(sig, pubs[p]) {
+			p++
+		}
+		if p == len(pubs) {
+			return false, nil
+		}
+		p++
+	}
+	return true, nil
+}
+
+// countOps 記下又執行了 n 個 op，超過 MaxOpsPerScript 就停
+func (e *scriptEngine) countOps(n int) error {
+	e.opCount += n
+	if e.opCount > MaxOpsPerScript {
+		return fmt.Errorf("too many ops (> %d)", MaxOpsPerScript)
+	}
+	return nil
+}
+
+// SigOpCount 這個輸出被花掉時，鎖定腳本最多要驗幾次簽名 (靜態上限，IF 兩邊都算)
+// CHECKMULTISIG 前面是 OP_1..OP_16 就算那麼多次，否則算 MaxPubKeysPerMulti 次；解析不了的腳本花不掉，算 0
+func (out *TxOutput) SigOpCount() int {
+	lock, err := out.LockingScript()
+	if err != nil {
+		return 0
+	}
+	ops, err := parseScript(lock)
+	if err != nil {
+		return 0
+	}
+	count := 0
+	for i, op := range ops {
+		switch op.code {
+		case OpCheckSig, OpCheckSigVerify:
+			count++
+		case OpCheckMultiSig, OpCheckMultiSigVerify:
+			if i > 0 && ops[i-1].code >= Op1 && ops[i-1].code <= Op16 {
+				count += int(ops[i-1].code - Op1 + 1)
+			} else {
+				count += MaxPubKeysPerMulti
+			}
+		}
+	}
+	return count
+}
+
+// checkSig 簽名格式：DER + 1 byte SigHashType (舊版交易沒有 SigHashType，摘要也是舊算法)
+func (e *scriptEngine) checkSig(sigBytes, pubBytes []byte) bool {
+	var digest []byte
+	if e.tx.Version < TxVersion {
+		digest = legacySigHash(e.tx)
+	} else {
+		if len(sigBytes) == 0 {
+			return false
+		}
+		hashType := SigHashType(sigBytes[len(sigBytes)-1])
+		sigBytes = sigBytes[:len(sigBytes)-1]
+
+		var err error
+		if digest, err = e.tx.SignatureHash(e.idx, e.prevOut, hashType); err != nil {
+			return false
+		}
+	}
+
+	sig, err := ecdsa.ParseDERSignature(sigBytes)
+	if err != nil {
+		return false
+	}
+	pub, err := btcec.ParsePubKey(pubBytes)
+	if err != nil {
+		return false
+	}
+	return sig.Verify(digest, pub)
+}
+
+func (e *scriptEngine) push(v []byte) {
+	e.stack = append(e.stack, v)
+}
+
+func (e *scriptEngine) pushBool(v bool) {
+	if v {
+		e.push([]byte{1})
+	} else {
+		e.push(nil)
+	}
+}
+
+func (e *scriptEngine) peek() ([]byte, error) {
+	if len(e.stack) == 0 {
+		return nil, ErrStackUnderflow
+	}
+	return e.stack[len(e.stack)-1], nil
+}
+
+func (e *scriptEngine) pop() ([]byte, error) {
+	v, err := e.peek()
+	if err != nil {
+		return nil, err
+	}
+	e.stack = e.stack[:len(e.stack)-1]
+	return v, nil
+}
+
+func (e *scriptEngine) popInt() (int, error) {
+	v, err := e.pop()
+	if err != nil {
+		return 0, err
+	}
+	num, err := decodeScriptNum(v, scriptNumMaxLen)
+	if err != nil {
+		return 0, err
+	}
+	return int(num), nil
+}
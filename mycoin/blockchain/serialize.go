@@ -0,0 +1,416 @@
+package blockchain
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"mycoin/utils"
+)
+
+// ==========================================
+// 📦 正式的二進位序列化格式 (共識 / 儲存 / P2P 共用)
+// ==========================================
+//
+// Block (blockFormatVersion = 2)
+//   u8      format version
+//   u64     height
+//   varbytes prev hash
+//   i64     timestamp
+//   u32     bits
+//   u64     nonce
+//   varbytes merkle root
+//   varstr  miner
+//   i64     reward
+//   varint  tx count, 接著每筆交易
+//
+// Transaction
+//   u32     tx version
+//   u8      flags (bit0 = coinbase)
+//   [32]    txid (只有格式 1 的區塊、TxVersionLegacy 的交易才帶；讀進來一律重算比對)
+//   varint  input count:  [32]txid u32 index varbytes sig varbytes pubkey varbytes script_sig u32 sequence
+//   varint  output count: i64 amount varstr to varbytes script
+//   u32     locktime
+//
+// 所有整數皆為 little-endian，數量與長度一律用最短的 varint。
+// Hash 不寫進資料，一律由內容重算。JSON 只剩下 RPC / API 顯示用途。
+
+const (
+	blockFormatVersion = 2
+
+	// 格式 1：舊交易會把 txid 寫在資料裡，格式 2 一律重算不再帶
+	blockFormatVersionLegacyID = 1
+
+	txFlagCoinbase = 0x01
+
+	// 防止惡意長度把記憶體吃光
+	maxVarBytes = 1 << 20
+	maxListLen  = 1 << 16
+)
+
+const coinbaseIndex = 0xffffffff
+
+var errNonCanonical = errors.New("non-canonical varint")
+
+// --------------------
+// Block
+// --------------------
+
+func (b *Block) encode(w *bytes.Buffer) {
+	w.WriteByte(blockFormatVersion)
+	putUint64(w, b.Height)
+	putVarBytes(w, b.PrevHash)
+	putUint64(w, uint64(b.Timestamp))
+	putUint32(w, b.Bits)
+	putUint64(w, b.Nonce)
+	putVarBytes(w, b.MerkleRoot)
+	putVarBytes(w, []byte(b.Miner))
+	putUint64(w, uint64(int64(b.Reward)))
+
+	putVarInt(w, uint64(len(b.Transactions)))
+	for i := range b.Transactions {
+		b.Transactions[i].encode(w, false)
+	}
+}
+
+func decodeBlock(r *bytes.Reader) (*Block, error) {
+	ver, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if ver != blockFormatVersion && ver != blockFormatVersionLegacyID {
+		return nil, fmt.Errorf("unknown block format version %d", ver)
+	}
+
+	b := &Block{}
+	if b.Height, err = readUint64(r); err != nil {
+		return nil, err
+	}
+	if b.PrevHash, err = readVarBytes(r); err != nil {
+		return nil, err
+	}
+	ts, err := readUint64(r)
+	if err != nil {
+		return nil, err
+	}
+	b.Timestamp = int64(ts)
+	if b.Bits, err = readUint32(r); err != nil {
+		return nil, err
+	}
+	if b.Nonce, err = readUint64(r); err != nil {
+		return nil, err
+	}
+	if b.MerkleRoot, err = readVarBytes(r); err != nil {
+		return nil, err
+	}
+	miner, err := readVarBytes(r)
+	if err != nil {
+		return nil, err
+	}
+	b.Miner = string(miner)
+	reward, err := readUint64(r)
+	if err != nil {
+		return nil, err
+	}
+	b.Reward = int(int64(reward))
+
+	count, err := readListLen(r)
+	if err != nil {
+		return nil, err
+	}
+	b.Transactions = make([]Transaction, 0, count)
+	for i := 0; i < count; i++ {
+		tx, err := decodeTransaction(r, ver == blockFormatVersionLegacyID)
+		if err != nil {
+			return nil, fmt.Errorf("tx %d: %w", i, err)
+		}
+		b.Transactions = append(b.Transactions, *tx)
+	}
+
+	// 🔥 Target 一律從 Bits 還原，Hash 一律由標頭重算，不信任任何外部欄位
+	b.Target = utils.CompactToBig(b.Bits)
+	b.Hash = b.CalcHash()
+	return b, nil
+}
+
+// --------------------
+// Transaction
+// --------------------
+
+// encode 寫出交易；forID=true 時清空一般交易的簽名與公鑰 (用於 txid / 簽名摘要)
+// txid 不寫進資料，舊交易也一樣用 legacyCalcID 重算
+func (tx *Transaction) encode(w *bytes.Buffer, forID bool) {
+	putUint32(w, uint32(tx.Version))
+
+	var flags byte
+	if tx.IsCoinbase {
+		flags |= txFlagCoinbase
+	}
+	w.WriteByte(flags)
+
+	putVarInt(w, uint64(len(tx.Inputs)))
+	for _, in := range tx.Inputs {
+		putHash(w, in.TxID)
+		if in.Index < 0 {
+			putUint32(w, coinbaseIndex)
+		} else {
+			putUint32(w, uint32(in.Index))
+		}
+
+		if forID && !tx.IsCoinbase {
+			putVarBytes(w, nil)
+			putVarBytes(w, nil)
+			putVarBytes(w, nil)
+		} else {
+			putVarBytes(w, fieldBytes(in.Sig, tx.IsCoinbase))
+			putVarBytes(w, fieldBytes(in.PubKey, tx.IsCoinbase))
+			putVarBytes(w, in.ScriptSig)
+		}
+		putUint32(w, in.Sequence)
+	}
+
+	putVarInt(w, uint64(len(tx.Outputs)))
+	for _, out := range tx.Outputs {
+		putUint64(w, uint64(int64(out.Amount)))
+		putVarBytes(w, []byte(out.To))
+		putVarBytes(w, out.Script)
+	}
+
+	putUint32(w, tx.LockTime)
+}
+
+// decodeTransaction 讀一筆交易；legacyID=true 表示資料來自格式 1，舊交易後面跟著一個 txid
+func decodeTransaction(r *bytes.Reader, legacyID bool) (*Transaction, error) {
+	ver, err := readUint32(r)
+	if err != nil {
+		return nil, err
+	}
+	if ver != TxVersionLegacy && ver != TxVersion {
+		return nil, fmt.Errorf("unknown tx version %d", ver)
+	}
+
+	flags, err := r.ReadByte()
+	if err != nil {
+		return nil, err
+	}
+	if flags&^txFlagCoinbase != 0 {
+		return nil, fmt.Errorf("unknown tx flags %02x", flags)
+	}
+
+	tx := &Transaction{
+		Version:    int32(ver),
+		IsCoinbase: flags&txFlagCoinbase != 0,
+	}
+
+	var claimedID string
+	if legacyID && tx.Version < TxVersion {
+		if claimedID, err = readHash(r); err != nil {
+			return nil, err
+		}
+	}
+
+	inCount, err := readListLen(r)
+	if err != nil {
+		return nil, err
+	}
+	tx.Inputs = make([]TxInput, 0, inCount)
+	for i := 0; i < inCount; i++ {
+		var in TxInput
+		if in.TxID, err = readHash(r); err != nil {
+			return nil, err
+		}
+		idx, err := readUint32(r)
+		if err != nil {
+			return nil, err
+		}
+		if idx == coinbaseIndex {
+			in.Index = -1
+		} else {
+			in.Index = int(idx)
+		}
+
+		sig, err := readVarBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		pub, err := readVarBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		in.Sig = fieldString(sig, tx.IsCoinbase)
+		in.PubKey = fieldString(pub, tx.IsCoinbase)
+		if in.ScriptSig, err = readScript(r); err != nil {
+			return nil, err
+		}
+		if in.Sequence, err = readUint32(r); err != nil {
+			return nil, err
+		}
+
+		tx.Inputs = append(tx.Inputs, in)
+	}
+
+	outCount, err := readListLen(r)
+	if err != nil {
+		return nil, err
+	}
+	tx.Outputs = make([]TxOutput, 0, outCount)
+	for i := 0; i < outCount; i++ {
+		amount, err := readUint64(r)
+		if err != nil {
+			return nil, err
+		}
+		to, err := readVarBytes(r)
+		if err != nil {
+			return nil, err
+		}
+		script, err := readScript(r)
+		if err != nil {
+			return nil, err
+		}
+		tx.Outputs = append(tx.Outputs, TxOutput{Amount: int(int64(amount)), To: string(to), Script: script})
+	}
+
+	if tx.LockTime, err = readUint32(r); err != nil {
+		return nil, err
+	}
+
+	// 🔥 txid 一律由內容重算；格式 1 帶來的 txid 只拿來比對，對不上就是被竄改過
+	tx.CalcID()
+	if legacyID && tx.Version < TxVersion && claimedID != tx.ID {
+		return nil, fmt.Errorf("legacy txid mismatch: claimed %s, computed %s", claimedID, tx.ID)
+	}
+	return tx, nil
+}
+
+// 一般交易的 Sig / PubKey 是 hex，存成原始 bytes；Coinbase 的是任意字串，原樣保存
+// 不是 hex 的一般交易會被 CheckInputs 拒收，這裡的退路只用來算還沒驗證的交易
+func fieldBytes(s string, coinbase bool) []byte {
+	if !coinbase {
+		if raw, err := hex.DecodeString(s); err == nil {
+			return raw
+		}
+	}
+	return []byte(s)
+}
+
+func fieldString(b []byte, coinbase bool) string {
+	if coinbase {
+		return string(b)
+	}
+	return hex.EncodeToString(b)
+}
+
+// --------------------
+// 基本型別
+// --------------------
+
+func putUint32(w *bytes.Buffer, v uint32) {
+	var buf [4]byte
+	binary.LittleEndian.PutUint32(buf[:], v)
+	w.Write(buf[:])
+}
+
+func putUint64(w *bytes.Buffer, v uint64) {
+	var buf [8]byte
+	binary.LittleEndian.PutUint64(buf[:], v)
+	w.Write(buf[:])
+}
+
+func putVarInt(w *bytes.Buffer, v uint64) {
+	var buf [binary.MaxVarintLen64]byte
+	n := binary.PutUvarint(buf[:], v)
+	w.Write(buf[:n])
+}
+
+func putVarBytes(w *bytes.Buffer, b []byte) {
+	putVarInt(w, uint64(len(b)))
+	w.Write(b)
+}
+
+// putHash 寫入 32 bytes 的交易 ID；空字串 (Coinbase 的來源) 寫成全 0
+// 其他長度或不是 hex 的 ID 由 CheckInputs 拒收，不會上鏈
+func putHash(w *bytes.Buffer, hexID string) {
+	var buf [32]byte
+	if raw, err := hex.DecodeString(hexID); err == nil && len(raw) == 32 {
+		copy(buf[:], raw)
+	}
+	w.Write(buf[:])
+}
+
+func readUint32(r *bytes.Reader) (uint32, error) {
+	var buf [4]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint32(buf[:]), nil
+}
+
+func readUint64(r *bytes.Reader) (uint64, error) {
+	var buf [8]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return 0, err
+	}
+	return binary.LittleEndian.Uint64(buf[:]), nil
+}
+
+// readVarInt 只接受最短編碼，確保同一份資料只有一種 bytes 表示
+func readVarInt(r *bytes.Reader) (uint64, error) {
+	before := r.Len()
+	v, err := binary.ReadUvarint(r)
+	if err != nil {
+		return 0, err
+	}
+	var buf [binary.MaxVarintLen64]byte
+	if before-r.Len() != binary.PutUvarint(buf[:], v) {
+		return 0, errNonCanonical
+	}
+	return v, nil
+}
+
+func readListLen(r *bytes.Reader) (int, error) {
+	n, err := readVarInt(r)
+	if err != nil {
+		return 0, err
+	}
+	if n > maxListLen {
+		return 0, fmt.Errorf("list too long: %d", n)
+	}
+	return int(n), nil
+}
+
+func readVarBytes(r *bytes.Reader) ([]byte, error) {
+	n, err := readVarInt(r)
+	if err != nil {
+		return nil, err
+	}
+	if n > maxVarBytes || n > uint64(r.Len()) {
+		return nil, fmt.Errorf("invalid length %d", n)
+	}
+	buf := make([]byte, n)
+	if _, err := io.ReadFull(r, buf); err != nil {
+		return nil, err
+	}
+	return buf, nil
+}
+
+// readScript 空腳本還原成 nil，讓 JSON 的 omitempty 與重新序列化都保持一致
+func readScript(r *bytes.Reader) ([]byte, error) {
+	b, err := readVarBytes(r)
+	if err != nil || len(b) == 0 {
+		return nil, err
+	}
+	return b, nil
+}
+
+func readHash(r *bytes.Reader) (string, error) {
+	var buf [32]byte
+	if _, err := io.ReadFull(r, buf[:]); err != nil {
+		return "", err
+	}
+	if buf == ([32]byte{}) {
+		return "", nil
+	}
+	return hex.EncodeToString(buf[:]), nil
+}
@@ -0,0 +1,120 @@
+package blockchain
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+)
+
+// SigHashType 簽名最後 1 byte，決定這個簽名「承諾」了交易的哪些部分
+type SigHashType byte
+
+const (
+	SigHashAll    SigHashType = 0x01 // 所有輸入 + 所有輸出
+	SigHashNone   SigHashType = 0x02 // 所有輸入，輸出隨便別人改
+	SigHashSingle SigHashType = 0x03 // 所有輸入 + 同位置的那一個輸出
+
+	// 只承諾自己這個輸入，其他人可以再加輸入 (群眾募資)
+	SigHashAnyoneCanPay SigHashType = 0x80
+)
+
+func (t SigHashType) base() SigHashType {
+	return t &^ SigHashAnyoneCanPay
+}
+
+func (t SigHashType) valid() bool {
+	b := t.base()
+	return b == SigHashAll || b == SigHashNone || b == SigHashSingle
+}
+
+// SignatureHash 第 idx 個輸入要簽的 32 bytes 摘要
+//
+// 摘要內容 (二進位，最後做兩次 SHA256)：
+//
+//	u32 tx version, u8 flags, u32 hash type
+//	輸入：ANYONECANPAY 時只有自己 ([32]txid u32 index u32 sequence)，否則全部輸入 + 自己的位置
+//	      (NONE / SINGLE 時別人的 sequence 寫 0，讓他們可以自己改)
+//	被花掉的輸出：i64 amount + varbytes 鎖定腳本
+//	輸出：ALL 全部 / NONE 不含 / SINGLE 只有同位置那一個
+//	u32 locktime
+//
+// 簽名、公鑰、ScriptSig 一律不在摘要裡。
+func (tx *Transaction) SignatureHash(idx int, prevOut TxOutput, hashType SigHashType) ([]byte, error) {
+	if idx < 0 || idx >= len(tx.Inputs) {
+		return nil, fmt.Errorf("input index %d out of range", idx)
+	}
+	if !hashType.valid() {
+		return nil, fmt.Errorf("unknown sighash type 0x%02x", byte(hashType))
+	}
+	if hashType.base() == SigHashSingle && idx >= len(tx.Outputs) {
+		return nil, fmt.Errorf("SIGHASH_SINGLE: no output at index %d", idx)
+	}
+
+	lock, err := prevOut.LockingScript()
+	if err != nil {
+		return nil, err
+	}
+
+	var w bytes.Buffer
+	putUint32(&w, uint32(tx.Version))
+	if tx.IsCoinbase {
+		w.WriteByte(txFlagCoinbase)
+	} else {
+		w.WriteByte(0)
+	}
+	putUint32(&w, uint32(hashType))
+
+	if hashType&SigHashAnyoneCanPay != 0 {
+		putVarInt(&w, 1)
+		putOutPoint(&w, tx.Inputs[idx])
+		putUint32(&w, tx.Inputs[idx].Sequence)
+	} else {
+		putVarInt(&w, uint64(len(tx.Inputs)))
+		for i, in := range tx.Inputs {
+			putOutPoint(&w, in)
+			if i != idx && hashType.base() != SigHashAll {
+				putUint32(&w, 0)
+			} else {
+				putUint32(&w, in.Sequence)
+			}
+		}
+		putUint32(&w, uint32(idx))
+	}
+
+	putUint64(&w, uint64(int64(prevOut.Amount)))
+	putVarBytes(&w, lock)
+
+	switch hashType.base() {
+	case SigHashAll:
+		putVarInt(&w, uint64(len(tx.Outputs)))
+		for _, out := range tx.Outputs {
+			putOutput(&w, out)
+		}
+	case SigHashNone:
+		putVarInt(&w, 0)
+	case SigHashSingle:
+		putVarInt(&w, 1)
+		putOutput(&w, tx.Outputs[idx])
+	}
+
+	putUint32(&w, tx.LockTime)
+
+	first := sha256.Sum256(w.Bytes())
+	second := sha256.Sum256(first[:])
+	return second[:], nil
+}
+
+func putOutPoint(w *bytes.Buffer, in TxInput) {
+	putHash(w, in.TxID)
+	if in.Index < 0 {
+		putUint32(w, coinbaseIndex)
+	} else {
+		putUint32(w, uint32(in.Index))
+	}
+}
+
+func putOutput(w *bytes.Buffer, out TxOutput) {
+	putUint64(w, uint64(int64(out.Amount)))
+	putVarBytes(w, []byte(out.To))
+	putVarBytes(w, out.Script)
+}
@@ -0,0 +1,226 @@
+package blockchain
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// ==========================================
+// 📸 UTXO 快照檔 (dumptxoutset / loadtxoutset)
+// ==========================================
+//
+//   [8]     magic "mycutxo" + 格式版本
+//   u32     網路 magic (Params.Net)
+//   u64     區塊頭數量，接著每個區塊頭：u32 長度 + 只有標頭的 Block 序列化
+//           (創世塊到快照區塊，新節點不必先跟別人要標頭就能驗證這條鏈)
+//   u64     UTXO 筆數，接著每筆：u32 長度 + UTXO 記錄 (欄位同 BlockUndo)
+//   [32]    內容雜湊 = sha256(所有 UTXO 的「長度 + 記錄」，依 key 排序)
+//
+// 快照對應的區塊就是最後一個區塊頭。內容雜湊只跟帳本內容有關，
+// 背景驗證重放到同一高度時，用 UTXOSetHash 算出來的值必須一模一樣。
+
+var snapshotMagic = [8]byte{'m', 'y', 'c', 'u', 't', 'x', 'o', 1}
+
+// maxSnapshotRecord 單筆區塊頭 / UTXO 記錄的長度上限
+const maxSnapshotRecord = 1 << 20
+
+var (
+	// ErrSnapshotFormat 不是 UTXO 快照，或是別的網路的快照
+	ErrSnapshotFormat = errors.New("not a UTXO snapshot for this network")
+	// ErrSnapshotHash 內容跟檔尾記錄的雜湊對不上 (檔案損毀或被改過)
+	ErrSnapshotHash = errors.New("snapshot content hash mismatch")
+)
+
+// SnapshotMeta 快照對應的區塊與帳本摘要
+type SnapshotMeta struct {
+	BlockHash string `json:"blockhash"`
+	Height    uint64 `json:"height"`
+	Count     uint64 `json:"count"`     // UTXO 筆數
+	UTXOHash  string `json:"utxo_hash"` // 內容雜湊 (hex)
+}
+
+// sortedUTXOs 整本帳依 key 排序 (內容雜湊要跟走訪順序無關)
+func sortedUTXOs(u *UTXOSet) []UTXO {
+	var utxos []UTXO
+	u.ForEach(func(key string, utxo UTXO) {
+		utxos = append(utxos, utxo)
+	})
+	sort.Slice(utxos, func(i, j int) bool {
+		return utxoKey(utxos[i].TxID, utxos[i].Index) < utxoKey(utxos[j].TxID, utxos[j].Index)
+	})
+	return utxos
+}
+
+// UTXOSetHash 整本帳的內容雜湊與筆數 (跟快照檔尾的雜湊同一種算法)
+func UTXOSetHash(u *UTXOSet) (string, uint64) {
+	h := sha256.New()
+	utxos := sortedUTXOs(u)
+	var rec bytes.Buffer
+	for _, utxo := range utxos {
+		rec.Reset()
+		encodeUTXO(&rec, utxo)
+		writeFrame(h, rec.Bytes())
+	}
+	return hex.EncodeToString(h.Sum(nil)), uint64(len(utxos))
+}
+
+// WriteUTXOSnapshot 把帳本寫成快照；headers 是創世塊到快照區塊的區塊頭 (最後一個就是快照區塊)
+// 帳本 u 必須正好是接上 headers 最後一塊之後的狀態
+func WriteUTXOSnapshot(w io.Writer, net uint32, headers []*Block, u *UTXOSet) (*SnapshotMeta, error) {
+	if len(headers) == 0 {
+		return nil, errors.New("snapshot needs at least the genesis header")
+	}
+	tip := headers[len(headers)-1]
+
+	bw := bufio.NewWriter(w)
+	bw.Write(snapshotMagic[:])
+	binary.Write(bw, binary.LittleEndian, net)
+
+	binary.Write(bw, binary.LittleEndian, uint64(len(headers)))
+	for _, hdr := range headers {
+		header := *hdr
+		header.Transactions = nil
+		writeFrame(bw, header.Serialize())
+	}
+
+	utxos := sortedUTXOs(u)
+	binary.Write(bw, binary.LittleEndian, uint64(len(utxos)))
+	h := sha256.New()
+	var rec bytes.Buffer
+	for _, utxo := range utxos {
+		rec.Reset()
+		encodeUTXO(&rec, utxo)
+		writeFrame(bw, rec.Bytes())
+		writeFrame(h, rec.Bytes())
+	}
+	sum := h.Sum(nil)
+	bw.Write(sum)
+	if err := bw.Flush(); err != nil {
+		return nil, err
+	}
+
+	return &SnapshotMeta{
+		BlockHash: hex.EncodeToString(tip.Hash),
+		Height:    tip.Height,
+		Count:     uint64(len(utxos)),
+		UTXOHash:  hex.EncodeToString(sum),
+	}, nil
+}
+
+// ReadUTXOSnapshot 讀快照：區塊頭、UTXO 依序交給回呼，讀完核對內容雜湊
+// 雜湊對不上回傳 ErrSnapshotHash —— 呼叫端要等這裡成功才能把讀到的東西寫進帳本
+func ReadUTXOSnapshot(r io.Reader, net uint32, onHeader func(*Block) error, onUTXO func(UTXO) error) (*SnapshotMeta, error) {
+	br := bufio.NewReader(r)
+
+	var magic [8]byte
+	var fileNet uint32
+	if _, err := io.ReadFull(br, magic[:]); err != nil || magic != snapshotMagic {
+		return nil, ErrSnapshotFormat
+	}
+	if err := binary.Read(br, binary.LittleEndian, &fileNet); err != nil || fileNet != net {
+		return nil, ErrSnapshotFormat
+	}
+
+	var headerCount uint64
+	if err := binary.Read(br, binary.LittleEndian, &headerCount); err != nil {
+		return nil, err
+	}
+	if headerCount == 0 {
+		return nil, errors.New("snapshot has no headers")
+	}
+	var tip *Block
+	for i := uint64(0); i < headerCount; i++ {
+		data, err := readFrame(br, maxSnapshotRecord)
+		if err != nil {
+			return nil, fmt.Errorf("header %d: %w", i, err)
+		}
+		hdr, err := DeserializeBlock(data)
+		if err != nil {
+			return nil, fmt.Errorf("header %d: %w", i, err)
+		}
+		if len(hdr.Transactions) != 0 {
+			return nil, fmt.Errorf("header %d carries transactions", i)
+		}
+		if err := onHeader(hdr); err != nil {
+			return nil, err
+		}
+		tip = hdr
+	}
+
+	var count uint64
+	if err := binary.Read(br, binary.LittleEndian, &count); err != nil {
+		return nil, err
+	}
+	h := sha256.New()
+	for i := uint64(0); i < count; i++ {
+		data, err := readFrame(br, maxSnapshotRecord)
+		if err != nil {
+			return nil, fmt.Errorf("utxo %d: %w", i, err)
+		}
+		writeFrame(h, data)
+
+		rec := bytes.NewReader(data)
+		utxo, err := decodeUTXO(rec)
+		if err == nil && rec.Len() != 0 {
+			err = errors.New("trailing bytes")
+		}
+		if err != nil {
+			return nil, fmt.Errorf("utxo %d: %w", i, err)
+		}
+		if err := onUTXO(utxo); err != nil {
+			return nil, err
+		}
+	}
+
+	var sum [32]byte
+	if _, err := io.ReadFull(br, sum[:]); err != nil {
+		return nil, fmt.Errorf("content hash: %w", err)
+	}
+	if !bytes.Equal(sum[:], h.Sum(nil)) {
+		return nil, ErrSnapshotHash
+	}
+	if _, err := br.ReadByte(); err != io.EOF {
+		return nil, errors.New("trailing data after snapshot")
+	}
+
+	return &SnapshotMeta{
+		BlockHash: hex.EncodeToString(tip.Hash),
+		Height:    tip.Height,
+		Count:     count,
+		UTXOHash:  hex.EncodeToString(sum[:]),
+	}, nil
+}
+
+func writeFrame(w io.Writer, data []byte) {
+	var n [4]byte
+	binary.LittleEndian.PutUint32(n[:], uint32(len(data)))
+	w.Write(n[:])
+	w.Write(data)
+}
+
+// readFrame 讀一筆「u32 長度 + 內容」；剛好在記錄邊界讀完回傳 io.EOF，斷在記錄中間是 io.ErrUnexpectedEOF
+func readFrame(r io.Reader, max uint32) ([]byte, error) {
+	var n [4]byte
+	if _, err := io.ReadFull(r, n[:]); err != nil {
+		return nil, err
+	}
+	size := binary.LittleEndian.Uint32(n[:])
+	if size > max {
+		return nil, fmt.Errorf("record too long: %d", size)
+	}
+	data := make([]byte, size)
+	if _, err := io.ReadFull(r, data); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, err
+	}
+	return data, nil
+}
@@ -0,0 +1,54 @@
+package blockchain
+
+// SubsidySchedule 挖礦獎勵規則：每 HalvingInterval 個區塊減半，減到 0 為止，總量因此有上限
+type SubsidySchedule struct {
+	GenesisReward   int    // 創世區塊的獎勵 (YiCent)
+	BaseSubsidy     int    // 高度 1 開始的每塊獎勵 (YiCent)
+	HalvingInterval uint64 // 幾個區塊減半一次
+}
+
+// MaxMoney 任何金額 (單一輸出或總和) 都不可能超過的上限 (以主網的總量為準)
+var MaxMoney = MainNetParams.Subsidy.MaxSupply()
+
+// BlockSubsidy 這個高度的區塊可以憑空產生多少錢 (不含手續費)
+func (s SubsidySchedule) BlockSubsidy(height uint64) int {
+	if height == 0 {
+		return s.GenesisReward
+	}
+	halvings := (height - 1) / s.HalvingInterval
+	if halvings >= 63 {
+		return 0
+	}
+	return s.BaseSubsidy >> halvings
+}
+
+// IssuedSupply 從創世到 height (含) 為止，規則允許產生的總量
+func (s SubsidySchedule) IssuedSupply(height uint64) int {
+	total := s.GenesisReward
+	for start := uint64(1); start <= height; start += s.HalvingInterval {
+		subsidy := s.BlockSubsidy(start)
+		if subsidy == 0 {
+			break
+		}
+		blocks := s.HalvingInterval
+		if height-start+1 < blocks {
+			blocks = height - start + 1
+		}
+		total += subsidy * int(blocks)
+	}
+	return total
+}
+
+// MaxSupply 獎勵減到 0 之後的總量
+func (s SubsidySchedule) MaxSupply() int {
+	total := s.GenesisReward
+	for subsidy := s.BaseSubsidy; subsidy > 0; subsidy >>= 1 {
+		total += subsidy * int(s.HalvingInterval)
+	}
+	return total
+}
+
+// MoneyRange 金額是否落在 [0, MaxMoney]
+func MoneyRange(amount int) bool {
+	return amount >= 0 && amount <= MaxMoney
+}
@@ -1,12 +1,12 @@
 package blockchain
 
 import (
+	"bytes"
 	"crypto/sha256"
-	"encoding/binary"
 	"encoding/hex"
-	"encoding/json"
+	"errors"
 	"fmt"
-	"time"
+	"strings"
 
 	"github.com/btcsuite/btcd/btcec/v2"
 	ecdsa "github.com/btcsuite/btcd/btcec/v2/ecdsa"
@@ -18,21 +18,88 @@ type TxInput struct {
 	Index  int    `json:"index"`  // 👈 對齊比特幣標準
 	Sig    string `json:"sig"`    // 👈 簽名
 	PubKey string `json:"pubkey"` // 👈 公鑰
+
+	// ⏳ 相對時間鎖 (見 locktime.go)；SequenceFinal 代表不鎖
+	Sequence uint32 `json:"sequence"`
+
+	// 📜 自訂解鎖腳本 (多簽、Hash 鎖…)；空的時候等同 <Sig> <PubKey> 的 P2PKH 解鎖
+	ScriptSig []byte `json:"script_sig,omitempty"`
 }
 
 // UTXO output
 type TxOutput struct {
 	Amount int    `json:"amount"` // 👈 存的是 YiCent (整數)
-	To     string `json:"to"`     // 👈 收款地址
+	To     string `json:"to"`     // 👈 收款地址 (有 Script 時是 ExtractAddress 算出來的索引地址)
+
+	// 📜 鎖定腳本；空的時候等同付給 To 的 P2PKH
+	Script []byte `json:"script,omitempty"`
+}
+
+// NewScriptOutput 建立帶自訂鎖定腳本的輸出 (託管、多簽、時間鎖…)
+func NewScriptOutput(amount int, script []byte) TxOutput {
+	return TxOutput{
+		Amount: amount,
+		To:     ExtractAddress(script),
+		Script: append([]byte(nil), script...),
+	}
+}
+
+// LockingScript 這個輸出真正的花費條件
+func (out *TxOutput) LockingScript() ([]byte, error) {
+	if len(out.Script) > 0 {
+		return out.Script, nil
+	}
+	return PayToAddressScript(out.To)
+}
+
+// UnlockingScript 這個輸入提交的解鎖腳本
+func (in *TxInput) UnlockingScript() ([]byte, error) {
+	if len(in.ScriptSig) > 0 {
+		return in.ScriptSig, nil
+	}
+	if in.Sig == "" {
+		return nil, fmt.Errorf("input %s_%d is not signed", in.TxID, in.Index)
+	}
+
+	sig, err := hex.DecodeString(in.Sig)
+	if err != nil {
+		return nil, fmt.Errorf("invalid sig hex: %v", err)
+	}
+	pub, err := hex.DecodeString(in.PubKey)
+	if err != nil {
+		return nil, fmt.Errorf("invalid pubkey hex: %v", err)
+	}
+	return PushOnlyScript(sig, pub), nil
+}
+
+const (
+	// 遷移前的舊交易：txid 與簽名摘要都是用 JSON 算的，ID 必須跟著資料一起保存
+	TxVersionLegacy = 1
+	// 目前版本：txid 與簽名摘要都由二進位序列化計算
+	TxVersion = 2
+)
+
+var ErrLegacyTx = errors.New("legacy tx version no longer accepted")
+
+// CheckVersion 舊交易的簽名摘要不承諾腳本、sequence 與金額，只准出現在 p.LegacyTxHeight 以前的區塊
+func (tx *Transaction) CheckVersion(height uint64, p *Params) error {
+	if tx.Version < TxVersion && height > p.LegacyTxHeight {
+		return fmt.Errorf("%w (height %d > %d)", ErrLegacyTx, height, p.LegacyTxHeight)
+	}
+	return nil
 }
 
 // Transaction
 type Transaction struct {
 	// 🚀 加入 JSON Tags，確保與前端/API 格式完全對齊
 	ID         string     `json:"txid"`
+	Version    int32      `json:"version"`
 	Inputs     []TxInput  `json:"vin"`
 	Outputs    []TxOutput `json:"vout"`
 	IsCoinbase bool       `json:"is_coinbase"`
+
+	// ⏳ 絕對時間鎖：< LockTimeThreshold 是區塊高度，否則是 Unix 時間；0 代表不鎖
+	LockTime uint32 `json:"locktime"`
 }
 
 type TxIndexEntry struct {
@@ -44,9 +111,11 @@ type TxIndexEntry struct {
 
 // 计算交易ID（只用未签名数据）
 func (tx *Transaction) CalcID() {
-	data, _ := json.Marshal(tx.cloneWithoutSign())
-	hash := sha256.Sum256(data)
-	tx.ID = hex.EncodeToString(hash[:])
+	if tx.Version < TxVersion {
+		tx.ID = legacyCalcID(tx)
+		return
+	}
+	tx.ID = HashTxBytes(tx.serializeForID())
 }
 
 func HashTxBytes(b []byte) string {
@@ -54,134 +123,178 @@ func HashTxBytes(b []byte) string {
 	return hex.EncodeToString(h[:])
 }
 
-// 签名交易
-// 請在 transaction.go 裡面修改！
-func (tx *Transaction) Sign(priv *btcec.PrivateKey) error {
+// 签名交易 (P2PKH，每個輸入都用 SIGHASH_ALL)
+// prevOuts[i] 是第 i 個輸入花掉的那個輸出，簽名會承諾它的金額與鎖定腳本
+func (tx *Transaction) Sign(priv *btcec.PrivateKey, prevOuts []TxOutput) error {
 	if tx.IsCoinbase {
 		return nil
 	}
+	if len(prevOuts) != len(tx.Inputs) {
+		return fmt.Errorf("have %d prevouts for %d inputs", len(prevOuts), len(tx.Inputs))
+	}
 
 	// 🚀 1. 關鍵新增：直接從傳進來的私鑰，推導出公鑰的 Hex 字串
 	pubKeyHex := hex.EncodeToString(priv.PubKey().SerializeCompressed())
 
 	for i := range tx.Inputs {
-		// 🚀 2. 關鍵新增：在算 Hash 之前，先把真正的公鑰塞進 Input 裡！
-		tx.Inputs[i].PubKey = pubKeyHex
-
-		data := tx.IDForSig(i) // 待签名摘要
-		hash := sha256.Sum256(data)
-
-		// ⭐ 正确的签名函数（btcec/v2）
-		sig := ecdsa.Sign(priv, hash[:])
+		sig, err := tx.SignInput(i, priv, prevOuts[i], SigHashAll)
+		if err != nil {
+			return fmt.Errorf("input %d: %v", i, err)
+		}
 
+		tx.Inputs[i].PubKey = pubKeyHex
 		// ⭐ Sig 是 string，所以转 hex
-		tx.Inputs[i].Sig = hex.EncodeToString(sig.Serialize())
+		tx.Inputs[i].Sig = hex.EncodeToString(sig)
 	}
 
 	return nil
 }
 
-// 验证交易签名
-func (tx *Transaction) Verify() bool {
-	if tx.IsCoinbase {
-		return true
+// SignInput 只產生第 idx 個輸入的簽名 (DER + 1 byte SigHashType)，不動交易本身
+// 多簽 / 群眾募資時每個人各自簽，再組 ScriptSig
+func (tx *Transaction) SignInput(idx int, priv *btcec.PrivateKey, prevOut TxOutput, hashType SigHashType) ([]byte, error) {
+	digest, err := tx.SignatureHash(idx, prevOut, hashType)
+	if err != nil {
+		return nil, err
 	}
 
-	for i, in := range tx.Inputs {
-		// 1️⃣ 构造与签名时完全一致的摘要
-		data := tx.IDForSig(i)
-		hash := sha256.Sum256(data)
+	// ⭐ 正确的签名函数（btcec/v2）
+	sig := ecdsa.Sign(priv, digest)
+	return append(sig.Serialize(), byte(hashType)), nil
+}
 
-		// 2️⃣ 解析 DER 签名（hex → bytes → signature）
-		sigBytes, err := hex.DecodeString(in.Sig)
-		if err != nil {
-			return false
-		}
+// Verify 對每個輸入執行「解鎖腳本 + 所花費輸出的鎖定腳本」
+// prevOuts[i] 是第 i 個輸入花掉的那個輸出
+func (tx *Transaction) Verify(prevOuts []TxOutput) error {
+	if tx.IsCoinbase {
+		return nil
+	}
+	if len(prevOuts) != len(tx.Inputs) {
+		return fmt.Errorf("have %d prevouts for %d inputs", len(prevOuts), len(tx.Inputs))
+	}
 
-		sig, err := ecdsa.ParseDERSignature(sigBytes)
+	for i := range tx.Inputs {
+		unlock, err := tx.Inputs[i].UnlockingScript()
 		if err != nil {
-			return false
+			return fmt.Errorf("input %d: %v", i, err)
 		}
-
-		// 3️⃣ 解析公钥
-		pubKeyBytes, err := hex.DecodeString(in.PubKey)
-		if err != nil {
-			return false
+		if err := VerifyScript(unlock, prevOuts[i], tx, i); err != nil {
+			return fmt.Errorf("input %d: %w", i, err)
 		}
+	}
 
-		pubKey, err := btcec.ParsePubKey(pubKeyBytes)
-		if err != nil {
-			return false
-		}
+	return nil
+}
 
-		// 4️⃣ 验签（注意：用 hash，不是 data）
-		if !sig.Verify(hash[:], pubKey) {
-			return false
+// CheckInputs 輸入欄位必須能原封不動地寫成二進位格式 (txid 與簽名摘要都由它計算)：
+// 版本已知、來源 txid 是 32 bytes 的小寫 hex、index 在 u32 範圍內、Sig / PubKey 是小寫 hex。
+// 不然兩筆內容不同的交易會序列化成同一份 bytes、算出同一個 txid
+// Coinbase 的輸入由 CheckCoinbase 檢查 (Sig / PubKey 是任意字串，原樣保存)
+func (tx *Transaction) CheckInputs() error {
+	if tx.Version != TxVersionLegacy && tx.Version != TxVersion {
+		return fmt.Errorf("unknown tx version %d", tx.Version)
+	}
+	if tx.IsCoinbase {
+		return nil
+	}
+	for i, in := range tx.Inputs {
+		if len(in.TxID) != 64 || !canonicalHex(in.TxID) || in.TxID == strings.Repeat("0", 64) {
+			return fmt.Errorf("input %d: malformed txid %q", i, in.TxID)
+		}
+		if in.Index < 0 || int64(in.Index) >= coinbaseIndex {
+			return fmt.Errorf("input %d: index %d out of range", i, in.Index)
+		}
+		if !canonicalHex(in.Sig) {
+			return fmt.Errorf("input %d: sig is not lowercase hex", i)
+		}
+		if !canonicalHex(in.PubKey) {
+			return fmt.Errorf("input %d: pubkey is not lowercase hex", i)
 		}
 	}
+	return nil
+}
 
-	return true
+// canonicalHex s 是小寫 hex (解碼再編碼回來一模一樣)
+func canonicalHex(s string) bool {
+	raw, err := hex.DecodeString(s)
+	return err == nil && hex.EncodeToString(raw) == s
 }
 
-// 增加一個 genesisData 參數
-func NewCoinbase(to string, reward int, genesisData string) *Transaction {
-	var sig string
+// CheckOutputs 每個輸出都必須解得出鎖定腳本，且 To 要跟腳本對得上 (AddrIndex 靠 To 建索引)
+func (tx *Transaction) CheckOutputs() error {
+	total := 0
+	for i, out := range tx.Outputs {
+		if out.Amount < 0 {
+			return fmt.Errorf("output %d: negative amount", i)
+		}
+		// 💰 單筆和總和都不能超過總量上限 (也順便擋掉加總溢位)
+		if !MoneyRange(out.Amount) {
+			return fmt.Errorf("output %d: amount above max money", i)
+		}
+		total += out.Amount
+		if !MoneyRange(total) {
+			return fmt.Errorf("output %d: total amount above max money", i)
+		}
+		if len(out.Script) == 0 {
+			if _, err := AddressToPubKeyHash(out.To); err != nil {
+				return fmt.Errorf("output %d: %v", i, err)
+			}
+			continue
+		}
+		if len(out.Script) > MaxScriptSize {
+			return fmt.Errorf("output %d: script too large", i)
+		}
+		if out.To != ExtractAddress(out.Script) {
+			return fmt.Errorf("output %d: address %s does not match script", i, out.To)
+		}
+	}
+	return nil
+}
 
-	// 🚀 關鍵判斷：如果有傳入創世字串，就用固定的！否則就用時間戳！
-	if genesisData != "" {
-		sig = genesisData
-	} else {
-		sig = fmt.Sprintf("%d", time.Now().UnixNano())
+// NewCoinbase 高度寫進 Coinbase 的解鎖腳本 (<height> [extra])，
+// 不同高度的 Coinbase 內容一定不同，ID 因此唯一且可重算，不再靠時間戳
+func NewCoinbase(to string, reward int, height uint64, extra string) *Transaction {
+	items := [][]byte{encodeScriptNum(int64(height))}
+	if extra != "" {
+		items = append(items, []byte(extra))
 	}
 
 	dummyInput := TxInput{
-		TxID:   "",
-		Index:  -1,
-		Sig:    sig, // 使用剛剛判斷好的 sig
-		PubKey: "Coinbase",
+		TxID:      "",
+		Index:     -1,
+		ScriptSig: PushOnlyScript(items...),
 	}
 
 	tx := &Transaction{
-		Inputs: []TxInput{dummyInput},
+		Version: TxVersion,
+		Inputs:  []TxInput{dummyInput},
 		Outputs: []TxOutput{
 			{Amount: reward, To: to},
 		},
 		IsCoinbase: true,
 	}
 
-	tx.ID = tx.DeterministicID()
+	tx.CalcID()
 	return tx
 }
 
-// 签名数据（只用未签名交易）
-func (tx *Transaction) IDForSig(idx int) []byte {
-	tmp := tx.cloneWithoutSign()
-	data, _ := json.Marshal(tmp)
-	fmt.Printf("\n🕵️ [Debug] IDForSig 準備 Hash 的 JSON: %s\n", string(data))
-	hash := sha256.Sum256(data)
-	return hash[:]
+// serializeForID 清空簽名與公鑰後的二進位內容 (txid 的來源)
+func (tx *Transaction) serializeForID() []byte {
+	var buf bytes.Buffer
+	tx.encode(&buf, true)
+	return buf.Bytes()
 }
 
-// cloneWithoutSign 返回一个交易副本，清空所有可能引起 Hash 變化的欄位
-func (tx *Transaction) cloneWithoutSign() *Transaction {
-	tmp := *tx
-	tmp.ID = "" // 🚀 防護 1：強制清空 ID
-
-	tmp.Inputs = make([]TxInput, len(tx.Inputs))
-	for i, in := range tx.Inputs {
-		tmp.Inputs[i] = TxInput{
-			TxID:   in.TxID,
-			Index:  in.Index,
-			Sig:    "", // 🚀 防護 2：清空簽名
-			PubKey: "", // 🚀 防護 3：強制清空公鑰 (這招最關鍵，徹底杜絕欄位賦值時間差)
-		}
-	}
-	return &tmp
+// Serialize 正式的二進位格式 (儲存、P2P 都用這個)
+func (tx *Transaction) Serialize() []byte {
+	var buf bytes.Buffer
+	tx.encode(&buf, false)
+	return buf.Bytes()
 }
 
-func (tx *Transaction) Serialize() []byte {
-	b, _ := json.Marshal(tx)
-	return b
+// VSize 交易的虛擬大小 (vbytes)，就是正式序列化後的長度；手續費率都以它為分母
+func (tx *Transaction) VSize() int {
+	return len(tx.Serialize())
 }
 
 func (tx *Transaction) Hash() string {
@@ -190,11 +303,20 @@ func (tx *Transaction) Hash() string {
 }
 
 func DeserializeTransaction(b []byte) (*Transaction, error) {
-	var tx Transaction
-	if err := json.Unmarshal(b, &tx); err != nil {
+	// 🧳 遷移相容：舊資料庫裡的交易還是 JSON
+	if len(b) > 0 && b[0] == '{' {
+		return decodeLegacyTransactionJSON(b)
+	}
+
+	r := bytes.NewReader(b)
+	tx, err := decodeTransaction(r, false)
+	if err != nil {
 		return nil, err
 	}
-	return &tx, nil
+	if r.Len() != 0 {
+		return nil, fmt.Errorf("trailing %d bytes after transaction", r.Len())
+	}
+	return tx, nil
 }
 
 // 修改簽名，增加 mempoolTxs 參數
@@ -241,6 +363,7 @@ func (tx *Transaction) Fee(utxo *UTXOSet, mempoolTxs map[string][]byte) int {
 }
 func NewTransaction(inputs []TxInput, outputs []TxOutput) *Transaction {
 	tx := &Transaction{
+		Version:    TxVersion,
 		Inputs:     inputs,
 		Outputs:    outputs,
 		IsCoinbase: false,
@@ -251,51 +374,6 @@ func NewTransaction(inputs []TxInput, outputs []TxOutput) *Transaction {
 	return tx
 }
 
-func (tx *Transaction) DeterministicID() string {
-	h := sha256.New()
-
-	// 1. CoinBase flag
-	if tx.IsCoinbase {
-		h.Write([]byte{1})
-	} else {
-		h.Write([]byte{0})
-	}
-
-	// ==========================================
-	// 🚀 關鍵修復：把 Inputs 也加進 Hash 計算裡！
-	// ==========================================
-	h.Write([]byte{byte(len(tx.Inputs))}) // 寫入 Inputs 數量
-	for _, in := range tx.Inputs {
-		h.Write([]byte(in.TxID)) // 寫入來源交易 ID
-
-		// 寫入 Index (8 bytes Big Endian)
-		idx := make([]byte, 8)
-		binary.BigEndian.PutUint64(idx, uint64(in.Index))
-		h.Write(idx)
-
-		h.Write([]byte(in.Sig))    // 🌟 我們剛剛加的時間戳就在這裡！現在它終於被算進去了！
-		h.Write([]byte(in.PubKey)) // 寫入公鑰
-	}
-	// ==========================================
-
-	// 3. outputs count
-	h.Write([]byte{byte(len(tx.Outputs))})
-
-	// 4. each output
-	for _, out := range tx.Outputs {
-		// Amount (8 bytes Big Endian)
-		amt := make([]byte, 8)
-		binary.BigEndian.PutUint64(amt, uint64(out.Amount))
-		h.Write(amt)
-
-		// To (public key)
-		h.Write([]byte(out.To))
-	}
-
-	sum := h.Sum(nil)
-	return hex.EncodeToString(sum)
-}
-
 func (tx *Transaction) GetTotalAmount() int {
 	total := 0
 	for _, out := range tx.Outputs {
@@ -0,0 +1,174 @@
+package blockchain
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+)
+
+// BlockUndo 一個區塊花掉的所有 UTXO (依交易、輸入的順序)
+// 斷開區塊時把它們原樣放回帳本，不必再去 txindex / 舊區塊裡翻交易
+//
+// 格式：varint 數量，接著每個 UTXO
+//
+//	[32]txid u32 index i64 amount varstr to varbytes script u64 height i64 time u8 coinbase
+type BlockUndo struct {
+	Spent []UTXO
+}
+
+func (bu *BlockUndo) Serialize() []byte {
+	var w bytes.Buffer
+	putVarInt(&w, uint64(len(bu.Spent)))
+	for _, u := range bu.Spent {
+		encodeUTXO(&w, u)
+	}
+	return w.Bytes()
+}
+
+func DeserializeBlockUndo(data []byte) (*BlockUndo, error) {
+	r := bytes.NewReader(data)
+	n, err := readListLen(r)
+	if err != nil {
+		return nil, err
+	}
+
+	bu := &BlockUndo{Spent: make([]UTXO, 0, n)}
+	for i := 0; i < n; i++ {
+		u, err := decodeUTXO(r)
+		if err != nil {
+			return nil, err
+		}
+		bu.Spent = append(bu.Spent, u)
+	}
+	if r.Len() != 0 {
+		return nil, errors.New("trailing bytes after block undo")
+	}
+	return bu, nil
+}
+
+// encodeUTXO 一筆 UTXO 的二進位格式 (undo 紀錄與 UTXO 快照共用)
+func encodeUTXO(w *bytes.Buffer, u UTXO) {
+	putHash(w, u.TxID)
+	putUint32(w, uint32(u.Index))
+	putUint64(w, uint64(int64(u.Amount)))
+	putVarBytes(w, []byte(u.To))
+	putVarBytes(w, u.Script)
+	putUint64(w, u.Height)
+	putUint64(w, uint64(u.Time))
+	if u.Coinbase {
+		w.WriteByte(1)
+	} else {
+		w.WriteByte(0)
+	}
+}
+
+func decodeUTXO(r *bytes.Reader) (UTXO, error) {
+	var u UTXO
+	var err error
+	if u.TxID, err = readHash(r); err != nil {
+		return u, err
+	}
+	idx, err := readUint32(r)
+	if err != nil {
+		return u, err
+	}
+	u.Index = int(idx)
+	amount, err := readUint64(r)
+	if err != nil {
+		return u, err
+	}
+	u.Amount = int(int64(amount))
+	to, err := readVarBytes(r)
+	if err != nil {
+		return u, err
+	}
+	u.To = string(to)
+	if u.Script, err = readScript(r); err != nil {
+		return u, err
+	}
+	if u.Height, err = readUint64(r); err != nil {
+		return u, err
+	}
+	t, err := readUint64(r)
+	if err != nil {
+		return u, err
+	}
+	u.Time = int64(t)
+	flag, err := r.ReadByte()
+	if err != nil {
+		return u, err
+	}
+	u.Coinbase = flag == 1
+	return u, nil
+}
+
+// ConnectBlock 把區塊套用到帳本上，回傳斷開時需要的 undo 資料
+// mtp 是區塊父鏈的 MTP (跟驗證時的 SpendContext.Time 一樣)，記在新的 UTXO 上
+// 中途失敗會把已經套用的交易退回去，帳本維持原狀
+func (u *UTXOSet) ConnectBlock(block *Block, mtp int64) (*BlockUndo, error) {
+	undo := &BlockUndo{}
+	for i, tx := range block.Transactions {
+		if !tx.IsCoinbase {
+			mark := len(undo.Spent)
+			for _, in := range tx.Inputs {
+				key := utxoKey(in.TxID, in.Index)
+				utxo, ok := u.Lookup(key)
+				if !ok {
+					undo.Spent = undo.Spent[:mark]
+					u.disconnectTxs(block.Transactions[:i], undo.Spent)
+					return nil, fmt.Errorf("block %d tx %s: UTXO not found: %s", block.Height, tx.ID, key)
+				}
+				undo.Spent = append(undo.Spent, utxo)
+			}
+			if err := u.Spend(tx); err != nil {
+				// 同一筆交易花兩次同一個輸出：已刪掉的部分從 undo 放回
+				for _, spent := range undo.Spent[mark:] {
+					u.put(spent)
+				}
+				undo.Spent = undo.Spent[:mark]
+				u.disconnectTxs(block.Transactions[:i], undo.Spent)
+				return nil, fmt.Errorf("block %d tx %s: %w", block.Height, tx.ID, err)
+			}
+		}
+		u.Add(tx, block.Height, mtp)
+	}
+	return undo, nil
+}
+
+// DisconnectBlock ConnectBlock 的反向操作：刪掉區塊產生的輸出，放回它花掉的輸出
+func (u *UTXOSet) DisconnectBlock(block *Block, undo *BlockUndo) error {
+	inputs := 0
+	for _, tx := range block.Transactions {
+		if !tx.IsCoinbase {
+			inputs += len(tx.Inputs)
+		}
+	}
+	if inputs != len(undo.Spent) {
+		return fmt.Errorf("undo data for block %d has %d entries, block spends %d", block.Height, len(undo.Spent), inputs)
+	}
+	u.disconnectTxs(block.Transactions, undo.Spent)
+	return nil
+}
+
+// disconnectTxs 由後往前撤銷交易；spent 是這些交易依序花掉的 UTXO
+func (u *UTXOSet) disconnectTxs(txs []Transaction, spent []UTXO) {
+	for i := len(txs) - 1; i >= 0; i-- {
+		tx := txs[i]
+		for j, out := range tx.Outputs {
+			u.del(utxoKey(tx.ID, j), UTXO{TxID: tx.ID, Index: j, Amount: out.Amount, To: out.To, Script: out.Script})
+		}
+		if tx.IsCoinbase {
+			continue
+		}
+		n := len(tx.Inputs)
+		for _, utxo := range spent[len(spent)-n:] {
+			u.put(utxo)
+		}
+		spent = spent[:len(spent)-n]
+	}
+}
+
+// put 放回一個 UTXO
+func (u *UTXOSet) put(utxo UTXO) {
+	u.set(utxoKey(utxo.TxID, utxo.Index), utxo)
+}
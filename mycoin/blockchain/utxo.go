@@ -1,9 +1,11 @@
 package blockchain
 
 import (
-	"encoding/hex"
+	"container/list"
 	"encoding/json"
 	"fmt"
+	"sort"
+	"sync"
 
 	"mycoin/database"
 )
@@ -13,94 +15,241 @@ type UTXO struct {
 	TxID   string
 	Index  int
 	Amount int
-	To     string // 收款公钥 hex
+	To     string // 收款地址 (腳本輸出時是 ScriptAddress)
+	Script []byte `json:",omitempty"` // 鎖定腳本，空的代表付給 To 的 P2PKH
+
+	// ⏳ 建立這個 UTXO 的區塊高度，以及那個區塊的父鏈 MTP (相對時間鎖用，BIP68)
+	// 不用區塊自己的時間戳：礦工可以把時間戳往前後填，提早或延後解鎖
+	Height uint64
+	Time   int64
+
+	Coinbase bool `json:",omitempty"` // 礦工獎勵，要等成熟才能花
 }
 
-// UTXOSet 管理整个节点的 UTXO 集合
+// Output 這筆 UTXO 原本的輸出 (金額與鎖定條件)
+func (u *UTXO) Output() TxOutput {
+	return TxOutput{Amount: u.Amount, To: u.To, Script: u.Script}
+}
+
+// DefaultUTXOCacheSize 記憶體裡最多快取幾筆「沒改過」的 UTXO
+const DefaultUTXOCacheSize = 200000
+
+// UTXOSet 分層的 UTXO 帳本
+//
+//	根帳本 (NewUTXOSet(db))：改過的帳目留在記憶體 (write-back)，由 Commit 跟區塊一起寫回；
+//	                       沒改過的帳目放在硬碟，讀過的進 LRU 快取
+//	覆蓋層 (View())       ：只記自己的改動，查不到就往下一層問，丟掉就等於沒發生 (驗證用的沙盒)
+//	純記憶體 (NewUTXOSet(nil))：沒有下一層，全部放在記憶體
 type UTXOSet struct {
-	Set       map[string]UTXO     // key = TxID_Index
-	AddrIndex map[string][]string // 按地址索引，加速查询
-	DB        *database.BoltDB
+	DB database.Store
+
+	base    *UTXOSet             // 覆蓋層的下一層
+	entries map[string]utxoEntry // 這一層改過的帳目 (key = TxID_Index)
+	cache   *utxoCache           // 根帳本才有：硬碟讀回來、沒改過的帳目
+}
+
+// utxoEntry 一筆改過的帳目；spent 代表在這一層被花掉了 (下一層可能還有)
+type utxoEntry struct {
+	utxo  UTXO
+	spent bool
 }
 
 // 创建新的 UTXOSet
-func NewUTXOSet(db *database.BoltDB) *UTXOSet {
-	return &UTXOSet{
-		Set:       make(map[string]UTXO),
-		AddrIndex: make(map[string][]string),
-		DB:        db,
+func NewUTXOSet(db database.Store) *UTXOSet {
+	u := &UTXOSet{
+		DB:      db,
+		entries: make(map[string]utxoEntry),
 	}
+	if db != nil {
+		u.cache = newUTXOCache(DefaultUTXOCacheSize)
+	}
+	return u
 }
 
-func (u *UTXOSet) Clear() error {
-	// 清空内存中的 UTXO
-	u.Set = make(map[string]UTXO)
-	u.AddrIndex = make(map[string][]string)
+// View 在這本帳上開一層覆蓋層：改動只留在覆蓋層，不會碰到原本的帳
+// (取代整本複製的 Clone，成本只跟改動的筆數有關)
+func (u *UTXOSet) View() *UTXOSet {
+	return &UTXOSet{
+		base:    u,
+		entries: make(map[string]utxoEntry),
+	}
+}
 
-	// 清空 DB bucket （可选但推荐）
-	if u.DB != nil {
-		err := u.DB.ClearBucket("utxo")
-		if err != nil {
-			return err
-		}
+// SetCacheSize 調整 LRU 快取的容量 (筆數)
+func (u *UTXOSet) SetCacheSize(size int) {
+	if u.cache != nil {
+		u.cache.resize(size)
 	}
+}
 
-	return nil
+func utxoKey(txid string, index int) string {
+	return fmt.Sprintf("%s_%d", txid, index)
 }
 
-// 添加UTXO（交易输出）
-// 添加UTXO（交易输出）
-func (u *UTXOSet) Add(tx Transaction) {
-	for i, out := range tx.Outputs {
+// utxoAddrKey 地址索引的 key：地址 + 0x00 + UTXO key，同一個地址的帳目在 Bolt 裡排在一起
+func utxoAddrKey(addr, key string) string {
+	return addr + "\x00" + key
+}
 
-		key := fmt.Sprintf("%s_%d", tx.ID, i)
+// hasLower 有沒有下一層：有的話花掉要留墓碑，不能直接刪
+func (u *UTXOSet) hasLower() bool {
+	return u.base != nil || u.DB != nil
+}
 
-		// 构造 UTXO 对象
-		utxo := UTXO{
-			TxID:   tx.ID,
-			Index:  i,
-			Amount: out.Amount,
-			To:     out.To,
-		}
+// Lookup 依序查這一層、下一層 (或快取 / 硬碟)
+func (u *UTXOSet) Lookup(key string) (UTXO, bool) {
+	if e, ok := u.entries[key]; ok {
+		return e.utxo, !e.spent
+	}
+	return u.lookupLower(key)
+}
 
-		// 1️⃣ 写入内存 Set (Map 会自动覆盖旧值，所以很安全)
-		u.Set[key] = utxo
+func (u *UTXOSet) lookupLower(key string) (UTXO, bool) {
+	if u.base != nil {
+		return u.base.Lookup(key)
+	}
+	if u.DB == nil {
+		return UTXO{}, false
+	}
+	if utxo, ok := u.cache.get(key); ok {
+		return utxo, true
+	}
+	data := u.DB.Get(database.BucketUTXO, key)
+	if data == nil {
+		return UTXO{}, false
+	}
+	var utxo UTXO
+	if err := json.Unmarshal(data, &utxo); err != nil {
+		fmt.Printf("⚠️ [UTXO] 帳目 %s 損毀: %v\n", key, err)
+		return UTXO{}, false
+	}
+	u.cache.add(key, utxo)
+	return utxo, true
+}
+
+// set 在這一層記下一筆可花的帳目
+func (u *UTXOSet) set(key string, utxo UTXO) {
+	u.entries[key] = utxoEntry{utxo: utxo}
+	if u.cache != nil {
+		u.cache.remove(key)
+	}
+}
+
+// del 在這一層把帳目花掉 (有下一層就留墓碑)
+func (u *UTXOSet) del(key string, utxo UTXO) {
+	if u.hasLower() {
+		u.entries[key] = utxoEntry{utxo: utxo, spent: true}
+	} else {
+		delete(u.entries, key)
+	}
+	if u.cache != nil {
+		u.cache.remove(key)
+	}
+}
 
-		// 2️⃣ 🚀 写入地址索引前，先检查是否已经存在（防止影分身！）
-		exists := false
-		for _, existingKey := range u.AddrIndex[out.To] {
-			if existingKey == key {
-				exists = true
-				break
+// Commit 把還沒寫回硬碟的帳目放進 batch (還在的寫入、花掉的刪除，地址索引一起改)
+// 覆蓋層連同下面各層一起放 (上層蓋過下層)。帳本只會跟著區塊的 batch 一起原子寫入，
+// 這裡不動記憶體：batch 寫成功後再呼叫 Committed
+func (u *UTXOSet) Commit(batch *database.Batch) {
+	layers := u.layers()
+	if layers[0].DB == nil {
+		return
+	}
+	for _, l := range layers {
+		for key, e := range l.entries {
+			if e.spent {
+				batch.Delete(database.BucketUTXO, key)
+				batch.Delete(database.BucketUTXOAddr, utxoAddrKey(e.utxo.To, key))
+				continue
 			}
+			PutUTXO(batch, e.utxo)
 		}
+	}
+}
 
-		// 只有當這個 key 不存在時，我們才把它加進陣列裡
-		if !exists {
-			u.AddrIndex[out.To] = append(u.AddrIndex[out.To], key)
+// Committed Commit 放進去的 batch 寫入成功後呼叫：覆蓋層的改動併進根帳本，寫完的帳目移到 LRU 快取
+// 寫入失敗就不要呼叫，改動還留在記憶體 (覆蓋層直接丟掉就等於沒發生)
+func (u *UTXOSet) Committed() {
+	layers := u.layers()
+	root := layers[0]
+	if root.DB == nil {
+		return
+	}
+	for _, l := range layers[1:] {
+		for key, e := range l.entries {
+			root.entries[key] = e
 		}
-
-		// 3️⃣ ⭐ 持久化到数据库（可选，但推荐）
-		if u.DB != nil {
-			b, _ := json.Marshal(utxo)
-			err := u.DB.Put("utxo", key, b)
-			if err != nil {
-				fmt.Println("❌ failed to persist utxo:", err)
-			}
+		l.entries = make(map[string]utxoEntry)
+	}
+	for key, e := range root.entries {
+		if e.spent {
+			root.cache.remove(key)
+			continue
 		}
+		root.cache.add(key, e.utxo)
+	}
+	root.entries = make(map[string]utxoEntry)
+}
+
+// layers 從根帳本往上到這一層
+func (u *UTXOSet) layers() []*UTXOSet {
+	var layers []*UTXOSet
+	for l := u; l != nil; l = l.base {
+		layers = append([]*UTXOSet{l}, layers...)
 	}
+	return layers
+}
+
+// PutUTXO 把一筆帳目 (連同地址索引) 直接放進 batch，不經過記憶體裡的帳本 (載入快照用)
+func PutUTXO(batch *database.Batch, utxo UTXO) {
+	key := utxoKey(utxo.TxID, utxo.Index)
+	b, _ := json.Marshal(utxo)
+	batch.Put(database.BucketUTXO, key, b)
+	batch.Put(database.BucketUTXOAddr, utxoAddrKey(utxo.To, key), nil)
+}
+
+// Dirty 還沒寫回硬碟的帳目數
+func (u *UTXOSet) Dirty() int {
+	return len(u.entries)
 }
-func (u *UTXOSet) Clone() *UTXOSet {
-	// 🚀 關鍵：傳入 nil，確保沙盒不會誤寫硬碟
-	nu := NewUTXOSet(nil)
 
-	for k, v := range u.Set {
-		nu.Set[k] = v
+// DiffBase 覆蓋層跟下一層不一樣的 key (撤下再接回同一批區塊後應該是空的)
+func (u *UTXOSet) DiffBase() []string {
+	if u.base == nil {
+		return nil
 	}
-	for addr, keys := range u.AddrIndex {
-		nu.AddrIndex[addr] = append([]string{}, keys...)
+	var diff []string
+	for key, e := range u.entries {
+		got, ok := u.base.Lookup(key)
+		if ok == e.spent || (ok && !sameUTXO(got, e.utxo)) {
+			diff = append(diff, key)
+		}
+	}
+	sort.Strings(diff)
+	return diff
+}
+
+func sameUTXO(a, b UTXO) bool {
+	return a.TxID == b.TxID && a.Index == b.Index && a.Amount == b.Amount && a.To == b.To &&
+		string(a.Script) == string(b.Script) && a.Height == b.Height && a.Time == b.Time && a.Coinbase == b.Coinbase
+}
+
+// 添加UTXO（交易输出）
+// height 是這筆交易所在的區塊，mtp 是那個區塊的父鏈 MTP，都會記在 UTXO 上給相對時間鎖用
+func (u *UTXOSet) Add(tx Transaction, height uint64, mtp int64) {
+	for i, out := range tx.Outputs {
+		u.set(utxoKey(tx.ID, i), UTXO{
+			TxID:   tx.ID,
+			Index:  i,
+			Amount: out.Amount,
+			To:     out.To,
+			Script: out.Script,
+			Height: height,
+			Time:   mtp,
+
+			Coinbase: tx.IsCoinbase,
+		})
 	}
-	return nu
 }
 
 // 消耗UTXO（交易输入），返回错误
@@ -109,70 +258,115 @@ func (u *UTXOSet) Spend(tx Transaction) error {
 		return nil
 	}
 	for _, in := range tx.Inputs {
-		key := fmt.Sprintf("%s_%d", in.TxID, in.Index)
-		utxo, ok := u.Set[key]
+		key := utxoKey(in.TxID, in.Index)
+		utxo, ok := u.Lookup(key)
 		if !ok {
 			return fmt.Errorf("UTXO not found: %s", key)
 		}
 
-		// 🚀 關鍵修復 1：將 Hex 公鑰還原成 Base58 錢包地址
-		pubBytes, err := hex.DecodeString(in.PubKey)
-		if err != nil {
-			return fmt.Errorf("invalid pubkey hex: %v", err)
-		}
-
-		// ⚠️ 注意：如果你的 PubKeyToAddress 是在 blockchain 包裡，這裡就是 blockchain.PubKeyToAddress
-		// 如果這個 Spend 函數本身就在 blockchain 包裡，直接呼叫 PubKeyToAddress 即可
-		addr := PubKeyToAddress(pubBytes)
+		// 📜 能不能花由腳本決定 (Transaction.Verify)，這裡只負責記帳
+		u.del(key, utxo)
+	}
+	return nil
+}
 
-		// 🚀 關鍵修復 2：用算出來的「地址 (addr)」來跟 UTXO 上的「地址 (utxo.To)」比對
-		if utxo.To != addr {
-			return fmt.Errorf("UTXO owner mismatch: %s", key)
+// 查询某个地址所有可用UTXO (依高度排序，同高度依 key)
+func (u *UTXOSet) GetUTXOs(addr string) []UTXO {
+	found := u.addrUTXOs(addr)
+	utxos := make([]UTXO, 0, len(found))
+	for _, utxo := range found {
+		utxos = append(utxos, utxo)
+	}
+	sort.Slice(utxos, func(i, j int) bool {
+		if utxos[i].Height != utxos[j].Height {
+			return utxos[i].Height < utxos[j].Height
 		}
-
-		// 删除UTXO
-		delete(u.Set, key)
-
-		if u.DB != nil {
-			u.DB.Delete("utxo", key)
+		if utxos[i].TxID != utxos[j].TxID {
+			return utxos[i].TxID < utxos[j].TxID
 		}
+		return utxos[i].Index < utxos[j].Index
+	})
+	return utxos
+}
 
-		// 🚀 關鍵修復 3：同步地址索引時，也必須使用「地址 (addr)」來尋找，而不是公鑰！
-		keys := u.AddrIndex[addr]
-		for i, k := range keys {
-			if k == key {
-				u.AddrIndex[addr] = append(keys[:i], keys[i+1:]...)
-				break
+func (u *UTXOSet) addrUTXOs(addr string) map[string]UTXO {
+	found := make(map[string]UTXO)
+	switch {
+	case u.base != nil:
+		found = u.base.addrUTXOs(addr)
+	case u.DB != nil:
+		// 🗂️ 硬碟上的地址索引只記 key，帳目本身再去快取 / utxo bucket 拿
+		var keys []string
+		prefix := addr + "\x00"
+		u.DB.IteratePrefix(database.BucketUTXOAddr, prefix, func(k, v []byte) {
+			keys = append(keys, string(k[len(prefix):]))
+		})
+		for _, key := range keys {
+			if utxo, ok := u.lookupLower(key); ok {
+				found[key] = utxo
 			}
 		}
 	}
-	return nil
+
+	for key, e := range u.entries {
+		if e.utxo.To != addr {
+			continue
+		}
+		if e.spent {
+			delete(found, key)
+		} else {
+			found[key] = e.utxo
+		}
+	}
+	return found
 }
 
-// 查询某个地址所有可用UTXO
-func (u *UTXOSet) GetUTXOs(pub string) []UTXO {
-	keys := u.AddrIndex[pub]
-	utxos := make([]UTXO, 0, len(keys))
-	for _, k := range keys {
-		if utxo, ok := u.Set[k]; ok {
-			utxos = append(utxos, utxo)
+// ForEach 走訪整本帳 (根帳本會掃過硬碟，只給統計 / 匯出用)
+func (u *UTXOSet) ForEach(fn func(key string, utxo UTXO)) {
+	switch {
+	case u.base != nil:
+		u.base.ForEach(func(key string, utxo UTXO) {
+			if _, shadowed := u.entries[key]; !shadowed {
+				fn(key, utxo)
+			}
+		})
+	case u.DB != nil:
+		u.DB.Iterate(database.BucketUTXO, func(k, v []byte) {
+			key := string(k)
+			if _, shadowed := u.entries[key]; shadowed {
+				return
+			}
+			var utxo UTXO
+			if err := json.Unmarshal(v, &utxo); err != nil {
+				fmt.Printf("⚠️ [UTXO] 帳目 %s 損毀: %v\n", key, err)
+				return
+			}
+			fn(key, utxo)
+		})
+	}
+
+	for key, e := range u.entries {
+		if !e.spent {
+			fn(key, e.utxo)
 		}
 	}
-	return utxos
+}
+
+// Count 整本帳的筆數
+func (u *UTXOSet) Count() int {
+	count := 0
+	u.ForEach(func(string, UTXO) { count++ })
+	return count
 }
 
 // 检查UTXO是否存在
 func (u *UTXOSet) Exists(txID string, idx int, pub string) bool {
-	key := fmt.Sprintf("%s_%d", txID, idx)
-	v, ok := u.Set[key]
+	v, ok := u.Lookup(utxoKey(txID, idx))
 	return ok && v.To == pub
 }
 
 func (u *UTXOSet) Get(txid string, index int) (*TxOutput, bool) {
-	// 正确的 key
-	key := fmt.Sprintf("%s_%d", txid, index)
-
-	utxo, ok := u.Set[key]
+	utxo, ok := u.Lookup(utxoKey(txid, index))
 	if !ok {
 		return nil, false
 	}
@@ -181,6 +375,7 @@ func (u *UTXOSet) Get(txid string, index int) (*TxOutput, bool) {
 	return &TxOutput{
 		Amount: utxo.Amount,
 		To:     utxo.To,
+		Script: utxo.Script,
 	}, true
 }
 
@@ -188,46 +383,27 @@ func (u *UTXOSet) FindSpendableOutputs(pubKey string, amount int) (int, map[stri
 	unspentOutputs := make(map[string][]int)
 	accumulated := 0
 
-	// 利用你寫好的 AddrIndex 快速找出這個人的所有 UTXO
-	keys := u.AddrIndex[pubKey]
+	for _, utxo := range u.GetUTXOs(pubKey) {
+		accumulated += utxo.Amount
+		unspentOutputs[utxo.TxID] = append(unspentOutputs[utxo.TxID], utxo.Index)
 
-	for _, k := range keys {
-		if utxo, ok := u.Set[k]; ok {
-			accumulated += utxo.Amount
-			unspentOutputs[utxo.TxID] = append(unspentOutputs[utxo.TxID], utxo.Index)
-
-			// 錢湊夠了就停止，不需要把所有的 UTXO 都找出來
-			if accumulated >= amount {
-				break
-			}
+		// 錢湊夠了就停止，不需要把所有的 UTXO 都找出來
+		if accumulated >= amount {
+			break
 		}
 	}
 
 	return accumulated, unspentOutputs
 }
 
-func (u *UTXOSet) Revert(tx Transaction) {
+// TxLookup 依 txid 找回已確認的交易與它所在的區塊 (節點用 txindex + 區塊檔實作)
+type TxLookup func(txid string) (*Transaction, *Block, error)
+
+// Revert 沒有 undo 紀錄時的舊式回滾：被花掉的輸出靠 lookup 找回原始交易補回來
+func (u *UTXOSet) Revert(tx Transaction, lookup TxLookup) {
 	// 1. 刪除該交易產生的所有 Output (原本 Add 進去的現在要拿掉)
 	for i, out := range tx.Outputs {
-		key := fmt.Sprintf("%s_%d", tx.ID, i)
-
-		// 從記憶體 Map 刪除
-		delete(u.Set, key)
-
-		// 從地址索引 AddrIndex 刪除 (同步清理，避免餘額虛高)
-		addr := out.To
-		keys := u.AddrIndex[addr]
-		for j, k := range keys {
-			if k == key {
-				u.AddrIndex[addr] = append(keys[:j], keys[j+1:]...)
-				break
-			}
-		}
-
-		// 從硬碟 BoltDB 刪除
-		if u.DB != nil {
-			u.DB.Delete("utxo", key)
-		}
+		u.del(utxoKey(tx.ID, i), UTXO{TxID: tx.ID, Index: i, Amount: out.Amount, To: out.To, Script: out.Script})
 	}
 
 	// 2. 恢復該交易花掉的 Input (原本 Spend 掉的錢，現在要倒退還給主人)
@@ -235,98 +411,97 @@ func (u *UTXOSet) Revert(tx Transaction) {
 	if !tx.IsCoinbase {
 		for _, in := range tx.Inputs {
 			// 🚀 關鍵：去資料庫裡找回老爸交易的原始數據
-			parentTx := u.lookupTransaction(in.TxID)
-			if parentTx != nil {
+			parentTx, parentBlock, err := lookup(in.TxID)
+			if err == nil && in.Index < len(parentTx.Outputs) {
 				prevOut := parentTx.Outputs[in.Index]
-				utxo := UTXO{
+				u.set(utxoKey(in.TxID, in.Index), UTXO{
 					TxID:   in.TxID,
 					Index:  in.Index,
 					Amount: prevOut.Amount,
 					To:     prevOut.To,
-				}
-				key := fmt.Sprintf("%s_%d", in.TxID, in.Index)
-
-				// 恢復到記憶體 Map
-				u.Set[key] = utxo
-
-				// 恢復到地址索引 AddrIndex (並做防重複檢查)
-				exists := false
-				for _, existingKey := range u.AddrIndex[prevOut.To] {
-					if existingKey == key {
-						exists = true
-						break
-					}
-				}
-				if !exists {
-					u.AddrIndex[prevOut.To] = append(u.AddrIndex[prevOut.To], key)
-				}
-
-				// 恢復到硬碟 BoltDB
-				if u.DB != nil {
-					utxoBytes, _ := json.Marshal(utxo)
-					u.DB.Put("utxo", key, utxoBytes)
-				}
+					Script: prevOut.Script,
+					Height: parentBlock.Height,
+					Time:   parentBlock.Timestamp,
+
+					Coinbase: parentTx.IsCoinbase,
+				})
 			}
 		}
 	}
 }
 
-// lookupTransaction 輔助函數：直接從資料庫找回舊交易資料
-func (u *UTXOSet) lookupTransaction(txid string) *Transaction {
-	if u.DB == nil {
-		return nil
-	}
-
-	// 1. 從 txindex bucket 查找交易所在的索引
-	data := u.DB.Get("txindex", txid)
-	if data == nil {
-		return nil
-	}
+// BuildAddrIndex 從 utxo bucket 重建硬碟上的地址索引 (舊資料庫升級用)
+func BuildAddrIndex(db database.Store) error {
+	batch := database.NewBatch()
+	batch.ClearBucket(database.BucketUTXOAddr)
+	db.Iterate(database.BucketUTXO, func(k, v []byte) {
+		var utxo UTXO
+		if err := json.Unmarshal(v, &utxo); err == nil {
+			batch.Put(database.BucketUTXOAddr, utxoAddrKey(utxo.To, string(k)), nil)
+		}
+	})
+	return db.Write(batch)
+}
 
-	var idx TxIndexEntry
-	if err := json.Unmarshal(data, &idx); err != nil {
-		return nil
-	}
+// utxoCache 沒改過的帳目的 LRU 快取 (查詢會動到順序，所以自己上鎖)
+type utxoCache struct {
+	mu    sync.Mutex
+	size  int
+	ll    *list.List
+	items map[string]*list.Element
+}
 
-	// 2. 根據索引提供的 BlockHash 讀取完整的區塊體
-	blockBytes := u.DB.Get("blocks", idx.BlockHash)
-	if blockBytes == nil {
-		return nil
-	}
+type cacheItem struct {
+	key  string
+	utxo UTXO
+}
 
-	// 3. 反序列化區塊並提取指定位置的交易
-	block, err := DeserializeBlock(blockBytes)
-	if err != nil {
-		return nil
-	}
+func newUTXOCache(size int) *utxoCache {
+	return &utxoCache{size: size, ll: list.New(), items: make(map[string]*list.Element)}
+}
 
-	if idx.TxOffset < 0 || idx.TxOffset >= len(block.Transactions) {
-		return nil
+func (c *utxoCache) get(key string) (UTXO, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.ll.MoveToFront(el)
+		return el.Value.(*cacheItem).utxo, true
 	}
-
-	return &block.Transactions[idx.TxOffset]
+	return UTXO{}, false
 }
 
-// FlushToDB 將記憶體中所有的 UTXO 狀態強行刷新到資料庫
-func (u *UTXOSet) FlushToDB() {
-	if u.DB == nil {
+func (c *utxoCache) add(key string, utxo UTXO) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		el.Value.(*cacheItem).utxo = utxo
+		c.ll.MoveToFront(el)
 		return
 	}
+	c.items[key] = c.ll.PushFront(&cacheItem{key: key, utxo: utxo})
+	c.evict()
+}
 
-	// 1. 先清空資料庫中的舊帳本，防止殘留舊的分塊資料
-	err := u.DB.ClearBucket("utxo")
-	if err != nil {
-		fmt.Printf("⚠️ [UTXO] 無法清空舊帳本: %v\n", err)
+func (c *utxoCache) remove(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[key]; ok {
+		c.ll.Remove(el)
+		delete(c.items, key)
 	}
+}
 
-	// 2. 將記憶體中目前的 Set 逐一寫入
-	count := 0
-	for key, utxo := range u.Set {
-		utxoBytes, err := json.Marshal(utxo)
-		if err == nil {
-			u.DB.Put("utxo", key, utxoBytes)
-			count++
-		}
+func (c *utxoCache) resize(size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.size = size
+	c.evict()
+}
+
+func (c *utxoCache) evict() {
+	for c.size >= 0 && c.ll.Len() > c.size {
+		el := c.ll.Back()
+		c.ll.Remove(el)
+		delete(c.items, el.Value.(*cacheItem).key)
 	}
-	fmt.Printf("💾 [UTXO] 已成功將 %d 筆帳目刷新至硬碟資料庫\n", count)
 }
@@ -0,0 +1,343 @@
+package database
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+)
+
+// DefaultMaxBlockFileSize 一個區塊檔寫到這麼大就換下一個檔
+const DefaultMaxBlockFileSize = 16 << 20
+
+// blockRecordHeader 每筆記錄前面的 magic(4) + 長度(4)
+const blockRecordHeader = 8
+
+// ErrBlockFileMissing 區塊檔已經刪掉 (例如修剪) 或從來沒寫過
+var ErrBlockFileMissing = errors.New("block file missing")
+
+// BlockPos 區塊 body 在區塊檔裡的位置 (存在 BlockIndex 裡，跟著 index bucket 落地)
+type BlockPos struct {
+	File   uint32 `json:"file"`
+	Offset uint32 `json:"offset"` // 資料 (不含記錄頭) 的起點
+	Size   uint32 `json:"size"`
+}
+
+// BlockStorage 只往後追加的區塊 body 儲存；索引 (hash → BlockPos) 由呼叫端存在 Store 裡
+type BlockStorage interface {
+	// Append 寫入一筆資料並落到硬碟，回傳它的位置
+	Append(data []byte) (BlockPos, error)
+	Read(pos BlockPos) ([]byte, error)
+	// Scan 依檔案與寫入順序走訪所有記錄 (-reindex 用)；壞掉的尾巴直接略過
+	Scan(fn func(pos BlockPos, data []byte)) error
+	// Files 目前還在的檔案編號 (由小到大)，最後一個是正在寫的
+	Files() ([]uint32, error)
+	// CurrentFile 正在寫的檔案，不能刪
+	CurrentFile() uint32
+	// RemoveFile 整個檔案刪掉 (修剪)；不存在也不算錯
+	RemoveFile(file uint32) error
+	Close() error
+}
+
+// FlatFiles 區塊檔放在 dir/blk00000.dat、blk00001.dat...
+// 每筆記錄 = magic (網路識別碼) + 長度 + 序列化區塊，magic 讓 Scan 能認出記錄邊界
+type FlatFiles struct {
+	mu      sync.Mutex
+	dir     string
+	magic   uint32
+	maxSize int64
+
+	cur     uint32
+	curSize int64
+	w       *os.File
+}
+
+var _ BlockStorage = (*FlatFiles)(nil)
+
+// OpenFlatFiles 打開 dir 下的區塊檔，接在編號最大的檔案後面繼續寫
+func OpenFlatFiles(dir string, magic uint32, maxSize int64) (*FlatFiles, error) {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, err
+	}
+	if maxSize <= 0 {
+		maxSize = DefaultMaxBlockFileSize
+	}
+	ff := &FlatFiles{dir: dir, magic: magic, maxSize: maxSize}
+
+	files, err := ff.Files()
+	if err != nil {
+		return nil, err
+	}
+	if len(files) > 0 {
+		ff.cur = files[len(files)-1]
+	}
+	if err := ff.openCurrent(); err != nil {
+		return nil, err
+	}
+	return ff, nil
+}
+
+func (ff *FlatFiles) path(file uint32) string {
+	return filepath.Join(ff.dir, fmt.Sprintf("blk%05d.dat", file))
+}
+
+// openCurrent 打開正在寫的檔案；上次寫到一半斷電留下的殘骸先截掉，
+// 不然新記錄會接在殘骸後面，Scan 碰到殘骸就停，之後寫的區塊 -reindex 全部看不到
+func (ff *FlatFiles) openCurrent() error {
+	f, err := os.OpenFile(ff.path(ff.cur), os.O_CREATE|os.O_RDWR|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	raw, err := io.ReadAll(f)
+	if err != nil {
+		f.Close()
+		return err
+	}
+	valid := ff.scanRecords(ff.cur, raw, nil)
+	if valid < int64(len(raw)) {
+		if err := ff.truncate(f, valid); err != nil {
+			f.Close()
+			return fmt.Errorf("truncate torn record in blk%05d.dat: %w", ff.cur, err)
+		}
+	}
+	ff.w = f
+	ff.curSize = valid
+	return nil
+}
+
+// truncate 把檔案截回 size (O_APPEND 下一次寫入就從這裡開始)
+func (ff *FlatFiles) truncate(f *os.File, size int64) error {
+	if err := f.Truncate(size); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+func (ff *FlatFiles) Append(data []byte) (BlockPos, error) {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+
+	rec := int64(blockRecordHeader + len(data))
+	if ff.curSize > 0 && ff.curSize+rec > ff.maxSize {
+		if err := ff.w.Close(); err != nil {
+			return BlockPos{}, err
+		}
+		ff.cur++
+		if err := ff.openCurrent(); err != nil {
+			return BlockPos{}, err
+		}
+	}
+
+	buf := make([]byte, rec)
+	binary.LittleEndian.PutUint32(buf[0:4], ff.magic)
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(len(data)))
+	copy(buf[blockRecordHeader:], data)
+
+	// 🛡️ 先落到硬碟再讓呼叫端寫索引：斷電時最多在檔尾留下沒人指到的記錄
+	// 寫失敗就截回原本的長度，不留半筆記錄，curSize 也才會跟檔案的實際長度一致
+	_, err := ff.w.Write(buf)
+	if err == nil {
+		err = ff.w.Sync()
+	}
+	if err != nil {
+		if terr := ff.truncate(ff.w, ff.curSize); terr != nil {
+			return BlockPos{}, fmt.Errorf("%v (truncate blk%05d.dat: %v)", err, ff.cur, terr)
+		}
+		return BlockPos{}, err
+	}
+
+	pos := BlockPos{File: ff.cur, Offset: uint32(ff.curSize + blockRecordHeader), Size: uint32(len(data))}
+	ff.curSize += rec
+	return pos, nil
+}
+
+func (ff *FlatFiles) Read(pos BlockPos) ([]byte, error) {
+	f, err := os.Open(ff.path(pos.File))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, fmt.Errorf("%w: blk%05d.dat", ErrBlockFileMissing, pos.File)
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	data := make([]byte, pos.Size)
+	if _, err := f.ReadAt(data, int64(pos.Offset)); err != nil {
+		return nil, fmt.Errorf("read blk%05d.dat@%d: %w", pos.File, pos.Offset, err)
+	}
+	return data, nil
+}
+
+func (ff *FlatFiles) Scan(fn func(pos BlockPos, data []byte)) error {
+	files, err := ff.Files()
+	if err != nil {
+		return err
+	}
+	for _, file := range files {
+		raw, err := os.ReadFile(ff.path(file))
+		if err != nil {
+			return err
+		}
+		ff.scanRecords(file, raw, fn)
+	}
+	return nil
+}
+
+// scanRecords 依序走訪 raw 裡完整的記錄 (fn 可以是 nil)，回傳最後一筆完整記錄的結尾
+func (ff *FlatFiles) scanRecords(file uint32, raw []byte, fn func(pos BlockPos, data []byte)) int64 {
+	off := 0
+	for off+blockRecordHeader <= len(raw) {
+		if binary.LittleEndian.Uint32(raw[off:off+4]) != ff.magic {
+			break // 寫到一半斷電留下的殘骸，這個檔後面都不可信
+		}
+		size := int(binary.LittleEndian.Uint32(raw[off+4 : off+8]))
+		start := off + blockRecordHeader
+		if start+size > len(raw) {
+			break
+		}
+		if fn != nil {
+			fn(BlockPos{File: file, Offset: uint32(start), Size: uint32(size)}, raw[start:start+size])
+		}
+		off = start + size
+	}
+	return int64(off)
+}
+
+func (ff *FlatFiles) Files() ([]uint32, error) {
+	matches, err := filepath.Glob(filepath.Join(ff.dir, "blk*.dat"))
+	if err != nil {
+		return nil, err
+	}
+	var files []uint32
+	for _, m := range matches {
+		var file uint32
+		if _, err := fmt.Sscanf(filepath.Base(m), "blk%05d.dat", &file); err == nil {
+			files = append(files, file)
+		}
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i] < files[j] })
+	return files, nil
+}
+
+func (ff *FlatFiles) CurrentFile() uint32 {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+	return ff.cur
+}
+
+func (ff *FlatFiles) RemoveFile(file uint32) error {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+	if file == ff.cur {
+		return fmt.Errorf("blk%05d.dat is still being written", file)
+	}
+	if err := os.Remove(ff.path(file)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+	return nil
+}
+
+func (ff *FlatFiles) Close() error {
+	ff.mu.Lock()
+	defer ff.mu.Unlock()
+	return ff.w.Close()
+}
+
+// MemBlockFiles 純記憶體的 BlockStorage (搭配 MemDB 使用)，Offset 是檔案裡的記錄序號
+type MemBlockFiles struct {
+	mu      sync.RWMutex
+	maxSize int64
+	files   map[uint32][][]byte
+	sizes   map[uint32]int64
+	cur     uint32
+}
+
+var _ BlockStorage = (*MemBlockFiles)(nil)
+
+func NewMemBlockFiles(maxSize int64) *MemBlockFiles {
+	if maxSize <= 0 {
+		maxSize = DefaultMaxBlockFileSize
+	}
+	return &MemBlockFiles{
+		maxSize: maxSize,
+		files:   map[uint32][][]byte{0: nil},
+		sizes:   map[uint32]int64{0: 0},
+	}
+}
+
+func (m *MemBlockFiles) Append(data []byte) (BlockPos, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	rec := int64(blockRecordHeader + len(data))
+	if m.sizes[m.cur] > 0 && m.sizes[m.cur]+rec > m.maxSize {
+		m.cur++
+		m.files[m.cur] = nil
+	}
+	pos := BlockPos{File: m.cur, Offset: uint32(len(m.files[m.cur])), Size: uint32(len(data))}
+	m.files[m.cur] = append(m.files[m.cur], append([]byte{}, data...))
+	m.sizes[m.cur] += rec
+	return pos, nil
+}
+
+func (m *MemBlockFiles) Read(pos BlockPos) ([]byte, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	recs, ok := m.files[pos.File]
+	if !ok {
+		return nil, fmt.Errorf("%w: file %d", ErrBlockFileMissing, pos.File)
+	}
+	if int(pos.Offset) >= len(recs) {
+		return nil, fmt.Errorf("file %d has no record %d: %w", pos.File, pos.Offset, io.ErrUnexpectedEOF)
+	}
+	return append([]byte{}, recs[pos.Offset]...), nil
+}
+
+func (m *MemBlockFiles) Scan(fn func(pos BlockPos, data []byte)) error {
+	files, _ := m.Files()
+	for _, file := range files {
+		m.mu.RLock()
+		recs := m.files[file]
+		m.mu.RUnlock()
+		for i, data := range recs {
+			fn(BlockPos{File: file, Offset: uint32(i), Size: uint32(len(data))}, data)
+		}
+	}
+	return nil
+}
+
+func (m *MemBlockFiles) Files() ([]uint32, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	files := make([]uint32, 0, len(m.files))
+	for file := range m.files {
+		files = append(files, file)
+	}
+	sort.Slice(files, func(i, j int) bool { return files[i] < files[j] })
+	return files, nil
+}
+
+func (m *MemBlockFiles) CurrentFile() uint32 {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.cur
+}
+
+func (m *MemBlockFiles) RemoveFile(file uint32) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if file == m.cur {
+		return fmt.Errorf("file %d is still being written", file)
+	}
+	delete(m.files, file)
+	delete(m.sizes, file)
+	return nil
+}
+
+func (m *MemBlockFiles) Close() error {
+	return nil
+}
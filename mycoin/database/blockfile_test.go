@@ -0,0 +1,54 @@
+package database
+
+import (
+	"os"
+	"testing"
+)
+
+const testMagic = 0xd9b4bef9
+
+func TestFlatFilesTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	ff, err := OpenFlatFiles(dir, testMagic, DefaultMaxBlockFileSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, data := range []string{"one", "two"} {
+		if _, err := ff.Append([]byte(data)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ff.Close()
+
+	// 模擬寫到一半斷電：檔尾多一段不完整的記錄
+	f, err := os.OpenFile(ff.path(0), os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.Write([]byte{0xf9, 0xbe, 0xb4, 0xd9, 0xff, 0x00})
+	f.Close()
+
+	// 重開之後殘骸要被截掉，新記錄接在最後一筆完整記錄後面
+	ff, err = OpenFlatFiles(dir, testMagic, DefaultMaxBlockFileSize)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ff.Close()
+	pos, err := ff.Append([]byte("three"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if data, err := ff.Read(pos); err != nil || string(data) != "three" {
+		t.Fatalf("Read = %q, %v", data, err)
+	}
+
+	var got []string
+	if err := ff.Scan(func(pos BlockPos, data []byte) {
+		got = append(got, string(data))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[2] != "three" {
+		t.Fatalf("Scan = %v, want [one two three]", got)
+	}
+}
@@ -1,38 +1,48 @@
 package database
 
 import (
+	"bytes"
 	"fmt"
-	"log"
 
 	bolt "go.etcd.io/bbolt"
 )
 
+// BoltDB 以 BoltDB 單檔資料庫實作 Store
 type BoltDB struct {
 	DB *bolt.DB
 }
 
-func OpenDB(path string) *BoltDB {
+var _ Store = (*BoltDB)(nil)
+
+// OpenDB 打開 (或建立) path 的 Bolt 資料庫，並建好所有 bucket
+func OpenDB(path string) (*BoltDB, error) {
 	db, err := bolt.Open(path, 0600, nil)
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("open %s: %w", path, err)
 	}
 
 	// 创建 bucket
-	db.Update(func(tx *bolt.Tx) error {
-		tx.CreateBucketIfNotExists([]byte("blocks"))
-		tx.CreateBucketIfNotExists([]byte("index"))
-		tx.CreateBucketIfNotExists([]byte("utxo"))
-		tx.CreateBucketIfNotExists([]byte("meta"))
-		tx.CreateBucketIfNotExists([]byte("txindex"))
-		tx.CreateBucketIfNotExists([]byte("mempool"))
-		tx.CreateBucketIfNotExists([]byte("peerstore"))
+	err = db.Update(func(tx *bolt.Tx) error {
+		for _, bucket := range Buckets {
+			if _, err := tx.CreateBucketIfNotExists([]byte(bucket)); err != nil {
+				return fmt.Errorf("create bucket %s: %w", bucket, err)
+			}
+		}
 		return nil
 	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &BoltDB{DB: db}, nil
+}
 
-	return &BoltDB{DB: db}
+func (db *BoltDB) Close() error {
+	return db.DB.Close()
 }
 
-func (db *BoltDB) Put(bucket, key string, value []byte) error {
+func (db *BoltDB) Put(bucket Bucket, key string, value []byte) error {
 	return db.DB.Update(func(tx *bolt.Tx) error {
 
 		b, err := tx.CreateBucketIfNotExists([]byte(bucket))
@@ -44,47 +54,79 @@ func (db *BoltDB) Put(bucket, key string, value []byte) error {
 	})
 }
 
-func (b *BoltDB) Get(bucket string, key string) []byte {
-	var val []byte
-	b.DB.View(func(tx *bolt.Tx) error {
-		// 1. 🕵️ 探長第一步：先拿出抽屜，不要急著拿資料！
-		bkt := tx.Bucket([]byte(bucket))
+// Write 依序執行 batch 裡的動作，任何一步失敗整批回滾
+func (db *BoltDB) Write(batch *Batch) error {
+	if batch == nil || len(batch.ops) == 0 {
+		return nil
+	}
+	return db.DB.Update(func(tx *bolt.Tx) error {
+		for _, op := range batch.ops {
+			if op.kind == opClear {
+				if err := tx.DeleteBucket([]byte(op.bucket)); err != nil && err != bolt.ErrBucketNotFound {
+					return err
+				}
+				if _, err := tx.CreateBucket([]byte(op.bucket)); err != nil {
+					return err
+				}
+				continue
+			}
 
-		// 2. 🛡️ 探長防彈衣：檢查抽屜到底存不存在？
-		if bkt != nil {
-			// 3. 抽屜確實存在，這時才安全地伸手進去拿資料
-			v := bkt.Get([]byte(key))
-			if v != nil {
-				// 完美保留你原本複製資料的寫法
-				val = append([]byte{}, v...)
+			b, err := tx.CreateBucketIfNotExists([]byte(op.bucket))
+			if err != nil {
+				return err
+			}
+			if op.kind == opDelete {
+				err = b.Delete([]byte(op.key))
+			} else {
+				err = b.Put([]byte(op.key), op.value)
+			}
+			if err != nil {
+				return fmt.Errorf("%s/%s: %w", op.bucket, op.key, err)
 			}
 		}
 		return nil
 	})
+}
+
+func (b *BoltDB) Get(bucket Bucket, key string) []byte {
+	var val []byte
+	b.DB.View(func(tx *bolt.Tx) error {
+		val = boltGet(tx, bucket, key)
+		return nil
+	})
 	return val
 }
 
-func (b *BoltDB) Delete(bucket string, key string) {
-	b.DB.Update(func(tx *bolt.Tx) error {
-		return tx.Bucket([]byte(bucket)).Delete([]byte(key))
+func (b *BoltDB) Delete(bucket Bucket, key string) error {
+	return b.DB.Update(func(tx *bolt.Tx) error {
+		bkt := tx.Bucket([]byte(bucket))
+		if bkt == nil {
+			return nil
+		}
+		return bkt.Delete([]byte(key))
 	})
 }
 
-func (db *BoltDB) Iterate(bucket string, fn func(k, v []byte)) error {
+func (db *BoltDB) Iterate(bucket Bucket, fn func(k, v []byte)) error {
 	return db.DB.View(func(tx *bolt.Tx) error {
-		b := tx.Bucket([]byte(bucket))
-		if b == nil {
-			return fmt.Errorf("bucket %s not found", bucket)
-		}
+		return boltRange(tx, bucket, nil, nil, fn)
+	})
+}
 
-		return b.ForEach(func(k, v []byte) error {
-			fn(k, v)
-			return nil
-		})
+// IteratePrefix 只走訪 key 以 prefix 開頭的項目 (Bolt 的 key 有排序，直接 Seek 過去)
+func (db *BoltDB) IteratePrefix(bucket Bucket, prefix string, fn func(k, v []byte)) error {
+	return db.DB.View(func(tx *bolt.Tx) error {
+		return boltPrefix(tx, bucket, prefix, fn)
 	})
 }
 
-func (db *BoltDB) ClearBucket(bucket string) error {
+func (db *BoltDB) IterateRange(bucket Bucket, start, limit string, fn func(k, v []byte)) error {
+	return db.DB.View(func(tx *bolt.Tx) error {
+		return boltRange(tx, bucket, []byte(start), []byte(limit), fn)
+	})
+}
+
+func (db *BoltDB) ClearBucket(bucket Bucket) error {
 	return db.DB.Update(func(tx *bolt.Tx) error {
 		err := tx.DeleteBucket([]byte(bucket))
 		if err != nil && err != bolt.ErrBucketNotFound {
@@ -94,3 +136,51 @@ func (db *BoltDB) ClearBucket(bucket string) error {
 		return err
 	})
 }
+
+// boltGet 讀出來的值要複製一份，Bolt 的記憶體在交易結束後就不能用了
+func boltGet(tx *bolt.Tx, bucket Bucket, key string) []byte {
+	bkt := tx.Bucket([]byte(bucket))
+	if bkt == nil {
+		return nil
+	}
+	v := bkt.Get([]byte(key))
+	if v == nil {
+		return nil
+	}
+	return append([]byte{}, v...)
+}
+
+func boltPrefix(tx *bolt.Tx, bucket Bucket, prefix string, fn func(k, v []byte)) error {
+	b := tx.Bucket([]byte(bucket))
+	if b == nil {
+		return fmt.Errorf("%w: %s", ErrBucketNotFound, bucket)
+	}
+
+	p := []byte(prefix)
+	c := b.Cursor()
+	for k, v := c.Seek(p); k != nil && bytes.HasPrefix(k, p); k, v = c.Next() {
+		fn(k, v)
+	}
+	return nil
+}
+
+// boltRange 走訪 start <= key < limit (空的 start / limit 代表不設限)
+func boltRange(tx *bolt.Tx, bucket Bucket, start, limit []byte, fn func(k, v []byte)) error {
+	b := tx.Bucket([]byte(bucket))
+	if b == nil {
+		return fmt.Errorf("%w: %s", ErrBucketNotFound, bucket)
+	}
+
+	c := b.Cursor()
+	k, v := c.First()
+	if len(start) > 0 {
+		k, v = c.Seek(start)
+	}
+	for ; k != nil; k, v = c.Next() {
+		if len(limit) > 0 && bytes.Compare(k, limit) >= 0 {
+			break
+		}
+		fn(k, v)
+	}
+	return nil
+}
@@ -0,0 +1,164 @@
+package database
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// ErrKeyRequired 寫入空的 key (Bolt 也不收，兩邊行為一致)
+var ErrKeyRequired = errors.New("key required")
+
+// MemDB 純記憶體的 Store：不碰硬碟，關掉就沒了 (單元測試、試驗用)
+type MemDB struct {
+	mu      sync.RWMutex
+	buckets map[Bucket]map[string][]byte
+}
+
+var _ Store = (*MemDB)(nil)
+
+// NewMemDB 建一個空的記憶體資料庫，bucket 跟 OpenDB 一樣預先建好
+func NewMemDB() *MemDB {
+	db := &MemDB{buckets: make(map[Bucket]map[string][]byte)}
+	for _, bucket := range Buckets {
+		db.buckets[bucket] = make(map[string][]byte)
+	}
+	return db
+}
+
+func (db *MemDB) Close() error {
+	return nil
+}
+
+func (db *MemDB) Get(bucket Bucket, key string) []byte {
+	db.mu.RLock()
+	defer db.mu.RUnlock()
+	return memGet(db.buckets, bucket, key)
+}
+
+func (db *MemDB) Put(bucket Bucket, key string, value []byte) error {
+	if key == "" {
+		return fmt.Errorf("%s: %w", bucket, ErrKeyRequired)
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.bucket(bucket)[key] = append([]byte{}, value...)
+	return nil
+}
+
+func (db *MemDB) Delete(bucket Bucket, key string) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	delete(db.buckets[bucket], key)
+	return nil
+}
+
+func (db *MemDB) ClearBucket(bucket Bucket) error {
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	db.buckets[bucket] = make(map[string][]byte)
+	return nil
+}
+
+// Write 整批套用：先檢查完所有動作才開始寫，有一步不合法就整批不寫；持鎖期間別人看不到寫一半的狀態
+func (db *MemDB) Write(batch *Batch) error {
+	if batch == nil || len(batch.ops) == 0 {
+		return nil
+	}
+	for _, op := range batch.ops {
+		if op.kind == opPut && op.key == "" {
+			return fmt.Errorf("%s: %w", op.bucket, ErrKeyRequired)
+		}
+	}
+	db.mu.Lock()
+	defer db.mu.Unlock()
+	for _, op := range batch.ops {
+		switch op.kind {
+		case opClear:
+			db.buckets[op.bucket] = make(map[string][]byte)
+		case opDelete:
+			delete(db.buckets[op.bucket], op.key)
+		default:
+			db.bucket(op.bucket)[op.key] = append([]byte{}, op.value...)
+		}
+	}
+	return nil
+}
+
+func (db *MemDB) Iterate(bucket Bucket, fn func(k, v []byte)) error {
+	return db.IterateRange(bucket, "", "", fn)
+}
+
+func (db *MemDB) IteratePrefix(bucket Bucket, prefix string, fn func(k, v []byte)) error {
+	return db.iterate(bucket, func(k string) bool { return strings.HasPrefix(k, prefix) }, fn)
+}
+
+func (db *MemDB) IterateRange(bucket Bucket, start, limit string, fn func(k, v []byte)) error {
+	return db.iterate(bucket, inRange(start, limit), fn)
+}
+
+// iterate 先在鎖裡挑出項目，放開鎖才呼叫 fn (fn 裡可以再讀寫資料庫)
+func (db *MemDB) iterate(bucket Bucket, match func(k string) bool, fn func(k, v []byte)) error {
+	db.mu.RLock()
+	var keys []string
+	var vals [][]byte
+	err := memIterate(db.buckets, bucket, match, func(k, v []byte) {
+		keys = append(keys, string(k))
+		vals = append(vals, v)
+	})
+	db.mu.RUnlock()
+	if err != nil {
+		return err
+	}
+
+	for i, k := range keys {
+		fn([]byte(k), vals[i])
+	}
+	return nil
+}
+
+// bucket 取出 bucket，不存在就建一個 (跟 Bolt 的 Put 一樣)；呼叫前要持寫鎖
+func (db *MemDB) bucket(bucket Bucket) map[string][]byte {
+	kv, ok := db.buckets[bucket]
+	if !ok {
+		kv = make(map[string][]byte)
+		db.buckets[bucket] = kv
+	}
+	return kv
+}
+
+func memGet(buckets map[Bucket]map[string][]byte, bucket Bucket, key string) []byte {
+	v, ok := buckets[bucket][key]
+	if !ok {
+		return nil
+	}
+	return append([]byte{}, v...)
+}
+
+// memIterate 依 key 排序走訪 (跟 Bolt 一樣)，match 決定要不要交給 fn
+func memIterate(buckets map[Bucket]map[string][]byte, bucket Bucket, match func(k string) bool, fn func(k, v []byte)) error {
+	kv, ok := buckets[bucket]
+	if !ok {
+		return fmt.Errorf("%w: %s", ErrBucketNotFound, bucket)
+	}
+
+	keys := make([]string, 0, len(kv))
+	for k := range kv {
+		if match(k) {
+			keys = append(keys, k)
+		}
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		fn([]byte(k), kv[k])
+	}
+	return nil
+}
+
+func inRange(start, limit string) func(k string) bool {
+	return func(k string) bool {
+		return k >= start && (limit == "" || k < limit)
+	}
+}
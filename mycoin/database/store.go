@@ -0,0 +1,97 @@
+package database
+
+import "errors"
+
+// Bucket 資料庫裡的一個 key space；一律用下面的常數，不要手打字串
+type Bucket string
+
+const (
+	BucketBlocks         Bucket = "blocks"          // 舊版的區塊 body (hash → 序列化區塊)；格式 4 起搬到區塊檔，只剩升級時讀
+	BucketIndex          Bucket = "index"           // 區塊索引 (hash → BlockIndex)
+	BucketUTXO           Bucket = "utxo"            // 帳本 (TxID_Index → UTXO)
+	BucketUTXOAddr       Bucket = "utxoaddr"        // 地址索引 (地址 + \x00 + TxID_Index → 空值)
+	BucketMeta           Bucket = "meta"            // 鏈頭、修剪高度等單筆狀態
+	BucketTxIndex        Bucket = "txindex"         // 交易索引 (txid → TxIndexEntry)
+	BucketMempool        Bucket = "mempool"         // 交易池 (txid → 序列化交易)
+	BucketMempoolTimes   Bucket = "mempool_times"   // 交易進池時間 (txid → Unix 秒，十進位字串)
+	BucketMempoolSources Bucket = "mempool_sources" // 交易是哪個節點傳來的 (txid → NodeID，十進位字串)
+	BucketPeerStore      Bucket = "peerstore"       // 認識的節點地址
+	BucketUndo           Bucket = "undo"            // 區塊 undo 紀錄 (hash → BlockUndo)
+)
+
+// Buckets 開資料庫時預先建立的全部 key space
+var Buckets = []Bucket{
+	BucketBlocks, BucketIndex, BucketUTXO, BucketUTXOAddr, BucketMeta,
+	BucketTxIndex, BucketMempool, BucketMempoolTimes, BucketMempoolSources, BucketPeerStore, BucketUndo,
+}
+
+// ErrBucketNotFound 讀取不存在的 bucket
+var ErrBucketNotFound = errors.New("bucket not found")
+
+// Reader 唯讀操作；走訪時 key 由小到大排序，fn 拿到的 k/v 只在回呼裡有效
+type Reader interface {
+	Get(bucket Bucket, key string) []byte
+	Iterate(bucket Bucket, fn func(k, v []byte)) error
+	// IteratePrefix 只走訪 key 以 prefix 開頭的項目
+	IteratePrefix(bucket Bucket, prefix string, fn func(k, v []byte)) error
+	// IterateRange 走訪 start <= key < limit 的項目 (limit 為空代表到底)
+	IterateRange(bucket Bucket, start, limit string, fn func(k, v []byte)) error
+}
+
+// Store 節點用的儲存後端 (BoltDB、純記憶體...)
+type Store interface {
+	Reader
+	Put(bucket Bucket, key string, value []byte) error
+	Delete(bucket Bucket, key string) error
+	ClearBucket(bucket Bucket) error
+	// Write 一次套用整批動作，要嘛全部成功，要嘛全部不寫
+	Write(batch *Batch) error
+	Close() error
+}
+
+// Batch 一組寫入動作，交給 Store.Write 一次完成
+// (接上 / 撤下區塊時 blocks、index、utxo、txindex、undo、meta 要嘛全部寫入，要嘛全部不寫)
+type Batch struct {
+	ops []batchOp
+}
+
+type batchOp struct {
+	bucket Bucket
+	key    string
+	value  []byte
+	kind   int
+}
+
+const (
+	opPut = iota
+	opDelete
+	opClear
+)
+
+func NewBatch() *Batch {
+	return &Batch{}
+}
+
+func (b *Batch) Put(bucket Bucket, key string, value []byte) {
+	b.ops = append(b.ops, batchOp{bucket: bucket, key: key, value: value, kind: opPut})
+}
+
+func (b *Batch) Delete(bucket Bucket, key string) {
+	b.ops = append(b.ops, batchOp{bucket: bucket, key: key, kind: opDelete})
+}
+
+// ClearBucket 清空整個 bucket (之後的 Put 仍然有效)
+func (b *Batch) ClearBucket(bucket Bucket) {
+	b.ops = append(b.ops, batchOp{bucket: bucket, kind: opClear})
+}
+
+// Append 把另一個 batch 的動作接在後面
+func (b *Batch) Append(other *Batch) {
+	if other != nil {
+		b.ops = append(b.ops, other.ops...)
+	}
+}
+
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
@@ -0,0 +1,108 @@
+package database
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// 每個測試都對 MemDB 跟 BoltDB 各跑一次，兩個後端的行為必須一樣
+func forEachStore(t *testing.T, fn func(t *testing.T, db Store)) {
+	t.Run("mem", func(t *testing.T) {
+		fn(t, NewMemDB())
+	})
+	t.Run("bolt", func(t *testing.T) {
+		db, err := OpenDB(filepath.Join(t.TempDir(), "test.db"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer db.Close()
+		fn(t, db)
+	})
+}
+
+func collect(t *testing.T, iter func(fn func(k, v []byte)) error) []string {
+	t.Helper()
+	var got []string
+	if err := iter(func(k, v []byte) {
+		got = append(got, string(k)+"="+string(v))
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return got
+}
+
+func TestStoreIterationOrder(t *testing.T) {
+	forEachStore(t, func(t *testing.T, db Store) {
+		// 故意亂序寫入，走訪時一律由小到大
+		for _, k := range []string{"b2", "a", "c", "b1", "b"} {
+			if err := db.Put(BucketMeta, k, []byte(k)); err != nil {
+				t.Fatal(err)
+			}
+		}
+
+		all := collect(t, func(fn func(k, v []byte)) error { return db.Iterate(BucketMeta, fn) })
+		if want := []string{"a=a", "b=b", "b1=b1", "b2=b2", "c=c"}; !reflect.DeepEqual(all, want) {
+			t.Fatalf("Iterate = %v, want %v", all, want)
+		}
+
+		prefix := collect(t, func(fn func(k, v []byte)) error { return db.IteratePrefix(BucketMeta, "b", fn) })
+		if want := []string{"b=b", "b1=b1", "b2=b2"}; !reflect.DeepEqual(prefix, want) {
+			t.Fatalf("IteratePrefix = %v, want %v", prefix, want)
+		}
+
+		// start 含、limit 不含；limit 為空代表到底
+		rng := collect(t, func(fn func(k, v []byte)) error { return db.IterateRange(BucketMeta, "b1", "c", fn) })
+		if want := []string{"b1=b1", "b2=b2"}; !reflect.DeepEqual(rng, want) {
+			t.Fatalf("IterateRange(b1, c) = %v, want %v", rng, want)
+		}
+		tail := collect(t, func(fn func(k, v []byte)) error { return db.IterateRange(BucketMeta, "b2", "", fn) })
+		if want := []string{"b2=b2", "c=c"}; !reflect.DeepEqual(tail, want) {
+			t.Fatalf("IterateRange(b2, \"\") = %v, want %v", tail, want)
+		}
+	})
+}
+
+func TestStoreBatchOrder(t *testing.T) {
+	forEachStore(t, func(t *testing.T, db Store) {
+		db.Put(BucketUTXO, "old", []byte("1"))
+
+		// 動作照順序執行：清空之後的 Put 仍然有效，Delete 蓋掉前面的 Put
+		batch := NewBatch()
+		batch.Put(BucketUTXO, "gone", []byte("x"))
+		batch.ClearBucket(BucketUTXO)
+		batch.Put(BucketUTXO, "kept", []byte("2"))
+		batch.Put(BucketMeta, "best", []byte("h"))
+		batch.Delete(BucketMeta, "best")
+		if err := db.Write(batch); err != nil {
+			t.Fatal(err)
+		}
+
+		utxo := collect(t, func(fn func(k, v []byte)) error { return db.Iterate(BucketUTXO, fn) })
+		if want := []string{"kept=2"}; !reflect.DeepEqual(utxo, want) {
+			t.Fatalf("utxo = %v, want %v", utxo, want)
+		}
+		if v := db.Get(BucketMeta, "best"); v != nil {
+			t.Fatalf("meta/best = %q, want deleted", v)
+		}
+	})
+}
+
+func TestStoreBatchAtomic(t *testing.T) {
+	forEachStore(t, func(t *testing.T, db Store) {
+		db.Put(BucketMeta, "best", []byte("old"))
+
+		// 最後一步不合法 (空 key)：前面的動作也一個都不能寫進去
+		batch := NewBatch()
+		batch.Put(BucketMeta, "best", []byte("new"))
+		batch.ClearBucket(BucketUTXO)
+		batch.Put(BucketUndo, "", []byte("bad"))
+		if err := db.Write(batch); err == nil {
+			t.Fatal("Write with an empty key succeeded")
+		}
+
+		if v := string(db.Get(BucketMeta, "best")); v != "old" {
+			t.Fatalf("meta/best = %q after failed batch, want %q", v, "old")
+		}
+	})
+}
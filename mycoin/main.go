@@ -1,16 +1,19 @@
 package main
 
 import (
-	"encoding/hex"
+	"errors"
 	"flag"
 	"fmt"
 	"net"
 	"os"
 	"path/filepath"
+	"strings"
 	"time" // 引入 time 包
 
 	"mycoin/api"
+	"mycoin/blockchain"
 	"mycoin/indexer"
+	"mycoin/mempool"
 	"mycoin/miner"
 	"mycoin/network"
 	"mycoin/node"
@@ -20,18 +23,22 @@ import (
 )
 
 // ... (loadOrCreateMinerWallet 函數保持不變) ...
-func loadOrCreateMinerWallet(path string) *wallet.Wallet {
-	// ... (保持原樣) ...
-	if _, err := os.Stat(path); err == nil {
-		w, err := wallet.LoadWallet(path)
-		if err == nil {
-			fmt.Println("⛏ Miner wallet loaded:", w.Address)
-			return w
-		}
-		fmt.Println("⚠️ 矿工钱包读取失败，重新生成:", err)
+func loadOrCreateMinerWallet(path string, params *blockchain.Params) *wallet.Wallet {
+	w, err := wallet.LoadWallet(path, params)
+	if err == nil {
+		fmt.Println("⛏ Miner wallet loaded:", w.Address)
+		return w
+	}
+	// 🔑 只有檔案真的不存在才生新的；讀不了 (例如網路前綴不對) 就停下來，絕不覆蓋舊私鑰
+	if !errors.Is(err, os.ErrNotExist) {
+		fmt.Printf("❌ 矿工钱包 %s 读取失败: %v\n", path, err)
+		os.Exit(1)
 	}
 	fmt.Println("矿工钱包不存在，正在生成...")
-	w, _ := wallet.NewWallet()
+	if w, err = wallet.NewWallet(params); err != nil {
+		fmt.Println("❌ 生成矿工钱包失败:", err)
+		os.Exit(1)
+	}
 	if err := wallet.SaveWallet(path, w); err != nil {
 		fmt.Println("❌ 保存矿工钱包失败:", err)
 		os.Exit(1)
@@ -40,17 +47,88 @@ func loadOrCreateMinerWallet(path string) *wallet.Wallet {
 	return w
 }
 
+// defaultDatadir 沒給 -datadir 時的資料目錄
+func defaultDatadir(mode string, params *blockchain.Params) string {
+	dir := "archive"
+	if mode == node.ModePruned {
+		dir = "pruned"
+	}
+	// 非主網的資料放在子目錄，避免跟主網的鏈混在一起
+	if params != &blockchain.MainNetParams {
+		dir = filepath.Join(dir, params.Name)
+	}
+	return dir
+}
+
 func main() {
+	// 📦 離線子指令：mycoin export-blocks / import-blocks
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "export-blocks":
+			os.Exit(runExportBlocks(os.Args[2:]))
+		case "import-blocks":
+			os.Exit(runImportBlocks(os.Args[2:]))
+		}
+	}
+
+	netName := flag.String("network", "mainnet", "Network: mainnet, testnet or regtest")
 	mode := flag.String("mode", "archive", "Node mode: archive or pruned")
 	datadir := flag.String("datadir", "", "Directory for all node data")
+	maxFutureTime := flag.Int64("maxfuturetime", node.DefaultMaxFutureBlockTime, "Seconds a block timestamp may be ahead of the local clock")
+	reindex := flag.Bool("reindex", false, "Rebuild the block index, tx index and UTXO set from stored blocks")
+	reindexChainstate := flag.Bool("reindex-chainstate", false, "Rebuild only the UTXO set from stored blocks")
+	checkBlocks := flag.Int("checkblocks", node.DefaultCheckBlocks, "Blocks to verify against the UTXO set at startup (0 = skip)")
+	utxoCache := flag.Int("utxocache", blockchain.DefaultUTXOCacheSize, "Unmodified UTXO entries kept in the in-memory cache")
+	pruneDepth := flag.Uint64("prunedepth", node.PruneDepth, "Pruned mode: recent main-chain blocks to keep")
+	pruneTarget := flag.Uint64("prunetarget", 0, "Pruned mode: target size of stored blocks and undo data in MiB (0 = no target)")
+	blockCache := flag.Int("blockcache", node.DefaultBlockCacheSize, "Recently used block bodies kept in memory")
+	maxMempool := flag.Int64("maxmempool", mempool.DefaultMaxBytes>>20, "Keep the transaction memory pool below this many MiB")
+	mempoolExpiry := flag.Int64("mempoolexpiry", int64(mempool.DefaultExpiry/time.Hour), "Drop transactions from the memory pool after this many hours")
+	limitAncestors := flag.Int("limitancestorcount", mempool.DefaultAncestorLimit, "Reject transactions with more in-mempool ancestors than this (including itself)")
+	limitDescendants := flag.Int("limitdescendantcount", mempool.DefaultDescendantLimit, "Reject transactions that would give an in-mempool ancestor more descendants than this (including itself)")
+	loadSnapshot := flag.String("loadsnapshot", "", "Bootstrap the chainstate from a UTXO snapshot file (see the dumptxoutset RPC); only networks with pinned snapshots, currently regtest")
+	assumeUTXO := flag.String("assumeutxo", "", "Regtest only: pin a snapshot as <height>:<block_hash>:<utxo_hash> so it can be loaded")
 	flag.Parse()
 
-	if *datadir == "" {
-		if *mode == "archive" {
-			*datadir = "archive"
-		} else {
-			*datadir = "pruned"
+	if *mode != node.ModeArchive && *mode != node.ModePruned {
+		fmt.Println("❌ unknown -mode:", *mode)
+		os.Exit(1)
+	}
+
+	// 🌐 選網路：主網 / 測試網 / regtest 各有自己的創世區塊、Magic、埠口與地址前綴
+	params, err := blockchain.ParamsForNetwork(*netName)
+	if err != nil {
+		fmt.Println("❌", err)
+		os.Exit(1)
+	}
+	blockchain.SetActiveNetParams(params)
+
+	// 📸 快照雜湊跟著網路參數發布；只有每次都不一樣的 regtest 鏈可以在啟動時自己釘
+	if *assumeUTXO != "" {
+		if params != &blockchain.RegTestParams {
+			fmt.Println("❌ -assumeutxo is only allowed on regtest")
+			os.Exit(1)
 		}
+		var height uint64
+		parts := strings.Split(*assumeUTXO, ":")
+		if len(parts) != 3 || parts[1] == "" || parts[2] == "" {
+			fmt.Println("❌ invalid -assumeutxo (want <height>:<block_hash>:<utxo_hash>)")
+			os.Exit(1)
+		}
+		if _, err := fmt.Sscanf(parts[0], "%d", &height); err != nil {
+			fmt.Println("❌ invalid -assumeutxo height:", err)
+			os.Exit(1)
+		}
+		params.AssumeUTXO[height] = blockchain.AssumeUTXOPin{BlockHash: parts[1], UTXOHash: parts[2]}
+	}
+	// 主網 / 測試網還沒有發布任何快照，直接擋掉，不用等讀完整個檔才發現
+	if *loadSnapshot != "" && len(params.AssumeUTXO) == 0 {
+		fmt.Printf("❌ -loadsnapshot: no snapshots are pinned for %s (regtest only, pin one with -assumeutxo)\n", params.Name)
+		os.Exit(1)
+	}
+
+	if *datadir == "" {
+		*datadir = defaultDatadir(*mode, params)
 	}
 
 	os.MkdirAll(*datadir, 0755)
@@ -60,29 +138,50 @@ func main() {
 	// -------------------------------
 	// 1. 创建 Node
 	// -------------------------------
-	nd := node.NewNode(*mode, *datadir)
+	nd, err := node.NewNode(params, *mode, *datadir)
+	if err != nil {
+		fmt.Println("❌ 無法打開資料庫:", err)
+		os.Exit(1)
+	}
+	nd.MaxFutureBlockTime = *maxFutureTime
+	nd.Reindex = *reindex
+	nd.ReindexChainstate = *reindexChainstate
+	nd.CheckBlocks = *checkBlocks
+	nd.UTXOCacheSize = *utxoCache
+	nd.BlockCacheSize = *blockCache
+	nd.MempoolMaxBytes = *maxMempool << 20
+	nd.MempoolExpiry = time.Duration(*mempoolExpiry) * time.Hour
+	nd.MempoolAncestorLimit = *limitAncestors
+	nd.MempoolDescendantLimit = *limitDescendants
+	nd.PruneDepth = *pruneDepth
+	nd.PruneTarget = *pruneTarget << 20
 	nd.Start()
+	if *loadSnapshot != "" {
+		if _, err := nd.LoadUTXOSnapshot(*loadSnapshot); err != nil {
+			fmt.Println("❌ 無法載入快照:", err)
+			os.Exit(1)
+		}
+	}
 
 	// ==========================================
 	// 🧬 2. 提取 DNA 並初始化 PostgreSQL Indexer
 	// ==========================================
 	// 確保節點成功加載了區塊鏈 (至少會有 1 個創世區塊)
-	if len(nd.Chain) == 0 {
+	if nd.Chain.Len() == 0 {
 		panic("🚨 嚴重錯誤：節點啟動失敗，沒有任何區塊！")
 	}
 
-	// 取得創世區塊的 Hash 並轉成 Hex 字串
-	// (注意：需要 import "encoding/hex")
-	genesisHash := hex.EncodeToString(nd.Chain[0].Hash)
+	// 取得創世區塊的 Hash (索引裡已經是 Hex 字串)
+	genesisHash := nd.Chain.Genesis().Hash
 
 	// 把這串 DNA 傳給 Indexer 進行比對與大掃除！
-	nodeHeight := len(nd.Chain)
+	nodeHeight := nd.Chain.Len()
 	indexer.InitDB(genesisHash, nodeHeight)
 	// -------------------------------
 	// 3. 载入矿工钱包
 	// -------------------------------
 	walletPath := filepath.Join(*datadir, "miner.dat")
-	minerWallet := loadOrCreateMinerWallet(walletPath)
+	minerWallet := loadOrCreateMinerWallet(walletPath, params)
 
 	// -------------------------------
 	// 3. 设置挖矿地址
@@ -100,7 +199,7 @@ func main() {
 
 	nd.Broadcaster = handler // 這裡綁定廣播器
 
-	listenAddr := "0.0.0.0:9001"
+	listenAddr := "0.0.0.0:" + params.DefaultPort
 	publicIP := detectBestIP()
 
 	// ==========================================
@@ -109,13 +208,14 @@ func main() {
 	handler.LocalVersion = network.VersionPayload{
 		Version: 1,
 		// 💡 探長小提醒：如果你的 nd.Chain 已經棄用，建議改成 nd.Best.Height
-		Height:  nd.Best.Height,  // 或者維持你原本的 uint64(len(nd.Chain)) 也可以
-		CumWork: nd.Best.CumWork, // 順便把工作量也帶上
-		NodeID:  nd.NodeID,       // 🚀 關鍵：放入真正的 uint64 靈魂代碼！
+		Height:   nd.Best.Height,  // 或者維持你原本的 uint64(len(nd.Chain)) 也可以
+		CumWork:  nd.Best.CumWork, // 順便把工作量也帶上
+		NodeID:   nd.NodeID,       // 🚀 關鍵：放入真正的 uint64 靈魂代碼！
+		Services: handler.LocalServices(),
 	}
 
 	// 升級一下超帥的啟動日誌！
-	fmt.Printf("🔎 Node will advertise itself with IP: %s:9001 and NodeID: %d\n", publicIP, handler.LocalVersion.NodeID)
+	fmt.Printf("🔎 [%s] Node will advertise itself with IP: %s:%s and NodeID: %d\n", params.Name, publicIP, params.DefaultPort, handler.LocalVersion.NodeID)
 
 	pm := network.NewPeerManager(net, listenAddr, 16)
 	net.PeerManager = pm
@@ -128,14 +228,14 @@ func main() {
 		Node:    nd,
 		Handler: handler,
 	}
-	go nodeRPC.Start(":8081")
+	go nodeRPC.Start(":" + params.RPCPort)
 
 	walletRPC := rpcwallet.RPCServer{
 		Node:    nd,
 		Wallet:  minerWallet,
 		Handler: handler,
 	}
-	go walletRPC.Start(":8082")
+	go walletRPC.Start(":" + params.WalletRPCPort)
 
 	fmt.Println("🟢 Full Node + Wallet RPC 已完全启动")
 
@@ -158,6 +258,8 @@ func main() {
 	// 🌟 6.5 啟動區塊瀏覽器 API 伺服器 (背景執行)
 	// ==========================================
 	if indexer.Enabled {
+		api.NodeRPCURL = "http://localhost:" + params.RPCPort + "/rpc"
+		api.WalletRPCURL = "http://localhost:" + params.WalletRPCPort + "/wallet"
 		go api.StartServer("8080")
 	}
 
@@ -0,0 +1,170 @@
+package mempool
+
+import (
+	"errors"
+	"fmt"
+
+	"mycoin/blockchain"
+)
+
+const (
+	// DefaultAncestorLimit 一筆交易連同池子裡的祖先最多幾筆
+	DefaultAncestorLimit = 25
+	// DefaultDescendantLimit 一筆交易連同池子裡的子孫最多幾筆
+	DefaultDescendantLimit = 25
+)
+
+var (
+	// ErrTooManyAncestors 新交易的未確認祖先鏈太長
+	ErrTooManyAncestors = errors.New("too many unconfirmed ancestors")
+	// ErrTooManyDescendants 新交易會讓某個祖先的未確認子孫太多
+	ErrTooManyDescendants = errors.New("too many unconfirmed descendants")
+)
+
+// TxEntry 池子裡一筆交易的快取：手續費、大小進池時算好，
+// 祖先 / 子孫的整包統計 (都含自己) 在交易進出池子時跟著更新
+type TxEntry struct {
+	ID   string
+	Tx   *blockchain.Transaction
+	Fee  int
+	Size int
+
+	AncestorCount int
+	AncestorSize  int
+	AncestorFee   int
+
+	DescendantCount int
+	DescendantSize  int
+	DescendantFee   int
+
+	// Ancestors 池子裡的所有祖先 (拓撲順序，祖先在前，不含自己)；只有 Entries 給的複本才有
+	Ancestors []string
+}
+
+// FeeRate 自己的費率
+func (e *TxEntry) FeeRate() blockchain.FeeRate {
+	return blockchain.NewFeeRate(e.Fee, e.Size)
+}
+
+// AncestorFeeRate 連同所有祖先一起上鏈的整包費率 (礦工挑交易看這個)
+func (e *TxEntry) AncestorFeeRate() blockchain.FeeRate {
+	return blockchain.NewFeeRate(e.AncestorFee, e.AncestorSize)
+}
+
+// DescendantFeeRate 連同所有子孫的整包費率 (池子滿了踢交易看這個)
+func (e *TxEntry) DescendantFeeRate() blockchain.FeeRate {
+	return blockchain.NewFeeRate(e.DescendantFee, e.DescendantSize)
+}
+
+// Entry 一筆交易的快取複本 (不含 Ancestors)
+func (m *Mempool) Entry(txid string) (TxEntry, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.entries[txid]
+	if !ok {
+		return TxEntry{}, false
+	}
+	return *e, true
+}
+
+// Entries 整個池子的快取複本，連同每筆的祖先清單 (礦工組區塊模板用，不用再反序列化)
+func (m *Mempool) Entries() map[string]*TxEntry {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	out := make(map[string]*TxEntry, len(m.entries))
+	for txid, e := range m.entries {
+		c := *e
+		c.Ancestors = m.ancestorsUnsafe(txid)
+		out[txid] = &c
+	}
+	return out
+}
+
+// ancestorsUnsafe txid 在池子裡的所有祖先，拓撲順序 (祖先在前)，不含自己
+func (m *Mempool) ancestorsUnsafe(txid string) []string {
+	var result []string
+	visited := map[string]bool{txid: true}
+	var walk func(id string)
+	walk = func(id string) {
+		for _, p := range m.Parents[id] {
+			if visited[p] {
+				continue
+			}
+			visited[p] = true
+			walk(p)
+			result = append(result, p)
+		}
+	}
+	walk(txid)
+	return result
+}
+
+// ancestorSetUnsafe 還沒進池的 tx 在池子裡的所有祖先
+func (m *Mempool) ancestorSetUnsafe(tx *blockchain.Transaction) map[string]bool {
+	ancestors := make(map[string]bool)
+	for _, in := range tx.Inputs {
+		if _, ok := m.entries[in.TxID]; !ok || ancestors[in.TxID] {
+			continue
+		}
+		ancestors[in.TxID] = true
+		for _, a := range m.ancestorsUnsafe(in.TxID) {
+			ancestors[a] = true
+		}
+	}
+	return ancestors
+}
+
+// checkChainLimitsUnsafe 新交易 (池內祖先 ancestors) 進池後，祖先數、以及每個祖先的子孫數都不能超過上限
+// evicted 是這次替換會踢掉的交易 (不算進子孫數)
+func (m *Mempool) checkChainLimitsUnsafe(ancestors map[string]bool, evicted map[string]bool) error {
+	if m.AncestorLimit > 0 && len(ancestors)+1 > m.AncestorLimit {
+		return fmt.Errorf("%w: %d > %d", ErrTooManyAncestors, len(ancestors)+1, m.AncestorLimit)
+	}
+	if m.DescendantLimit > 0 {
+		for a := range ancestors {
+			count := m.entries[a].DescendantCount + 1
+			for _, d := range m.descendantsUnsafe(a) {
+				if evicted[d] {
+					count--
+				}
+			}
+			if count > m.DescendantLimit {
+				return fmt.Errorf("%w: %s would have %d > %d", ErrTooManyDescendants, short(a), count, m.DescendantLimit)
+			}
+		}
+	}
+	return nil
+}
+
+// relativesUnsafe txid 的所有祖先與子孫 (不含自己)：它進出池子時，這些交易的整包統計都會變
+func (m *Mempool) relativesUnsafe(txid string) []string {
+	return append(m.ancestorsUnsafe(txid), m.descendantsUnsafe(txid)...)
+}
+
+// refreshUnsafe 重算這些交易的祖先 / 子孫整包統計 (不在池子裡的略過)
+// 鏈長有上限，直接從頭加比增量維護 (還要處理菱形的共同祖先) 單純
+func (m *Mempool) refreshUnsafe(ids []string) {
+	for _, id := range ids {
+		e, ok := m.entries[id]
+		if !ok {
+			continue
+		}
+		e.AncestorCount, e.AncestorSize, e.AncestorFee = 1, e.Size, e.Fee
+		for _, a := range m.ancestorsUnsafe(id) {
+			ae := m.entries[a]
+			e.AncestorCount++
+			e.AncestorSize += ae.Size
+			e.AncestorFee += ae.Fee
+		}
+		e.DescendantCount, e.DescendantSize, e.DescendantFee = 1, e.Size, e.Fee
+		for _, d := range m.descendantsUnsafe(id) {
+			de := m.entries[d]
+			e.DescendantCount++
+			e.DescendantSize += de.Size
+			e.DescendantFee += de.Fee
+		}
+		m.indexUnsafe(e)
+	}
+}
@@ -0,0 +1,221 @@
+package mempool
+
+import (
+	"container/heap"
+	"log"
+
+	"mycoin/blockchain"
+)
+
+// ==========================================
+// 🧹 淘汰索引：池子超過 MaxBytes 時從分數最低的開始踢 (連同子孫)
+// ==========================================
+//
+// 分數取「自己」跟「自己 + 子孫整包」費率較高的那個 (富兒子會幫窮老爸撐住)。
+// 整包統計一變就放一筆新分數進 heap，舊的留在裡面，拿出來時對不上 evictScores 就丟掉
+
+// evictItem 淘汰索引裡的一筆：txid 與放進來當時的分數
+type evictItem struct {
+	txid  string
+	score blockchain.FeeRate
+}
+
+// less 分數低的先踢；同分時固定挑 txid 小的，結果才不會每次不一樣
+func (a evictItem) less(b evictItem) bool {
+	if a.score != b.score {
+		return a.score < b.score
+	}
+	return a.txid < b.txid
+}
+
+// evictQueue 分數由低到高的 heap (container/heap)
+type evictQueue []evictItem
+
+func (q evictQueue) Len() int            { return len(q) }
+func (q evictQueue) Less(i, j int) bool  { return q[i].less(q[j]) }
+func (q evictQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *evictQueue) Push(x interface{}) { *q = append(*q, x.(evictItem)) }
+func (q *evictQueue) Pop() interface{} {
+	old := *q
+	it := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return it
+}
+
+func evictScore(fee, size, descFee, descSize int) blockchain.FeeRate {
+	rate := blockchain.NewFeeRate(fee, size)
+	if pkg := blockchain.NewFeeRate(descFee, descSize); pkg > rate {
+		return pkg
+	}
+	return rate
+}
+
+// indexUnsafe e 剛進池或整包統計變了：分數有變就在索引放一筆新的
+func (m *Mempool) indexUnsafe(e *TxEntry) {
+	score := evictScore(e.Fee, e.Size, e.DescendantFee, e.DescendantSize)
+	if old, ok := m.evictScores[e.ID]; ok && old == score {
+		return
+	}
+	m.evictScores[e.ID] = score
+	heap.Push(&m.evictIndex, evictItem{txid: e.ID, score: score})
+
+	// 過期的項目太多就整個重建，索引大小維持在池子的常數倍
+	if len(m.evictIndex) > 2*len(m.evictScores)+64 {
+		m.evictIndex = m.evictIndex[:0]
+		for id, s := range m.evictScores {
+			m.evictIndex = append(m.evictIndex, evictItem{txid: id, score: s})
+		}
+		heap.Init(&m.evictIndex)
+	}
+}
+
+// currentUnsafe it 還是這筆交易現在的分數 (交易還在、分數沒變過)
+func (m *Mempool) currentUnsafe(it evictItem) bool {
+	s, ok := m.evictScores[it.txid]
+	return ok && s == it.score
+}
+
+// pendingTx 還沒進池的新交易 (模擬修剪用)；ancestors 是它在池子裡的所有祖先
+type pendingTx struct {
+	id        string
+	fee, size int
+	ancestors map[string]bool
+}
+
+// planTrimUnsafe 模擬把池子修剪到 MaxBytes 以內，池子本身不動：回傳依序要踢掉的交易 (各自連同子孫)
+// gone 是模擬前先拿掉的交易 (RBF 要換掉的，必須連同子孫)，add 是先放進來的新交易 (可以是 nil)；
+// 輪到新交易或它的祖先被踢時回傳 ok = false
+func (m *Mempool) planTrimUnsafe(gone map[string]bool, add *pendingTx) (plan []evictItem, ok bool) {
+	if m.MaxBytes <= 0 {
+		return nil, true
+	}
+
+	// 模擬中被拿掉的交易，以及祖先因此 (或因為新交易) 變動的子孫整包統計
+	type delta struct{ fee, size int }
+	removed := make(map[string]bool)
+	adj := make(map[string]delta)
+	bytes := m.bytes
+	var local evictQueue // 分數被模擬改過的交易另外排
+
+	simScore := func(id string) blockchain.FeeRate {
+		e, d := m.entries[id], adj[id]
+		return evictScore(e.Fee, e.Size, e.DescendantFee+d.fee, e.DescendantSize+d.size)
+	}
+	// removeSet 拿掉一整組交易 (一筆連同它的子孫)，還留著的祖先扣掉它們、重新排分數
+	removeSet := func(ids []string) {
+		for _, id := range ids {
+			removed[id] = true
+			bytes -= int64(m.entries[id].Size)
+		}
+		touched := make(map[string]bool)
+		for _, id := range ids {
+			e := m.entries[id]
+			for _, a := range m.ancestorsUnsafe(id) {
+				if removed[a] {
+					continue
+				}
+				d := adj[a]
+				d.fee -= e.Fee
+				d.size -= e.Size
+				adj[a] = d
+				touched[a] = true
+			}
+		}
+		for a := range touched {
+			heap.Push(&local, evictItem{txid: a, score: simScore(a)})
+		}
+	}
+
+	var gones []string
+	for id := range gone {
+		gones = append(gones, id)
+	}
+	removeSet(gones)
+
+	var addItem evictItem
+	if add != nil {
+		bytes += int64(add.size)
+		addItem = evictItem{txid: add.id, score: blockchain.NewFeeRate(add.fee, add.size)}
+		for a := range add.ancestors {
+			d := adj[a]
+			d.fee += add.fee
+			d.size += add.size
+			adj[a] = d
+			heap.Push(&local, evictItem{txid: a, score: simScore(a)})
+		}
+	}
+
+	// 從索引拿出來的項目最後放回去 (真的踢掉時才會變成過期項目)
+	var popped []evictItem
+	defer func() {
+		for _, it := range popped {
+			if m.currentUnsafe(it) {
+				heap.Push(&m.evictIndex, it)
+			}
+		}
+	}()
+
+	for bytes > m.MaxBytes {
+		// 索引頂端：過期的、模擬裡已經拿掉的、分數被模擬改過的 (在 local 裡) 都跳過
+		for m.evictIndex.Len() > 0 {
+			top := m.evictIndex[0]
+			if _, changed := adj[top.txid]; m.currentUnsafe(top) && !removed[top.txid] && !changed {
+				break
+			}
+			popped = append(popped, heap.Pop(&m.evictIndex).(evictItem))
+		}
+		for local.Len() > 0 {
+			top := local[0]
+			if !removed[top.txid] && top.score == simScore(top.txid) {
+				break
+			}
+			heap.Pop(&local)
+		}
+
+		var next evictItem
+		found := false
+		if m.evictIndex.Len() > 0 {
+			next, found = m.evictIndex[0], true
+		}
+		if local.Len() > 0 && (!found || local[0].less(next)) {
+			next, found = local[0], true
+		}
+		if add != nil && (!found || addItem.less(next)) {
+			next, found = addItem, true
+		}
+		if !found {
+			break
+		}
+		if add != nil && (next.txid == add.id || add.ancestors[next.txid]) {
+			return nil, false
+		}
+
+		if m.evictIndex.Len() > 0 && m.evictIndex[0] == next {
+			popped = append(popped, heap.Pop(&m.evictIndex).(evictItem))
+		} else {
+			heap.Pop(&local)
+		}
+		set := []string{next.txid}
+		for _, d := range m.descendantsUnsafe(next.txid) {
+			if !removed[d] {
+				set = append(set, d)
+			}
+		}
+		removeSet(set)
+		plan = append(plan, next)
+	}
+	return plan, true
+}
+
+// applyTrimUnsafe 照 planTrimUnsafe 的結果把交易 (連同子孫) 踢掉，回傳一共踢了幾筆
+func (m *Mempool) applyTrimUnsafe(plan []evictItem) int {
+	removed := 0
+	for _, it := range plan {
+		gone := m.removeWithDescendantsUnsafe(it.txid)
+		removed += len(gone)
+
+		log.Printf("🧹 [Mempool Eviction] 池子超過 %d bytes，踢掉低費率交易: %s (%s，連同子孫共 %d 筆)\n",
+			m.MaxBytes, short(it.txid), it.score, len(gone))
+	}
+	return removed
+}
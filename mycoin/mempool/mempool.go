@@ -10,6 +10,24 @@ import (
 	"time"
 )
 
+const (
+	// MinRelayFeeRate 進 Mempool (以及轉發) 的最低費率：1000 vB 以內的交易至少 1 YiCent
+	MinRelayFeeRate blockchain.FeeRate = 1
+	// IncrementalRelayFeeRate RBF 替換時，新交易除了付清被換掉的手續費，還要以這個費率再付一次自己的大小
+	IncrementalRelayFeeRate blockchain.FeeRate = 1
+
+	// DefaultMaxBytes 池子預設的總大小上限 (vbytes)
+	DefaultMaxBytes = 300 << 20
+	// DefaultExpiry 交易進池超過這麼久還沒上鏈就丟掉
+	DefaultExpiry = 14 * 24 * time.Hour
+)
+
+// CongestionFeeRate 池子裡排了 count 筆交易時的建議費率 (礦工的打包門檻跟錢包的 estimatefee 用同一個公式)
+// 沒人排隊就是最低轉發費率，每多 5 筆再加 2 YiCent / 1000 vB
+func CongestionFeeRate(count int) blockchain.FeeRate {
+	return MinRelayFeeRate + blockchain.FeeRate((count/5)*2)
+}
+
 type Mempool struct {
 	Txs      map[string][]byte
 	mu       sync.Mutex
@@ -17,15 +35,30 @@ type Mempool struct {
 	Parents  map[string][]string // child → parents
 	Children map[string][]string // parent → children
 	Sources  map[string]uint64
-	MaxTx    int
-	DB       *database.BoltDB
-	Times    map[string]int64 // 👈 探長的打卡鐘：TxID -> Unix 時間戳
+	MaxBytes int64         // 池子總大小上限 (vbytes)，超過就從費率最低的開始踢
+	Expiry   time.Duration // 進池超過這麼久還沒上鏈就丟掉 (0 = 永不過期)
+
+	AncestorLimit   int // 一筆交易連同祖先最多幾筆 (0 = 不限)
+	DescendantLimit int // 一筆交易連同子孫最多幾筆 (0 = 不限)
 
+	DB    database.Store
+	Times map[string]int64 // 👈 探長的打卡鐘：TxID -> Unix 時間戳
+
+	entries map[string]*TxEntry
+	bytes   int64 // 目前所有交易的總大小
+
+	evictIndex  evictQueue                    // 淘汰分數由低到高 (見 evict.go)
+	evictScores map[string]blockchain.FeeRate // 每筆交易現在的淘汰分數
 }
 
+// Reset 只清記憶體 (資料庫裡的交易還在，之後可以重新 Load)
 func (m *Mempool) Reset() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
+	m.resetUnsafe()
+}
+
+func (m *Mempool) resetUnsafe() {
 	m.Txs = make(map[string][]byte)
 
 	// 🛡️ 探長加碼：清空的時候也要重新給一個新櫃子
@@ -35,22 +68,22 @@ func (m *Mempool) Reset() {
 	m.Spent = make(map[string]string)
 	m.Parents = make(map[string][]string)
 	m.Children = make(map[string][]string)
+	m.entries = make(map[string]*TxEntry)
+	m.bytes = 0
+	m.evictIndex = nil
+	m.evictScores = make(map[string]blockchain.FeeRate)
 }
 
-func NewMempool(maxTx int, db *database.BoltDB) *Mempool {
-	return &Mempool{
-		Times: make(map[string]int64),
-		Txs:   make(map[string][]byte),
-		// ==========================================
-		// 🌟 探長提醒：這個專屬身分證的櫃子終於買進來了！
-		// ==========================================
-		Sources:  make(map[string]uint64),
-		Spent:    make(map[string]string),
-		Parents:  make(map[string][]string),
-		Children: make(map[string][]string),
-		MaxTx:    maxTx,
-		DB:       db,
+func NewMempool(maxBytes int64, expiry time.Duration, db database.Store) *Mempool {
+	m := &Mempool{
+		MaxBytes:        maxBytes,
+		Expiry:          expiry,
+		AncestorLimit:   DefaultAncestorLimit,
+		DescendantLimit: DefaultDescendantLimit,
+		DB:              db,
 	}
+	m.resetUnsafe()
+	return m
 }
 func utxoKey(txid string, index int) string {
 	return fmt.Sprintf("%s_%d", txid, index)
@@ -63,56 +96,169 @@ func (m *Mempool) Has(txid string) bool {
 	return ok
 }
 
-func (m *Mempool) AddTxRBF(txid string, txBytes []byte, utxo *blockchain.UTXOSet, fromNodeID uint64) bool {
+// spendCtx 是下一個區塊 (高度 / 時間)，還不能上鏈的交易 (LockTime 未到) 一律不收
+func (m *Mempool) AddTxRBF(txid string, txBytes []byte, utxo *blockchain.UTXOSet, fromNodeID uint64, spendCtx blockchain.SpendContext) bool {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
+	if _, ok := m.Txs[txid]; ok {
+		return false
+	}
 	newTx, err := blockchain.DeserializeTransaction(txBytes)
 	if err != nil {
 		return false
 	}
 
+	if !newTx.IsFinal(spendCtx) {
+		fmt.Printf("⏳ [Mempool] 拒絕尚未 final 的交易 %s (locktime %d)\n", short(txid), newTx.LockTime)
+		return false
+	}
+
+	// ⌛ 先丟過期的 (跟新交易無關的例行清理；過期的父交易不能再被花)
+	m.expireUnsafe(time.Now())
+
+	// 💸 一律比費率 (每 vB 付多少)，大交易不能用一樣的總手續費佔更多區塊空間
 	newFee := newTx.Fee(utxo, m.Txs)
-	// 🕵️ 大偵探建議：定義一個最小增量 (0.01 YiCoin)
-	const MinIncrementalFee = 1
+	newSize := newTx.VSize()
+	newRate := blockchain.NewFeeRate(newFee, newSize)
 
-	// 3️⃣ RBF：查找衝突
+	// 3️⃣ RBF：查找衝突，整批 (連同子孫) 都要付得起才換 (規則見 rbf.go)
 	conflicts := m.findConflicts(newTx)
+	var evicted map[string]bool
 	if len(conflicts) > 0 {
-		for oldTxid := range conflicts {
-			oldBytes := m.Txs[oldTxid]
-			oldTx, _ := blockchain.DeserializeTransaction(oldBytes)
-			oldFee := oldTx.Fee(utxo, m.Txs)
-
-			// 🚀 修改點：新小費必須比舊的小費多出至少一個門檻
-			if newFee < oldFee+MinIncrementalFee {
-				fmt.Printf("🚫 [RBF] 拒絕替換：新小費 %.2f 不足以覆蓋舊小費 %.2f (需多於 %.2f)\n",
-					float64(newFee)/100.0, float64(oldFee)/100.0, float64(MinIncrementalFee)/100.0)
-				return false
-			}
+		evicted, err = m.checkReplacementUnsafe(newTx, newFee, newSize, conflicts)
+		if err != nil {
+			fmt.Printf("🚫 [RBF] 拒絕替換 %s: %v\n", short(txid), err)
+			return false
 		}
+	}
+
+	// ⛓️ 未確認的交易鏈不能無限長 (組包、踢交易都要走整條鏈)；要被換掉的不算
+	ancestors := m.ancestorSetUnsafe(newTx)
+	if err := m.checkChainLimitsUnsafe(ancestors, evicted); err != nil {
+		fmt.Printf("⛓️ [Mempool] 拒絕交易 %s: %v\n", short(txid), err)
+		return false
+	}
+
+	// 🔥 Mempool Eviction (汰弱留強)：先模擬換掉衝突、放進新交易之後修剪到大小上限；
+	// 新交易自己 (或它的祖先) 會被踢的話直接拒收，池子 (包括衝突交易) 完全不動
+	fee := newFee
+	if fee < 0 {
+		fee = 0 // 跟 addTxUnsafe 一樣
+	}
+	trim, ok := m.planTrimUnsafe(evicted, &pendingTx{id: txid, fee: fee, size: newSize, ancestors: ancestors})
+	if !ok {
+		fmt.Printf("🧹 [Mempool] 池子已滿，新交易 %s 費率 %s 排不進去\n", short(txid), newRate)
+		return false
+	}
 
+	if len(conflicts) > 0 {
 		for oldTxid := range conflicts {
-			m.removeTxUnsafe(oldTxid)
+			m.removeWithDescendantsUnsafe(oldTxid)
 		}
+		log.Printf("🔁 [RBF] 交易 %s (%s) 替換掉 %d 筆交易 (含子孫)\n", short(txid), newRate, len(evicted))
 	}
 
-	// 🔥 Mempool Eviction (汰弱留強)
-	if len(m.Txs) >= m.MaxTx {
-		lowestTxid, lowestFee := m.findLowestFeeTx(utxo)
-		if lowestTxid == "" || newFee <= lowestFee {
-			return false
+	m.addTxUnsafe(txid, newTx, txBytes, newFee, fromNodeID, 0)
+	m.applyTrimUnsafe(trim)
+	return true
+}
+
+// Restore 把已經驗證過的交易直接放回池子 (鏈重組、重啟載入用)：不查費率、不做 RBF、不檢查大小
+// 進池時間沿用 enterTime (0 = 資料庫裡的紀錄，沒有就用現在)；放完要呼叫 Limit
+func (m *Mempool) Restore(txid string, txBytes []byte, utxo *blockchain.UTXOSet, enterTime int64, fromNodeID uint64) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.Txs[txid]; ok {
+		return false
+	}
+	tx, err := blockchain.DeserializeTransaction(txBytes)
+	if err != nil {
+		return false
+	}
+	m.addTxUnsafe(txid, tx, txBytes, tx.Fee(utxo, m.Txs), fromNodeID, enterTime)
+	return true
+}
+
+// Load 從資料庫載回上次的交易池 (進池時間與來源一起)，回傳載入的筆數
+// 父交易要先放，子交易才算得出手續費，所以一直掃到沒有新交易能放為止
+func (m *Mempool) Load(utxo *blockchain.UTXOSet) int {
+	if m.DB == nil {
+		return 0
+	}
+	stored := make(map[string][]byte)
+	m.DB.Iterate(database.BucketMempool, func(k, v []byte) {
+		stored[string(k)] = append([]byte(nil), v...)
+	})
+
+	count := 0
+	for progress := true; progress; {
+		progress = false
+		for txid, txBytes := range stored {
+			if m.hasMempoolParent(txBytes, stored) {
+				continue
+			}
+			if m.Restore(txid, txBytes, utxo, 0, 0) {
+				count++
+			}
+			delete(stored, txid)
+			progress = true
+		}
+	}
+	return count
+}
+
+// hasMempoolParent 交易還有父交易在 pending 裡 (還沒放進池子)
+func (m *Mempool) hasMempoolParent(txBytes []byte, pending map[string][]byte) bool {
+	tx, err := blockchain.DeserializeTransaction(txBytes)
+	if err != nil {
+		return false
+	}
+	for _, in := range tx.Inputs {
+		if _, ok := pending[in.TxID]; ok {
+			return true
 		}
+	}
+	return false
+}
+
+// Limit 丟掉過期的交易，再把池子修剪到 MaxBytes 以內，回傳各丟了幾筆
+func (m *Mempool) Limit(now time.Time) (expired, trimmed int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
 
-		m.removeTxUnsafe(lowestTxid)
+	return m.expireUnsafe(now), m.trimUnsafe()
+}
 
-		// 🚀 修改點：讓日誌印出人類看得懂的小數點
-		log.Printf("🧹 [Mempool Eviction] 踢掉低手續費交易: %s (Fee: %.2f) -> 換入新交易: %s (Fee: %.2f)\n",
-			lowestTxid[:8], float64(lowestFee)/100.0, txid[:8], float64(newFee)/100.0)
+// expireUnsafe 進池超過 Expiry 的交易連同子孫一起丟掉
+func (m *Mempool) expireUnsafe(now time.Time) int {
+	if m.Expiry <= 0 {
+		return 0
 	}
+	cutoff := now.Add(-m.Expiry).Unix()
 
-	m.addTxUnsafe(txid, newTx, txBytes, fromNodeID)
-	return true
+	removed := 0
+	for txid := range m.Txs {
+		if _, ok := m.Txs[txid]; !ok {
+			continue // 已經跟著祖先一起被丟掉了
+		}
+		if t, ok := m.Times[txid]; ok && t < cutoff {
+			gone := m.removeWithDescendantsUnsafe(txid)
+			removed += len(gone)
+		}
+	}
+	if removed > 0 {
+		log.Printf("⌛ [Mempool] %d 筆交易放太久 (超過 %s)，已過期丟掉\n", removed, m.Expiry)
+	}
+	return removed
+}
+
+// trimUnsafe 總大小超過 MaxBytes 時，照淘汰索引從分數最低的交易開始踢 (連同子孫)
+// 分數取「自己」跟「自己 + 子孫整包」費率較高的那個：高費率子交易撐著的父交易不會先被踢
+func (m *Mempool) trimUnsafe() int {
+	plan, _ := m.planTrimUnsafe(nil, nil)
+	return m.applyTrimUnsafe(plan)
 }
 
 func (m *Mempool) Get(txid string) ([]byte, bool) {
@@ -140,14 +286,24 @@ func (m *Mempool) GetAll() map[string][]byte {
 	return out
 }
 
+// Clear 連資料庫一起清空
 func (m *Mempool) Clear() {
 	m.mu.Lock()
 	defer m.mu.Unlock()
 
-	m.Txs = make(map[string][]byte)
-	m.Spent = make(map[string]string)
-	m.Times = make(map[string]int64)    // 👈 探長提醒：加上這行
-	m.Sources = make(map[string]uint64) // 👈 探長提醒：加上這行
+	m.resetUnsafe()
+	if m.DB != nil {
+		m.DB.ClearBucket(database.BucketMempool)
+		m.DB.ClearBucket(database.BucketMempoolTimes)
+		m.DB.ClearBucket(database.BucketMempoolSources)
+	}
+}
+
+// Size 池子裡的交易數與總大小 (vbytes)
+func (m *Mempool) Size() (count int, bytes int64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	return len(m.Txs), m.bytes
 }
 
 func (m *Mempool) HasDoubleSpend(tx *blockchain.Transaction) bool {
@@ -181,25 +337,32 @@ func (m *Mempool) addTxUnsafe(
 	txid string,
 	tx *blockchain.Transaction,
 	txBytes []byte,
+	fee int,
 	fromNodeID uint64, // 👈 已經加上了
+	enterTime int64, // 0 = 查資料庫，沒有就用現在
 ) {
+	if fee < 0 {
+		fee = 0
+	}
+	size := tx.VSize()
 	m.Txs[txid] = txBytes
+	m.entries[txid] = &TxEntry{ID: txid, Tx: tx, Fee: fee, Size: size}
+	m.bytes += int64(size)
 
 	if m.DB != nil {
-		m.DB.Put("mempool", txid, txBytes)
+		m.DB.Put(database.BucketMempool, txid, txBytes)
 	}
 
 	// ==========================================
 	// 🌟 探長的「永恆記憶與身分溯源」持久化打卡鐘
 	// ==========================================
-	var enterTime int64
 	var finalFromID uint64 = fromNodeID // 預設使用這次傳入的 NodeID
-	isNewTransaction := true
+	isNewTransaction := enterTime == 0
 
 	// 1. 嘗試從資料庫找舊時間與舊身分 (防重啟歸零)
-	if m.DB != nil {
+	if m.DB != nil && isNewTransaction {
 		// 找時間
-		timeBytes := m.DB.Get("mempool_times", txid)
+		timeBytes := m.DB.Get(database.BucketMempoolTimes, txid)
 		if len(timeBytes) > 0 {
 			parsedTime, parseErr := strconv.ParseInt(string(timeBytes), 10, 64)
 			if parseErr == nil {
@@ -210,7 +373,7 @@ func (m *Mempool) addTxUnsafe(
 
 		// 🕵️ 探長新增：如果是舊交易，我們得把當時是誰給的 NodeID 讀回來
 		if !isNewTransaction {
-			sourceBytes := m.DB.Get("mempool_sources", txid)
+			sourceBytes := m.DB.Get(database.BucketMempoolSources, txid)
 			if len(sourceBytes) > 0 {
 				parsedID, parseErr := strconv.ParseUint(string(sourceBytes), 10, 64)
 				if parseErr == nil {
@@ -220,18 +383,18 @@ func (m *Mempool) addTxUnsafe(
 		}
 	}
 
-	// 2. 如果是「全新交易」，打上現在的時間、登記來源，並寫入資料庫保存！
+	// 2. 如果是「全新交易」，打上現在的時間；連同來源寫入資料庫保存！
 	if isNewTransaction {
 		enterTime = time.Now().Unix()
-		if m.DB != nil {
-			// 存時間
-			timeStr := strconv.FormatInt(enterTime, 10)
-			m.DB.Put("mempool_times", txid, []byte(timeStr))
-
-			// 🕵️ 探長新增：存來源身分證
-			idStr := strconv.FormatUint(finalFromID, 10)
-			m.DB.Put("mempool_sources", txid, []byte(idStr))
-		}
+	}
+	if m.DB != nil {
+		// 存時間
+		timeStr := strconv.FormatInt(enterTime, 10)
+		m.DB.Put(database.BucketMempoolTimes, txid, []byte(timeStr))
+
+		// 🕵️ 探長新增：存來源身分證
+		idStr := strconv.FormatUint(finalFromID, 10)
+		m.DB.Put(database.BucketMempoolSources, txid, []byte(idStr))
 	}
 
 	// 3. 把最終確定的時間與來源寫入記憶體 map
@@ -246,10 +409,89 @@ func (m *Mempool) addTxUnsafe(
 
 		// 🚀 關鍵修復：直接檢查底層 Map，絕對不要呼叫 m.Has()！
 		if _, exists := m.Txs[in.TxID]; exists {
-			m.Parents[txid] = append(m.Parents[txid], in.TxID)
-			m.Children[in.TxID] = append(m.Children[in.TxID], txid)
+			m.link(in.TxID, txid)
+		}
+	}
+
+	// 👶 子交易比父交易先進池 (重啟載入、鏈重組放回) 時，補上親子關係
+	for i := range tx.Outputs {
+		if child, ok := m.Spent[utxoKey(txid, i)]; ok {
+			m.link(txid, child)
+		}
+	}
+
+	// 📦 自己、所有祖先與子孫的整包統計都要算進這筆
+	m.refreshUnsafe(append(m.relativesUnsafe(txid), txid))
+}
+
+// link 登記 parent → child (同一對只記一次，一筆交易可能花同一個老爸的好幾個輸出)
+func (m *Mempool) link(parent, child string) {
+	for _, p := range m.Parents[child] {
+		if p == parent {
+			return
 		}
 	}
+	m.Parents[child] = append(m.Parents[child], parent)
+	m.Children[parent] = append(m.Children[parent], child)
+}
+
+// unlink 把 txid 從親子關係裡拿掉 (子交易留著，只是不再有這個老爸)
+func (m *Mempool) unlink(txid string) {
+	for _, p := range m.Parents[txid] {
+		m.Children[p] = removeString(m.Children[p], txid)
+		if len(m.Children[p]) == 0 {
+			delete(m.Children, p)
+		}
+	}
+	for _, c := range m.Children[txid] {
+		m.Parents[c] = removeString(m.Parents[c], txid)
+		if len(m.Parents[c]) == 0 {
+			delete(m.Parents, c)
+		}
+	}
+	delete(m.Parents, txid)
+	delete(m.Children, txid)
+}
+
+func removeString(list []string, s string) []string {
+	out := list[:0]
+	for _, v := range list {
+		if v != s {
+			out = append(out, v)
+		}
+	}
+	return out
+}
+
+// descendantsUnsafe txid 在池子裡的所有子孫 (不含自己)
+func (m *Mempool) descendantsUnsafe(txid string) []string {
+	var result []string
+	visited := map[string]bool{txid: true}
+	queue := []string{txid}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		for _, c := range m.Children[cur] {
+			if !visited[c] {
+				visited[c] = true
+				result = append(result, c)
+				queue = append(queue, c)
+			}
+		}
+	}
+	return result
+}
+
+// removeWithDescendantsUnsafe 踢掉 txid 連同所有子孫 (花它輸出的交易少了它就上不了鏈)
+func (m *Mempool) removeWithDescendantsUnsafe(txid string) []string {
+	if _, ok := m.Txs[txid]; !ok {
+		return nil
+	}
+	gone := append([]string{txid}, m.descendantsUnsafe(txid)...)
+	for _, id := range gone {
+		m.removeTxUnsafe(id)
+	}
+	return gone
 }
 
 func (m *Mempool) removeTxUnsafe(txid string) {
@@ -267,36 +509,23 @@ func (m *Mempool) removeTxUnsafe(txid string) {
 			delete(m.Spent, key)
 		}
 	}
+	relatives := m.relativesUnsafe(txid)
+	m.unlink(txid)
+	m.bytes -= int64(m.entries[txid].Size)
+	delete(m.entries, txid)
+	delete(m.evictScores, txid)
 	delete(m.Txs, txid)
 	delete(m.Times, txid)
 	delete(m.Sources, txid)
 
 	if m.DB != nil {
-		m.DB.Delete("mempool", txid)
-	}
-}
-
-func (m *Mempool) findLowestFeeTx(utxo *blockchain.UTXOSet) (string, int) {
-	lowestFee := int(^uint(0) >> 1) // MaxInt
-	lowestTxid := ""
-
-	for txid, txBytes := range m.Txs {
-		tx, err := blockchain.DeserializeTransaction(txBytes)
-		if err != nil {
-			continue
-		}
-
-		// 🚀 修改點：計算手續費時，讓它參考整個 Mempool (m.Txs)
-		// 這樣富兒子的手續費就不會是 0，而是真實的 35 元
-		fee := tx.Fee(utxo, m.Txs)
-
-		if fee < lowestFee {
-			lowestFee = fee
-			lowestTxid = txid
-		}
+		m.DB.Delete(database.BucketMempool, txid)
+		m.DB.Delete(database.BucketMempoolTimes, txid)
+		m.DB.Delete(database.BucketMempoolSources, txid)
 	}
 
-	return lowestTxid, lowestFee
+	// 📦 祖先少了一個子孫、子孫少了一個祖先
+	m.refreshUnsafe(relatives)
 }
 
 func (m *Mempool) Remove(txid string) {
@@ -306,6 +535,14 @@ func (m *Mempool) Remove(txid string) {
 	m.removeTxUnsafe(txid)
 }
 
+// EnterTime 交易進池的時間 (Unix 秒)，不在池子裡回傳 0
+func (m *Mempool) EnterTime(txid string) int64 {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.Times[txid]
+}
+
 func (m *Mempool) GetSource(txid string) uint64 {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -315,3 +552,11 @@ func (m *Mempool) GetSource(txid string) uint64 {
 	}
 	return 0 // 如果找不到或是本地發起的，回傳 0
 }
+
+// short 日誌用的短 ID；ID 可能來自對方 (長度不一定夠)，不能直接切 [:8]
+func short(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
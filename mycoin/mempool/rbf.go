@@ -0,0 +1,102 @@
+package mempool
+
+import (
+	"errors"
+	"fmt"
+
+	"mycoin/blockchain"
+)
+
+// ==========================================
+// 🔁 Full-RBF 替換規則 (仿 BIP125，不需要先標記可替換)
+// ==========================================
+//
+//  1. 一次替換 (衝突交易連同它們的子孫) 最多踢掉 MaxReplacementEvictions 筆
+//  2. 新交易不能花被替換掉的交易的輸出 (替換完那些輸出就不存在了)
+//  3. 新交易不能引入新的未確認輸入，只能花舊交易也花過的 (防止拿低費率的長鏈卡住替換)
+//  4. 新費率要比每一筆直接衝突的交易都高
+//  5. 總手續費要付清所有被踢掉的交易，再以 IncrementalRelayFeeRate 付一次自己的大小
+
+// MaxReplacementEvictions 一次替換最多踢掉幾筆交易 (含子孫)
+const MaxReplacementEvictions = 100
+
+var (
+	ErrTooManyReplacements       = errors.New("replacement would evict too many transactions")
+	ErrReplacementSpendsConflict = errors.New("replacement spends an output of a transaction it replaces")
+	ErrReplacementNewUnconfirmed = errors.New("replacement adds a new unconfirmed input")
+	ErrReplacementFeeRate        = errors.New("replacement fee rate is not higher than the original")
+	ErrReplacementFee            = errors.New("replacement fee does not pay for the evicted transactions")
+	ErrNotInMempool              = errors.New("transaction not in mempool")
+)
+
+// checkReplacementUnsafe 檢查 tx 能不能替換 conflicts，回傳會被踢掉的全部交易 (衝突 + 子孫)
+func (m *Mempool) checkReplacementUnsafe(tx *blockchain.Transaction, fee, size int, conflicts map[string]bool) (map[string]bool, error) {
+	evicted := make(map[string]bool)
+	for c := range conflicts {
+		evicted[c] = true
+		for _, d := range m.descendantsUnsafe(c) {
+			evicted[d] = true
+		}
+	}
+	if len(evicted) > MaxReplacementEvictions {
+		return nil, fmt.Errorf("%w: %d > %d", ErrTooManyReplacements, len(evicted), MaxReplacementEvictions)
+	}
+
+	// 舊交易花過的未確認父交易
+	oldParents := make(map[string]bool)
+	for c := range conflicts {
+		for _, in := range m.entries[c].Tx.Inputs {
+			oldParents[in.TxID] = true
+		}
+	}
+	for _, in := range tx.Inputs {
+		if evicted[in.TxID] {
+			return nil, fmt.Errorf("%w: %s", ErrReplacementSpendsConflict, short(in.TxID))
+		}
+		if _, unconfirmed := m.entries[in.TxID]; unconfirmed && !oldParents[in.TxID] {
+			return nil, fmt.Errorf("%w: %s", ErrReplacementNewUnconfirmed, short(in.TxID))
+		}
+	}
+
+	newRate := blockchain.NewFeeRate(fee, size)
+	for c := range conflicts {
+		if oldRate := m.entries[c].FeeRate(); newRate <= oldRate {
+			return nil, fmt.Errorf("%w: %s <= %s (%s)", ErrReplacementFeeRate, newRate, oldRate, short(c))
+		}
+	}
+
+	if minFee := m.minReplacementFeeUnsafe(conflicts, evicted, size); fee < minFee {
+		return nil, fmt.Errorf("%w: %d < %d", ErrReplacementFee, fee, minFee)
+	}
+	return evicted, nil
+}
+
+// minReplacementFeeUnsafe 付清被踢掉的手續費 + 自己的轉發費用，而且費率要高過每一筆直接衝突
+func (m *Mempool) minReplacementFeeUnsafe(conflicts, evicted map[string]bool, size int) int {
+	minFee := IncrementalRelayFeeRate.FeeFor(size)
+	for id := range evicted {
+		minFee += m.entries[id].Fee
+	}
+	for c := range conflicts {
+		if byRate := (m.entries[c].FeeRate() + 1).FeeFor(size); byRate > minFee {
+			minFee = byRate
+		}
+	}
+	return minFee
+}
+
+// MinReplacementFee 要替換池子裡的 txid (連同它的子孫)，大小 size 的新交易至少要付多少手續費 (bumpfee 用)
+func (m *Mempool) MinReplacementFee(txid string, size int) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if _, ok := m.entries[txid]; !ok {
+		return 0, ErrNotInMempool
+	}
+	conflicts := map[string]bool{txid: true}
+	evicted := map[string]bool{txid: true}
+	for _, d := range m.descendantsUnsafe(txid) {
+		evicted[d] = true
+	}
+	return m.minReplacementFeeUnsafe(conflicts, evicted, size), nil
+}
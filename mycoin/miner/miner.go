@@ -7,16 +7,15 @@ import (
 	"mycoin/blockchain"
 	"mycoin/mempool"
 	"mycoin/utils"
-
-	"sort"
 )
 
 type MinerNode interface {
 	GetBestBlock() *blockchain.Block
 	GetUTXO() *blockchain.UTXOSet
 	GetTarget() *big.Int
-	GetReward() int
+	GetReward(height uint64) int
 	GetCurrentTarget() *big.Int
+	GetMedianTimePast() int64
 	GetMempool() *mempool.Mempool
 	AddBlockInterface(blk *blockchain.Block) error
 
@@ -29,9 +28,16 @@ type MinerNode interface {
 	Unlock() // 👈 增加這行
 }
 
+// TxPackage 一筆交易連同它還沒上鏈的祖先 (子交易離不開父交易，要一起打包)
 type TxPackage struct {
-	Txs []*blockchain.Transaction
-	Fee int
+	Txs   []*blockchain.Transaction
+	Fee   int // 整包的手續費
+	VSize int // 整包的 vbytes
+}
+
+// FeeRate 整包的費率 (祖先一起算：高費率的子交易可以帶著低費率的父交易上鏈)
+func (p TxPackage) FeeRate() blockchain.FeeRate {
+	return blockchain.NewFeeRate(p.Fee, p.VSize)
 }
 
 type Miner struct {
@@ -61,80 +67,44 @@ func (m *Miner) Mine(includeMempool bool) *blockchain.Block {
 	}
 	originalTip := prev.Hash // 記住我們是基於哪個塊開始挖的 (例如高度 39)
 
-	// --- (中間打包交易的部分保持不變) ---
-	const MaxTxPerBlock = 5
+	// --- (中間打包交易的部分) ---
 	var txs []blockchain.Transaction
-	included := make(map[string]bool)
 	totalFee := 0
 
 	if includeMempool {
-		pkgs := m.buildPackages()
-
-		// 1. 按手續費排序 (你原本就有的代碼)
-		sort.Slice(pkgs, func(i, j int) bool {
-			return pkgs[i].Fee > pkgs[j].Fee
-		})
-
-		// ==========================================
-		// 🕵️ 大偵探修改：繞過介面限制，直接計算 pkgs 裡的交易總數！
-		// ==========================================
-		mempoolSize := 0
-		for _, pkg := range pkgs {
-			mempoolSize += len(pkg.Txs) // 把每個包裹裡的交易數量加起來
-		}
+		// ⏳ 這個模板要放在 prev 之後，LockTime 還沒到的交易不能打包 (時間看 prev 的 MTP，跟驗證區塊時一樣)
+		spendCtx := blockchain.SpendContext{Height: prev.Height + 1, Time: m.Node.GetMedianTimePast()}
+		entries, sigOps := m.buildEntries(spendCtx)
 
-		baseFee := 1 // 基礎底價 (沒人排隊時，至少要 1 元才幫忙打包)
+		mempoolSize := len(entries) // 排隊中、可以打包的交易數
 
-		// 計算擁堵溢價 (Congestion Premium)
-		congestionPremium := (mempoolSize / 5) * 2
+		// 動態最低費率：最低轉發費率加上擁堵溢價 (Congestion Premium)
+		dynamicMinFeeRate := mempool.CongestionFeeRate(mempoolSize)
 
-		// 最終算出來的動態最低手續費
-		dynamicMinFee := baseFee + congestionPremium
+		fmt.Printf("📊 [Miner 報價中心] 排隊數: %d | 本期門檻: %s\n", mempoolSize, dynamicMinFeeRate)
 
-		fmt.Printf("📊 [Miner 報價中心] 排隊數: %d | 本期門檻: %.2f YiCoin\n", mempoolSize, float64(dynamicMinFee)/100.0)
-		// 2. 開始遍歷包裹
+		// 2. 依整包費率裝箱，直到區塊裝滿 (區塊頭與 Coinbase 的空間先預留)
+		pkgs := selectPackages(entries, sigOps, blockchain.MaxBlockSize-blockReserve, blockchain.MaxBlockSigOps, dynamicMinFeeRate)
 		for _, pkg := range pkgs {
-
-			// 🚀 把原本的 MinPackageFee 換成我們算出來的 dynamicMinFee
-			if pkg.Fee < dynamicMinFee {
-				fmt.Printf("⚠️ [Miner] 忽略低手續費包裹 (%.2f < %.2f)\n", float64(pkg.Fee)/100.0, float64(dynamicMinFee)/100.0)
-				continue
-			}
-			// ==========================================
-
-			if len(txs)+len(pkg.Txs) > MaxTxPerBlock {
-				continue // 裝不下就先跳過這個包裹，等下一個區塊再打包
-			}
-
-			// 2. 確定裝得下！把「整個包裹的手續費」加進礦工口袋 (只加一次！)
+			// 把「整個包裹的手續費」加進礦工口袋；包裹之間不會重複 (已打包的祖先不再算進後面的包裹)
 			totalFee += pkg.Fee
 
-			// 3. 拆開包裹，把交易塞進區塊
+			// 👷 探長指示：毒交易等一下交給 Node.go 裡的 AddBlock 去抓！
 			for _, tx := range pkg.Txs {
-				if tx == nil {
-					continue
-				}
-
-				// 已經被別人打包過的就跳過
-				if included[tx.ID] {
-					continue
-				}
-
-				// 👷 探長指示：因為我們改用「核彈排毒法 (if n.AddBlock)」，
-				// 這裡不再需要 X光安檢門了！直接無腦打包！
-				// 毒交易等一下交給 Node.go 裡的 AddBlock 去抓！
-
 				txs = append(txs, *tx)
-				included[tx.ID] = true
 			}
 		}
+		if skipped := mempoolSize - len(txs); skipped > 0 {
+			fmt.Printf("⚠️ [Miner] %d 筆交易這次沒打包 (費率低於門檻或區塊已滿)\n", skipped)
+		}
 	}
 
 	// Coinbase 交易
 	cb := blockchain.NewCoinbase(
 		m.Address,
-		m.Node.GetReward()+totalFee,
-		"", // 👈 就是這個空字串！
+		m.Node.GetReward(prev.Height+1)+totalFee,
+		prev.Height+1, // 👈 高度承諾，Coinbase ID 因此唯一
+		"",
 	)
 	// ------------------------------------
 
@@ -151,11 +121,18 @@ func (m *Miner) Mine(includeMempool bool) *blockchain.Block {
 		txs, // 👈 現在這個 txs 裡面，已經包含了熱騰騰的 cb 礦工獎勵了！
 		m.Node.GetCurrentTarget(),
 		m.Address,
-		m.Node.GetReward(),
+		m.Node.GetReward(prev.Height+1),
 	)
 
+	// ⏱ 時間戳必須大於鏈頭的 MTP (同一秒連續出塊時往後推)
+	if mtp := m.Node.GetMedianTimePast(); block.Timestamp <= mtp {
+		block.Timestamp = mtp + 1
+	}
+
 	// 確保 Bits 正確設置 (這是為了網路傳輸驗證)
 	block.Bits = utils.BigToCompact(block.Target)
+	// 驗證端只看得到 Bits，挖礦也要用 Bits 還原的 Target，否則可能挖出別人不收的塊
+	block.Target = utils.CompactToBig(block.Bits)
 
 	// 3. 🔥🔥🔥 挖礦與中斷檢測 (核心修改) 🔥🔥🔥
 	ok := block.Mine(func() bool {
@@ -195,118 +172,3 @@ func (m *Miner) Mine(includeMempool bool) *blockchain.Block {
 	// 挖礦成功，返回區塊
 	return block
 }
-func (m *Miner) collectAncestors(txid string, visited map[string]bool) []*blockchain.Transaction {
-	if visited[txid] {
-		return nil
-	}
-	visited[txid] = true
-
-	// 1️⃣ 獲取 Mempool 並鎖定，防止遞迴過程中資料被修改
-	mp := m.Node.GetMempool()
-	// 這裡建議在呼叫 collectAncestors 的最外層（buildPackages）就上鎖
-	// 但如果要在這裡處理，我們需要確保不會造成死鎖 (Deadlock)
-
-	var result []*blockchain.Transaction
-
-	// 🛡️ 防護罩 1：安全讀取 Parents
-	parents, pExists := mp.Parents[txid]
-	if pExists {
-		for _, parent := range parents {
-			anc := m.collectAncestors(parent, visited)
-			if anc != nil {
-				result = append(result, anc...)
-			}
-		}
-	}
-
-	// 🛡️ 防護罩 2：安全讀取交易資料
-	txBytes, exists := mp.Txs[txid]
-	if !exists || len(txBytes) == 0 { // 👈 多檢查長度，防止 DeserializeTransaction 吃到空字節
-		return result
-	}
-
-	tx, err := blockchain.DeserializeTransaction(txBytes)
-	if err != nil || tx == nil {
-		fmt.Printf("⚠️ [Miner] 交易 %s 資料損毀或格式錯誤: %v\n", txid[:8], err)
-		return result
-	}
-
-	result = append(result, tx)
-	return result
-}
-
-func (m *Miner) buildPackages() []TxPackage {
-	// 1️⃣ 第一步：凍結現場 (上鎖)
-	m.Node.Lock()
-	defer m.Node.Unlock()
-
-	var pkgs []TxPackage
-	// 🕵️ 新增：全域訪問標記，確保每筆交易只被處理一次
-	globalVisited := make(map[string]bool)
-
-	// 這裡我們直接操作 mp 簡化代碼
-	mp := m.Node.GetMempool()
-
-	for txid := range mp.Txs {
-		// 如果這筆交易已經在之前的某個包裹裡了，直接跳過
-		if globalVisited[txid] {
-			continue
-		}
-
-		// 收集這筆交易及其所有未確認的祖先
-		localVisited := make(map[string]bool)
-		txs := m.collectAncestors(txid, localVisited)
-
-		// 標記這些交易，防止後續重複打包
-		for _, t := range txs {
-			globalVisited[t.ID] = true
-		}
-
-		// ==========================================
-		// 🕵️ 透視算帳法：開始算這整包的價值
-		// ==========================================
-		packageFee := 0
-		for _, tx := range txs {
-			if tx == nil {
-				continue
-			}
-
-			totalOut := 0
-			for _, out := range tx.Outputs {
-				totalOut += out.Amount
-			}
-
-			totalIn := 0
-			for _, in := range tx.Inputs {
-				utxoKey := fmt.Sprintf("%s_%d", in.TxID, in.Index)
-
-				// [A] 先查已確認的帳本
-				if utxo, ok := m.Node.GetUTXO().Set[utxoKey]; ok {
-					totalIn += utxo.Amount
-				} else {
-					// [B] 去 Mempool 找未確認的老爸
-					if parentTxBytes, exists := mp.Txs[in.TxID]; exists {
-						if len(parentTxBytes) > 0 {
-							parentTx, _ := blockchain.DeserializeTransaction(parentTxBytes)
-							if parentTx != nil && in.Index < len(parentTx.Outputs) {
-								totalIn += parentTx.Outputs[in.Index].Amount
-							}
-						}
-					}
-				}
-			}
-
-			txFee := totalIn - totalOut
-			if txFee > 0 {
-				packageFee += txFee
-			}
-		}
-
-		pkgs = append(pkgs, TxPackage{
-			Txs: txs,
-			Fee: packageFee,
-		})
-	}
-
-	return pkgs
-}
@@ -0,0 +1,177 @@
+package miner
+
+import (
+	"container/heap"
+	"fmt"
+
+	"mycoin/blockchain"
+	"mycoin/mempool"
+)
+
+// blockReserve 區塊頭 + Coinbase 預留的空間 (bytes)，剩下的才拿來裝交易
+const blockReserve = 1000
+
+// buildEntries 從 Mempool 拿一份快取複本 (手續費、大小、祖先都算好了，不用再反序列化)，
+// 連同每筆交易花掉的鎖定腳本要驗幾次簽名 (區塊有 MaxBlockSigOps 上限)
+// 自己或祖先還沒 final 的交易不能打包，直接排除
+func (m *Miner) buildEntries(spendCtx blockchain.SpendContext) (map[string]*mempool.TxEntry, map[string]int) {
+	m.Node.Lock()
+	defer m.Node.Unlock()
+
+	entries := m.Node.GetMempool().Entries()
+	m.dropNotFinal(entries, spendCtx)
+
+	// 花的是帳本裡的 UTXO 或池子裡父交易的輸出
+	utxo := m.Node.GetUTXO()
+	sigOps := make(map[string]int, len(entries))
+	for txid, e := range entries {
+		for _, in := range e.Tx.Inputs {
+			var out blockchain.TxOutput
+			if prev, ok := utxo.Lookup(fmt.Sprintf("%s_%d", in.TxID, in.Index)); ok {
+				out = prev.Output()
+			} else if parent, ok := entries[in.TxID]; ok && in.Index >= 0 && in.Index < len(parent.Tx.Outputs) {
+				out = parent.Tx.Outputs[in.Index]
+			} else {
+				continue
+			}
+			sigOps[txid] += out.SigOpCount()
+		}
+	}
+	return entries, sigOps
+}
+
+// dropNotFinal 拿掉自己或祖先還沒 final 的交易
+func (m *Miner) dropNotFinal(entries map[string]*mempool.TxEntry, spendCtx blockchain.SpendContext) {
+	notFinal := make(map[string]bool)
+	for txid, e := range entries {
+		if !e.Tx.IsFinal(spendCtx) {
+			notFinal[txid] = true
+		}
+	}
+	if len(notFinal) == 0 {
+		return
+	}
+	for txid, e := range entries {
+		if notFinal[txid] {
+			delete(entries, txid)
+			continue
+		}
+		for _, anc := range e.Ancestors {
+			if notFinal[anc] {
+				delete(entries, txid)
+				break
+			}
+		}
+	}
+}
+
+// selectPackages 依「祖先費率」裝箱 (仿 Bitcoin Core 的 modified ancestor score)：
+// 每次挑 (還沒打包的祖先 + 自己) 整包費率最高的那一包，塞得下就放進區塊；
+// 打包之後只更新這些交易的子孫 (整包統計扣掉剛打包的祖先)，不用每一輪重算整個池子，
+// 直到區塊滿了或剩下的包裹都低於 minRate；整包要驗的簽名數 (sigOps) 也不能超過 maxSigOps
+func selectPackages(entries map[string]*mempool.TxEntry, sigOps map[string]int, maxSize, maxSigOps int, minRate blockchain.FeeRate) []TxPackage {
+	// Ancestors 是完整的池內祖先，反過來就是每筆交易的池內子孫
+	descendants := make(map[string][]string)
+	for id, e := range entries {
+		for _, anc := range e.Ancestors {
+			descendants[anc] = append(descendants[anc], id)
+		}
+	}
+
+	// 一開始就是 Mempool 算好的祖先整包統計
+	scores := make(map[string]packageScore, len(entries))
+	queue := make(packageQueue, 0, len(entries))
+	for id, e := range entries {
+		s := packageScore{id: id, fee: e.AncestorFee, vsize: e.AncestorSize}
+		scores[id] = s
+		queue = append(queue, s)
+	}
+	heap.Init(&queue)
+
+	included := make(map[string]bool)
+	remaining, remainingSigOps := maxSize, maxSigOps
+	var pkgs []TxPackage
+
+	for queue.Len() > 0 {
+		s := heap.Pop(&queue).(packageScore)
+		if included[s.id] || scores[s.id] != s {
+			continue // 過期的分數：祖先打包之後已經放了新的進佇列
+		}
+		if s.fee < minRate.FeeFor(s.vsize) {
+			break // 佇列依費率排，後面的只會更低
+		}
+		if s.vsize > remaining {
+			continue // 塞不下；之後有祖先被打包、包裹變小時會再回到佇列
+		}
+
+		e := entries[s.id]
+		pkg := TxPackage{Fee: s.fee, VSize: s.vsize}
+		pkgSigOps := sigOps[s.id]
+		for _, anc := range e.Ancestors {
+			if !included[anc] {
+				pkg.Txs = append(pkg.Txs, entries[anc].Tx)
+				pkgSigOps += sigOps[anc]
+			}
+		}
+		if pkgSigOps > remainingSigOps {
+			continue // 簽名數超過區塊上限；跟塞不下一樣，祖先被打包後會再回到佇列
+		}
+		pkg.Txs = append(pkg.Txs, e.Tx)
+		for _, tx := range pkg.Txs {
+			included[tx.ID] = true
+		}
+
+		// 子孫的包裹不再包含剛打包的交易
+		for _, tx := range pkg.Txs {
+			te := entries[tx.ID]
+			for _, d := range descendants[tx.ID] {
+				if included[d] {
+					continue
+				}
+				ds := scores[d]
+				ds.fee -= te.Fee
+				ds.vsize -= te.Size
+				scores[d] = ds
+				heap.Push(&queue, ds)
+			}
+		}
+
+		remaining -= pkg.VSize
+		remainingSigOps -= pkgSigOps
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs
+}
+
+// packageScore 一筆交易連同還沒打包的祖先，整包的手續費與大小
+type packageScore struct {
+	id    string
+	fee   int
+	vsize int
+}
+
+// better 費率高的先；同費率時手續費高的先；再一樣就照 txid，結果才不會每次不一樣
+func (a packageScore) better(b packageScore) bool {
+	ra, rb := blockchain.NewFeeRate(a.fee, a.vsize), blockchain.NewFeeRate(b.fee, b.vsize)
+	if ra != rb {
+		return ra > rb
+	}
+	if a.fee != b.fee {
+		return a.fee > b.fee
+	}
+	return a.id < b.id
+}
+
+// packageQueue 整包費率由高到低的 heap (container/heap)
+type packageQueue []packageScore
+
+func (q packageQueue) Len() int            { return len(q) }
+func (q packageQueue) Less(i, j int) bool  { return q[i].better(q[j]) }
+func (q packageQueue) Swap(i, j int)       { q[i], q[j] = q[j], q[i] }
+func (q *packageQueue) Push(x interface{}) { *q = append(*q, x.(packageScore)) }
+func (q *packageQueue) Pop() interface{} {
+	old := *q
+	s := old[len(old)-1]
+	*q = old[:len(old)-1]
+	return s
+}
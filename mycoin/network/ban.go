@@ -0,0 +1,79 @@
+package network
+
+import (
+	"log"
+	"net"
+	"time"
+)
+
+const (
+	// 違規分數累積到 100 就斷線並封鎖 (比照 Bitcoin Core)
+	BanThreshold = 100
+	BanDuration  = 24 * time.Hour
+)
+
+// Misbehave 替 peer 記上違規分數，回傳是否已達封鎖門檻
+func (p *Peer) Misbehave(score int) bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.BanScore += score
+	return p.BanScore >= BanThreshold
+}
+
+// misbehaving 懲罰送來壞資料的鄰居，分數滿了就踢出廣播名單並封鎖 IP
+func (h *Handler) misbehaving(peer *Peer, score int, reason string) {
+	log.Printf("⚠️ [Ban] %s 違規 (+%d): %s\n", peer.Addr, score, reason)
+
+	if !peer.Misbehave(score) {
+		return
+	}
+
+	log.Printf("⛔ [Ban] %s 違規分數已達 %d，斷線並封鎖 %v\n", peer.Addr, BanThreshold, BanDuration)
+
+	if h.Network != nil {
+		h.Network.mu.Lock()
+		if existing, ok := h.Network.Peers[peer.NodeID]; ok && existing == peer {
+			delete(h.Network.Peers, peer.NodeID)
+		}
+		h.Network.mu.Unlock()
+
+		if h.Network.PeerManager != nil {
+			h.Network.PeerManager.Ban(peer.Addr)
+		}
+	}
+
+	peer.Close()
+}
+
+// Ban 封鎖某個位址的 IP (不分 port)
+func (pm *PeerManager) Ban(addr string) {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	pm.Banned[banKey(addr)] = time.Now().Add(BanDuration)
+}
+
+// IsBanned 檢查位址是否仍在封鎖期內，過期的順手清掉
+func (pm *PeerManager) IsBanned(addr string) bool {
+	pm.mu.Lock()
+	defer pm.mu.Unlock()
+
+	key := banKey(addr)
+	until, ok := pm.Banned[key]
+	if !ok {
+		return false
+	}
+	if time.Now().After(until) {
+		delete(pm.Banned, key)
+		return false
+	}
+	return true
+}
+
+func banKey(addr string) string {
+	if host, _, err := net.SplitHostPort(addr); err == nil {
+		return host
+	}
+	return addr
+}
@@ -4,10 +4,15 @@ import (
 	"encoding/base64"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math/big"
+	"sync"
+	"time"
+
 	"mycoin/blockchain"
+	"mycoin/database"
 	"mycoin/node"
 
 	"github.com/mitchellh/mapstructure"
@@ -17,8 +22,18 @@ type Handler struct {
 	Node         *node.Node
 	Network      *Network
 	LocalVersion VersionPayload
+
+	// 📸 快照以下歷史區塊的下載 (一次一批，收齊或逾時才要下一批)
+	historyMu      sync.Mutex
+	historyPending map[string]bool
+	historyAsked   time.Time
 }
 
+const (
+	historyBatch   = 16
+	historyTimeout = 30 * time.Second
+)
+
 func (p *Peer) Close() {
 	if p.Conn != nil {
 		p.Conn.Close()
@@ -68,6 +83,9 @@ func (h *Handler) OnMessage(peer *Peer, msg *Message) {
 	case MsgHeaders:
 		h.handleHeaders(peer, msg)
 
+	case MsgNotFound:
+		h.handleNotFound(peer, msg)
+
 	case "mempool":
 		h.handleMempool(peer, msg)
 
@@ -77,12 +95,12 @@ func (h *Handler) OnMessage(peer *Peer, msg *Message) {
 
 	// ⭐ Fast Sync 完成检测（补丁 #4）
 	if h.Node.IsSyncing && h.Node.HeadersSynced && h.Node.BodiesSynced {
-		fmt.Println("🎉 Fast Sync complete! Rebuilding UTXO...")
+		fmt.Println("🎉 Fast Sync complete! Connecting UTXO...")
 
-		h.Node.RebuildUTXO()
 		h.Node.IsSyncing = false
-
-		fmt.Println("🎉 Node is now fully synced and valid.")
+		if h.catchUpUTXO() {
+			fmt.Println("🎉 Node is now fully synced and valid.")
+		}
 	}
 }
 
@@ -106,10 +124,11 @@ func (h *Handler) handleVersion(peer *Peer, msg *Message) {
 		peer.Send(Message{
 			Type: MsgVersion,
 			Data: VersionPayload{
-				Version: 1,
-				Height:  h.Node.Best.Height,
-				CumWork: h.Node.Best.CumWork,
-				NodeID:  h.Node.NodeID, // 👈 🚨 探長急救 2：遞名片時，記得填上自己的身分證！
+				Version:  1,
+				Height:   h.Node.Best.Height,
+				CumWork:  h.Node.Best.CumWork,
+				NodeID:   h.Node.NodeID, // 👈 🚨 探長急救 2：遞名片時，記得填上自己的身分證！
+				Services: h.LocalServices(),
 			},
 		})
 		peer.State = StateVersionSent
@@ -118,6 +137,7 @@ func (h *Handler) handleVersion(peer *Peer, msg *Message) {
 	// 记录对方的版本信息
 	peer.Height = v.Height
 	peer.CumWork = v.CumWork
+	peer.Services = v.Services
 	peer.State = StateVersionRecv
 
 	// ==========================================================
@@ -196,6 +216,9 @@ func (h *Handler) handleVerAck(peer *Peer, msg *Message) {
 				Locators: h.buildBlockLocator(),
 			},
 		})
+
+		// 📸 從快照啟動的節點順便補歷史區塊
+		h.RequestSnapshotHistory(peer)
 	}
 }
 
@@ -245,7 +268,7 @@ func (h *Handler) handleInv(peer *Peer, msg *Message) {
 
 		for _, txid := range inv.Hashes {
 			if !h.Node.Mempool.Has(txid) {
-				fmt.Printf("📥 [P2P] 看到新交易 %s，準備發送 GetData...\n", txid[:8])
+				fmt.Printf("📥 [P2P] 看到新交易 %s，準備發送 GetData...\n", short(txid))
 				peer.Send(Message{
 					Type: MsgGetData,
 					Data: GetDataPayload{
@@ -272,27 +295,39 @@ func (h *Handler) handleGetData(peer *Peer, msg *Message) {
 	case "block":
 		// 🤫 探長指令：這裡不印日誌保持安靜，但必須把區塊寄出去！
 		bi := h.Node.Blocks[req.Hash]
-		if bi == nil {
+		if bi == nil || !bi.HasBody() {
+			h.sendNotFound(peer, req, "unknown")
+			return
+		}
+		// 🌿 修剪掉的區塊只剩區塊頭，明確告訴對方去找別人要
+		if bi.Pruned {
+			h.sendNotFound(peer, req, "pruned")
+			return
+		}
+		blk, err := h.Node.ReadBlock(bi)
+		if err != nil {
+			log.Printf("⚠️ [P2P] 讀不到區塊 %s: %v", req.Hash, err)
+			h.sendNotFound(peer, req, "unavailable")
 			return
 		}
 
 		// 將區塊打包並發送
-		dto := BlockToDTO(bi.Block, bi)
 		peer.Send(Message{
 			Type: MsgBlock,
-			Data: dto,
+			Data: BlockPayload{Block: blk.Serialize()},
 		})
 
 	case "tx":
 		// ... 這裡是你剛才寫好的交易處理與日誌 (保持原樣) ...
-		fmt.Printf("🕵️ [Windows-Debug] 收到來自 %s 的 GetData，索取【交易】: %s\n", peer.Addr, req.Hash[:8])
+		fmt.Printf("🕵️ [Windows-Debug] 收到來自 %s 的 GetData，索取【交易】: %s\n", peer.Addr, short(req.Hash))
 		tx, ok := h.Node.Mempool.Get(req.Hash)
 		if !ok {
-			fmt.Printf("⚠️ [Windows-Debug] 找不到交易 %s\n", req.Hash[:8])
+			fmt.Printf("⚠️ [Windows-Debug] 找不到交易 %s\n", short(req.Hash))
+			h.sendNotFound(peer, req, "unknown")
 			return
 		}
 
-		fmt.Printf("📤 [P2P-交貨] 找到交易 %s，正在發送 MsgTx 給 %s...\n", req.Hash[:8], peer.Addr)
+		fmt.Printf("📤 [P2P-交貨] 找到交易 %s，正在發送 MsgTx 給 %s...\n", short(req.Hash), peer.Addr)
 		peer.Send(Message{
 			Type: MsgTx,
 			Data: TxPayload{Tx: tx},
@@ -300,26 +335,67 @@ func (h *Handler) handleGetData(peer *Peer, msg *Message) {
 	}
 }
 
+// LocalServices 本節點宣告的服務：修剪節點、還在補歷史的快照節點只能提供最近的區塊
+func (h *Handler) LocalServices() uint64 {
+	if h.Node.IsPruned() || h.Node.SnapshotActive() {
+		return ServiceNetworkLimited
+	}
+	return ServiceNetwork
+}
+
+func (h *Handler) sendNotFound(peer *Peer, req GetDataPayload, reason string) {
+	peer.Send(Message{
+		Type: MsgNotFound,
+		Data: NotFoundPayload{Type: req.Type, Hash: req.Hash, Reason: reason},
+	})
+}
+
+// ======================
+// notfound
+// ======================
+func (h *Handler) handleNotFound(peer *Peer, msg *Message) {
+	var nf NotFoundPayload
+	if err := decode(msg.Data, &nf); err != nil {
+		log.Println("decode notfound error:", err)
+		return
+	}
+	fmt.Printf("🌿 [P2P] %s 沒有 %s %s (%s)\n", peer.Addr, nf.Type, nf.Hash, nf.Reason)
+}
+
 // ======================
 // block
 // ======================
 
 func (h *Handler) handleBlock(peer *Peer, msg *Message) {
-	var dto BlockDTO
-	if err := decode(msg.Data, &dto); err != nil {
+	raw, err := decodeBytes(msg.Data, "block")
+	if err != nil {
 		log.Printf("❌ [Network] Block decode error from %s: %v", peer.Addr, err)
-		// 為了除錯，甚至可以把原始數據印出來看
-		// fmt.Printf("Raw Data: %+v\n", msg.Data)
 		return
 	}
 
-	blk := DTOToBlock(dto)
+	blk, err := blockchain.DeserializeBlock(raw)
+	if err != nil {
+		log.Printf("❌ [Network] Block deserialize error from %s: %v", peer.Addr, err)
+		return
+	}
 	hashHex := hex.EncodeToString(blk.Hash)
 	prevHex := hex.EncodeToString(blk.PrevHash)
 
+	// 📸 快照以下的歷史區塊：交給背景驗證，不走一般的同步流程
+	if h.Node.WantsSnapshotBlock(hashHex) {
+		if !h.Node.AddBlock(blk) {
+			h.misbehaving(peer, 20, "invalid historical block")
+		}
+		h.historyMu.Lock()
+		delete(h.historyPending, hashHex)
+		h.historyMu.Unlock()
+		h.RequestSnapshotHistory(peer)
+		return
+	}
+
 	// 1. 檢查是否已經擁有此塊 (防止重複處理)
 	bi := h.Node.Blocks[hashHex]
-	alreadyHasBody := (bi != nil && bi.Block != nil)
+	alreadyHasBody := (bi != nil && bi.HasBody())
 
 	if alreadyHasBody {
 		// 只有當我們還在同步模式，且收到這個塊所在的鏈「比我們當前的最強鏈工作量更大」時
@@ -366,7 +442,7 @@ func (h *Handler) handleBlock(peer *Peer, msg *Message) {
 	}
 
 	// 情況 B：認識爸爸，但爸爸只有頭沒有身體 (半孤塊)
-	if parent.Block == nil {
+	if !parent.HasBody() {
 		fmt.Printf("⚠️ 父塊 %s 只有標頭缺少實體，將區塊 %d 存入孤立池\n", prevHex, blk.Height)
 		h.Node.AddOrphan(blk)
 
@@ -384,7 +460,7 @@ func (h *Handler) handleBlock(peer *Peer, msg *Message) {
 	// ---------------------------------------------------------
 	// 4. 驗證並寫入資料庫
 	// ---------------------------------------------------------
-	// 能走到這裡，代表 parent 絕對存在，而且 parent.Block 絕對不是 nil！
+	// 能走到這裡，代表 parent 絕對存在，而且 parent 的實體已經收過了！
 	success := h.Node.AddBlock(blk)
 	if !success {
 		// 這裡的失敗就是真的失敗了 (比如雙花、簽名錯誤等惡意攻擊)
@@ -392,8 +468,7 @@ func (h *Handler) handleBlock(peer *Peer, msg *Message) {
 		return
 	}
 
-	// 填充內存資料
-	bi.Block = blk
+	// 填充內存資料 (body 已由 AddBlock 寫進區塊檔)
 	bi.Parent = parent
 
 	// 維護樹狀結構
@@ -520,23 +595,16 @@ func (h *Handler) finishSyncing() bool {
 					bi.Parent = p
 					changed = true
 				} else {
-					// 從硬碟救援指標
-					data := h.Node.DB.Get("blocks", bi.PrevHash)
+					// 從硬碟救援指標 (索引裡記著 body 在區塊檔的位置)
+					data := h.Node.DB.Get(database.BucketIndex, bi.PrevHash)
 
 					// 直接檢查長度即可，nil 也會回傳 0
 					if len(data) > 0 {
-						parentBlock, err := blockchain.DeserializeBlock(data)
-						if err == nil {
-							pIdx := &node.BlockIndex{
-								Hash:      hex.EncodeToString(parentBlock.Hash),
-								Height:    bi.Height - 1,
-								Block:     parentBlock,
-								PrevHash:  hex.EncodeToString(parentBlock.PrevHash),
-								Bits:      parentBlock.Bits,
-								Timestamp: parentBlock.Timestamp,
-							}
-							h.Node.Blocks[pIdx.Hash] = pIdx
-							bi.Parent = pIdx
+						var pIdx node.BlockIndex
+						if err := json.Unmarshal(data, &pIdx); err == nil && pIdx.HasBody() {
+							pIdx.CumWorkInt, _ = new(big.Int).SetString(pIdx.CumWork, 16)
+							h.Node.Blocks[pIdx.Hash] = &pIdx
+							bi.Parent = &pIdx
 							changed = true
 							fmt.Printf("💾 從硬碟救援了高度 %d 的區塊指標\n", pIdx.Height)
 						}
@@ -552,7 +620,7 @@ func (h *Handler) finishSyncing() bool {
 	// 重新尋找最強鏈頭
 	var actualBest *node.BlockIndex
 	for _, bi := range h.Node.Blocks {
-		if bi.Block != nil && (actualBest == nil || bi.Height > actualBest.Height) {
+		if bi.HasBody() && !bi.Failed && (actualBest == nil || bi.Height > actualBest.Height) {
 			actualBest = bi
 		}
 	}
@@ -560,11 +628,11 @@ func (h *Handler) finishSyncing() bool {
 		h.Node.Best = actualBest
 	}
 
-	// 組裝主鏈
-	newMainChain := []*blockchain.Block{}
+	// 組裝主鏈 (只放索引，body 要用時再從區塊檔讀)
+	newMainChain := []*node.BlockIndex{}
 	cur := h.Node.Best
-	for cur != nil && cur.Block != nil {
-		newMainChain = append([]*blockchain.Block{cur.Block}, newMainChain...)
+	for cur != nil && cur.HasBody() {
+		newMainChain = append([]*node.BlockIndex{cur}, newMainChain...)
 		cur = cur.Parent
 	}
 
@@ -573,10 +641,10 @@ func (h *Handler) finishSyncing() bool {
 		fmt.Printf("⚠️ [Sync] 依然斷鏈！目前起點高度: %d\n",
 			func() uint64 {
 				if len(newMainChain) > 0 {
-					fmt.Printf("🕵️ [Debug] 第 1 塊積木(Height: %d) 紀錄的爸爸 Hash 是: %x\n",
+					fmt.Printf("🕵️ [Debug] 第 1 塊積木(Height: %d) 紀錄的爸爸 Hash 是: %s\n",
 						newMainChain[0].Height, newMainChain[0].PrevHash)
 					fmt.Printf("🕵️ [Debug] 我現在記憶體裡的創世塊 Hash 是: %x\n",
-						h.Node.Blocks[hex.EncodeToString(blockchain.NewGenesisBlock(h.Node.Target).Hash)].Hash)
+						h.Node.Blocks[hex.EncodeToString(h.Node.Params.GenesisBlock().Hash)].Hash)
 					return newMainChain[0].Height
 				}
 				return 999
@@ -586,22 +654,37 @@ func (h *Handler) finishSyncing() bool {
 	}
 
 	// 數據寫入正式狀態
-	h.Node.Chain = newMainChain
+	h.Node.UpdateChainFromBest()
 	h.Node.SyncState = node.SyncSynced
 	h.Node.IsSyncing = false
-	h.Node.DB.Put("meta", "best", []byte(h.Node.Best.Hash))
+	// 鏈頭由 CatchUpUTXO 跟新帳本一起寫入
 
 	// ==========================================
 	// 🏆 探長關鍵點：手術做完了，先解鎖！
 	// ==========================================
-	h.Node.Unlock() // 🔓 把鎖放開，讓 RebuildUTXO 可以自己拿鎖
+	h.Node.Unlock() // 🔓 把鎖放開，讓 CatchUpUTXO 可以自己拿鎖
 
-	fmt.Println("💰 鏈條完整！啟動全局帳本重建...")
-	h.Node.RebuildUTXO() // 👈 這行會自己去處理它的鎖
+	fmt.Println("💰 鏈條完整！帳本從上次的鏈頭往前接...")
+	if !h.catchUpUTXO() {
+		return false
+	}
 
 	fmt.Printf("✅ 同步完成！高度: %d\n", h.Node.Best.Height)
 	return true
 }
+
+// catchUpUTXO 同步完把帳本接到新鏈頭；接不完時鏈頭會停在帳本接到的地方，之後再跟鄰居要
+func (h *Handler) catchUpUTXO() bool {
+	err := h.Node.CatchUpUTXO()
+	if errors.Is(err, node.ErrUTXOInconsistent) {
+		log.Fatalf("❌ 同步後的帳本無法回滾: %v", err)
+	}
+	if err != nil {
+		log.Printf("❌ [Sync] 帳本沒辦法接到新鏈頭: %v\n", err)
+		return false
+	}
+	return true
+}
 func (h *Handler) broadcastInvExcept(hash string, except *Peer) {
 	h.Network.mu.Lock()
 	defer h.Network.mu.Unlock()
@@ -657,6 +740,21 @@ func decode(src any, dst any) error {
 	return decoder.Decode(src)
 }
 
+// decodeBytes 取出 payload 中的 []byte 欄位 (JSON 會把它編成 Base64 字串)
+func decodeBytes(src any, key string) ([]byte, error) {
+	dataMap, ok := src.(map[string]interface{})
+	if !ok {
+		return nil, fmt.Errorf("payload is %T, not an object", src)
+	}
+
+	str, ok := dataMap[key].(string)
+	if !ok {
+		return nil, fmt.Errorf("missing %q field", key)
+	}
+
+	return base64.StdEncoding.DecodeString(str)
+}
+
 func (h *Handler) handleGetAddr(peer *Peer, msg *Message) {
 	addrs := h.Network.PeerManager.AddrMgr.GetAll()
 
@@ -734,25 +832,10 @@ func (h *Handler) handleTx(peer *Peer, msg *Message) {
 		return
 	}
 
-	// ==========================================
-	// 🌟 探長終極鑰匙：手動處理 Base64 字串！
-	// ==========================================
-	dataMap, ok := msg.Data.(map[string]interface{})
-	if !ok {
-		fmt.Println("❌ [Kali-Debug] 封包格式錯誤，不是 map[string]interface{}")
-		return
-	}
-
-	txBase64Str, ok := dataMap["tx"].(string)
-	if !ok {
-		fmt.Println("❌ [Kali-Debug] 找不到 'tx' 欄位，或者它不是字串！")
-		return
-	}
-
 	// 1. 將 Base64 字串解碼回原始的二進位位元組 ([]byte)
-	txBytes, err := base64.StdEncoding.DecodeString(txBase64Str)
+	txBytes, err := decodeBytes(msg.Data, "tx")
 	if err != nil {
-		fmt.Printf("❌ [Kali-Debug] Base64 解碼失敗！錯誤: %v\n", err)
+		fmt.Printf("❌ [Kali-Debug] 交易封包解碼失敗！錯誤: %v\n", err)
 		return
 	}
 
@@ -768,22 +851,22 @@ func (h *Handler) handleTx(peer *Peer, msg *Message) {
 		return
 	}
 
-	fmt.Printf("✅ [Kali-Debug] 成功解析交易 %s，準備交給大門保全 (AddTx)...\n", tx.ID[:8])
+	fmt.Printf("✅ [Kali-Debug] 成功解析交易 %s，準備交給大門保全 (AddTx)...\n", short(tx.ID))
 
 	// 3. 交給 Node 處理！(走正門)
 	if ok := h.Node.AddTx(*tx, peer.NodeID); !ok {
-		fmt.Printf("❌ [Kali-Debug] 交易 %s 被 Node.AddTx 拒絕！\n", tx.ID[:8])
+		fmt.Printf("❌ [Kali-Debug] 交易 %s 被 Node.AddTx 拒絕！\n", short(tx.ID))
 		return
 	}
 
-	fmt.Printf("📥 ✅ [P2P] 交易 %s 成功從網路進入 Mempool！\n", tx.ID[:8])
+	fmt.Printf("📥 ✅ [P2P] 交易 %s 成功從網路進入 Mempool！\n", short(tx.ID))
 
 	// 4. 接力廣播給其他節點
 	h.broadcastTxInv(tx.ID)
 }
 func (h *Handler) broadcastTxInv(txid string) {
 	// 🌟 顯影劑 1：確認有沒有進來
-	fmt.Println("🕵️ [Debug] 進入 broadcastTxInv，準備廣播交易:", txid[:8])
+	fmt.Println("🕵️ [Debug] 進入 broadcastTxInv，準備廣播交易:", short(txid))
 
 	// 🛡️ 防禦 1：如果自己還沒同步完，不廣播
 	if h.Node.SyncState != node.SyncSynced {
@@ -820,7 +903,7 @@ func (h *Handler) broadcastTxInv(txid string) {
 	}
 
 	if count > 0 {
-		fmt.Printf("📢 [P2P] 已向 %d 個鄰居廣播交易清單 (Inv): %s\n", count, txid[:8])
+		fmt.Printf("📢 [P2P] 已向 %d 個鄰居廣播交易清單 (Inv): %s\n", count, short(txid))
 	} else {
 		// 🌟 顯影劑 4：鄰居都不理我？
 		fmt.Println("⚠️ [Debug] 廣播跑完了，但是 count 是 0！沒有符合條件的鄰居。")
@@ -870,23 +953,22 @@ func (h *Handler) handleGetHeaders(peer *Peer, msg *Message) {
 	}
 
 	// ------------------------------------------------------------------
-	// 步驟 2: 線性讀取主鏈 (陣列遍歷)
+	// 步驟 2: 依高度讀取主鏈的標頭
+	// body 有沒有到手都照送 (還在下載、修剪掉、快照以下)，對方要的只是標頭鏈
 	// ------------------------------------------------------------------
 	var headers []HeaderDTO
 	const MaxHeaders = 2000
 
 	scanHeight := startHeight + 1
-	chainLen := int64(len(h.Node.Chain))
 
-	for scanHeight < chainLen && len(headers) < MaxHeaders {
-		// 直接從陣列拿，絕對不會錯！
-		block := h.Node.Chain[scanHeight]
+	for len(headers) < MaxHeaders {
+		bi := h.Node.BlockByHeight(uint64(scanHeight))
+		if bi == nil {
+			break
+		}
 
 		// 轉成 HeaderDTO
-		hashHex := hex.EncodeToString(block.Hash)
-		if bi, ok := h.Node.Blocks[hashHex]; ok {
-			headers = append(headers, BlockIndexToHeaderDTO(bi))
-		}
+		headers = append(headers, BlockIndexToHeaderDTO(bi))
 
 		scanHeight++
 	}
@@ -929,41 +1011,26 @@ func (h *Handler) handleHeaders(peer *Peer, msg *Message) {
 		return
 	}
 
-	// 2️⃣ 將收到的 Headers 加入我們的記憶體中 (搭鷹架)
+	// 2️⃣ 逐一驗證後才加入我們的記憶體中 (搭鷹架)
+	// 🛡️ 對方的 CumWork 一律不信，PoW / Bits / 時間戳都由 Node 在本地重驗
 	addedCount := 0
 	for _, hdr := range payload.Headers {
-		if _, ok := h.Node.Blocks[hdr.Hash]; ok {
-			continue // 已經有了，跳過
-		}
-
-		// --- 建立 BlockIndex ---
-		bi := &node.BlockIndex{
-			Hash:      hdr.Hash,
-			PrevHash:  hdr.PrevHash,
-			Height:    hdr.Height,
-			CumWork:   hdr.CumWork,
-			Bits:      hdr.Bits,
-			Timestamp: hdr.Timestamp,
-		}
-		bi.CumWorkInt = new(big.Int)
-		if hdr.CumWork != "" {
-			bi.CumWorkInt.SetString(hdr.CumWork, 16)
-		} else {
-			bi.CumWorkInt.SetInt64(0)
-		}
-
-		h.Node.Blocks[hdr.Hash] = bi
-
-		if parent, ok := h.Node.Blocks[hdr.PrevHash]; ok {
-			bi.Parent = parent
-			parent.Children = append(parent.Children, bi)
+		_, known, err := h.Node.ProcessHeader(HeaderDTOToBlock(hdr))
+		if err != nil {
+			fmt.Printf("❌ [Sync] 拒絕來自 %s 的 Header %d: %v\n", peer.Addr, hdr.Height, err)
+
+			if errors.Is(err, node.ErrOrphanHeader) {
+				// 接不上的標頭可能只是分叉太深，小懲即可
+				h.misbehaving(peer, 20, "unconnecting headers")
+			} else {
+				h.misbehaving(peer, BanThreshold, "invalid header: "+err.Error())
+			}
+			return
 		}
 
-		if h.Node.Best == nil || bi.CumWorkInt.Cmp(h.Node.Best.CumWorkInt) > 0 {
-			h.Node.Best = bi
+		if !known {
+			addedCount++
 		}
-
-		addedCount++
 	}
 
 	// 3️⃣ 狀態判斷與下一步行動
@@ -1004,7 +1071,7 @@ func (h *Handler) requestMissingBlockBodies(peer *Peer) {
 
 	// 1. 收集缺口，限制一次請求的數量（例如 16 個）
 	for bi != nil && bi.Height > 0 {
-		if bi.Block == nil {
+		if !bi.HasBody() {
 			// 注意：我們是往回走，所以收集到的順序是 [新 -> 舊]
 			missingBlocks = append(missingBlocks, bi)
 		}
@@ -1047,6 +1114,47 @@ func (h *Handler) requestMissingBlockBodies(peer *Peer) {
 		// fmt.Println("✅ 檢查完畢，區塊完整，無需動作。")
 	}
 }
+// RequestSnapshotHistory 跟 peer (nil = 隨便挑一個完整節點) 要背景驗證接下來需要的歷史區塊
+// 上一批還沒收齊、也還沒逾時就先不要
+func (h *Handler) RequestSnapshotHistory(peer *Peer) {
+	h.historyMu.Lock()
+	defer h.historyMu.Unlock()
+	if len(h.historyPending) > 0 && time.Since(h.historyAsked) < historyTimeout {
+		return
+	}
+	if peer == nil {
+		peer = h.fullPeer()
+	}
+	// 修剪節點給不出舊區塊
+	if peer == nil || peer.Services&ServiceNetwork == 0 {
+		return
+	}
+
+	hashes := h.Node.SnapshotBlocksToDownload(historyBatch)
+	if len(hashes) == 0 {
+		return
+	}
+	h.historyPending = make(map[string]bool, len(hashes))
+	for _, hash := range hashes {
+		h.historyPending[hash] = true
+		h.requestBlock(peer, hash)
+	}
+	h.historyAsked = time.Now()
+	fmt.Printf("📸 [Snapshot] 向 %s 索取 %d 個歷史區塊\n", peer.Addr, len(hashes))
+}
+
+// fullPeer 隨便挑一個有完整歷史的活躍連線
+func (h *Handler) fullPeer() *Peer {
+	h.Network.mu.Lock()
+	defer h.Network.mu.Unlock()
+	for _, p := range h.Network.Peers {
+		if p.State == StateActive && p.Services&ServiceNetwork != 0 {
+			return p
+		}
+	}
+	return nil
+}
+
 func (h *Handler) requestBlock(peer *Peer, hash string) {
 	peer.Send(Message{
 		Type: MsgGetData,
@@ -1058,33 +1166,13 @@ func (h *Handler) requestBlock(peer *Peer, hash string) {
 }
 
 func (h *Handler) buildBlockLocator() []string {
-	var locators []string
-
-	bi := h.Node.Best
-	step := 1
-	height := 0
-
-	for bi != nil {
-		locators = append(locators, bi.Hash)
-
-		if height >= 10 {
-			step *= 2
-		}
-
-		for i := 0; i < step && bi != nil; i++ {
-			bi = bi.Parent
-		}
-		height++
-	}
-
-	return locators
+	return h.Node.BlockLocator()
 }
 
 // mycoin/network/handle.go
 
 func (h *Handler) BroadcastNewBlock(b *blockchain.Block) {
-	// 準備數據 (這裡假設你的 BlockToDTO 已經修正)
-	dto := BlockToDTO(b, nil)
+	payload := BlockPayload{Block: b.Serialize()}
 
 	log.Printf("📣 [強力廣播] 準備發送區塊: 高度 %d, Hash %x", b.Height, b.Hash)
 
@@ -1100,7 +1188,7 @@ func (h *Handler) BroadcastNewBlock(b *blockchain.Block) {
 		if p.State == StateActive {
 			p.Send(Message{
 				Type: MsgBlock,
-				Data: dto,
+				Data: payload,
 			})
 			fmt.Printf("   -> ✅ 已發送 MsgBlock 給 %s [身分證: %d]\n", p.Addr, nodeID)
 			activeCount++
@@ -1127,7 +1215,7 @@ func (h *Handler) countMissingBlocks() int {
 	for _, bi := range h.Node.Blocks {
 		// 如果只有 Index (標頭已收) 但 Block 欄位是 nil (實體未收)
 		// 且高度大於 0 (創世塊通常我們自己就有，不用算進去)
-		if bi.Block == nil && bi.Height > 0 {
+		if !bi.HasBody() && bi.Height > 0 {
 			missingCount++
 		}
 	}
@@ -1138,3 +1226,11 @@ func (h *Handler) countMissingBlocks() int {
 func (h *Handler) BroadcastTransaction(txid string) {
 	h.broadcastTxInv(txid)
 }
+
+// short 日誌用的短 ID；ID 可能來自對方 (長度不一定夠)，不能直接切 [:8]
+func short(id string) string {
+	if len(id) > 8 {
+		return id[:8]
+	}
+	return id
+}
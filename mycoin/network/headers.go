@@ -2,9 +2,9 @@ package network
 
 import (
 	"encoding/hex"
-	"math/big"
 	"mycoin/blockchain"
 	"mycoin/node"
+	"mycoin/utils"
 )
 
 type HeaderDTO struct {
@@ -24,39 +24,34 @@ type HeadersPayload struct {
 }
 
 func HeaderDTOToBlock(h HeaderDTO) *blockchain.Block {
-	target := new(big.Int)
-	target.SetString(h.Target, 16)
-
 	// 🔥 必须 hex → bytes
 	prevHashBytes, _ := hex.DecodeString(h.PrevHash)
 	hashBytes, _ := hex.DecodeString(h.Hash)
+	merkleBytes, _ := hex.DecodeString(h.MerkleRoot)
 
 	return &blockchain.Block{
-		Height:    h.Height,
-		PrevHash:  prevHashBytes, // []byte
-		Timestamp: h.Timestamp,
-		Nonce:     h.Nonce,
-		Target:    target,
-		Hash:      hashBytes, // []byte
-		Bits:      h.Bits,
+		Height:     h.Height,
+		PrevHash:   prevHashBytes, // []byte
+		Timestamp:  h.Timestamp,
+		Nonce:      h.Nonce,
+		Target:     utils.CompactToBig(h.Bits), // 不信任對方的 Target 字串
+		MerkleRoot: merkleBytes,
+		Hash:       hashBytes, // []byte
+		Bits:       h.Bits,
 	}
 }
 
 func BlockIndexToHeaderDTO(bi *node.BlockIndex) HeaderDTO {
 	dto := HeaderDTO{
-		Hash:      bi.Hash,
-		PrevHash:  bi.PrevHash,
-		Height:    bi.Height,
-		CumWork:   bi.CumWork,
-		Bits:      bi.Bits,
-		Timestamp: bi.Timestamp,
-	}
-
-	if bi.Block != nil { // body downloaded
-		dto.Target = bi.Block.Target.Text(16)
-		dto.Timestamp = bi.Block.Timestamp
-		dto.Nonce = bi.Block.Nonce
-		dto.MerkleRoot = hex.EncodeToString(bi.Block.MerkleRoot)
+		Hash:       bi.Hash,
+		PrevHash:   bi.PrevHash,
+		Height:     bi.Height,
+		CumWork:    bi.CumWork,
+		Bits:       bi.Bits,
+		Timestamp:  bi.Timestamp,
+		Nonce:      bi.Nonce,
+		MerkleRoot: bi.MerkleRoot,
+		Target:     utils.CompactToBig(bi.Bits).Text(16),
 	}
 
 	return dto
@@ -13,20 +13,29 @@ const (
 	MsgGetAddr    MsgType = "getaddr"
 	MsgGetHeaders MsgType = "getheaders" // ✅ 新增
 	MsgHeaders    MsgType = "headers"    // ✅ 新增
+	MsgNotFound   MsgType = "notfound"   // getdata 要的資料本地沒有 (例如已修剪)
 	MsgPing               = "ping"
 	MsgPong               = "pong"
 )
 
 type Message struct {
-	Type MsgType `json:"type" mapstructure:"type"`
-	Data any     `json:"data" mapstructure:"data"`
+	Magic uint32  `json:"magic" mapstructure:"magic"` // 網路識別碼 (blockchain.Params.Net)
+	Type  MsgType `json:"type" mapstructure:"type"`
+	Data  any     `json:"data" mapstructure:"data"`
 }
 
+// 節點服務旗標 (VersionPayload.Services)
+const (
+	ServiceNetwork        uint64 = 1 << 0  // 能提供完整歷史區塊
+	ServiceNetworkLimited uint64 = 1 << 10 // 修剪節點：只提供最近的區塊
+)
+
 type VersionPayload struct {
-	Version int    `json:"version" mapstructure:"version"`
-	Height  uint64 `json:"height" mapstructure:"height"`
-	CumWork string `json:"cum_work" mapstructure:"cum_work"`
-	NodeID  uint64 `json:"node_id" mapstructure:"node_id"`
+	Version  int    `json:"version" mapstructure:"version"`
+	Height   uint64 `json:"height" mapstructure:"height"`
+	CumWork  string `json:"cum_work" mapstructure:"cum_work"`
+	NodeID   uint64 `json:"node_id" mapstructure:"node_id"`
+	Services uint64 `json:"services" mapstructure:"services"`
 }
 
 type InvPayload struct {
@@ -39,6 +48,18 @@ type GetDataPayload struct {
 	Hash string `json:"hash" mapstructure:"hash"`
 }
 
+// NotFoundPayload 回覆拿不到的 getdata，Reason 說明原因 (例如 "pruned")
+type NotFoundPayload struct {
+	Type   string `json:"type" mapstructure:"type"`
+	Hash   string `json:"hash" mapstructure:"hash"`
+	Reason string `json:"reason" mapstructure:"reason"`
+}
+
+// BlockPayload 區塊以 blockchain.Block.Serialize 的二進位格式傳輸
+type BlockPayload struct {
+	Block []byte `json:"block" mapstructure:"block"`
+}
+
 type TxPayload struct {
 	Tx []byte `json:"tx" mapstructure:"tx"`
 }
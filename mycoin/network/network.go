@@ -27,7 +27,7 @@ func NewNetwork(handler *Handler) *Network {
 }
 
 func (n *Network) AddConn(conn net.Conn) {
-	peer := NewPeer(conn)
+	peer := NewPeer(conn, n.Node.Params.Net)
 
 	// =================================================================
 	// 🛑 探長急救包：這裡還不知道對方的 NodeID，絕對不能加入 VIP 名單！
@@ -35,18 +35,22 @@ type Peer struct {
 	LastSeen int64
 	Outbound bool
 	NodeID   uint64
+	Services uint64 // 對方在 version 裡宣告的服務旗標
+	BanScore int    // 🚨 違規分數，累積到 BanThreshold 就斷線
+	Magic    uint32 // 本地網路的 Magic，送出時蓋上、收到時比對
 
 	mu  sync.Mutex
 	enc *json.Encoder
 	dec *json.Decoder
 }
 
-func NewPeer(conn net.Conn) *Peer {
+func NewPeer(conn net.Conn, magic uint32) *Peer {
 	return &Peer{
-		Conn: conn,
-		Addr: conn.RemoteAddr().String(),
-		enc:  json.NewEncoder(conn),
-		dec:  json.NewDecoder(conn),
+		Conn:  conn,
+		Addr:  conn.RemoteAddr().String(),
+		Magic: magic,
+		enc:   json.NewEncoder(conn),
+		dec:   json.NewDecoder(conn),
 	}
 }
 
@@ -56,6 +60,7 @@ func (p *Peer) Send(msg Message) {
 	defer p.mu.Unlock()
 
 	if p.Conn != nil {
+		msg.Magic = p.Magic
 		err := p.enc.Encode(msg)
 		if err != nil {
 			log.Printf("⚠️ [Network] 發送訊息失敗給 %s: %v\n", p.Addr, err)
@@ -71,6 +76,13 @@ func (p *Peer) ReadLoop(onMessage func(*Peer, *Message)) {
 			return
 		}
 
+		// 🌐 Magic 不同代表對方在別的網路 (例如主網節點連到 regtest)，直接斷線
+		if msg.Magic != p.Magic {
+			log.Printf("⛔ peer %s is on another network (magic %08x, want %08x)\n", p.Addr, msg.Magic, p.Magic)
+			p.Close()
+			return
+		}
+
 		p.LastSeen = time.Now().Unix()
 
 		// ⭐ 正确的调用方式：传入 peer + msg
@@ -6,9 +6,10 @@ import (
 	"log"
 	"math/rand/v2"
 	"net"
-	"strings"
 	"sync"
 	"time"
+
+	"mycoin/database"
 )
 
 var DefaultSeeds = []string{
@@ -27,6 +28,8 @@ type PeerManager struct {
 	MaxPeers int
 	ListenOn string
 
+	Banned map[string]time.Time // IP → 封鎖到期時間
+
 	mu sync.Mutex
 }
 
@@ -35,6 +38,7 @@ func NewPeerManager(net *Network, listen string, maxPeers int) *PeerManager {
 		Network:  net,
 		AddrMgr:  NewAddrManager(),
 		Active:   make(map[string]*Peer),
+		Banned:   make(map[string]time.Time),
 		MaxPeers: maxPeers,
 		ListenOn: listen,
 	}
@@ -98,6 +102,9 @@ func (pm *PeerManager) Connect(addr string) {
 	if addr == pm.ListenOn { // ⭐ 阻止自连接
 		return
 	}
+	if pm.IsBanned(addr) {
+		return
+	}
 	pm.mu.Lock()
 	if pm.Outbound >= pm.MaxPeers/2 {
 		pm.mu.Unlock()
@@ -156,7 +163,13 @@ func (pm *PeerManager) onNewConn(conn net.Conn, outbound bool) {
 		return
 	}
 
-	peer := NewPeer(conn)
+	if pm.IsBanned(remote) {
+		log.Println("⛔ Reject banned peer", remote)
+		conn.Close()
+		return
+	}
+
+	peer := NewPeer(conn, pm.Network.Node.Params.Net)
 	peer.Outbound = outbound
 
 	pm.AddrMgr.Add(peer.Addr)
@@ -200,10 +213,11 @@ func (pm *PeerManager) onNewConn(conn net.Conn, outbound bool) {
 		peer.Send(Message{
 			Type: MsgVersion,
 			Data: VersionPayload{
-				Version: 1,
-				Height:  pm.Network.Node.Best.Height,
-				CumWork: pm.Network.Node.Best.CumWork,
-				NodeID:  pm.Network.Node.NodeID, // 🌟 探長急救：千萬別忘記帶身分證出門！
+				Version:  1,
+				Height:   pm.Network.Node.Best.Height,
+				CumWork:  pm.Network.Node.Best.CumWork,
+				NodeID:   pm.Network.Node.NodeID, // 🌟 探長急救：千萬別忘記帶身分證出門！
+				Services: pm.Network.Handler.LocalServices(),
 			},
 		})
 		log.Println("🚀 Sent version handshake to", peer.Addr)
@@ -211,13 +225,13 @@ func (pm *PeerManager) onNewConn(conn net.Conn, outbound bool) {
 }
 
 func (pm *PeerManager) RelayAddress(newAddr string) {
-	// 🕵️ 偵探校正：只取 IP，強制補上你的 P2P 監聽埠口 (9001)
+	// 🕵️ 偵探校正：只取 IP，強制補上這個網路的 P2P 埠口 (主網 9001)
 	host, _, err := net.SplitHostPort(newAddr)
 	if err != nil {
 		log.Println("⚠️ [Relay] 無法解析地址:", newAddr)
 		return
 	}
-	correctAddr := host + ":9001"
+	correctAddr := net.JoinHostPort(host, pm.Network.Node.Params.DefaultPort)
 
 	pm.mu.Lock()
 	defer pm.mu.Unlock()
@@ -262,6 +276,8 @@ func (pm *PeerManager) maintain() {
 	for range ticker.C {
 		pm.cleanup()
 		pm.ensurePeers()
+		// 📸 歷史區塊那一批逾時了 (對方斷線或沒回) 就換人要
+		pm.Network.Handler.RequestSnapshotHistory(nil)
 	}
 }
 
@@ -274,13 +290,13 @@ func (pm *PeerManager) SavePeer(p *Peer) { // 👈 改成傳入 *Peer 物件
 	}
 
 	data, _ := json.Marshal(info)
-	pm.Network.Node.DB.Put("peerstore", p.Addr, data)
+	pm.Network.Node.DB.Put(database.BucketPeerStore, p.Addr, data)
 }
 
 func (pm *PeerManager) LoadPeers() []string {
 	var peers []string
 
-	pm.Network.Node.DB.Iterate("peerstore", func(k, v []byte) {
+	pm.Network.Node.DB.Iterate(database.BucketPeerStore, func(k, v []byte) {
 		peers = append(peers, string(k))
 	})
 
@@ -302,11 +318,7 @@ func (pm *PeerManager) LoadStaticSeeds() {
 // DNS SEED DISCOVERY（带超时 + IPv6 支持）
 // ===============================
 func (pm *PeerManager) QueryDNSSeeds() {
-	seeds := []string{
-		"seed1.mycoin.org",
-		"seed2.mycoin.org",
-		"seed.mycoin.net",
-	}
+	seeds := append([]string(nil), pm.Network.Node.Params.DNSSeeds...)
 
 	// 随机化顺序（更专业）
 	rand.Shuffle(len(seeds), func(i, j int) {
@@ -326,12 +338,8 @@ func (pm *PeerManager) QueryDNSSeeds() {
 
 		for _, ip := range ips {
 
-			// IPv6 地址要加 []
-			if strings.Contains(ip, ":") {
-				ip = "[" + ip + "]"
-			}
-
-			addr := ip + ":9001"
+			// IPv6 地址的 [] 由 JoinHostPort 處理
+			addr := net.JoinHostPort(ip, pm.Network.Node.Params.DefaultPort)
 			pm.AddrMgr.Add(addr)
 			log.Println("🌎 DNS seed discovered:", addr)
 		}
@@ -1,6 +1,7 @@
 package network
 
 import (
+	"encoding/hex"
 	"math/big"
 
 	"mycoin/blockchain"
@@ -11,26 +12,31 @@ func TxToDTO(tx blockchain.Transaction) TransactionDTO {
 	outs := make([]TxOutDTO, 0, len(tx.Outputs))
 	for _, o := range tx.Outputs {
 		outs = append(outs, TxOutDTO{
-			Value: big.NewInt(int64(o.Amount)).String(),
-			To:    o.To,
+			Value:  big.NewInt(int64(o.Amount)).String(),
+			To:     o.To,
+			Script: hex.EncodeToString(o.Script),
 		})
 	}
 
 	ins := make([]TxInDTO, 0, len(tx.Inputs))
 	for _, in := range tx.Inputs {
 		ins = append(ins, TxInDTO{
-			TxID:   in.TxID,
-			Index:  in.Index,
-			Sig:    in.Sig,
-			PubKey: in.PubKey,
+			TxID:      in.TxID,
+			Index:     in.Index,
+			Sig:       in.Sig,
+			PubKey:    in.PubKey,
+			ScriptSig: hex.EncodeToString(in.ScriptSig),
+			Sequence:  in.Sequence,
 		})
 	}
 
 	return TransactionDTO{
 		ID:         tx.ID,
+		Version:    tx.Version,
 		Inputs:     ins,
 		Outputs:    outs,
 		IsCoinbase: tx.IsCoinbase,
+		LockTime:   tx.LockTime,
 	}
 }
 
@@ -40,28 +46,50 @@ func DTOToTx(d TransactionDTO) blockchain.Transaction {
 		v := new(big.Int)
 		v.SetString(o.Value, 10)
 
+		script, _ := hex.DecodeString(o.Script)
+		if len(script) == 0 {
+			script = nil
+		}
+
 		outs = append(outs, blockchain.TxOutput{
 			Amount: int(v.Int64()),
 			To:     o.To,
+			Script: script,
 		})
 	}
 
 	ins := make([]blockchain.TxInput, 0, len(d.Inputs))
 	for _, in := range d.Inputs {
+		scriptSig, _ := hex.DecodeString(in.ScriptSig)
+		if len(scriptSig) == 0 {
+			scriptSig = nil
+		}
+
 		ins = append(ins, blockchain.TxInput{
-			TxID:   in.TxID,
-			Index:  in.Index,
-			Sig:    in.Sig,
-			PubKey: in.PubKey,
+			TxID:      in.TxID,
+			Index:     in.Index,
+			Sig:       in.Sig,
+			PubKey:    in.PubKey,
+			ScriptSig: scriptSig,
+			Sequence:  in.Sequence,
 		})
 	}
 
-	return blockchain.Transaction{
+	tx := blockchain.Transaction{
 		ID:         d.ID,
+		Version:    d.Version,
 		Inputs:     ins,
 		Outputs:    outs,
 		IsCoinbase: d.IsCoinbase,
+		LockTime:   d.LockTime,
+	}
+
+	// 沒帶版本的一律當成新版；ID 一律由內容決定 (舊版交易也用舊算法重算)，不信任外部傳來的 ID
+	if tx.Version == 0 {
+		tx.Version = blockchain.TxVersion
 	}
+	tx.CalcID()
+	return tx
 }
 
 func TxListToDTO(txs []blockchain.Transaction) []TransactionDTO {
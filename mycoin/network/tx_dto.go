@@ -4,9 +4,11 @@ package network
 
 type TransactionDTO struct {
 	ID         string     `json:"id" mapstructure:"id"`
+	Version    int32      `json:"version" mapstructure:"version"`
 	Inputs     []TxInDTO  `json:"inputs" mapstructure:"inputs"`   // 👈 嵌套結構，標籤必備！
 	Outputs    []TxOutDTO `json:"outputs" mapstructure:"outputs"` // 👈 同上
 	IsCoinbase bool       `json:"is_coinbase" mapstructure:"is_coinbase"`
+	LockTime   uint32     `json:"locktime" mapstructure:"locktime"`
 }
 
 type TxInDTO struct {
@@ -14,9 +16,14 @@ type TxInDTO struct {
 	Index  int    `json:"index" mapstructure:"index"`
 	Sig    string `json:"sig" mapstructure:"sig"`       // hex string
 	PubKey string `json:"pubkey" mapstructure:"pubkey"` // hex string
+
+	ScriptSig string `json:"script_sig,omitempty" mapstructure:"script_sig"` // hex，自訂解鎖腳本
+	Sequence  uint32 `json:"sequence" mapstructure:"sequence"`
 }
 
 type TxOutDTO struct {
 	Value string `json:"value" mapstructure:"value"` // 🌟 探長提醒：用 string 傳輸大數是正確的！
 	To    string `json:"to" mapstructure:"to"`       // 收款公鑰 hex
+
+	Script string `json:"script,omitempty" mapstructure:"script"` // hex，自訂鎖定腳本
 }
@@ -1,8 +1,11 @@
 package node
 
 import (
+	"encoding/hex"
 	"math/big"
 	"mycoin/blockchain"
+	"mycoin/database"
+	"mycoin/utils"
 )
 
 type BlockIndex struct {
@@ -11,14 +14,52 @@ type BlockIndex struct {
 	CumWork  string `json:"cumwork"`
 	PrevHash string `json:"prevhash"`
 
-	Timestamp int64  `json:"timestamp"`
-	Bits      uint32 `json:"bits"`
+	Timestamp  int64  `json:"timestamp"`
+	Bits       uint32 `json:"bits"`
+	Nonce      uint64 `json:"nonce"`
+	MerkleRoot string `json:"merkle_root"` // hex，只有標頭時也能轉發給別人
+	Pruned     bool   `json:"pruned,omitempty"` // body 與 undo 已修剪，只剩區塊頭
+
+	// body 在區塊檔裡的位置；要用時經 Node.ReadBlock 讀出 (有快取)，不常駐記憶體
+	HaveData bool              `json:"have_data,omitempty"`
+	Pos      database.BlockPos `json:"pos"`
+
+	// 快照以下的主鏈區塊：帳本來自 UTXO 快照，body 由背景驗證補下載、重放確認後才清掉
+	AssumeValid bool `json:"assume_valid,omitempty"`
+
+	// ❌ 驗證不過的區塊 (或接在它後面的分岔)：留著免得重複下載，但不會再被選成鏈頭
+	Failed bool `json:"failed,omitempty"`
 
 	CumWorkInt *big.Int `json:"-"`
 	// 重启后重新填充
-	Block    *blockchain.Block `json:"-"`
-	Parent   *BlockIndex       `json:"-"`
-	Children []*BlockIndex     `json:"-"`
+	Parent   *BlockIndex   `json:"-"`
+	Children []*BlockIndex `json:"-"`
+}
+
+// HasBody 區塊 body 已經收到並驗證過 (修剪掉的也算，只是本地不再保存；快照代表的區塊也算)
+func (bi *BlockIndex) HasBody() bool {
+	return bi.HaveData || bi.Pruned || bi.AssumeValid
+}
+
+// Header 只有區塊頭、沒有交易的區塊 (算 Hash、轉發標頭、驗證父子關係都夠用)
+func (bi *BlockIndex) Header() *blockchain.Block {
+	hash, _ := hex.DecodeString(bi.Hash)
+	prev, _ := hex.DecodeString(bi.PrevHash)
+	if bi.PrevHash == "" {
+		prev = make([]byte, 32) // 創世塊：索引裡沒記 PrevHash，區塊頭裡是全 0
+	}
+	merkle, _ := hex.DecodeString(bi.MerkleRoot)
+	return &blockchain.Block{
+		Height:     bi.Height,
+		PrevHash:   prev,
+		Timestamp:  bi.Timestamp,
+		Nonce:      bi.Nonce,
+		Target:     utils.CompactToBig(bi.Bits),
+		MerkleRoot: merkle,
+		Hash:       hash,
+		HashHex:    bi.Hash,
+		Bits:       bi.Bits,
+	}
 }
 
 func WorkFromTarget(target *big.Int) *big.Int {
@@ -0,0 +1,171 @@
+package node
+
+import (
+	"container/list"
+	"encoding/hex"
+	"fmt"
+	"sync"
+
+	"mycoin/blockchain"
+	"mycoin/database"
+)
+
+// DefaultBlockCacheSize 記憶體裡最多留幾個讀過的區塊 body
+const DefaultBlockCacheSize = 64
+
+// ReadBlock 讀出 bi 的完整區塊：先查快取，沒有再從區塊檔讀
+func (n *Node) ReadBlock(bi *BlockIndex) (*blockchain.Block, error) {
+	if bi == nil {
+		return nil, fmt.Errorf("nil block index")
+	}
+	if bi.Pruned {
+		return nil, ErrBlockPruned
+	}
+	if !bi.HaveData {
+		return nil, fmt.Errorf("block %d (%s) has no body", bi.Height, short(bi.Hash))
+	}
+	if blk, ok := n.blockCache.get(bi.Hash); ok {
+		return blk, nil
+	}
+
+	raw, err := n.BlockFiles.Read(bi.Pos)
+	if err != nil {
+		return nil, fmt.Errorf("block %d (%s): %w", bi.Height, short(bi.Hash), err)
+	}
+	blk, err := blockchain.DeserializeBlock(raw)
+	if err != nil {
+		return nil, fmt.Errorf("block %d (%s): %w", bi.Height, short(bi.Hash), err)
+	}
+	if got := hex.EncodeToString(blk.Hash); got != bi.Hash {
+		return nil, fmt.Errorf("block %d: file position points at %s", bi.Height, short(got))
+	}
+	n.blockCache.add(bi.Hash, blk)
+	return blk, nil
+}
+
+// GetBlock 跟 ReadBlock 一樣，讀不到就回傳 nil (查詢介面用)
+func (n *Node) GetBlock(bi *BlockIndex) *blockchain.Block {
+	blk, err := n.ReadBlock(bi)
+	if err != nil {
+		return nil
+	}
+	return blk
+}
+
+// storeBlock 把 body 追加到區塊檔並記下位置；index 由呼叫端連同其他變動一起寫入
+func (n *Node) storeBlock(bi *BlockIndex, block *blockchain.Block) error {
+	pos, err := n.BlockFiles.Append(block.Serialize())
+	if err != nil {
+		return fmt.Errorf("append block %d: %w", block.Height, err)
+	}
+	bi.Pos = pos
+	bi.HaveData = true
+	bi.Pruned = false
+	n.blockCache.add(bi.Hash, block)
+	return nil
+}
+
+// SetBlockCacheSize 調整區塊快取大小
+func (n *Node) SetBlockCacheSize(size int) {
+	n.BlockCacheSize = size
+	n.blockCache.resize(size)
+}
+
+// removeUnusedBlockFiles 刪掉沒有任何區塊還指著的舊區塊檔 (修剪後才會出現)，回傳刪了幾個
+func (n *Node) removeUnusedBlockFiles() (int, error) {
+	files, err := n.BlockFiles.Files()
+	if err != nil {
+		return 0, err
+	}
+	inUse := make(map[uint32]bool)
+	for _, bi := range n.Blocks {
+		if bi.HaveData {
+			inUse[bi.Pos.File] = true
+		}
+	}
+
+	removed := 0
+	cur := n.BlockFiles.CurrentFile()
+	for _, file := range files {
+		if file == cur || inUse[file] {
+			continue
+		}
+		if err := n.BlockFiles.RemoveFile(file); err != nil {
+			return removed, err
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// blockCache 最近用過的區塊 body 的 LRU 快取 (查詢會動到順序，所以自己上鎖)
+type blockCache struct {
+	mu    sync.Mutex
+	size  int
+	ll    *list.List
+	items map[string]*list.Element
+}
+
+type blockCacheItem struct {
+	hash  string
+	block *blockchain.Block
+}
+
+func newBlockCache(size int) *blockCache {
+	return &blockCache{size: size, ll: list.New(), items: make(map[string]*list.Element)}
+}
+
+func (c *blockCache) get(hash string) (*blockchain.Block, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[hash]; ok {
+		c.ll.MoveToFront(el)
+		return el.Value.(*blockCacheItem).block, true
+	}
+	return nil, false
+}
+
+func (c *blockCache) add(hash string, block *blockchain.Block) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[hash]; ok {
+		el.Value.(*blockCacheItem).block = block
+		c.ll.MoveToFront(el)
+		return
+	}
+	c.items[hash] = c.ll.PushFront(&blockCacheItem{hash: hash, block: block})
+	c.evict()
+}
+
+func (c *blockCache) remove(hash string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if el, ok := c.items[hash]; ok {
+		c.ll.Remove(el)
+		delete(c.items, hash)
+	}
+}
+
+func (c *blockCache) resize(size int) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.size = size
+	c.evict()
+}
+
+func (c *blockCache) evict() {
+	for c.size >= 0 && c.ll.Len() > c.size {
+		el := c.ll.Back()
+		c.ll.Remove(el)
+		delete(c.items, el.Value.(*blockCacheItem).hash)
+	}
+}
+
+// hasStoredBlocks 區塊檔裡有沒有任何記錄 (索引壞掉時決定要重建還是從頭來)
+func (n *Node) hasStoredBlocks() bool {
+	found := false
+	n.BlockFiles.Scan(func(pos database.BlockPos, data []byte) {
+		found = true
+	})
+	return found
+}
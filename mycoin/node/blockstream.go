@@ -0,0 +1,125 @@
+package node
+
+import (
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+
+	"mycoin/blockchain"
+)
+
+// blockStreamProgress 匯出 / 匯入每幾個區塊印一次進度
+const blockStreamProgress = 1000
+
+var (
+	// ErrImportGenesis 區塊流的創世塊跟這個網路的不一樣
+	ErrImportGenesis = errors.New("block stream starts from a different genesis block")
+	// ErrImportRejected 區塊沒通過驗證 (或接不上已知的區塊)，原因看前面的日誌
+	ErrImportRejected = errors.New("block rejected")
+)
+
+// ImportStats 一次匯入的統計
+type ImportStats struct {
+	Read      int // 從檔案讀到的區塊
+	Connected int // 經過驗證新加入的區塊
+	Skipped   int // 本地已經有的區塊 (含創世塊)
+}
+
+// ExportBlocks 把主鏈高度 [from, to] 的區塊依序寫成區塊流 (to = 0 代表到鏈頭)，回傳寫出的區塊數
+// 修剪掉或還沒下載的區塊讀不到，直接回傳錯誤
+func (n *Node) ExportBlocks(w io.Writer, from, to uint64) (int, error) {
+	tip := n.Chain.Tip()
+	if tip == nil {
+		return 0, fmt.Errorf("no active chain")
+	}
+	if to == 0 || to > tip.Height {
+		to = tip.Height
+	}
+	if from > to {
+		return 0, fmt.Errorf("invalid range: from %d > to %d", from, to)
+	}
+
+	sw, err := blockchain.NewBlockStreamWriter(w, n.Params.Net)
+	if err != nil {
+		return 0, err
+	}
+	count := 0
+	for _, bi := range n.Chain.Slice(from, to) {
+		block, err := n.ReadBlock(bi)
+		if err != nil {
+			return count, fmt.Errorf("export block %d: %w", bi.Height, err)
+		}
+		if err := sw.WriteBlock(block); err != nil {
+			return count, err
+		}
+		count++
+		if count%blockStreamProgress == 0 {
+			fmt.Printf("📤 [Export] %d/%d (高度 %d)\n", count, to-from+1, bi.Height)
+		}
+	}
+	if err := sw.Flush(); err != nil {
+		return count, err
+	}
+	return count, nil
+}
+
+// ImportBlocks 從區塊流依序匯入區塊：每塊都走 AddBlock → connectBlock，跟從網路收到的一樣完整驗證
+// 本地已經有的區塊略過；第一個被拒絕的區塊就停下來 (之前匯入的都已經落地)
+func (n *Node) ImportBlocks(r io.Reader) (*ImportStats, error) {
+	sr, err := blockchain.NewBlockStreamReader(r, n.Params.Net)
+	if err != nil {
+		return nil, err
+	}
+
+	// 同步中的節點只存區塊不接主鏈；匯入時當作已同步，每塊都對帳本驗證後接上
+	n.mu.Lock()
+	prevState, prevSyncing := n.SyncState, n.IsSyncing
+	n.SyncState, n.IsSyncing = SyncSynced, false
+	n.mu.Unlock()
+	defer func() {
+		n.mu.Lock()
+		n.SyncState, n.IsSyncing = prevState, prevSyncing
+		n.mu.Unlock()
+	}()
+
+	genesisHash := hex.EncodeToString(n.Params.GenesisBlock().Hash)
+	stats := &ImportStats{}
+	for {
+		block, err := sr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return stats, fmt.Errorf("read block %d: %w", stats.Read, err)
+		}
+		stats.Read++
+
+		hashHex := hex.EncodeToString(block.Hash)
+		if block.Height == 0 {
+			if hashHex != genesisHash {
+				return stats, ErrImportGenesis
+			}
+			stats.Skipped++
+			continue
+		}
+
+		n.mu.Lock()
+		bi := n.Blocks[hashHex]
+		have := bi != nil && bi.HasBody() && !n.isSnapshotHistory(bi)
+		n.mu.Unlock()
+		if have {
+			stats.Skipped++
+			continue
+		}
+
+		if !n.AddBlock(block) {
+			return stats, fmt.Errorf("%w: height %d (%s)", ErrImportRejected, block.Height, short(hashHex))
+		}
+		stats.Connected++
+		if stats.Connected%blockStreamProgress == 0 {
+			fmt.Printf("📥 [Import] 已匯入 %d 塊 (高度 %d)\n", stats.Connected, block.Height)
+		}
+	}
+	return stats, nil
+}
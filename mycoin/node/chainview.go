@@ -0,0 +1,140 @@
+package node
+
+import "sync"
+
+// ChainView 主鏈視圖：nodes[h] 就是主鏈 (Best 往回到創世塊) 高度 h 的區塊索引
+// 鏈頭每次變動 (接上、撤下、重組、收到工作量更多的標頭) 都用 SetTip 更新，
+// 只改分岔點以上那一段；查高度、查是否在主鏈上都是 O(1)
+type ChainView struct {
+	mu    sync.RWMutex
+	nodes []*BlockIndex
+}
+
+func NewChainView() *ChainView {
+	return &ChainView{}
+}
+
+// SetTip 把視圖換成以 tip 結尾的鏈 (tip = nil 清空)
+// 從 tip 往回填，遇到已經在視圖裡的同一個索引就停：延長一塊是 O(1)，重組是 O(重組深度)
+func (c *ChainView) SetTip(tip *BlockIndex) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if tip == nil {
+		c.nodes = nil
+		return
+	}
+	size := int(tip.Height) + 1
+	if size <= len(c.nodes) {
+		for i := size; i < len(c.nodes); i++ {
+			c.nodes[i] = nil
+		}
+		c.nodes = c.nodes[:size]
+	} else {
+		c.nodes = append(c.nodes, make([]*BlockIndex, size-len(c.nodes))...)
+	}
+	for bi := tip; bi != nil && c.nodes[bi.Height] != bi; bi = bi.Parent {
+		c.nodes[bi.Height] = bi
+	}
+}
+
+// Tip 鏈頭 (空的視圖回傳 nil)
+func (c *ChainView) Tip() *BlockIndex {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(c.nodes) == 0 {
+		return nil
+	}
+	return c.nodes[len(c.nodes)-1]
+}
+
+// Genesis 創世塊 (空的視圖回傳 nil)
+func (c *ChainView) Genesis() *BlockIndex {
+	return c.BlockByHeight(0)
+}
+
+// Len 主鏈區塊數 (= 鏈頭高度 + 1)
+func (c *ChainView) Len() int {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return len(c.nodes)
+}
+
+// BlockByHeight 主鏈上高度 h 的區塊，超過鏈頭回傳 nil
+func (c *ChainView) BlockByHeight(h uint64) *BlockIndex {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if h >= uint64(len(c.nodes)) {
+		return nil
+	}
+	return c.nodes[h]
+}
+
+// Contains bi 是否在主鏈上
+func (c *ChainView) Contains(bi *BlockIndex) bool {
+	if bi == nil {
+		return false
+	}
+	main := c.BlockByHeight(bi.Height)
+	return main != nil && main.Hash == bi.Hash
+}
+
+// Next 主鏈上 bi 的下一塊 (bi 不在主鏈上或已經是鏈頭時回傳 nil)
+func (c *ChainView) Next(bi *BlockIndex) *BlockIndex {
+	if !c.Contains(bi) {
+		return nil
+	}
+	return c.BlockByHeight(bi.Height + 1)
+}
+
+// Slice 主鏈高度 [from, to] 的區塊 (由舊到新，複製一份；to 超過鏈頭就到鏈頭為止)
+func (c *ChainView) Slice(from, to uint64) []*BlockIndex {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(c.nodes) == 0 {
+		return nil
+	}
+	if to >= uint64(len(c.nodes)) {
+		to = uint64(len(c.nodes)) - 1
+	}
+	if from > to {
+		return nil
+	}
+	return append([]*BlockIndex(nil), c.nodes[from:to+1]...)
+}
+
+// Indexes 整條主鏈 (由舊到新，複製一份)
+func (c *ChainView) Indexes() []*BlockIndex {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return append([]*BlockIndex(nil), c.nodes...)
+}
+
+// Locator 從鏈頭往回的區塊定位器：最近 10 塊逐一列出，之後間隔加倍，最後一定是創世塊
+func (c *ChainView) Locator() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	if len(c.nodes) == 0 {
+		return nil
+	}
+	var locators []string
+	step := uint64(1)
+	h := uint64(len(c.nodes) - 1)
+	for {
+		if bi := c.nodes[h]; bi != nil {
+			locators = append(locators, bi.Hash)
+		}
+		if h == 0 {
+			return locators
+		}
+		if len(locators) >= 10 {
+			step *= 2
+		}
+		if step > h {
+			h = 0
+		} else {
+			h -= step
+		}
+	}
+}
@@ -1,12 +1,16 @@
 package node
 
 import (
+	"bytes"
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"math/big"
 	"mycoin/blockchain"
+	"mycoin/database"
+	"time"
 
 	"mycoin/utils"
 )
@@ -21,34 +25,57 @@ func (n *Node) connectBlock(block *blockchain.Block, parent *BlockIndex) bool {
 		log.Panic("嚴重錯誤：connectBlock 收到了 nil 的 parent！這是系統邏輯漏洞。")
 		return false
 	}
-	if parent.Block == nil {
+	if !parent.HasBody() {
 		log.Panic("嚴重錯誤：connectBlock 收到了沒有 Block 實體的 parent！這不該發生。")
 		return false
 	}
+	hashHex := hex.EncodeToString(block.Hash)
+	if parent.Failed {
+		fmt.Printf("❌ [Consensus] 區塊 %s 接在驗證失敗的分岔上\n", short(hashHex))
+		return false
+	}
+	if bi, ok := n.Blocks[hashHex]; ok && bi.Failed {
+		fmt.Printf("❌ [Consensus] 區塊 %s 之前已驗證失敗\n", short(hashHex))
+		return false
+	}
+
+	// ⛏️ 工作量證明、高度與 Merkle Root 每條路徑都要驗 (同步中、沒看過標頭的分岔區塊也一樣)：
+	// 不然沒有工作量的區塊也會寫進區塊檔，帶著算出來的累積工作量進索引
+	if err := block.CheckProofOfWork(); err != nil {
+		fmt.Printf("❌ [Consensus] 工作量驗證失敗: %v\n", err)
+		return false
+	}
+	if block.Height != parent.Height+1 {
+		fmt.Printf("❌ [Consensus] 區塊高度 %d 接不上父塊 %d\n", block.Height, parent.Height)
+		return false
+	}
+	if !bytes.Equal(blockchain.ComputeMerkleRoot(block.Transactions), block.MerkleRoot) {
+		fmt.Printf("❌ [Consensus] 區塊 %d 的交易跟 Merkle Root 對不上\n", block.Height)
+		return false
+	}
+
+	// 📏 區塊大小是共識規則：同步中只存不驗的區塊也不能超過上限
+	if err := block.CheckSize(); err != nil {
+		fmt.Printf("❌ [Consensus] 區塊大小驗證失敗: %v\n", err)
+		return false
+	}
 
 	// ----------------------------------------------------
 	// 1️⃣ 驗證難度 (Bits Check)
 	// ----------------------------------------------------
 	// 確保區塊頭裡的 Bits 符合協議要求
-	if (parent.Height+1)%blockchain.DifficultyInterval == 0 {
-		// 🔴 調整週期：計算新難度
-		expectedTarget := n.retargetDifficulty(parent)
-		expectedBits := utils.BigToCompact(expectedTarget)
+	if err := n.checkBits(parent, block.Bits); err != nil {
+		fmt.Printf("❌ [Consensus] 難度驗證失敗: %v\n", err)
+		return false
+	}
 
-		if expectedBits != block.Bits {
-			fmt.Printf("❌ [Consensus] 難度驗證失敗 (Retarget)！預期: %d, 實際: %d\n", expectedBits, block.Bits)
-			return false
-		}
-	} else {
-		// 🔴 非調整週期：必須跟父塊難度一模一樣
-		if block.Bits != parent.Bits {
-			fmt.Printf("❌ [Consensus] 難度驗證失敗 (Fixed)！預期: %d, 實際: %d\n", parent.Bits, block.Bits)
-			return false
-		}
+	if err := n.checkBlockTime(parent, block.Timestamp); err != nil {
+		fmt.Printf("❌ [Consensus] 時間戳驗證失敗: %v\n", err)
+		return false
 	}
 
-	// 計算累積工作量
-	work := computeWork(block.Target)
+	// 計算累積工作量 (以 Bits 為準)
+	work := computeWork(utils.CompactToBig(block.Bits))
 	cumWork := new(big.Int).Add(parent.CumWorkInt, work)
 
 	// ----------------------------------------------------
@@ -56,10 +83,19 @@ func (n *Node) connectBlock(block *blockchain.Block, parent *BlockIndex) bool {
 	// ----------------------------------------------------
 	// 🚨 探長改裝：只有狀態完全等於「SyncSynced (已同步)」時，才准進行 UTXO 檢查！
 	// 這樣不論 IsSyncing 是什麼值，只要你還沒同步完，這裡就不會噴紅字。
-	if n.SyncState == SyncSynced {
-		err := VerifyBlockWithUTXO(block, parent.Block, n.UTXO)
+	// 🌿 分岔上的區塊花的是分岔自己的 UTXO，等真的重組時才在 switchUTXO 裡驗證
+	if n.SyncState == SyncSynced && parent == n.Best {
+		err := VerifyBlockWithUTXO(block, parent, n.UTXO, n.Params, n.GetReward(block.Height))
 		if err != nil {
 			log.Println("❌ Block validation failed:", err)
+			// 先收過標頭的話，索引裡要記下來，不然標頭鏈還會把它當成最強鏈
+			if bi, ok := n.Blocks[hashHex]; ok {
+				batch := database.NewBatch()
+				n.markFailed(bi, batch)
+				if err := n.DB.Write(batch); err != nil {
+					log.Println("❌ 區塊索引寫入失敗:", err)
+				}
+			}
 			return false
 		}
 	}
@@ -67,14 +103,14 @@ func (n *Node) connectBlock(block *blockchain.Block, parent *BlockIndex) bool {
 	// ----------------------------------------------------
 	// 3️⃣ 創建或更新 BlockIndex
 	// ----------------------------------------------------
-	hashHex := hex.EncodeToString(block.Hash)
 	bi, exists := n.Blocks[hashHex]
 
 	if exists {
 		// 情況 A: 索引已存在
-		bi.Block = block
 		bi.Bits = block.Bits
 		bi.Timestamp = block.Timestamp
+		bi.Nonce = block.Nonce
+		bi.MerkleRoot = hex.EncodeToString(block.MerkleRoot)
 		bi.Parent = parent // 確保父子關係正確
 
 		// 🔥 修正：強制更新工作量，不要用 if bi.CumWorkInt == nil 判斷
@@ -90,9 +126,10 @@ func (n *Node) connectBlock(block *blockchain.Block, parent *BlockIndex) bool {
 			Height:     parent.Height + 1,
 			Timestamp:  block.Timestamp,
 			Bits:       block.Bits,
+			Nonce:      block.Nonce,
+			MerkleRoot: hex.EncodeToString(block.MerkleRoot),
 			CumWork:    cumWork.Text(16),
 			CumWorkInt: cumWork,
-			Block:      block,
 			Parent:     parent,
 			Children:   []*BlockIndex{},
 		}
@@ -114,11 +151,15 @@ func (n *Node) connectBlock(block *blockchain.Block, parent *BlockIndex) bool {
 	}
 
 	// ----------------------------------------------------
-	// 4️⃣ 持久化 (先存 DB，確保重啟不丟失)
+	// 4️⃣ 持久化：body 先追加到區塊檔，索引 (含檔案位置) 放進批次 (接上主鏈時連同 undo 紀錄一起寫)
 	// ----------------------------------------------------
-	n.DB.Put("blocks", hashHex, block.Serialize())
+	if err := n.storeBlock(bi, block); err != nil {
+		log.Println("❌ 區塊寫入失敗:", err)
+		return false
+	}
 	idxBytes, _ := json.Marshal(bi)
-	n.DB.Put("index", hashHex, idxBytes)
+	batch := database.NewBatch()
+	batch.Put(database.BucketIndex, hashHex, idxBytes)
 
 	if bi.Height >= n.Best.Height { // 只在高度接近時印出，避免洗版
 		fmt.Printf("⚖️ [Chain Selection] Local Best: %d (Work: %s) vs New Block: %d (Work: %s)\n",
@@ -138,15 +179,40 @@ func (n *Node) connectBlock(block *blockchain.Block, parent *BlockIndex) bool {
 	// 🚨 探長的終極綠色通道：同步期間只存積木，不接主鏈，不算帳！
 	// ==========================================================
 	if n.SyncState != SyncSynced { // 👈 統一用 SyncState 判斷，邏輯最穩！
+		if err := n.DB.Write(batch); err != nil {
+			log.Println("❌ 區塊寫入失敗:", err)
+			return false
+		}
 		return true
 	}
 	// ==========================================================
 
 	// 下面這些，只有在「非同步狀態（平常挖礦、日常接收新塊）」時才會執行！
 	if parent == n.Best {
+		// 增量更新帳本，undo 紀錄與交易索引跟區塊一起落地
+		// 先接在覆蓋層上：硬碟寫成功了才併進帳本、移動鏈頭，寫失敗時丟掉覆蓋層就等於沒發生
+		view := n.UTXO.View()
+		if err := n.ConnectBlock(bi, view, batch); err != nil {
+			log.Println("❌ 區塊無法接上帳本:", err)
+			// 區塊本身還是留著，但標成失敗，之後不會再接它或接在它後面的分岔
+			n.markFailed(bi, batch)
+			if err := n.DB.Write(batch); err != nil {
+				log.Println("❌ 區塊寫入失敗:", err)
+			}
+			return false
+		}
+
+		// 💾 鏈頭與帳本的變動跟區塊放在同一個交易：斷電時要嘛整塊接上，要嘛完全沒發生
+		batch.Put(database.BucketMeta, "best", []byte(bi.Hash))
+		view.Commit(batch)
+		if err := n.DB.Write(batch); err != nil {
+			log.Println("❌ 區塊寫入失敗:", err)
+			return false
+		}
+		view.Committed()
+
 		n.Best = bi
-		n.Chain = append(n.Chain, block)
-		n.updateUTXO(block) // 增量更新帳本
+		n.Chain.SetTip(bi)
 
 		log.Printf("⛏️ Main chain extended to height: %d (Hash: %s)\n", bi.Height, hashHex)
 		chainSwitched = true
@@ -154,24 +220,43 @@ func (n *Node) connectBlock(block *blockchain.Block, parent *BlockIndex) bool {
 	} else if bi.CumWorkInt.Cmp(n.Best.CumWorkInt) > 0 {
 		log.Printf("🔁 REORG DETECTED! Current Best: %d, New Best: %d\n", n.Best.Height, bi.Height)
 
+		// ⏪⏩ 用 undo 紀錄只撤下 / 接上分岔的那幾塊，不再重掃整條鏈
 		oldChain, newChain := n.reorgTo(bi)
-		n.rebuildChain(oldChain, newChain, bi)
+		if err := n.rebuildChain(oldChain, newChain, bi, batch); err != nil {
+			if errors.Is(err, ErrUTXOInconsistent) {
+				// 帳本回不去了：什麼都不寫，直接停機，重啟時從資料庫 (還是重組前的狀態) 載入
+				log.Fatalf("❌ 鏈重組失敗且無法回滾: %v", err)
+			}
+			log.Println("❌ 鏈重組失敗，維持原本的主鏈:", err)
+			// 區塊本身還是留著 (驗證不過的已經在 switchUTXO 標成失敗，不會再重組過去)
+			if err := n.DB.Write(batch); err != nil {
+				log.Println("❌ 區塊寫入失敗:", err)
+			}
+			return false
+		}
 
-		// 🏆 Kali 救星：在切換主鏈後，徹底重新掃描帳本
-		fmt.Println("🔄 執行核彈級動態鏈重組 (Full UTXO Rebuild)...")
-		go n.RebuildUTXO()
+		batch.Put(database.BucketMeta, "best", []byte(n.Best.Hash))
+		n.UTXO.Commit(batch)
+		if err := n.DB.Write(batch); err != nil {
+			// 記憶體裡的鏈頭與帳本已經換到新鏈，硬碟還在舊鏈：直接停機，重啟時從資料庫載入
+			log.Fatalf("❌ 鏈重組寫入失敗: %v", err)
+		}
+		n.UTXO.Committed()
 
 		chainSwitched = true
+
+	} else if err := n.DB.Write(batch); err != nil {
+		log.Println("❌ 區塊寫入失敗:", err)
+		return false
 	}
 
 	if chainSwitched {
-		// 1. 持久化最新鏈頭 (Tip)
-		n.DB.Put("meta", "best", []byte(n.Best.Hash))
-
-		// 2. 🚀 強制同步：確保 Kali 這種快速同步的節點，硬碟裡的帳本與記憶體完全一致
-		n.UTXO.FlushToDB()
+		// 🌿 修剪模式：鏈頭往前走，最舊的區塊跟著清掉
+		if _, err := n.PruneBlocks(); err != nil {
+			log.Println("⚠️", err)
+		}
 
-		// 3. 🧹 清理 Mempool
+		// 🧹 清理 Mempool
 		txCount := 0
 		for _, tx := range block.Transactions {
 			if !tx.IsCoinbase {
@@ -181,7 +266,7 @@ func (n *Node) connectBlock(block *blockchain.Block, parent *BlockIndex) bool {
 		}
 		fmt.Printf("🧹 [Mempool] 已清理區塊 %d 中的 %d 筆交易\n", block.Height, txCount)
 
-		// 4. 🚀 發送中斷信號給礦工 (若當前正在挖礦)
+		// 🚀 發送中斷信號給礦工 (若當前正在挖礦)
 		select {
 		case n.MinerResetChan <- true:
 			fmt.Println("⚡ [Consensus] 鏈頭更新，已通知礦工重新計算")
@@ -193,6 +278,31 @@ func (n *Node) connectBlock(block *blockchain.Block, parent *BlockIndex) bool {
 	return true
 
 }
+
+// markFailed 把區塊和接在它後面的分岔都標成失敗，索引放進 batch
+func (n *Node) markFailed(bi *BlockIndex, batch *database.Batch) {
+	queue := []*BlockIndex{bi}
+	for len(queue) > 0 {
+		cur := queue[0]
+		queue = queue[1:]
+		cur.Failed = true
+		idxBytes, _ := json.Marshal(cur)
+		batch.Put(database.BucketIndex, cur.Hash, idxBytes)
+		queue = append(queue, cur.Children...)
+	}
+}
+
+// bestValidTip 有 body、沒被標成失敗的區塊裡，累積工作量最多的一個
+func (n *Node) bestValidTip() *BlockIndex {
+	var best *BlockIndex
+	for _, bi := range n.Blocks {
+		if bi.HasBody() && !bi.Failed && (best == nil || bi.CumWorkInt.Cmp(best.CumWorkInt) > 0) {
+			best = bi
+		}
+	}
+	return best
+}
+
 func (n *Node) attachOrphans(parentHash string) {
 	n.mu.Lock() // 🔒 短暫上鎖，安全提取孤塊名單
 	orphans := n.Orphans[parentHash]
@@ -273,7 +383,7 @@ func (n *Node) reorgTo(newTip *BlockIndex) (oldChain []*BlockIndex, newChain []*
 	return oldChain, newChain
 }
 
-func (n *Node) indexTransactions(block *blockchain.Block, bi *BlockIndex) {
+func (n *Node) indexTransactions(batch *database.Batch, block *blockchain.Block) {
 	blockHashHex := hex.EncodeToString(block.Hash) // 因为区块哈希是 binary
 
 	for i, tx := range block.Transactions {
@@ -283,28 +393,30 @@ func (n *Node) indexTransactions(block *blockchain.Block, bi *BlockIndex) {
 
 		idx := blockchain.TxIndexEntry{
 			BlockHash: blockHashHex, // hex
-			Height:    bi.Height,
+			Height:    block.Height,
 			TxOffset:  i,
 		}
 
 		data, _ := json.Marshal(idx)
 
 		// key 必须是字符串（hex）
-		n.DB.Put("txindex", txidHex, data)
+		batch.Put(database.BucketTxIndex, txidHex, data)
 	}
 }
 
-func (n *Node) removeTxIndex(block *blockchain.Block) {
+func (n *Node) removeTxIndex(batch *database.Batch, block *blockchain.Block) {
 	for _, tx := range block.Transactions {
-		n.DB.Delete("txindex", tx.ID)
+		batch.Delete(database.BucketTxIndex, tx.ID)
 	}
 }
 
 func (n *Node) removeConfirmedTxs(block *blockchain.Block) {
 	for _, tx := range block.Transactions {
 		if !tx.IsCoinbase {
-			n.DB.Delete("mempool", tx.ID)
+			n.DB.Delete(database.BucketMempool, tx.ID)
 			n.Mempool.Remove(tx.ID)
 		}
 	}
+	// ⌛ 順便清掉放太久的交易
+	n.Mempool.Limit(time.Now())
 }
@@ -4,21 +4,80 @@ import (
 	"fmt"
 	"math/big"
 	"mycoin/utils"
+	"sort"
+	"time"
 )
 
-// 這裡定義你的難度參數
+// 難度週期與出塊間隔放在 blockchain.Params，這裡只剩時間戳規則
 const (
-	DifficultyInterval = 10
-	TargetSpacing      = 30 // seconds (注意：我改回 10 秒方便測試，你原本寫 1 秒也可以)
-	IntervalTimespan   = DifficultyInterval * TargetSpacing
+	// 區塊時間預設最多只能比本地時鐘快 2 小時 (可用 Node.MaxFutureBlockTime 調整)
+	DefaultMaxFutureBlockTime = 2 * 60 * 60
+
+	// 區塊時間必須大於前 11 個區塊時間的中位數 (median-time-past)
+	MedianTimeBlocks = 11
 )
 
+// expectedBits 根據父塊推算下一個區塊應該使用的 Bits
+func (n *Node) expectedBits(parent *BlockIndex) uint32 {
+	// 🧪 regtest：難度永遠停在 PowLimit
+	if n.Params.NoRetargeting {
+		return utils.BigToCompact(n.Target)
+	}
+	if (parent.Height+1)%n.Params.DifficultyInterval == 0 {
+		// 🔴 調整週期：計算新難度
+		return utils.BigToCompact(n.retargetDifficulty(parent))
+	}
+	// 🔴 非調整週期：必須跟父塊難度一模一樣
+	return parent.Bits
+}
+
+// checkBits 確保區塊頭裡的 Bits 符合難度調整規則
+func (n *Node) checkBits(parent *BlockIndex, bits uint32) error {
+	expected := n.expectedBits(parent)
+	if bits != expected {
+		return fmt.Errorf("bad difficulty bits at height %d: expected %d, got %d", parent.Height+1, expected, bits)
+	}
+	return nil
+}
+
+// medianTimePast 從 bi 往回數 11 個區塊 (含 bi) 的時間戳中位數
+// 單一礦工亂填時間改變不了中位數，難度調整因此不會被時間戳操控
+func medianTimePast(bi *BlockIndex) int64 {
+	times := make([]int64, 0, MedianTimeBlocks)
+	for cur := bi; cur != nil && len(times) < MedianTimeBlocks; cur = cur.Parent {
+		times = append(times, cur.Timestamp)
+	}
+	if len(times) == 0 {
+		return 0
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i] < times[j] })
+	return times[len(times)/2]
+}
+
+// checkBlockTime 新區塊的時間戳必須大於父鏈的 MTP，且不能跑到未來太遠
+func (n *Node) checkBlockTime(parent *BlockIndex, timestamp int64) error {
+	if mtp := medianTimePast(parent); timestamp <= mtp {
+		return fmt.Errorf("block timestamp %d not after median time past %d", timestamp, mtp)
+	}
+	limit := time.Now().Unix() + n.MaxFutureBlockTime
+	if timestamp > limit {
+		return fmt.Errorf("block timestamp %d too far in the future (limit %d)", timestamp, limit)
+	}
+	return nil
+}
+
+// GetMedianTimePast 鏈頭的 MTP，礦工的時間戳至少要比它大 1
+func (n *Node) GetMedianTimePast() int64 {
+	return medianTimePast(n.Best)
+}
+
 func (n *Node) retargetDifficulty(last *BlockIndex) *big.Int {
 	// 1. 找到舊週期的第一個區塊
-	firstHeight := last.Height - DifficultyInterval + 1
-	if int(firstHeight) < 0 {
-		firstHeight = 0
+	var firstHeight uint64
+	if last.Height+1 > n.Params.DifficultyInterval {
+		firstHeight = last.Height + 1 - n.Params.DifficultyInterval
 	}
+	intervalTimespan := n.Params.TargetTimespan()
 
 	first := last
 	// 安全檢查：防止 first 為 nil
@@ -34,8 +93,8 @@ func (n *Node) retargetDifficulty(last *BlockIndex) *big.Int {
 	actualTimespan := last.Timestamp - first.Timestamp
 
 	// 限制上下限（/4 ～ ×4）
-	minTimespan := int64(IntervalTimespan / 4)
-	maxTimespan := int64(IntervalTimespan * 4)
+	minTimespan := intervalTimespan / 4
+	maxTimespan := intervalTimespan * 4
 
 	if actualTimespan < minTimespan {
 		actualTimespan = minTimespan
@@ -51,9 +110,9 @@ func (n *Node) retargetDifficulty(last *BlockIndex) *big.Int {
 	oldTarget := utils.CompactToBig(last.Bits)
 
 	// 計算新 Target
-	// newTarget = oldTarget * actualTimespan / IntervalTimespan
+	// newTarget = oldTarget * actualTimespan / intervalTimespan
 	newTarget := new(big.Int).Mul(oldTarget, big.NewInt(actualTimespan))
-	newTarget.Div(newTarget, big.NewInt(IntervalTimespan))
+	newTarget.Div(newTarget, big.NewInt(intervalTimespan))
 
 	// 最大目標檢查 (不能比創世難度更簡單)
 	if newTarget.Cmp(n.Target) > 0 {
@@ -61,7 +120,7 @@ func (n *Node) retargetDifficulty(last *BlockIndex) *big.Int {
 	}
 
 	fmt.Printf("⏱ [Consensus] 難度調整: Span %ds (預期 %ds) | Old: %x -> New: %x\n",
-		actualTimespan, IntervalTimespan,
+		actualTimespan, intervalTimespan,
 		oldTarget, newTarget, // 這裡可以縮短顯示，不然 log 會很長
 	)
 
@@ -81,13 +140,15 @@ func (n *Node) GetCurrentTarget() *big.Int {
 		return new(big.Int).Set(n.Target)
 	}
 
-	// 不到週期 → 返回當前區塊難度
-	if (last.Height+1)%DifficultyInterval != 0 {
-		if last.Block != nil {
-			return new(big.Int).Set(last.Block.Target)
+	// regtest 或不到週期 → 返回當前區塊難度
+	if n.Params.NoRetargeting {
+		return new(big.Int).Set(n.Target)
+	}
+	if (last.Height+1)%n.Params.DifficultyInterval != 0 {
+		// 區塊頭就記著 Bits，不必讀 body
+		if last.Bits != 0 {
+			return utils.CompactToBig(last.Bits)
 		}
-		// 如果只有 Header (Block==nil)，暫時返回 n.Target 或上一級難度
-		// 這裡為了不崩潰，返回 n.Target (理想情況應該從 Bits 還原)
 		return new(big.Int).Set(n.Target)
 	}
 
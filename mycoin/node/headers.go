@@ -0,0 +1,97 @@
+package node
+
+import (
+	"bytes"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"math/big"
+	"mycoin/blockchain"
+	"mycoin/utils"
+)
+
+// ErrOrphanHeader 表示標頭接不上任何已知區塊 (可能只是對方順序亂了，不一定是惡意)
+var ErrOrphanHeader = errors.New("header does not connect")
+
+// ProcessHeader 驗證並接收一個只有標頭的區塊 (headers-first 同步專用)
+//
+// 對方傳來的 CumWork 一律不信任：PoW、Bits、時間戳都在本地重新檢查，
+// 累積工作量也由父塊加上本塊的工作量自己算。
+// 回傳 known=true 表示這個標頭早就在索引裡了。
+func (n *Node) ProcessHeader(hdr *blockchain.Block) (bi *BlockIndex, known bool, err error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	// 索引的 key 一律用本地算出來的 Hash；對方宣稱的 Hash 對不上 (包含沒給) 直接拒絕
+	hash := hdr.CalcHash()
+	if !bytes.Equal(hash, hdr.Hash) {
+		return nil, false, fmt.Errorf("header hash mismatch: claimed %x, computed %x", hdr.Hash, hash)
+	}
+	hashHex := hex.EncodeToString(hash)
+	if existing, ok := n.Blocks[hashHex]; ok {
+		return existing, true, nil
+	}
+
+	// 1️⃣ 父塊必須已知，否則無法驗證難度與工作量
+	prevHex := hex.EncodeToString(hdr.PrevHash)
+	parent, ok := n.Blocks[prevHex]
+	if !ok {
+		return nil, false, fmt.Errorf("%w: %s (unknown parent %s)", ErrOrphanHeader, short(hashHex), short(prevHex))
+	}
+	if parent.Failed {
+		return nil, false, fmt.Errorf("header %s builds on failed block %s", short(hashHex), short(prevHex))
+	}
+	if hdr.Height != parent.Height+1 {
+		return nil, false, fmt.Errorf("header %s has height %d, expected %d", short(hashHex), hdr.Height, parent.Height+1)
+	}
+
+	// 2️⃣ 工作量證明 (Hash 必須由標頭內容算出，且低於 Bits 的 Target)
+	if err := hdr.CheckProofOfWork(); err != nil {
+		return nil, false, err
+	}
+
+	// 3️⃣ 難度調整規則
+	if err := n.checkBits(parent, hdr.Bits); err != nil {
+		return nil, false, err
+	}
+
+	// 4️⃣ 時間戳
+	if err := n.checkBlockTime(parent, hdr.Timestamp); err != nil {
+		return nil, false, err
+	}
+
+	// 5️⃣ 本地計算累積工作量
+	work := computeWork(utils.CompactToBig(hdr.Bits))
+	cumWork := new(big.Int).Add(parent.CumWorkInt, work)
+
+	bi = &BlockIndex{
+		Hash:       hashHex,
+		PrevHash:   prevHex,
+		Height:     hdr.Height,
+		Timestamp:  hdr.Timestamp,
+		Bits:       hdr.Bits,
+		Nonce:      hdr.Nonce,
+		MerkleRoot: hex.EncodeToString(hdr.MerkleRoot),
+		CumWork:    cumWork.Text(16),
+		CumWorkInt: cumWork,
+		Parent:     parent,
+		Children:   []*BlockIndex{},
+	}
+
+	n.Blocks[hashHex] = bi
+	parent.Children = append(parent.Children, bi)
+
+	if n.Best == nil || bi.CumWorkInt.Cmp(n.Best.CumWorkInt) > 0 {
+		n.Best = bi
+		n.Chain.SetTip(bi)
+	}
+
+	return bi, false, nil
+}
+
+func short(hash string) string {
+	if len(hash) > 8 {
+		return hash[:8]
+	}
+	return hash
+}
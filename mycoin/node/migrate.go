@@ -0,0 +1,162 @@
+package node
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+
+	"mycoin/blockchain"
+	"mycoin/database"
+)
+
+// storageFormat 目前的資料格式版本 (2 = 二進位區塊，3 = 硬碟上的 UTXO 地址索引，4 = 區塊 body 搬到區塊檔，
+// 5 = UTXO.Time 改記父鏈 MTP)
+const storageFormat = "5"
+
+// migrateStorage 把舊版 JSON 格式的區塊與 mempool 交易改寫成二進位格式，並補建 UTXO 地址索引
+// (UTXO / index / txindex 仍然是 JSON，不在此列)
+func (n *Node) migrateStorage() error {
+	format := string(n.DB.Get(database.BucketMeta, "format"))
+	if format == storageFormat {
+		return nil
+	}
+
+	blocks := make(map[string][]byte)
+	n.DB.Iterate(database.BucketBlocks, func(k, v []byte) {
+		if len(v) > 0 && v[0] == '{' {
+			blocks[string(k)] = append([]byte(nil), v...)
+		}
+	})
+
+	decoded := make(map[string]*blockchain.Block, len(blocks))
+	legacyHeight := n.migratedLegacyTxHeight()
+	for hashHex, raw := range blocks {
+		blk, err := blockchain.DeserializeBlock(raw)
+		if err != nil {
+			return fmt.Errorf("migrate block %s: %w", hashHex, err)
+		}
+		// 🛡️ 重算出來的 Hash 必須跟 key 一致，否則代表舊 ID 還原錯了
+		if got := hex.EncodeToString(blk.Hash); got != hashHex {
+			return fmt.Errorf("migrate block %s: hash mismatch after decode (%s)", hashHex, got)
+		}
+		for _, tx := range blk.Transactions {
+			if tx.Version < blockchain.TxVersion && blk.Height > legacyHeight {
+				legacyHeight = blk.Height
+			}
+		}
+		decoded[hashHex] = blk
+	}
+
+	// 🧳 舊交易最後出現的高度先記下來 (改寫到一半關機，下次就看不到 JSON 區塊了)
+	if len(decoded) > 0 {
+		if err := n.DB.Put(database.BucketMeta, "legacytxheight", []byte(fmt.Sprint(legacyHeight))); err != nil {
+			return err
+		}
+		log.Printf("🧳 Legacy transactions found up to height %d\n", legacyHeight)
+	}
+	for hashHex, blk := range decoded {
+		if err := n.DB.Put(database.BucketBlocks, hashHex, blk.Serialize()); err != nil {
+			return err
+		}
+	}
+
+	txs := make(map[string][]byte)
+	n.DB.Iterate(database.BucketMempool, func(k, v []byte) {
+		if len(v) > 0 && v[0] == '{' {
+			txs[string(k)] = append([]byte(nil), v...)
+		}
+	})
+
+	for txid, raw := range txs {
+		tx, err := blockchain.DeserializeTransaction(raw)
+		if err != nil {
+			// mempool 丟了也沒關係，直接清掉
+			n.DB.Delete(database.BucketMempool, txid)
+			continue
+		}
+		if err := n.DB.Put(database.BucketMempool, txid, tx.Serialize()); err != nil {
+			return err
+		}
+	}
+
+	if len(blocks) > 0 || len(txs) > 0 {
+		log.Printf("🧳 Migrated %d blocks and %d mempool txs to binary format\n", len(blocks), len(txs))
+	}
+
+	// 🗂️ 帳本不再整本載入記憶體，地址查詢改走 utxoaddr bucket
+	if err := blockchain.BuildAddrIndex(n.DB); err != nil {
+		return fmt.Errorf("build utxo address index: %w", err)
+	}
+
+	if err := n.moveBlocksToFiles(); err != nil {
+		return fmt.Errorf("move blocks to block files: %w", err)
+	}
+
+	// ⏳ 舊帳本的 UTXO.Time 是區塊時間戳，要從區塊重建 (記在 meta/reindex，中途關機下次會接著做)
+	if format < "5" && n.DB.Get(database.BucketMeta, "best") != nil && n.DB.Get(database.BucketMeta, "reindex") == nil {
+		if n.PrunedHeight() > 0 {
+			log.Println("⚠️ 已修剪的節點無法重建帳本，舊 UTXO 的相對時間鎖仍以區塊時間戳計算")
+		} else if err := n.DB.Put(database.BucketMeta, "reindex", []byte(reindexChainstate)); err != nil {
+			return err
+		}
+	}
+
+	return n.DB.Put(database.BucketMeta, "format", []byte(storageFormat))
+}
+
+// migratedLegacyTxHeight migrateStorage 在舊格式區塊裡找到的最後一筆舊版交易高度 (沒遷移過就是 0)
+func (n *Node) migratedLegacyTxHeight() uint64 {
+	var h uint64
+	if data := n.DB.Get(database.BucketMeta, "legacytxheight"); data != nil {
+		fmt.Sscanf(string(data), "%d", &h)
+	}
+	return h
+}
+
+// moveBlocksToFiles 把 blocks bucket 裡的區塊 body 追加到區塊檔，索引記下位置後清空 bucket
+// 中途關機的話下次會再搬一次：區塊檔裡多出來的重複記錄沒有索引指著，不影響
+func (n *Node) moveBlocksToFiles() error {
+	positions := make(map[string]database.BlockPos)
+	var appendErr error
+	n.DB.Iterate(database.BucketBlocks, func(k, v []byte) {
+		if appendErr != nil {
+			return
+		}
+		pos, err := n.BlockFiles.Append(v)
+		if err != nil {
+			appendErr = err
+			return
+		}
+		positions[string(k)] = pos
+	})
+	if appendErr != nil {
+		return appendErr
+	}
+	if len(positions) == 0 {
+		return nil
+	}
+
+	batch := database.NewBatch()
+	n.DB.Iterate(database.BucketIndex, func(k, v []byte) {
+		var bi BlockIndex
+		if err := json.Unmarshal(v, &bi); err != nil {
+			return
+		}
+		pos, ok := positions[bi.Hash]
+		if !ok {
+			return
+		}
+		bi.Pos = pos
+		bi.HaveData = true
+		idxBytes, _ := json.Marshal(&bi)
+		batch.Put(database.BucketIndex, bi.Hash, idxBytes)
+	})
+	batch.ClearBucket(database.BucketBlocks)
+	if err := n.DB.Write(batch); err != nil {
+		return err
+	}
+
+	log.Printf("🧳 Moved %d block bodies into block files\n", len(positions))
+	return nil
+}
@@ -24,7 +24,7 @@ import (
 // --------------------
 
 type Node struct {
-	Chain          []*blockchain.Block
+	Chain          *ChainView // 主鏈視圖 (依高度索引，跟著 Best 更新)；區塊 body 用 ReadBlock 讀
 	Mempool        *mempool.Mempool
 	UTXO           *blockchain.UTXOSet
 	mu             sync.Mutex
@@ -34,9 +34,11 @@ type Node struct {
 	Orphans        map[string][]*blockchain.Block
 	Mode           string
 	Target         *big.Int
-	Reward         int
+	Params         *blockchain.Params // 這個節點跑的網路 (主網 / 測試網 / regtest)
 	Miner          *miner.Miner
-	DB             *database.BoltDB
+	DB             database.Store
+	BlockFiles     database.BlockStorage // 區塊 body 的平面檔，位置記在 BlockIndex.Pos
+	DataDir        string                // 資料目錄 (NewNodeWithStore 建的節點沒有，全部放記憶體)
 	MinerResetChan chan bool
 	Broadcaster    BlockBroadcaster
 	SyncState      SyncState
@@ -44,6 +46,33 @@ type Node struct {
 	HeadersSynced  bool
 	BodiesSynced   bool
 	NodeID         uint64
+
+	MaxFutureBlockTime int64 // 區塊時間最多能比本地時鐘快幾秒
+
+	Reindex           bool // 啟動時從 blocks 重建區塊索引、交易索引與帳本
+	ReindexChainstate bool // 啟動時只重建帳本
+	CheckBlocks       int  // 啟動時回頭核對帳本的區塊數
+	UTXOCacheSize     int  // UTXO 快取最多放幾筆沒改過的帳目
+	BlockCacheSize    int  // 記憶體裡最多留幾個區塊 body
+
+	MempoolMaxBytes int64         // 交易池總大小上限 (vbytes)
+	MempoolExpiry   time.Duration // 交易在池子裡最多放多久
+
+	MempoolAncestorLimit   int // 一筆交易連同池子裡的祖先最多幾筆
+	MempoolDescendantLimit int // 一筆交易連同池子裡的子孫最多幾筆
+
+	PruneDepth  uint64 // 修剪模式保留的最近主鏈區塊數
+	PruneTarget uint64 // 修剪模式的區塊 + undo 容量目標 (bytes，0 = 不限)
+
+	blockCache *blockCache
+	snapshot   *snapshotValidation // 從 UTXO 快照啟動、還沒驗完時才有
+}
+
+// newUTXOSet 開一本接在硬碟上的帳本 (快取大小照節點設定)
+func (n *Node) newUTXOSet() *blockchain.UTXOSet {
+	u := blockchain.NewUTXOSet(n.DB)
+	u.SetCacheSize(n.UTXOCacheSize)
+	return u
 }
 
 type BlockBroadcaster interface {
@@ -57,8 +86,8 @@ func (n *Node) HasBlock(hash []byte) bool {
 	// 1. 检查索引是否存在
 	bi, exists := n.Blocks[key]
 	if exists {
-		// 2. 如果索引存在，且 Block 指针不为空，说明拥有完整区块
-		return bi.Block != nil
+		// 2. 如果索引存在，且已经收到 body，说明拥有完整区块
+		return bi.HasBody()
 	}
 
 	// 3. 检查是否在孤块池
@@ -72,7 +101,7 @@ func (n *Node) HasBlock(hash []byte) bool {
 // 辅助函数也需要改
 func (n *Node) GetBlockByHash(hashHex string) *blockchain.Block {
 	if bi, ok := n.Blocks[hashHex]; ok {
-		return bi.Block // 直接返回索引里的 Block 指针
+		return n.GetBlock(bi) // 从区块档读 (有快取)
 	}
 	return nil
 }
@@ -101,16 +130,28 @@ func utxoKey(txid string, index int) string {
 // --------------------
 // 创建新节点（含创世块）
 // --------------------
-func NewNode(mode string, datadir string) *Node {
+func NewNode(params *blockchain.Params, mode string, datadir string) (*Node, error) {
 	os.MkdirAll(datadir, 0755)
 	dbPath := filepath.Join(datadir, "chain.db")
-	db := database.OpenDB(dbPath)
+	db, err := database.OpenDB(dbPath)
+	if err != nil {
+		return nil, err
+	}
+	blocks, err := database.OpenFlatFiles(filepath.Join(datadir, "blocks"), params.Net, database.DefaultMaxBlockFileSize)
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	n := NewNodeWithStore(params, mode, db, blocks)
+	n.DataDir = datadir
+	return n, nil
+}
 
-	target := new(big.Int)
-	target.SetString(
-		"00000fffffffffffffffffffffffffffffffffffffffffffffffffffffffffff",
-		16,
-	)
+// NewNodeWithStore 用現成的儲存後端建立節點
+// (例如 database.NewMemDB() + database.NewMemBlockFiles(0)，不碰硬碟)
+func NewNodeWithStore(params *blockchain.Params, mode string, db database.Store, blocks database.BlockStorage) *Node {
+	// 最簡單的難度由網路參數決定 (regtest 幾乎沒有難度)
+	target := new(big.Int).Set(params.PowLimit)
 
 	// ==========================================
 	// 🌟 探長加碼：在建立 Node 之前，先印製專屬身分證！
@@ -120,15 +161,22 @@ func NewNode(mode string, datadir string) *Node {
 
 	n := &Node{
 		Mode:    mode,
-		Chain:   []*blockchain.Block{},
-		Mempool: mempool.NewMempool(1000, db),
+		Chain:   NewChainView(),
+		Mempool: mempool.NewMempool(mempool.DefaultMaxBytes, mempool.DefaultExpiry, db),
 		UTXO:    blockchain.NewUTXOSet(db),
-		Target:  target,
-		Reward:  500,
-		Blocks:  make(map[string]*BlockIndex), // ✓ 修正
+
+		UTXOCacheSize:   blockchain.DefaultUTXOCacheSize,
+		BlockCacheSize:  DefaultBlockCacheSize,
+		MempoolMaxBytes: mempool.DefaultMaxBytes,
+		MempoolExpiry:   mempool.DefaultExpiry,
+		PruneDepth:      PruneDepth,
+		Target:          target,
+		Params:          params,
+		Blocks:          make(map[string]*BlockIndex), // ✓ 修正
 		//	BlockIndex: make(map[string]*blockchain.Block), // ✓ 修正
 		Orphans:        make(map[string][]*blockchain.Block),
 		DB:             db,
+		BlockFiles:     blocks,
 		MinerResetChan: make(chan bool, 1),
 		// ==========================================
 		// 🚨 探長加碼：給節點戴上「實習生」臂章
@@ -138,27 +186,48 @@ func NewNode(mode string, datadir string) *Node {
 		SyncState: SyncHeaders, // 👈 設定初始狀態為「抓取標頭」
 		// ==========================================
 		NodeID: myNodeID,
+
+		MaxFutureBlockTime: DefaultMaxFutureBlockTime,
+		CheckBlocks:        DefaultCheckBlocks,
+
+		MempoolAncestorLimit:   mempool.DefaultAncestorLimit,
+		MempoolDescendantLimit: mempool.DefaultDescendantLimit,
+
+		blockCache: newBlockCache(DefaultBlockCacheSize),
 	}
 
 	// ==========================================================
 	// 🚨 探長關鍵加碼：把創世區塊請進記憶體點名簿！
 	// ==========================================================
-	genesis := blockchain.NewGenesisBlock(target)
+	genesis := params.GenesisBlock()
 	gHash := hex.EncodeToString(genesis.Hash)
 
 	n.Blocks[gHash] = &BlockIndex{
 		Hash:       gHash,
 		Height:     0,
-		Block:      genesis,
 		CumWorkInt: big.NewInt(0),
+		Bits:       genesis.Bits,
+		Timestamp:  genesis.Timestamp,
+		MerkleRoot: hex.EncodeToString(genesis.MerkleRoot),
 	}
 	// 順便把 n.Best 設為創世，這樣同步才有一個起點
 	n.Best = n.Blocks[gHash]
+	n.Chain.SetTip(n.Best)
 	// ==========================================================
 
 	return n
 }
 
+// Close 關閉區塊檔與資料庫 (離線指令跑完時用)
+func (n *Node) Close() error {
+	n.stopSnapshotValidation()
+	err := n.BlockFiles.Close()
+	if dbErr := n.DB.Close(); err == nil {
+		err = dbErr
+	}
+	return err
+}
+
 // -----------------------------------------------------------------------------
 // 🔥 方案 A 核心：Node 主控挖礦邏輯 (請貼在 node/node.go 最後面)
 // -----------------------------------------------------------------------------
@@ -243,12 +312,18 @@ func (n *Node) AddTx(tx blockchain.Transaction, fromNodeID uint64) bool {
 	// ==========================================
 	// 🕵️ 第一關：門口保全 (手續費檢查)
 	// ==========================================
-	// 注意：這裡直接從當前 UTXO Set 查手續費
+	// 🧳 舊版交易的簽名不承諾腳本與金額，新交易一律不收
+	if tx.Version < blockchain.TxVersion {
+		fmt.Printf("🚫 [Security] 交易 %s 是舊版格式 (version %d)，直接拒絕\n", short(tx.ID), tx.Version)
+		return false
+	}
+
+	// 注意：這裡直接從當前 UTXO Set 查手續費；門檻依交易大小 (vB) 以最低費率計算
 	fee := tx.Fee(n.UTXO, n.Mempool.Txs)
-	const MinRelayFee = 1 // 只有手續費 >= 1 元才准進來
+	vsize := tx.VSize()
 
-	if fee < MinRelayFee {
-		fmt.Printf("🚫 [Security] 交易 %s 手續費太低 (%d < %d)，直接在門外踢掉！\n", tx.ID[:8], fee, MinRelayFee)
+	if minFee := mempool.MinRelayFeeRate.FeeFor(vsize); fee < minFee {
+		fmt.Printf("🚫 [Security] 交易 %s 手續費太低 (%d < %d，%d vB)，直接在門外踢掉！\n", short(tx.ID), fee, minFee, vsize)
 		return false
 	}
 	fmt.Println("👉 [X-Ray] 準備鎖定 n.mu 大門...")
@@ -256,7 +331,7 @@ func (n *Node) AddTx(tx blockchain.Transaction, fromNodeID uint64) bool {
 	defer n.mu.Unlock()
 	fmt.Println("👉 [X-Ray] 成功鎖定 n.mu，開始執行 VerifyTx...")
 
-	if err := VerifyTx(tx, n.UTXO, n.Mempool.Txs); err != nil {
+	if err := VerifyTx(tx, n.UTXO, n.Mempool.Txs, n.MempoolSpendContext()); err != nil {
 		fmt.Printf("❌ 交易驗證失敗被拒絕 (%s): %v\n", tx.ID, err)
 		return false
 	}
@@ -266,14 +341,9 @@ func (n *Node) AddTx(tx blockchain.Transaction, fromNodeID uint64) bool {
 		return false
 	}
 
-	fmt.Println("👉 [X-Ray] Mempool.Has 通過，開始執行 Mempool.HasDoubleSpend...")
-	if n.Mempool.HasDoubleSpend(&tx) {
-		fmt.Printf("❌ 交易被拒絕：與 Mempool 內的交易發生雙花衝突 (%s)\n", tx.ID)
-		return false
-	}
-
-	fmt.Println("👉 [X-Ray] Mempool.HasDoubleSpend 通過，開始進入 AddTxRBF 黑洞...")
-	ok := n.Mempool.AddTxRBF(tx.ID, tx.Serialize(), n.UTXO, fromNodeID)
+	// 🔁 跟 Mempool 內的交易雙花時不直接拒絕：交給 AddTxRBF 依替換規則決定 (Full-RBF)
+	fmt.Println("👉 [X-Ray] Mempool.Has 通過，開始進入 AddTxRBF 黑洞...")
+	ok := n.Mempool.AddTxRBF(tx.ID, tx.Serialize(), n.UTXO, fromNodeID, n.MempoolSpendContext())
 
 	fmt.Println("👉 [X-Ray] 成功逃出 AddTxRBF 黑洞！")
 	if !ok {
@@ -287,46 +357,6 @@ func (n *Node) AddTx(tx blockchain.Transaction, fromNodeID uint64) bool {
 
 }
 
-// --------------------
-// 区块追加（主链）
-// --------------------
-func (n *Node) appendBlock(block *blockchain.Block) {
-	// 1️⃣ 加入主链
-	n.Chain = append(n.Chain, block)
-
-	// 2️⃣ 更新 UTXO（只做共识状态）
-	for _, tx := range block.Transactions {
-		if !tx.IsCoinbase {
-			n.UTXO.Spend(tx)
-		}
-		n.UTXO.Add(tx)
-	}
-
-	// 3️⃣ 🔥 CPFP：mempool rebuild（关键）
-	oldTxs := n.Mempool.Txs
-	oldSources := n.Mempool.Sources // 🌟 探長關鍵修正：把來源身分證名冊也備份起來！
-
-	n.Mempool.Reset()
-
-	for txid, txBytes := range oldTxs {
-		// 🕵️ 查出這筆交易當初是誰送來的 (如果找不到，預設當作是自己 n.NodeID)
-		fromID := n.NodeID
-		if id, exists := oldSources[txid]; exists {
-			fromID = id
-		}
-
-		// 🚀 補上第 4 個參數 fromID！
-		if ok := n.Mempool.AddTxRBF(txid, txBytes, n.UTXO, fromID); !ok {
-			log.Println("🧹 mempool drop after block:", txid)
-		}
-	}
-
-	hashHex := hex.EncodeToString(block.Hash)
-
-	n.DB.Put("blocks", hashHex, block.Serialize())
-	n.DB.Put("meta", "best", []byte(hashHex))
-}
-
 // --------------------
 // 添加新区块
 // --------------------
@@ -339,7 +369,17 @@ func (n *Node) AddBlock(block *blockchain.Block) bool {
 
 	// 1. 重複檢查
 	if bi, exists := n.Blocks[hashHex]; exists {
-		if bi.Block != nil {
+		// 📸 快照以下的歷史區塊：只給背景驗證用，不會動到主鏈
+		if n.isSnapshotHistory(bi) {
+			err := n.storeSnapshotBlock(bi, block)
+			n.mu.Unlock()
+			if err != nil {
+				log.Printf("❌ [Snapshot] 歷史區塊 %d 無法使用: %v\n", block.Height, err)
+				return false
+			}
+			return true
+		}
+		if bi.HasBody() {
 			n.mu.Unlock() // 🔓 已經有了，安全解鎖
 			return true
 		}
@@ -348,8 +388,8 @@ func (n *Node) AddBlock(block *blockchain.Block) bool {
 
 	// 2. 孤兒檢查
 	parentIndex, exists := n.Blocks[prevHex]
-	if !exists || parentIndex.Block == nil {
-		log.Printf("⚠️ 發現孤塊: %d (缺少父塊 %s)\n", block.Height, prevHex[:8])
+	if !exists || !parentIndex.HasBody() {
+		log.Printf("⚠️ 發現孤塊: %d (缺少父塊 %s)\n", block.Height, short(prevHex))
 		n.AddOrphan(block)
 		n.mu.Unlock() // 🔓 存入孤兒院，安全解鎖
 		return false
@@ -363,21 +403,7 @@ func (n *Node) AddBlock(block *blockchain.Block) bool {
 		return false
 	}
 
-	// ==========================================
-	// 🚀 關鍵修正點：在解鎖前，確保帳本狀態已經同步
-	// ==========================================
-	// 雖然 connectBlock 裡可能有 Flush，但這裡做最後確認，確保 Kali 重啟必對。
-	// n.UTXO.FlushToDB() // 如果 connectBlock 已經做了，這裡可省略
-
-	isMain := false
-	curr := n.Best
-	for curr != nil {
-		if curr.Hash == hashHex {
-			isMain = true
-			break
-		}
-		curr = curr.Parent
-	}
+	isMain := n.Chain.Contains(n.Blocks[hashHex])
 
 	// 4. 釋放 Node 鎖
 	n.mu.Unlock()
@@ -397,67 +423,44 @@ func (n *Node) AddBlock(block *blockchain.Block) bool {
 // --------------------
 // 重建主链 (完美退回交易版)
 // --------------------
-func (n *Node) rebuildChain(oldChain, newChain []*BlockIndex, newTip *BlockIndex) {
+// 帳本用 undo 紀錄逐塊撤下 / 接上，成本只跟重組深度有關；新鏈接不上時維持舊鏈
+// 帳本變動、undo 與交易索引都放進 batch，由呼叫端連同鏈頭一次寫入
+func (n *Node) rebuildChain(oldChain, newChain []*BlockIndex, newTip *BlockIndex, batch *database.Batch) error {
 	fmt.Printf("🔄 [Reorg] 啟動鏈重組：舊鏈長度 %d -> 新鏈長度 %d\n", len(oldChain), len(newChain))
 
 	// ============================================================
 	// 🛠️ 核心修正：同步更新 UTXO 帳本 (必須在更新 n.Chain 之前處理)
+	// A. 撤銷舊鏈 (Tip 往回走)  B. 執行新鏈 (高度從小到大)
 	// ============================================================
-
-	// A. 撤銷舊鏈 (Rollback) - 必須由新往舊（Tip 往回走）撤銷
-	for i := len(oldChain) - 1; i >= 0; i-- {
-		oldBI := oldChain[i]
-		if oldBI != nil && oldBI.Block != nil {
-			fmt.Printf("⏪ 正在撤銷舊鏈區塊: %d (Hash: %s)\n", oldBI.Height, oldBI.Hash[:8])
-			for _, tx := range oldBI.Block.Transactions {
-				n.UTXO.Revert(tx)
-			}
-			// 🚀 【手術刀 1】：同步抹除 PostgreSQL 裡的幽靈數據
-			indexer.UnindexBlock(oldBI.Hash)
-		}
+	if err := n.switchUTXO(oldChain, newChain, batch); err != nil {
+		return fmt.Errorf("reorg aborted: %w", err)
 	}
 
-	// B. 執行新鏈 (Apply) - 必須由舊往新（高度從小到大）執行
+	// 🚀 【手術刀】：同步抹除 PostgreSQL 裡的幽靈數據，再寫入新鏈
+	for _, oldBI := range oldChain {
+		fmt.Printf("⏪ 已撤銷舊鏈區塊: %d (Hash: %s)\n", oldBI.Height, short(oldBI.Hash))
+		indexer.UnindexBlock(oldBI.Hash)
+	}
 	for _, newBI := range newChain {
-		if newBI != nil && newBI.Block != nil {
-			fmt.Printf("⏩ 正在執行新鏈區塊: %d (Hash: %s)\n", newBI.Height, newBI.Hash[:8])
-			for _, tx := range newBI.Block.Transactions {
-				if !tx.IsCoinbase {
-					n.UTXO.Spend(tx)
-				}
-				n.UTXO.Add(tx)
-			}
-			// 🚀 【手術刀 2】：把新鏈的資料重新寫入 PostgreSQL，確保它是最新狀態
-			indexer.IndexBlock(newBI.Block, newBI.Height, true)
+		fmt.Printf("⏩ 已執行新鏈區塊: %d (Hash: %s)\n", newBI.Height, short(newBI.Hash))
+		if blk := n.GetBlock(newBI); blk != nil {
+			indexer.IndexBlock(blk, newBI.Height, true)
 		}
 	}
 
-	// 💾 確保更新後的帳本狀態寫入 BoltDB，防止 Kali 重啟後遺失
-	n.UTXO.FlushToDB()
-
 	// ============================================================
-	// 1️⃣ 構建完整主鏈陣列 (原始邏輯)
+	// 1️⃣ 更新 Node 核心指標與主鏈視圖
 	// ============================================================
-	var fullChain []*blockchain.Block
-	cur := newTip
-	for cur != nil {
-		if cur.Block != nil {
-			fullChain = append([]*blockchain.Block{cur.Block}, fullChain...)
-		}
-		cur = cur.Parent
-	}
-
-	// 更新 Node 核心指標
-	n.Chain = fullChain
 	n.Best = newTip
+	n.UpdateChainFromBest()
 
 	// ============================================================
 	// 2️⃣ 收集新鏈中【已經確認】的交易 ID (原始邏輯)
 	// ============================================================
 	confirmedInNewChain := make(map[string]bool)
 	for _, bi := range newChain {
-		if bi != nil && bi.Block != nil {
-			for _, tx := range bi.Block.Transactions {
+		if blk := n.GetBlock(bi); blk != nil {
+			for _, tx := range blk.Transactions {
 				confirmedInNewChain[tx.ID] = true
 			}
 		}
@@ -470,8 +473,8 @@ func (n *Node) rebuildChain(oldChain, newChain []*BlockIndex, newTip *BlockIndex
 
 	// A. 抓出舊鏈中沒有被新鏈打包的交易
 	for _, old := range oldChain {
-		if old != nil && old.Block != nil {
-			for _, tx := range old.Block.Transactions {
+		if blk := n.GetBlock(old); blk != nil {
+			for _, tx := range blk.Transactions {
 				if !tx.IsCoinbase && !confirmedInNewChain[tx.ID] {
 					txsToRestore[tx.ID] = tx.Serialize()
 				}
@@ -486,30 +489,47 @@ func (n *Node) rebuildChain(oldChain, newChain []*BlockIndex, newTip *BlockIndex
 		}
 	}
 
+	// 原本就在池子裡的交易沿用進池時間與來源 (不然過期計時會被重組歸零)
+	enterTimes := make(map[string]int64, len(txsToRestore))
+	sources := make(map[string]uint64, len(txsToRestore))
+	for txid := range txsToRestore {
+		enterTimes[txid] = n.Mempool.EnterTime(txid)
+		sources[txid] = n.Mempool.GetSource(txid)
+	}
+
 	// ============================================================
 	// 4️⃣ 安全地重建 Mempool！ (原始邏輯)
 	// ============================================================
 	n.Mempool.Clear()
-	for txid, bytes := range txsToRestore {
-		// 🚀 關鍵防護：直接塞回底層 Map，不觸發複雜驗證，完美避開死鎖！
-		n.Mempool.Txs[txid] = bytes
-	}
 
-	// ============================================================
-	// 5️⃣ 重建交易索引 (TxIndex) (原始邏輯)
-	// ============================================================
-	for _, old := range oldChain {
-		if old != nil && old.Block != nil {
-			n.removeTxIndex(old.Block)
+	// 🚀 關鍵防護：用 Restore 直接放回，不走 AddTxRBF 的費率與 RBF 檢查！
+	// 但還是要對新帳本重驗一次：花了舊鏈 Coinbase 或還沒成熟的獎勵都要丟掉
+	// (父子交易順序不定，所以一直掃到沒有新交易能放回為止)
+	spendCtx := n.MempoolSpendContext()
+	pending := txsToRestore
+	for progress := true; progress; {
+		progress = false
+		for txid, bytes := range pending {
+			tx, err := blockchain.DeserializeTransaction(bytes)
+			if err != nil {
+				delete(pending, txid)
+				continue
+			}
+			if VerifyTx(*tx, n.UTXO, n.Mempool.Txs, spendCtx) != nil {
+				continue
+			}
+			n.Mempool.Restore(txid, bytes, n.UTXO, enterTimes[txid], sources[txid])
+			delete(pending, txid)
+			progress = true
 		}
 	}
-	for _, bi := range newChain {
-		if bi != nil && bi.Block != nil {
-			n.indexTransactions(bi.Block, bi)
-		}
+	if len(pending) > 0 {
+		log.Printf("🗑️ 鏈重組後有 %d 筆交易已失效，不放回 Mempool\n", len(pending))
 	}
+	n.Mempool.Limit(time.Now())
 
-	log.Printf("🔁 鏈重組完成！高度: %d, 已將 %d 筆交易退回 Mempool。\n", newTip.Height, len(txsToRestore))
+	log.Printf("🔁 鏈重組完成！高度: %d, Mempool 目前 %d 筆交易。\n", newTip.Height, len(n.Mempool.Txs))
+	return nil
 }
 
 // --------------------
@@ -522,34 +542,130 @@ func (n *Node) Start() {
 
 	fmt.Println("🚀 Node starting...")
 
+	if err := n.migrateStorage(); err != nil {
+		log.Fatalf("❌ storage migration failed: %v", err)
+	}
+	// 🧳 舊版交易只准出現在鏈上真的有舊交易的高度以內 (遷移時找出來的)，以上一律拒收
+	if h := n.migratedLegacyTxHeight(); h > n.Params.LegacyTxHeight {
+		n.Params.LegacyTxHeight = h
+	}
+
+	// 🗂️ 上次沒跑完的重建會記在 meta/reindex，這次自動接著做
+	pending := string(n.DB.Get(database.BucketMeta, "reindex"))
+	if n.Reindex {
+		n.DB.Put(database.BucketMeta, "reindex", []byte(reindexFull))
+		pending = reindexFull
+	}
+	n.UTXO = n.newUTXOSet()
+	n.blockCache.resize(n.BlockCacheSize)
+
+	// 🌿 修剪過的資料庫補不回舊區塊，只能繼續當修剪節點
+	if !n.IsPruned() && n.PrunedHeight() > 0 {
+		fmt.Printf("⚠️ 資料庫已修剪到高度 %d，改用 pruned 模式運行\n", n.PrunedHeight())
+		n.Mode = ModePruned
+	}
+
 	// -----------------------------------------
 	// 1️⃣ 讀取 best（檢查 DB 是否存在區塊）
 	// -----------------------------------------
-	bestHashBytes := n.DB.Get("meta", "best")
-	if bestHashBytes == nil {
-		fmt.Println("📦 No existing blockchain found. Creating genesis...")
-		n.initGenesis()
-		return
+	bestHashBytes := n.DB.Get(database.BucketMeta, "best")
+	if pending != reindexFull {
+		if bestHashBytes == nil {
+			fmt.Println("📦 No existing blockchain found. Creating genesis...")
+			n.initGenesis()
+			return
+		}
+		if err := n.loadBlockIndex(string(bestHashBytes)); err != nil {
+			// 索引壞了但區塊還在：改從區塊資料重建
+			fmt.Printf("⚠️ 區塊索引無法使用: %v\n", err)
+			if !n.hasStoredBlocks() {
+				fmt.Println("🔄 找不到任何區塊資料，自動重置創世區塊...")
+				n.DB.Delete(database.BucketMeta, "best")
+				n.initGenesis()
+				return
+			}
+			n.DB.Put(database.BucketMeta, "reindex", []byte(reindexFull))
+			pending = reindexFull
+		}
+	}
+	if pending == reindexFull {
+		if err := n.reindexBlocks(); err != nil {
+			log.Fatalf("❌ reindex failed: %v", err)
+		}
+		n.ReindexChainstate = true
+		pending = ""
+	}
+
+	// -----------------------------------------
+	// 6️⃣ 重建鏈
+	// -----------------------------------------
+	n.UpdateChainFromBest()
+
+	fmt.Printf("🏗  Loaded %d blocks from DB. Best height = %d\n",
+		n.Chain.Len(), n.Best.Height)
+
+	// -----------------------------------------
+	// 7️⃣ 載入 UTXO，核對最後幾個區塊；對不上就從區塊重建
+	// -----------------------------------------
+	switch {
+	case pending == reindexChainstate:
+		if err := n.reindexChainstate(true); err != nil {
+			log.Fatalf("❌ reindex-chainstate failed: %v", err)
+		}
+	case n.ReindexChainstate:
+		if err := n.reindexChainstate(false); err != nil {
+			log.Fatalf("❌ reindex-chainstate failed: %v", err)
+		}
+	default:
+		if err := n.VerifyChainState(n.CheckBlocks); err != nil {
+			log.Printf("⚠️ [Verify] 帳本與區塊不一致 (%v)，從區塊重建帳本...\n", err)
+			if err := n.reindexChainstate(false); err != nil {
+				log.Fatalf("❌ reindex-chainstate failed: %v", err)
+			}
+		}
+	}
+	if err := n.resumeSnapshotValidation(); err != nil {
+		log.Fatalf("❌ %v", err)
+	}
+	if _, err := n.PruneBlocks(); err != nil {
+		log.Println("⚠️", err)
+	}
+
+	// ... (Mempool 初始代碼) ...
+	n.Mempool = mempool.NewMempool(n.MempoolMaxBytes, n.MempoolExpiry, n.DB)
+	n.Mempool.AncestorLimit = n.MempoolAncestorLimit
+	n.Mempool.DescendantLimit = n.MempoolDescendantLimit
+	n.loadMempool()
+	n.IsSyncing = true
+
+	// ... (狀態設定) ...
+	if n.Best == nil || n.Best.Height == 0 {
+		n.SyncState = SyncIBD
+		fmt.Println("🆕 Fresh node, starting IBD...")
+	} else {
+		n.SyncState = SyncHeaders
+		fmt.Printf("📥 Resuming sync from height %d...\n", n.Best.Height)
 	}
-	bestHash := string(bestHashBytes)
 
+	fmt.Println("✅ Node is ready and searching for peers...")
+}
+
+// loadBlockIndex 從 index / blocks bucket 載入所有區塊並接回父子關係
+// meta/best 指到不存在的區塊時，改用工作量最多、有本體的區塊當鏈頭
+func (n *Node) loadBlockIndex(bestHash string) error {
 	// -----------------------------------------
 	// 2️⃣ 從 index bucket 加載所有 BlockIndex
 	// -----------------------------------------
 	indexes := make(map[string]*BlockIndex)
 
-	n.DB.Iterate("index", func(k, v []byte) {
+	n.DB.Iterate(database.BucketIndex, func(k, v []byte) {
 		var bi BlockIndex
 		json.Unmarshal(v, &bi)
 		indexes[bi.Hash] = &bi
 	})
 
 	if len(indexes) == 0 {
-		fmt.Println("⚠️ 警告：資料庫 meta 有紀錄，但 index 是空的！")
-		fmt.Println("🔄 自動重置創世區塊...")
-		n.DB.Delete("meta", "best")
-		n.initGenesis()
-		return
+		return fmt.Errorf("meta/best is set but the index bucket is empty")
 	}
 
 	// 補回 big.Int
@@ -562,18 +678,7 @@ func (n *Node) Start() {
 		}
 	}
 
-	// -----------------------------------------
-	// 3️⃣ 加載 Block 本體
-	// -----------------------------------------
-	for _, bi := range indexes {
-		raw := n.DB.Get("blocks", bi.Hash)
-		if raw != nil {
-			blk, err := blockchain.DeserializeBlock(raw)
-			if err == nil {
-				bi.Block = blk
-			}
-		}
-	}
+	// 區塊 body 留在區塊檔裡，用到時才由 ReadBlock 讀進快取
 
 	// -----------------------------------------
 	// 4️⃣ 重建父子關係
@@ -592,67 +697,28 @@ func (n *Node) Start() {
 	// 5️⃣ 確定 best index (最關鍵的防崩潰點)
 	// -----------------------------------------
 	bestIndex := indexes[bestHash]
-
-	// 🔥🔥🔥 絕對防禦：如果這裡是 nil，直接重置，不准往下跑！ 🔥🔥🔥
 	if bestIndex == nil {
-		fmt.Printf("❌ [Fatal] 資料庫損壞：找不到 BestBlock (Hash: %s)\n", bestHash)
-		fmt.Println("🧹 正在清除錯誤的 meta 標籤，請重新啟動節點...")
-		n.DB.Delete("meta", "best")
-		return // 👈 強制結束，防止後面報錯
+		fmt.Printf("❌ 資料庫損壞：找不到 BestBlock (Hash: %s)，改用工作量最多的區塊\n", short(bestHash))
+		for _, bi := range indexes {
+			if bi.HasBody() && !bi.Failed && (bestIndex == nil || bi.CumWorkInt.Cmp(bestIndex.CumWorkInt) > 0) {
+				bestIndex = bi
+			}
+		}
+		if bestIndex == nil {
+			return fmt.Errorf("no block bodies in the index")
+		}
+		// 帳本是跟著舊鏈頭寫的，必須重建
+		n.DB.Put(database.BucketMeta, "best", []byte(bestIndex.Hash))
+		n.ReindexChainstate = true
 	}
 
 	n.Best = bestIndex
 	n.Blocks = indexes
-
-	// -----------------------------------------
-	// 6️⃣ 重建鏈
-	// -----------------------------------------
-	var chain []*blockchain.Block
-	cur := bestIndex
-
-	for cur != nil {
-		if cur.Block != nil {
-			chain = append([]*blockchain.Block{cur.Block}, chain...)
-		}
-		cur = cur.Parent
-	}
-
-	n.Chain = chain
-
-	// 這裡就是你原本報錯的 466 行，現在 bestIndex 絕對不可能是 nil 了
-	fmt.Printf("🏗  Loaded %d blocks from DB. Best height = %d\n",
-		len(chain), bestIndex.Height)
-
-	// ... (後面的 UTXO 和 Mempool 加載代碼保持不變) ...
-	// 請確認後面還有加載 UTXO 和 Mempool 的代碼，不要漏掉了
-
-	// -----------------------------------------
-	// 7️⃣ 重建 UTXO
-	// -----------------------------------------
-	n.UTXO = blockchain.NewUTXOSet(n.DB)
-	n.DB.Iterate("utxo", func(k, v []byte) {
-		var u blockchain.UTXO
-		json.Unmarshal(v, &u)
-		n.UTXO.Set[string(k)] = u
-	})
-	// ... (Mempool 初始代碼) ...
-	n.Mempool = mempool.NewMempool(1000, n.DB)
-	n.loadMempool()
-	n.IsSyncing = true
-
-	// ... (狀態設定) ...
-	if n.Best == nil || n.Best.Height == 0 {
-		n.SyncState = SyncIBD
-		fmt.Println("🆕 Fresh node, starting IBD...")
-	} else {
-		n.SyncState = SyncHeaders
-		fmt.Printf("📥 Resuming sync from height %d...\n", n.Best.Height)
-	}
-
-	fmt.Println("✅ Node is ready and searching for peers...")
+	return nil
 }
+
 func (n *Node) initGenesis() {
-	genesis := blockchain.NewGenesisBlock(n.Target)
+	genesis := n.Params.GenesisBlock()
 
 	// =========================================================
 	// 🔥 符合現實的寫法：以 Bits 為準 (Bits as Truth) 🔥
@@ -668,9 +734,7 @@ func (n *Node) initGenesis() {
 	// =========================================================
 
 	hashHex := hex.EncodeToString(genesis.Hash)
-	// 🔴 核心修改：确保 bi 结构体包含了 Block 本体
 	bi := &BlockIndex{
-		Block:      genesis, // 挂载本体
 		Hash:       hashHex,
 		Height:     0,
 		CumWork:    work.Text(16),
@@ -678,41 +742,51 @@ func (n *Node) initGenesis() {
 		Parent:     nil,
 		Children:   []*BlockIndex{}, // 养成初始化切片的好习惯
 
-		Bits:      genesis.Bits,
-		Timestamp: genesis.Timestamp,
+		Bits:       genesis.Bits,
+		Timestamp:  genesis.Timestamp,
+		Nonce:      genesis.Nonce,
+		MerkleRoot: hex.EncodeToString(genesis.MerkleRoot),
 	}
 
-	// --- 写入数据库 ---
-	n.DB.Put("blocks", hashHex, genesis.Serialize())
+	// --- 写入数据库 (區塊 body 先進區塊檔；索引、鏈頭、帳本一次寫入) ---
+	if err := n.storeBlock(bi, genesis); err != nil {
+		log.Fatal("❌ 創世區塊寫入失敗:", err)
+	}
+	batch := database.NewBatch()
 
 	idxBytes, _ := json.Marshal(bi)
-	n.DB.Put("index", hashHex, idxBytes)
+	batch.Put(database.BucketIndex, hashHex, idxBytes)
 
-	n.DB.Put("meta", "best", []byte(hashHex))
+	batch.Put(database.BucketMeta, "best", []byte(hashHex))
 
 	// ---------------------------------------------------------
 	// 🔴 关键修改点：只保留一个 Map 的写入
 	// ---------------------------------------------------------
 
-	// 写入唯一索引库 (BlockIndex 内部已经持有 genesis 指针)
+	// 写入唯一索引库
 	n.Blocks[hashHex] = bi
 
 	// ❌ 删掉这行：n.BlockIndex[hashHex] = genesis
 
 	n.Best = bi
 
-	// 主链视图 (如果你依然想保留 n.Chain 这个切片的话)
-	n.Chain = []*blockchain.Block{genesis}
+	// 主链视图
+	n.Chain.SetTip(bi)
 
 	// 更新 UTXO
-	n.UTXO.Add(genesis.Transactions[0])
+	n.UTXO.Add(genesis.Transactions[0], genesis.Height, 0) // 創世塊沒有父鏈，MTP 當 0
+	n.UTXO.Commit(batch)
+	if err := n.DB.Write(batch); err != nil {
+		log.Fatal("❌ 創世區塊寫入失敗:", err)
+	}
+	n.UTXO.Committed()
 
 	fmt.Println("🪐 Genesis block created.")
 	fmt.Printf("🔍 [Init] Genesis Bits: %d (預期: 504365055)\n", bi.Bits)
 	fmt.Println("GENESIS TARGET =", utils.FormatTargetHex(genesis.Target))
 }
 
-func (n *Node) GetChain() []*blockchain.Block {
+func (n *Node) GetChain() *ChainView {
 	return n.Chain
 }
 
@@ -728,8 +802,9 @@ func (n *Node) GetBestIndex() interface{} {
 	return n.Best
 }
 
-func (n *Node) GetReward() int {
-	return n.Reward
+// GetReward 這個高度的區塊獎勵 (礦工與驗證都從這裡拿)
+func (n *Node) GetReward(height uint64) int {
+	return n.Params.Subsidy.BlockSubsidy(height)
 }
 
 func (n *Node) GetMempool() *mempool.Mempool {
@@ -744,21 +819,22 @@ func (n *Node) AddBlockInterface(blk *blockchain.Block) error {
 }
 
 func (n *Node) GetBestBlock() *blockchain.Block {
-	// 🛡️ 确保 Best 不为空且包含 Block 实体数据
-	if n.Best == nil || n.Best.Block == nil {
+	// 🛡️ 确保 Best 不为空且已经收到 body；矿工只需要区块头
+	if n.Best == nil || !n.Best.HasBody() {
 		return nil
 	}
-	return n.Best.Block
+	return n.Best.Header()
 }
 
 func (n *Node) PrintChainStatus() {
 	fmt.Println("📌 Chain Status")
 	fmt.Println("Height:", n.Best.Height)
-	fmt.Println("Target:", n.Best.Block.Target.Text(16))
+	fmt.Println("Target:", utils.CompactToBig(n.Best.Bits).Text(16))
 	fmt.Println("CumWork:", n.Best.CumWorkInt.String())
 }
 
-// RebuildUTXO rebuilds the full UTXO set from the chain stored in n.Chain.
+// RebuildUTXO 照主鏈從頭重建帳本、undo 紀錄與交易索引
+// 帳本直接寫在硬碟上、分批落地 (跟 -reindex-chainstate 同一套)，不會把整本帳放進記憶體
 func (n *Node) RebuildUTXO() error {
 	n.mu.Lock() // 🚨 這裡要鎖住，確保重建時沒人亂動帳本
 	defer n.mu.Unlock()
@@ -767,35 +843,18 @@ func (n *Node) RebuildUTXO() error {
 	// 0️⃣ 核心防護：確保主鏈視圖是最新的
 	n.UpdateChainFromBest()
 
-	// 1️⃣ 創建一個「純記憶體」的臨時帳本 (不要傳入 DB)
-	// 這樣在遍歷區塊時，Spend 和 Add 只會改記憶體，不會頻繁寫硬碟
-	tmpUtxo := blockchain.NewUTXOSet(nil)
-
-	// 2️⃣ 遍歷主鏈
-	txCount := 0
-	for _, block := range n.Chain {
-		if block == nil {
-			continue
-		}
-		for _, tx := range block.Transactions {
-			if !tx.IsCoinbase {
-				tmpUtxo.Spend(tx)
-			}
-			tmpUtxo.Add(tx)
-			txCount++
-		}
+	// 📸 帳本來自快照時本地沒有完整歷史，區塊是一塊塊接上來的，不用也沒辦法重建
+	if n.snapshot != nil {
+		fmt.Println("📸 [Full Rebuild] 帳本來自快照，背景驗證完成前不重建")
+		return nil
 	}
 
-	// 3️⃣ 準備切換：將臨時帳本重新關聯到正式 DB
-	tmpUtxo.DB = n.DB
-
-	// 4️⃣ 執行「一次性」落地
-	// 使用你剛寫好的 FlushToDB，它會清空舊 bucket 並把最新結果一次鎖定
-	fmt.Printf("💾 重建完成 (共 %d 筆交易)，正在執行批次存檔...\n", txCount)
-	tmpUtxo.FlushToDB()
-
-	// 5️⃣ 替換正式指標
-	n.UTXO = tmpUtxo
+	if err := n.reindexChainstate(false); err != nil {
+		return fmt.Errorf("full rebuild: %w", err)
+	}
+	if _, err := n.PruneBlocks(); err != nil {
+		log.Println("⚠️", err)
+	}
 
 	fmt.Println("✅ [Full Rebuild] 重建成功，帳本已完全同步。")
 	return nil
@@ -803,8 +862,8 @@ func (n *Node) RebuildUTXO() error {
 
 func (n *Node) AllBodiesDownloaded() bool {
 	for _, bi := range n.Blocks {
-		// 只要有一個索引沒掛載 Block 實體，就沒下載完
-		if bi == nil || bi.Block == nil || len(bi.Block.Transactions) == 0 {
+		// 只要有一個索引還沒收到 body，就沒下載完
+		if bi == nil || !bi.HasBody() {
 			return false
 		}
 	}
@@ -817,7 +876,7 @@ func (n *Node) AddOrphan(blk *blockchain.Block) {
 }
 
 func (n *Node) GetTxIndex(txid string) (*blockchain.TxIndexEntry, error) {
-	data := n.DB.Get("txindex", txid)
+	data := n.DB.Get(database.BucketTxIndex, txid)
 	if data == nil {
 		return nil, fmt.Errorf("tx not found")
 	}
@@ -832,14 +891,16 @@ func (n *Node) GetTransaction(txid string) (*blockchain.Transaction, *blockchain
 	if err != nil {
 		return nil, nil, err
 	}
+	if idx.Pruned {
+		return nil, nil, ErrBlockPruned
+	}
 
-	// 读 block
-	blockBytes := n.DB.Get("blocks", idx.BlockHash)
-	if blockBytes == nil {
+	// 读 block (区块档 + 快取)
+	bi, ok := n.Blocks[idx.BlockHash]
+	if !ok {
 		return nil, nil, fmt.Errorf("block not found")
 	}
-
-	block, err := blockchain.DeserializeBlock(blockBytes)
+	block, err := n.ReadBlock(bi)
 	if err != nil {
 		return nil, nil, err
 	}
@@ -855,28 +916,14 @@ func (n *Node) GetTransaction(txid string) (*blockchain.Transaction, *blockchain
 }
 
 func (n *Node) loadMempool() {
-	count := 0
-
-	n.DB.Iterate("mempool", func(k, v []byte) {
-		txid := string(k)
-
-		// 放入内存 mempool
-		n.Mempool.Txs[txid] = v
-
-		// ⭐ 重建 parent 依赖信息（你的逻辑）
-		tx, err := blockchain.DeserializeTransaction(v)
-		if err == nil {
-			for _, in := range tx.Inputs {
-				parent := in.TxID
-				n.Mempool.Parents[txid] =
-					append(n.Mempool.Parents[txid], parent)
-			}
-		}
-
-		count++
-	})
-
+	// 放入内存 mempool，親子關係、進池時間與來源一起重建
+	count := n.Mempool.Load(n.UTXO)
 	log.Printf("💾 Loaded %d mempool transactions from DB\n", count)
+
+	// 關機期間過期的、或上限調小後放不下的，一開機就清掉
+	if expired, trimmed := n.Mempool.Limit(time.Now()); expired+trimmed > 0 {
+		log.Printf("🧹 Mempool: %d expired, %d trimmed\n", expired, trimmed)
+	}
 }
 
 func (n *Node) BroadcastNewBlock(b *blockchain.Block) {
@@ -899,31 +946,23 @@ func (n *Node) AddHeader(bi *BlockIndex) {
 	// 若高度更高，则更新 best
 	if n.Best == nil || bi.Height > n.Best.Height {
 		n.Best = bi
+		n.Chain.SetTip(bi)
 	}
 }
 
 func (n *Node) GetBlocksWithoutBody() []string {
 	list := []string{}
 	for hash, bi := range n.Blocks {
-		if bi.Block == nil { // header-only
+		if !bi.HasBody() { // header-only
 			list = append(list, hash)
 		}
 	}
 	return list
 }
 
+// UpdateChainFromBest 讓主鏈視圖跟上 n.Best (只改分岔點以上那一段)
 func (n *Node) UpdateChainFromBest() {
-	var newChain []*blockchain.Block
-	cur := n.Best
-
-	// 從 Best 往前找 Parent，直到 Genesis，構建新的主鏈視圖
-	for cur != nil {
-		if cur.Block != nil {
-			newChain = append([]*blockchain.Block{cur.Block}, newChain...)
-		}
-		cur = cur.Parent
-	}
-	n.Chain = newChain
+	n.Chain.SetTip(n.Best)
 	log.Printf("⛓️ Chain view updated. New Height: %d, Tip: %s", n.Best.Height, n.Best.Hash)
 }
 
@@ -936,63 +975,45 @@ func (n *Node) FindCommonAncestor(locator []string) *BlockIndex {
 	}
 
 	// 找不到，返回 genesis
-	genesisHash := hex.EncodeToString(n.Chain[0].Hash)
-	return n.Blocks[genesisHash]
+	return n.Chain.Genesis()
 }
 
 func (n *Node) IsSynced() bool {
 	return n.SyncState == SyncSynced
 }
 
-func (n *Node) updateUTXO(block *blockchain.Block) {
-	fmt.Printf("📂 [UTXO] 正在更新區塊 %d 的帳本狀態...\n", block.Height)
-
-	for _, tx := range block.Transactions {
-		// 1. 移除已花費的輸出 (Inputs)
-		if !tx.IsCoinbase {
-			err := n.UTXO.Spend(tx)
-			if err != nil {
-				// 🛡️ 偵探提醒：如果在更新主鏈時發現錢不夠花，這是嚴重的共識錯誤
-				fmt.Printf("❌ [Fatal] 區塊 %d 交易 %s 花費失敗: %v\n", block.Height, tx.ID[:8], err)
-				// 在實際生產環境，這裡可能需要觸發鏈回滾或停止同步
-			}
-		}
-
-		// 2. 添加新產生的輸出 (Outputs)
-		n.UTXO.Add(tx)
-	}
-
-	// ==========================================
-	// 🚀 關鍵新增：確保每一磚一瓦都刻在硬碟上
-	// ==========================================
-	// 這樣即使在下一個區塊進來前斷電，Kali 重啟後餘額依然是正確的
-	n.UTXO.FlushToDB()
-	// ==========================================
-}
-
 func (n *Node) addTxsToMempool(txs []blockchain.Transaction) {
 	for _, tx := range txs {
-		// Coinbase 交易無法復活 (因為它們只在特定高度有效，且憑空產生)
-		if !tx.IsCoinbase {
+		// Coinbase 交易無法復活 (因為它們只在特定高度有效，且憑空產生)；舊版交易也不回 Mempool
+		if !tx.IsCoinbase && tx.Version >= blockchain.TxVersion {
 			// 使用 AddTxRBF 嘗試加入，如果 Mempool 滿了或有衝突會自動處理
-			n.Mempool.AddTxRBF(tx.ID, tx.Serialize(), n.UTXO, n.NodeID)
+			n.Mempool.AddTxRBF(tx.ID, tx.Serialize(), n.UTXO, n.NodeID, n.MempoolSpendContext())
 		}
 	}
 }
 
+// IsOnMainChain 主鏈視圖上同一高度的區塊就是 bi (高度相同但 Hash 不同就是側鏈區塊)
 func (n *Node) IsOnMainChain(bi *BlockIndex) bool {
-	// 1. 高度超过主链长度，肯定不是
-	if bi.Height >= uint64(len(n.Chain)) {
-		return false
-	}
+	return n.Chain.Contains(bi)
+}
+
+// BlockByHeight 主鏈上高度 h 的區塊索引，超過鏈頭回傳 nil
+func (n *Node) BlockByHeight(h uint64) *BlockIndex {
+	return n.Chain.BlockByHeight(h)
+}
 
-	// 2. 取出主链该高度的区块
-	mainBlock := n.Chain[bi.Height]
-	mainHashHex := hex.EncodeToString(mainBlock.Hash)
+// BlockLocator 從鏈頭往回的區塊定位器 (getheaders 用)
+func (n *Node) BlockLocator() []string {
+	return n.Chain.Locator()
+}
 
-	// 3. 比较 Hash 是否一致
-	// 如果高度相同但 Hash 不同，说明 bi 是侧链区块
-	return mainHashHex == bi.Hash
+// activeTip 主鏈上最高、而且 body 已經到手的區塊 (Best 可能只是收到的標頭)
+func (n *Node) activeTip() *BlockIndex {
+	tip := n.Best
+	for tip != nil && !tip.HasBody() {
+		tip = tip.Parent
+	}
+	return tip
 }
 
 func (n *Node) GetResetChan() chan bool {
@@ -1008,7 +1029,7 @@ func (n *Node) HasMissingBodies() bool {
 	// 遍歷所有已知區塊索引
 	for _, bi := range n.Blocks {
 		// 如果該索引的高度比目前主鏈高，且還沒有下載區塊體
-		if bi.Height > n.Best.Height && bi.Block == nil {
+		if bi.Height > n.Best.Height && !bi.HasBody() {
 			return true
 		}
 	}
@@ -2,70 +2,157 @@ package node
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
+
+	"mycoin/blockchain"
+	"mycoin/database"
+)
+
+const (
+	ModeArchive = "archive" // 保留所有區塊
+	ModePruned  = "pruned"  // 只保留最近的主鏈區塊
 )
 
+// PruneDepth 修剪模式預設保留的最近主鏈區塊數 (body 與 undo)
 const PruneDepth = 2000
 
-// 删除高度 < beforeHeight 的区块 body
-func (n *Node) PruneBlocks(beforeHeight uint64) {
+// ErrBlockPruned 區塊 body 已經修剪，本地只剩區塊頭
+var ErrBlockPruned = errors.New("block pruned")
 
-	fmt.Printf("🧹 Pruning blocks < %d (safe prune)\n", beforeHeight)
+// MinPruneDepth 容量目標再緊也至少保留這麼多塊，重組時才有 body 與 undo 可以撤下
+const MinPruneDepth = 288
 
-	// 先收集待删除的 block hashes（不能边遍历边删）
-	toPrune := []string{}
+func (n *Node) IsPruned() bool {
+	return n.Mode == ModePruned
+}
 
-	n.DB.Iterate("index", func(k, v []byte) {
-		var bi BlockIndex
-		if err := json.Unmarshal(v, &bi); err != nil {
-			fmt.Println("⚠️ Corrupted BlockIndex entry:", err)
-			return
-		}
+// PrunedHeight 已經修剪到的主鏈高度 (0 代表還沒修剪過)
+func (n *Node) PrunedHeight() uint64 {
+	var h uint64
+	if v := n.DB.Get(database.BucketMeta, "pruneheight"); v != nil {
+		fmt.Sscanf(string(v), "%d", &h)
+	}
+	return h
+}
 
-		// 永远保留 genesis
-		if bi.Height == 0 {
-			return
+// pruneCutoff 高度低於回傳值的區塊可以修剪
+// 先保留最近 PruneDepth 塊；有容量目標 (PruneTarget) 時再從舊的砍，但至少留 MinPruneDepth 塊
+func (n *Node) pruneCutoff(chain []*BlockIndex) uint64 {
+	keep := n.PruneDepth
+	if keep < MinPruneDepth {
+		keep = MinPruneDepth
+	}
+	tip := n.Best.Height
+	if tip+1 <= keep {
+		return 0
+	}
+	cutoff := tip + 1 - keep
+
+	if n.PruneTarget > 0 {
+		var size uint64
+		for i := len(chain) - 1; i > 0; i-- {
+			bi := chain[i]
+			if !bi.HaveData || bi.Height < cutoff {
+				break
+			}
+			size += uint64(bi.Pos.Size) + uint64(len(n.DB.Get(database.BucketUndo, bi.Hash)))
+			if size > n.PruneTarget && tip-bi.Height >= MinPruneDepth {
+				cutoff = bi.Height + 1
+				break
+			}
 		}
+	}
+	return cutoff
+}
+
+// PruneBlocks 刪掉 cutoff 以下的區塊 body 與 undo (創世塊除外)，回傳這次修剪的區塊數
+// 索引與區塊頭都留著；主鏈交易的 txindex 標成 Pruned，一次寫入
+// 區塊檔只能整個刪：檔裡的區塊全部修剪掉之後才把檔案刪掉
+func (n *Node) PruneBlocks() (int, error) {
+	if !n.IsPruned() || n.Best == nil {
+		return 0, nil
+	}
+	// 📸 背景驗證還要重放快照以下的區塊，驗完再一起修剪
+	if n.snapshot != nil {
+		return 0, nil
+	}
 
-		// 永远保留 best 及其所有 ancestor（完整主链）
-		// 你可以用 Parent 链判断，简单写法是：
-		if n.Best != nil && bi.Height > n.Best.Height {
-			return
+	chain := n.Chain.Indexes()
+	cutoff := n.pruneCutoff(chain)
+	if cutoff <= 1 {
+		return 0, nil
+	}
+
+	batch := database.NewBatch()
+	var pruned []*BlockIndex
+	onMain := make(map[*BlockIndex]bool, len(chain))
+	for _, bi := range chain {
+		onMain[bi] = true
+	}
+
+	// 主鏈：交易索引還在，只是標記區塊已經不在本地
+	for _, bi := range chain[1:] {
+		if bi.Height >= cutoff {
+			break
 		}
-		if n.isAncestor(n.Best, bi.Hash) {
-			return
+		if !bi.HaveData {
+			continue
 		}
+		block, err := n.ReadBlock(bi)
+		if err != nil {
+			return 0, fmt.Errorf("prune: %w", err)
+		}
+		blockHash := bi.Hash
+		for i, tx := range block.Transactions {
+			data, _ := json.Marshal(blockchain.TxIndexEntry{
+				BlockHash: blockHash,
+				Height:    bi.Height,
+				TxOffset:  i,
+				Pruned:    true,
+			})
+			batch.Put(database.BucketTxIndex, tx.ID, data)
+		}
+		pruned = append(pruned, bi)
+	}
 
-		// height-based prune
-		if bi.Height < beforeHeight {
-			toPrune = append(toPrune, bi.Hash)
+	// 分岔：舊的側鏈區塊也不可能再用到
+	for _, bi := range n.Blocks {
+		if bi.Height > 0 && bi.Height < cutoff && bi.HaveData && !onMain[bi] {
+			pruned = append(pruned, bi)
 		}
-	})
-
-	// -----------------------------------------------------
-	// 第二阶段：统一删除 block bodies（不会破坏 iterator）
-	// -----------------------------------------------------
-	for _, hash := range toPrune {
-		n.DB.Delete("blocks", hash)
-		// ⭐ 不删除 index（关键）
-		// ⭐ 不删除 BlockIndex（关键）
-		// ⭐ 不删除 parent/children（关键）
-		fmt.Println("🗑️ pruned block body:", hash)
 	}
 
-	fmt.Printf("✅ Safe prune complete. Pruned %d block bodies.\n", len(toPrune))
-}
+	if len(pruned) == 0 {
+		return 0, nil
+	}
+
+	for _, bi := range pruned {
+		batch.Delete(database.BucketUndo, bi.Hash)
+		bi.Pruned = true
+		bi.HaveData = false
+		idxBytes, _ := json.Marshal(bi)
+		batch.Put(database.BucketIndex, bi.Hash, idxBytes)
+	}
+	batch.Put(database.BucketMeta, "pruneheight", []byte(fmt.Sprint(cutoff-1)))
 
-// -----------------------------------------------------
-// 辅助函数：判断 b 是否是 a 的 ancestor
-// -----------------------------------------------------
-func (n *Node) isAncestor(tip *BlockIndex, targetHash string) bool {
-	cur := tip
-	for cur != nil {
-		if cur.Hash == targetHash {
-			return true
+	if err := n.DB.Write(batch); err != nil {
+		for _, bi := range pruned {
+			bi.Pruned = false
+			bi.HaveData = true
 		}
-		cur = cur.Parent
+		return 0, fmt.Errorf("prune: %w", err)
 	}
-	return false
+
+	for _, bi := range pruned {
+		n.blockCache.remove(bi.Hash)
+	}
+	files, err := n.removeUnusedBlockFiles()
+	if err != nil {
+		log.Println("⚠️ [Prune] 區塊檔刪除失敗:", err)
+	}
+
+	fmt.Printf("🌿 [Prune] 修剪 %d 個區塊 (高度 < %d)，刪除 %d 個區塊檔\n", len(pruned), cutoff, files)
+	return len(pruned), nil
 }
@@ -0,0 +1,462 @@
+package node
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"math/big"
+
+	"mycoin/blockchain"
+	"mycoin/database"
+	"mycoin/utils"
+)
+
+// DefaultCheckBlocks 啟動時拿帳本回頭核對的區塊數
+const DefaultCheckBlocks = 6
+
+// reindexBatchBlocks 重建帳本時每幾塊寫一次硬碟 (同時記下進度，中斷後從這裡接著跑)
+const reindexBatchBlocks = 500
+
+// meta/reindex 記錄還沒跑完的重建：中途關機，下次啟動會自動接著做
+const (
+	reindexFull       = "full"
+	reindexChainstate = "chainstate"
+)
+
+// VerifyChainState 在帳本副本上撤下最後 depth 個區塊再重新接上 (含完整驗證)，
+// 結果必須跟現在的帳本一模一樣；任何一步對不上就代表帳本跟鏈不一致
+func (n *Node) VerifyChainState(depth int) error {
+	if n.Best == nil {
+		return fmt.Errorf("no best block")
+	}
+	if depth <= 0 {
+		return nil
+	}
+
+	chain := n.Chain.Indexes()
+	start := len(chain) - depth
+	if start < 1 {
+		start = 1 // 創世塊沒有 undo
+	}
+
+	tmp := n.UTXO.View()
+
+	// ⏪ 由 tip 往回撤：撤到這一塊時，它產生的輸出 (沒被同塊交易花掉的) 都必須還在帳本裡
+	checked := len(chain)
+	for i := len(chain) - 1; i >= start; i-- {
+		bi := chain[i]
+		if bi.AssumeValid {
+			break // 快照區塊以下沒有 undo，帳本就是從這裡開始的
+		}
+		block, err := n.ReadBlock(bi)
+		if err != nil {
+			return err
+		}
+		data := n.DB.Get(database.BucketUndo, bi.Hash)
+		if data == nil {
+			break // 升級前的區塊沒有 undo，核對到這裡為止
+		}
+		undo, err := blockchain.DeserializeBlockUndo(data)
+		if err != nil {
+			return fmt.Errorf("block %d undo: %w", bi.Height, err)
+		}
+
+		spentInBlock := make(map[string]bool)
+		for _, tx := range block.Transactions {
+			for _, in := range tx.Inputs {
+				spentInBlock[fmt.Sprintf("%s_%d", in.TxID, in.Index)] = true
+			}
+		}
+		for _, tx := range block.Transactions {
+			for j, out := range tx.Outputs {
+				key := fmt.Sprintf("%s_%d", tx.ID, j)
+				if spentInBlock[key] {
+					continue
+				}
+				utxo, ok := tmp.Lookup(key)
+				if !ok || utxo.Amount != out.Amount || utxo.Height != bi.Height {
+					return fmt.Errorf("block %d: output %s missing from UTXO set", bi.Height, key)
+				}
+			}
+		}
+
+		if err := tmp.DisconnectBlock(block, undo); err != nil {
+			return err
+		}
+		checked = i
+	}
+
+	// ⏩ 再一塊一塊接回去，每塊都跑完整的共識驗證
+	for _, bi := range chain[checked:] {
+		block, err := n.ReadBlock(bi)
+		if err != nil {
+			return err
+		}
+		if err := VerifyBlockWithUTXO(block, bi.Parent, tmp, n.Params, n.GetReward(bi.Height)); err != nil {
+			return fmt.Errorf("block %d: %w", bi.Height, err)
+		}
+		if _, err := tmp.ConnectBlock(block, medianTimePast(bi.Parent)); err != nil {
+			return fmt.Errorf("block %d: %w", bi.Height, err)
+		}
+	}
+
+	// 撤下再接回同一批區塊，覆蓋層應該跟帳本完全一樣
+	if diff := tmp.DiffBase(); len(diff) > 0 {
+		return fmt.Errorf("UTXO %s differs from chain replay (%d entries)", diff[0], len(diff))
+	}
+
+	fmt.Printf("🔍 [Verify] 最後 %d 個區塊與帳本一致 (高度 %d)\n", len(chain)-checked, n.Best.Height)
+	return nil
+}
+
+// reindexBlocks 只靠區塊檔重建區塊索引：從創世塊沿 PrevHash 接起來，工作量最多的當鏈頭
+// 索引一次寫入，同時標記接下來要重建帳本 (中途關機會從帳本那一步接著做)
+func (n *Node) reindexBlocks() error {
+	fmt.Println("🗂️ [Reindex] 從區塊資料重建索引...")
+	if h := n.PrunedHeight(); h > 0 {
+		return fmt.Errorf("blocks below height %d were pruned; resync from scratch instead", h+1)
+	}
+
+	genesis := n.Params.GenesisBlock()
+	genesisHash := hex.EncodeToString(genesis.Hash)
+
+	// 區塊檔裡同一塊可能寫過不只一次 (例如寫完索引前斷電)，留第一筆就好
+	type storedBlock struct {
+		header *blockchain.Block
+		pos    database.BlockPos
+	}
+	children := make(map[string][]storedBlock)
+	seen := make(map[string]bool)
+	var genesisPos *database.BlockPos
+	total := 0
+	err := n.BlockFiles.Scan(func(pos database.BlockPos, data []byte) {
+		blk, err := blockchain.DeserializeBlock(data)
+		if err != nil {
+			fmt.Printf("⚠️ [Reindex] blk%05d.dat@%d 無法解析: %v\n", pos.File, pos.Offset, err)
+			return
+		}
+		hashHex := hex.EncodeToString(blk.Hash)
+		if seen[hashHex] {
+			return
+		}
+		seen[hashHex] = true
+		if hashHex == genesisHash {
+			p := pos
+			genesisPos = &p
+			return
+		}
+		// 只留區塊頭，交易等重建帳本時再從檔案讀
+		blk.Transactions = nil
+		prev := hex.EncodeToString(blk.PrevHash)
+		children[prev] = append(children[prev], storedBlock{header: blk, pos: pos})
+		total++
+	})
+	if err != nil {
+		return fmt.Errorf("scan block files: %w", err)
+	}
+
+	if genesisPos == nil {
+		return fmt.Errorf("genesis block %s not found in block storage", short(genesisHash))
+	}
+
+	work := computeWork(utils.CompactToBig(genesis.Bits))
+	root := &BlockIndex{
+		Hash:       genesisHash,
+		Height:     0,
+		CumWork:    work.Text(16),
+		CumWorkInt: work,
+		Children:   []*BlockIndex{},
+		Bits:       genesis.Bits,
+		Timestamp:  genesis.Timestamp,
+		Nonce:      genesis.Nonce,
+		MerkleRoot: hex.EncodeToString(genesis.MerkleRoot),
+		HaveData:   true,
+		Pos:        *genesisPos,
+	}
+	indexes := map[string]*BlockIndex{genesisHash: root}
+	best := root
+
+	batch := database.NewBatch()
+	batch.ClearBucket(database.BucketIndex)
+
+	queue := []*BlockIndex{root}
+	for len(queue) > 0 {
+		parent := queue[0]
+		queue = queue[1:]
+
+		for _, stored := range children[parent.Hash] {
+			blk := stored.header
+			hashHex := hex.EncodeToString(blk.Hash)
+			if _, seen := indexes[hashHex]; seen {
+				continue
+			}
+			if blk.Height != parent.Height+1 {
+				fmt.Printf("⚠️ [Reindex] 區塊 %s 高度 %d 接不上父塊 %d，略過\n", short(hashHex), blk.Height, parent.Height)
+				continue
+			}
+			if err := blk.CheckProofOfWork(); err != nil {
+				fmt.Printf("⚠️ [Reindex] 區塊 %s 工作量無效: %v\n", short(hashHex), err)
+				continue
+			}
+
+			cumWork := new(big.Int).Add(parent.CumWorkInt, computeWork(utils.CompactToBig(blk.Bits)))
+			bi := &BlockIndex{
+				Hash:       hashHex,
+				PrevHash:   parent.Hash,
+				Height:     parent.Height + 1,
+				Timestamp:  blk.Timestamp,
+				Bits:       blk.Bits,
+				Nonce:      blk.Nonce,
+				MerkleRoot: hex.EncodeToString(blk.MerkleRoot),
+				CumWork:    cumWork.Text(16),
+				CumWorkInt: cumWork,
+				HaveData:   true,
+				Pos:        stored.pos,
+				Parent:     parent,
+				Children:   []*BlockIndex{},
+			}
+			parent.Children = append(parent.Children, bi)
+			indexes[hashHex] = bi
+			queue = append(queue, bi)
+
+			if bi.CumWorkInt.Cmp(best.CumWorkInt) > 0 {
+				best = bi
+			}
+			if len(indexes)%1000 == 0 {
+				fmt.Printf("⏳ [Reindex] 索引 %d/%d\n", len(indexes), total)
+			}
+		}
+	}
+
+	for hash, bi := range indexes {
+		idxBytes, _ := json.Marshal(bi)
+		batch.Put(database.BucketIndex, hash, idxBytes)
+	}
+	batch.Put(database.BucketMeta, "best", []byte(best.Hash))
+	batch.Put(database.BucketMeta, "reindex", []byte(reindexChainstate))
+	batch.Delete(database.BucketMeta, "reindexheight")
+	// 📸 快照代表的區塊在區塊檔裡找不到，重建後的鏈只到實際存下來的區塊為止
+	batch.Delete(database.BucketMeta, "snapshot")
+	if err := n.DB.Write(batch); err != nil {
+		return fmt.Errorf("write index: %w", err)
+	}
+	n.snapshot = nil
+
+	if skipped := total + 1 - len(indexes); skipped > 0 {
+		fmt.Printf("⚠️ [Reindex] %d 個區塊接不上主幹，沒有放進索引\n", skipped)
+	}
+	fmt.Printf("✅ [Reindex] 索引重建完成：%d 個區塊，鏈頭高度 %d\n", len(indexes), best.Height)
+
+	n.Blocks = indexes
+	n.Best = best
+	return nil
+}
+
+// reindexChainstate 從創世塊重新接一次主鏈，重建帳本、undo 與交易索引
+// 每 reindexBatchBlocks 塊連同進度 (meta/reindexheight) 一起寫入；resume 時從上次的進度接著做
+func (n *Node) reindexChainstate(resume bool) error {
+	chain := n.Chain.Indexes()
+	if len(chain) == 0 {
+		return fmt.Errorf("no main chain to reindex")
+	}
+	// 先確認區塊本體都在 (修剪過的節點無法重建)，再動硬碟上的帳本
+	for _, bi := range chain {
+		if !bi.HaveData {
+			return fmt.Errorf("block %d (%s) has no body; chainstate cannot be rebuilt", bi.Height, short(bi.Hash))
+		}
+	}
+
+	start := 0
+	n.UTXO = n.newUTXOSet()
+	if resume {
+		if h := n.DB.Get(database.BucketMeta, "reindexheight"); h != nil {
+			fmt.Sscanf(string(h), "%d", &start)
+			start++
+		}
+	}
+	if start == 0 {
+		batch := database.NewBatch()
+		batch.ClearBucket(database.BucketUTXO)
+		batch.ClearBucket(database.BucketUTXOAddr)
+		batch.ClearBucket(database.BucketTxIndex)
+		batch.ClearBucket(database.BucketUndo)
+		batch.Put(database.BucketMeta, "reindex", []byte(reindexChainstate))
+		batch.Delete(database.BucketMeta, "reindexheight")
+		if err := n.DB.Write(batch); err != nil {
+			return err
+		}
+		fmt.Println("🔄 [Reindex] 從創世塊重建帳本...")
+	} else {
+		fmt.Printf("🔄 [Reindex] 從高度 %d 接著重建帳本...\n", start)
+	}
+
+	tip := chain[len(chain)-1]
+	batch := database.NewBatch()
+	var last *BlockIndex
+	if start > 0 && start <= len(chain) {
+		last = chain[start-1]
+	}
+
+	flush := func() error {
+		batch.Put(database.BucketMeta, "reindexheight", []byte(fmt.Sprint(last.Height)))
+		n.UTXO.Commit(batch)
+		if err := n.DB.Write(batch); err != nil {
+			return err
+		}
+		n.UTXO.Committed()
+		batch = database.NewBatch()
+		fmt.Printf("⏳ [Reindex] 帳本 %d/%d\n", last.Height, tip.Height)
+		return nil
+	}
+
+	for i := start; i < len(chain); i++ {
+		bi := chain[i]
+		block, err := n.ReadBlock(bi)
+		if err == nil && bi.Parent != nil {
+			err = VerifyBlockWithUTXO(block, bi.Parent, n.UTXO, n.Params, n.GetReward(bi.Height))
+		}
+		if err == nil {
+			err = n.ConnectBlock(bi, n.UTXO, batch)
+		}
+		if err != nil {
+			// 🚫 存下來的區塊本身不合法：主鏈停在它的父塊
+			log.Printf("❌ [Reindex] 區塊 %d (%s) 驗證失敗，主鏈停在高度 %d: %v\n", bi.Height, short(bi.Hash), bi.Height-1, err)
+			break
+		}
+		last = bi
+		if (i+1)%reindexBatchBlocks == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+		}
+	}
+	if last == nil {
+		return fmt.Errorf("genesis block does not connect")
+	}
+
+	batch.Put(database.BucketMeta, "best", []byte(last.Hash))
+	batch.Delete(database.BucketMeta, "reindex")
+	batch.Delete(database.BucketMeta, "reindexheight")
+	n.UTXO.Commit(batch)
+	if err := n.DB.Write(batch); err != nil {
+		return err
+	}
+	n.UTXO.Committed()
+
+	n.Best = last
+	n.UpdateChainFromBest()
+	fmt.Printf("✅ [Reindex] 帳本重建完成：高度 %d，%d 筆 UTXO\n", last.Height, n.UTXO.Count())
+	return nil
+}
+
+// CatchUpUTXO 同步期間只存區塊不算帳，帳本還停在 meta/best；這裡從那一塊往主鏈鏈頭一塊塊接上。
+// 修剪節點、從快照啟動的節點都沒有完整歷史，不能從創世塊重放。
+// 中途有區塊驗證不過時，把它跟後面的分岔標成失敗，改接剩下工作量最多的有效鏈頭 (鏈頭永遠跟帳本一致)
+func (n *Node) CatchUpUTXO() error {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.catchUpUTXO()
+}
+
+func (n *Node) catchUpUTXO() error {
+	tip := n.Best
+	data := n.DB.Get(database.BucketMeta, "best")
+	ledger := n.Blocks[string(data)]
+	if ledger == nil {
+		return fmt.Errorf("chainstate tip %s is not in the block index", short(string(data)))
+	}
+	n.UpdateChainFromBest()
+
+	// 1️⃣ 同步期間換了分岔：帳本所在的塊不在新主鏈上，先用 undo 撤回分岔點
+	var oldChain []*BlockIndex
+	fork := ledger
+	for fork != nil && !n.Chain.Contains(fork) {
+		oldChain = append(oldChain, fork)
+		fork = fork.Parent
+	}
+	if fork == nil {
+		n.Best = ledger
+		n.UpdateChainFromBest()
+		return fmt.Errorf("chainstate tip %d does not connect to the new chain", ledger.Height)
+	}
+	batch := database.NewBatch()
+	if err := n.switchUTXO(oldChain, nil, batch); err != nil {
+		n.Best = ledger
+		n.UpdateChainFromBest()
+		return fmt.Errorf("roll back to fork %d: %w", fork.Height, err)
+	}
+	if len(oldChain) > 0 {
+		fmt.Printf("⏪ [Sync] 帳本從高度 %d 撤回分岔點 %d\n", ledger.Height, fork.Height)
+	}
+
+	// 2️⃣ 往前接：每 reindexBatchBlocks 塊連同 meta/best 寫一次，中斷後下次從這裡接著做
+	last := fork
+	flush := func() error {
+		batch.Put(database.BucketMeta, "best", []byte(last.Hash))
+		n.UTXO.Commit(batch)
+		if err := n.DB.Write(batch); err != nil {
+			return err
+		}
+		n.UTXO.Committed()
+		batch = database.NewBatch()
+		return nil
+	}
+
+	var connectErr error
+	invalid := false
+	for i, bi := range n.Chain.Slice(fork.Height+1, tip.Height) {
+		if !bi.HaveData {
+			connectErr = fmt.Errorf("block %d (%s) has no body", bi.Height, short(bi.Hash))
+			break
+		}
+		block, err := n.ReadBlock(bi)
+		if err != nil {
+			connectErr = fmt.Errorf("block %d (%s): %w", bi.Height, short(bi.Hash), err)
+			break
+		}
+		err = VerifyBlockWithUTXO(block, bi.Parent, n.UTXO, n.Params, n.GetReward(bi.Height))
+		if err == nil {
+			err = n.ConnectBlock(bi, n.UTXO, batch)
+		}
+		if err != nil {
+			// 🚫 區塊本身不合法：連同後面的分岔標成失敗 (跟 meta/best 一起寫)，之後不會再選它們
+			n.markFailed(bi, batch)
+			invalid = true
+			connectErr = fmt.Errorf("block %d (%s): %w", bi.Height, short(bi.Hash), err)
+			break
+		}
+		last = bi
+		if (i+1)%reindexBatchBlocks == 0 {
+			if err := flush(); err != nil {
+				return err
+			}
+			fmt.Printf("⏳ [Sync] 帳本 %d/%d\n", last.Height, tip.Height)
+		}
+	}
+	if err := flush(); err != nil {
+		return err
+	}
+
+	if last != n.Best {
+		n.Best = last
+		n.UpdateChainFromBest()
+	}
+	if invalid {
+		log.Printf("❌ [Sync] %v\n", connectErr)
+		// 換到剩下工作量最多的有效鏈頭再接一次 (每輪至少多標一個失敗區塊，不會一直繞)
+		if best := n.bestValidTip(); best != nil && best != last {
+			fmt.Printf("🔀 [Sync] 改接有效鏈頭 %d (%s)\n", best.Height, short(best.Hash))
+			n.Best = best
+			return n.catchUpUTXO()
+		}
+	} else if connectErr != nil {
+		return fmt.Errorf("chain stops at %d: %w", last.Height, connectErr)
+	}
+
+	if _, err := n.PruneBlocks(); err != nil {
+		log.Println("⚠️", err)
+	}
+	fmt.Printf("✅ [Sync] 帳本已接到高度 %d\n", last.Height)
+	return nil
+}
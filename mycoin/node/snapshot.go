@@ -0,0 +1,586 @@
+package node
+
+import (
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"mycoin/blockchain"
+	"mycoin/database"
+)
+
+var (
+	// ErrSnapshotLoaded 已經從快照啟動、背景驗證還沒做完，不能再載入另一個
+	ErrSnapshotLoaded = errors.New("a UTXO snapshot is already loaded")
+	// ErrSnapshotNotPinned 快照的高度沒有釘在 Params.AssumeUTXO 裡 (主網 / 測試網目前都沒有)
+	ErrSnapshotNotPinned = errors.New("no assumeutxo hash pinned for this snapshot height")
+	// ErrSnapshotName RPC 只能用 <datadir>/snapshots 底下的檔名，不能帶路徑
+	ErrSnapshotName = errors.New("snapshot name must be a plain file name")
+	// ErrSnapshotExists 快照檔已經存在，不覆蓋
+	ErrSnapshotExists = errors.New("snapshot file already exists")
+)
+
+// snapshotValidationDB 背景帳本的資料庫檔名 (在資料目錄下，跟主帳本分開)
+const snapshotValidationDB = "snapshot_validation.db"
+
+// snapshotState 存在 meta/snapshot：快照的摘要與背景驗證的結論 (驗證通過後整筆刪掉)
+type snapshotState struct {
+	blockchain.SnapshotMeta
+	Invalid bool `json:"invalid,omitempty"`
+}
+
+// snapshotValidation 從快照啟動後的背景驗證
+// 另開一本帳 (snapshotValidationDB)，從創世塊重放快照以下的主鏈區塊，重放到快照高度時內容雜湊必須跟快照一樣。
+// 進度 (meta/best) 跟帳目一起落地，重啟後接著做；重放在自己的 goroutine 裡跑，只有拿區塊、記進度時才拿 n.mu
+type snapshotValidation struct {
+	state  snapshotState
+	db     database.Store
+	utxo   *blockchain.UTXOSet // 只有背景 goroutine 會碰
+	height uint64              // 背景帳本已經接到的高度 (n.mu 保護，只有背景 goroutine 會改)
+
+	wake chan struct{} // 有新的歷史區塊可以接
+	quit chan struct{}
+	done chan struct{}
+}
+
+// SnapshotStatus 背景驗證的進度 (getsnapshotinfo 用)
+type SnapshotStatus struct {
+	blockchain.SnapshotMeta
+	ValidatedHeight uint64 `json:"validated_height"`
+	Invalid         bool   `json:"invalid"`
+}
+
+// SnapshotPath RPC 給的快照名稱 → <datadir>/snapshots/<name>
+// 只接受單純的檔名：RPC 呼叫端不能讀寫這個目錄以外的檔案
+func (n *Node) SnapshotPath(name string) (string, error) {
+	if n.DataDir == "" {
+		return "", fmt.Errorf("node has no data directory")
+	}
+	if name == "" || name == "." || name == ".." || filepath.Base(name) != name || strings.ContainsAny(name, `/\`) {
+		return "", ErrSnapshotName
+	}
+	dir := filepath.Join(n.DataDir, "snapshots")
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return "", err
+	}
+	return filepath.Join(dir, name), nil
+}
+
+// DumpUTXOSnapshot 把主鏈上 blockHash (空字串 = 目前鏈頭) 那一刻的帳本寫成快照檔
+// 不在鏈頭時用 undo 紀錄在覆蓋層上倒退回去，硬碟上的帳本不受影響；
+// 寫完才連結到 path，中斷不會留下半個檔，path 已經存在就不寫
+func (n *Node) DumpUTXOSnapshot(path, blockHash string) (*blockchain.SnapshotMeta, error) {
+	if _, err := os.Stat(path); err == nil {
+		return nil, fmt.Errorf("%w: %s", ErrSnapshotExists, path)
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	tip := n.activeTip()
+	if tip == nil {
+		return nil, fmt.Errorf("no active chain")
+	}
+	target := tip
+	if blockHash != "" {
+		bi, ok := n.Blocks[blockHash]
+		if !ok || !n.IsOnMainChain(bi) || bi.Height > tip.Height {
+			return nil, fmt.Errorf("block %s is not on the main chain", blockHash)
+		}
+		target = bi
+	}
+	if sv := n.snapshot; sv != nil && target.Height < sv.state.Height {
+		return nil, fmt.Errorf("chainstate below height %d comes from an unvalidated snapshot", sv.state.Height)
+	}
+
+	view := n.UTXO.View()
+	for h := tip.Height; h > target.Height; h-- {
+		bi := n.Chain.BlockByHeight(h)
+		block, err := n.ReadBlock(bi)
+		if err != nil {
+			return nil, fmt.Errorf("roll back block %d: %w", h, err)
+		}
+		data := n.DB.Get(database.BucketUndo, bi.Hash)
+		if data == nil {
+			return nil, fmt.Errorf("roll back block %d: no undo data", bi.Height)
+		}
+		undo, err := blockchain.DeserializeBlockUndo(data)
+		if err != nil {
+			return nil, fmt.Errorf("block %d undo: %w", bi.Height, err)
+		}
+		if err := view.DisconnectBlock(block, undo); err != nil {
+			return nil, err
+		}
+	}
+
+	headers := make([]*blockchain.Block, 0, target.Height+1)
+	for _, bi := range n.Chain.Slice(0, target.Height) {
+		headers = append(headers, bi.Header())
+	}
+
+	tmp := path + ".incomplete"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+	if err != nil {
+		return nil, err
+	}
+	meta, err := blockchain.WriteUTXOSnapshot(f, n.Params.Net, headers, view)
+	if err == nil {
+		err = f.Sync()
+	}
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		// Link 遇到已經存在的檔案會失敗 (Rename 會直接蓋掉)
+		if err = os.Link(tmp, path); errors.Is(err, os.ErrExist) {
+			err = fmt.Errorf("%w: %s", ErrSnapshotExists, path)
+		}
+	}
+	os.Remove(tmp)
+	if err != nil {
+		return nil, err
+	}
+
+	fmt.Printf("📸 [Snapshot] 高度 %d 的帳本已寫入 %s (%d 筆 UTXO，雜湊 %s)\n", meta.Height, path, meta.Count, short(meta.UTXOHash))
+	return meta, nil
+}
+
+// LoadUTXOSnapshot 從快照檔啟動：帳本直接換成快照，鏈頭跳到快照區塊，之後就能接新區塊、回應查詢；
+// 快照以下的區塊標成 AssumeValid，由背景驗證補下載並重放，確認快照屬實
+// 只接受 Params.AssumeUTXO 釘住的 (高度, 區塊 Hash, 內容雜湊)
+func (n *Node) LoadUTXOSnapshot(path string) (*blockchain.SnapshotMeta, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	// 帳本現在對應的鏈頭 (處理快照的標頭之後，Best 可能換成只有標頭的快照區塊)
+	n.mu.Lock()
+	tip := n.activeTip()
+	n.mu.Unlock()
+
+	// 1️⃣ 讀檔：區塊頭先收著，帳目先放進 batch；內容雜湊對上之前什麼都不寫
+	var headers []*blockchain.Block
+	batch := database.NewBatch()
+	batch.ClearBucket(database.BucketUTXO)
+	batch.ClearBucket(database.BucketUTXOAddr)
+	batch.ClearBucket(database.BucketMempool)
+	meta, err := blockchain.ReadUTXOSnapshot(f, n.Params.Net,
+		func(hdr *blockchain.Block) error {
+			headers = append(headers, hdr)
+			return nil
+		},
+		func(utxo blockchain.UTXO) error {
+			blockchain.PutUTXO(batch, utxo)
+			return nil
+		})
+	if err != nil {
+		return nil, fmt.Errorf("read snapshot: %w", err)
+	}
+	want, ok := n.Params.AssumeUTXO[meta.Height]
+	if !ok {
+		return nil, fmt.Errorf("%w (height %d)", ErrSnapshotNotPinned, meta.Height)
+	}
+	if meta.BlockHash != want.BlockHash {
+		return nil, fmt.Errorf("snapshot block at height %d is pinned to %s, file has %s", meta.Height, want.BlockHash, meta.BlockHash)
+	}
+	if meta.UTXOHash != want.UTXOHash {
+		return nil, fmt.Errorf("%w: height %d is pinned to %s, file has %s", blockchain.ErrSnapshotHash, meta.Height, want.UTXOHash, meta.UTXOHash)
+	}
+
+	// 2️⃣ 區塊頭：第一個必須是這個網路的創世塊，其餘照一般的標頭規則驗 (PoW、難度、時間戳)
+	if hex.EncodeToString(headers[0].Hash) != hex.EncodeToString(n.Params.GenesisBlock().Hash) {
+		return nil, fmt.Errorf("snapshot starts from a different genesis block")
+	}
+	for _, hdr := range headers[1:] {
+		if _, _, err := n.ProcessHeader(hdr); err != nil {
+			return nil, fmt.Errorf("snapshot header %d: %w", hdr.Height, err)
+		}
+	}
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+
+	if n.snapshot != nil {
+		return nil, ErrSnapshotLoaded
+	}
+	base := n.Blocks[meta.BlockHash]
+	if base == nil {
+		return nil, fmt.Errorf("snapshot block %s is not in the block index", short(meta.BlockHash))
+	}
+	if n.activeTip() != tip {
+		return nil, fmt.Errorf("chain tip moved while loading the snapshot")
+	}
+	if tip.Height >= base.Height {
+		return nil, fmt.Errorf("chain tip %d is already at or past snapshot height %d", tip.Height, base.Height)
+	}
+	anc := base
+	for anc != nil && anc.Height > tip.Height {
+		anc = anc.Parent
+	}
+	if anc != tip {
+		return nil, fmt.Errorf("snapshot block %s does not build on the active chain", short(base.Hash))
+	}
+
+	// 3️⃣ 現在的鏈頭到快照之間的區塊都由快照代表：標成 AssumeValid，
+	//    索引 (原本只在記憶體裡的標頭也一起落地)、鏈頭、快照狀態跟帳本一次寫入
+	var assumed []*BlockIndex
+	for bi := base; bi != tip; bi = bi.Parent {
+		bi.AssumeValid = true
+		assumed = append(assumed, bi)
+		idxBytes, _ := json.Marshal(bi)
+		batch.Put(database.BucketIndex, bi.Hash, idxBytes)
+	}
+	state := snapshotState{SnapshotMeta: *meta}
+	stateBytes, _ := json.Marshal(state)
+	batch.Put(database.BucketMeta, "snapshot", stateBytes)
+	batch.Put(database.BucketMeta, "best", []byte(base.Hash))
+	if err := n.DB.Write(batch); err != nil {
+		for _, bi := range assumed {
+			bi.AssumeValid = false
+		}
+		return nil, fmt.Errorf("write snapshot: %w", err)
+	}
+
+	n.UTXO = n.newUTXOSet()
+	n.Mempool.Clear()
+	n.Best = base
+	n.UpdateChainFromBest()
+
+	fmt.Printf("📸 [Snapshot] 已從快照載入 %d 筆 UTXO，鏈頭跳到高度 %d；快照以下的區塊在背景驗證\n", meta.Count, base.Height)
+	if err := n.startSnapshotValidation(state, true); err != nil {
+		log.Println("⚠️ [Snapshot] 背景驗證無法啟動，重啟後會再試:", err)
+	}
+	return meta, nil
+}
+
+// resumeSnapshotValidation 啟動時如果帳本來自還沒驗完的快照，重新開始背景驗證
+func (n *Node) resumeSnapshotValidation() error {
+	data := n.DB.Get(database.BucketMeta, "snapshot")
+	if data == nil {
+		n.removeSnapshotValidationDB() // 上次驗完、或 -reindex 之後留下的背景帳本
+		return nil
+	}
+	var state snapshotState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("meta/snapshot: %w", err)
+	}
+	if state.Invalid {
+		return fmt.Errorf("UTXO snapshot at height %d failed background validation; restart with -reindex", state.Height)
+	}
+	return n.startSnapshotValidation(state, false)
+}
+
+// startSnapshotValidation 打開背景帳本並啟動背景 goroutine
+// fresh = 剛載入的快照，背景帳本從創世塊開始；否則接著 meta/best 記下的進度
+func (n *Node) startSnapshotValidation(state snapshotState, fresh bool) error {
+	db, err := n.openSnapshotValidationDB()
+	if err != nil {
+		return fmt.Errorf("open background chainstate: %w", err)
+	}
+	sv := &snapshotValidation{
+		state: state,
+		db:    db,
+		utxo:  blockchain.NewUTXOSet(db),
+		wake:  make(chan struct{}, 1),
+		quit:  make(chan struct{}),
+		done:  make(chan struct{}),
+	}
+	sv.utxo.SetCacheSize(n.UTXOCacheSize)
+
+	bi := n.Blocks[string(db.Get(database.BucketMeta, "best"))]
+	if !fresh && bi != nil && n.IsOnMainChain(bi) && bi.Height <= state.Height {
+		sv.height = bi.Height
+		if sv.height > 0 {
+			fmt.Printf("📸 [Snapshot] 背景驗證從高度 %d 接著做\n", sv.height)
+		}
+	} else {
+		genesis := n.Params.GenesisBlock()
+		batch := database.NewBatch()
+		batch.ClearBucket(database.BucketUTXO)
+		batch.ClearBucket(database.BucketUTXOAddr)
+		sv.utxo.Add(genesis.Transactions[0], genesis.Height, 0) // 創世塊沒有父鏈，MTP 當 0
+		sv.utxo.Commit(batch)
+		batch.Put(database.BucketMeta, "best", []byte(hex.EncodeToString(genesis.Hash)))
+		if err := db.Write(batch); err != nil {
+			db.Close()
+			return fmt.Errorf("reset background chainstate: %w", err)
+		}
+		sv.utxo.Committed()
+	}
+
+	n.snapshot = sv
+	go n.runSnapshotValidation(sv)
+	sv.notify()
+	return nil
+}
+
+// openSnapshotValidationDB 背景帳本放在資料目錄下自己的資料庫；沒有資料目錄的節點放記憶體
+func (n *Node) openSnapshotValidationDB() (database.Store, error) {
+	if n.DataDir == "" {
+		return database.NewMemDB(), nil
+	}
+	db, err := database.OpenDB(filepath.Join(n.DataDir, snapshotValidationDB))
+	if err != nil {
+		return nil, err
+	}
+	return db, nil
+}
+
+func (n *Node) removeSnapshotValidationDB() {
+	if n.DataDir != "" {
+		os.Remove(filepath.Join(n.DataDir, snapshotValidationDB))
+	}
+}
+
+// notify 叫醒背景 goroutine (已經有一個沒處理的通知就不用再塞)
+func (sv *snapshotValidation) notify() {
+	select {
+	case sv.wake <- struct{}{}:
+	default:
+	}
+}
+
+// stopSnapshotValidation 停下背景驗證並關掉背景帳本 (進度已經落地，下次啟動接著做)
+func (n *Node) stopSnapshotValidation() {
+	n.mu.Lock()
+	sv := n.snapshot
+	n.mu.Unlock()
+	if sv == nil {
+		return
+	}
+	select {
+	case <-sv.done:
+	default:
+		close(sv.quit)
+		<-sv.done
+	}
+}
+
+// runSnapshotValidation 背景驗證的 goroutine：每次有新的歷史區塊就往前接，驗完 (或確定不可信) 就結束
+func (n *Node) runSnapshotValidation(sv *snapshotValidation) {
+	defer close(sv.done)
+	for {
+		select {
+		case <-sv.quit:
+			sv.db.Close()
+			return
+		case <-sv.wake:
+		}
+		if n.advanceSnapshotValidation(sv) {
+			sv.db.Close()
+			n.removeSnapshotValidationDB()
+			return
+		}
+	}
+}
+
+// SnapshotActive 帳本來自還沒驗完的快照 (本地沒有完整歷史)
+func (n *Node) SnapshotActive() bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.snapshot != nil
+}
+
+// SnapshotStatus 背景驗證進度；沒有載入快照時回傳 nil
+func (n *Node) SnapshotStatus() *SnapshotStatus {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	sv := n.snapshot
+	if sv == nil {
+		return nil
+	}
+	return &SnapshotStatus{SnapshotMeta: sv.state.SnapshotMeta, ValidatedHeight: sv.height, Invalid: sv.state.Invalid}
+}
+
+// SnapshotBlocksToDownload 背景驗證接下來需要、本地還沒有的歷史區塊 (由舊到新，最多 max 個)
+func (n *Node) SnapshotBlocksToDownload(max int) []string {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	sv := n.snapshot
+	if sv == nil || sv.state.Invalid {
+		return nil
+	}
+	var hashes []string
+	for h := sv.height + 1; h <= sv.state.Height && len(hashes) < max; h++ {
+		bi := n.Chain.BlockByHeight(h)
+		if bi == nil {
+			break
+		}
+		if !bi.HaveData {
+			hashes = append(hashes, bi.Hash)
+		}
+	}
+	return hashes
+}
+
+// WantsSnapshotBlock hash 是不是背景驗證還缺的歷史區塊
+func (n *Node) WantsSnapshotBlock(hash string) bool {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	bi, ok := n.Blocks[hash]
+	return ok && n.isSnapshotHistory(bi)
+}
+
+func (n *Node) isSnapshotHistory(bi *BlockIndex) bool {
+	sv := n.snapshot
+	return sv != nil && !bi.HaveData && bi.Height <= sv.state.Height && n.IsOnMainChain(bi)
+}
+
+// storeSnapshotBlock 收下快照以下的歷史區塊：交易要跟索引裡標頭的 merkle root 對得上才存，存完推進背景驗證
+func (n *Node) storeSnapshotBlock(bi *BlockIndex, block *blockchain.Block) error {
+	if merkle := hex.EncodeToString(blockchain.ComputeMerkleRoot(block.Transactions)); merkle != bi.MerkleRoot {
+		return fmt.Errorf("block %d: merkle root %s does not match header", bi.Height, short(merkle))
+	}
+	if err := block.CheckSize(); err != nil {
+		return fmt.Errorf("block %d: %w", bi.Height, err)
+	}
+	if err := n.storeBlock(bi, block); err != nil {
+		return err
+	}
+	idxBytes, _ := json.Marshal(bi)
+	if err := n.DB.Put(database.BucketIndex, bi.Hash, idxBytes); err != nil {
+		return err
+	}
+	n.snapshot.notify()
+	return nil
+}
+
+// advanceSnapshotValidation 把已經下載、接得上的歷史區塊重放進背景帳本 (完整共識驗證)，
+// 順便補上 undo 與交易索引；接到快照高度時比對內容雜湊。驗證有了結論 (成功或失敗) 時回傳 true
+// 重放時不拿 n.mu：背景帳本只有這個 goroutine 在用，快照以下的主鏈區塊也不會再變
+func (n *Node) advanceSnapshotValidation(sv *snapshotValidation) bool {
+	batch := database.NewBatch() // 主資料庫：undo 與交易索引
+	var last *BlockIndex
+	pending := 0
+	start := sv.height
+
+	// 先寫 undo 與交易索引再記進度：中途斷電頂多把同樣的內容重寫一次
+	flush := func() bool {
+		if pending == 0 {
+			return true
+		}
+		state := database.NewBatch()
+		sv.utxo.Commit(state)
+		state.Put(database.BucketMeta, "best", []byte(last.Hash))
+		err := n.DB.Write(batch)
+		if err == nil {
+			err = sv.db.Write(state)
+		}
+		if err != nil {
+			log.Println("⚠️ [Snapshot] 背景驗證進度寫入失敗:", err)
+			return false
+		}
+		sv.utxo.Committed()
+		batch = database.NewBatch()
+		pending = 0
+		return true
+	}
+
+	for {
+		select {
+		case <-sv.quit:
+			flush()
+			return false
+		default:
+		}
+
+		bi, block, err := n.nextSnapshotBlock(sv)
+		if bi == nil {
+			break
+		}
+		if err == nil {
+			err = VerifyBlockWithUTXO(block, bi.Parent, sv.utxo, n.Params, n.GetReward(bi.Height))
+		}
+		var undo *blockchain.BlockUndo
+		if err == nil {
+			undo, err = sv.utxo.ConnectBlock(block, medianTimePast(bi.Parent))
+		}
+		if err != nil {
+			n.mu.Lock()
+			n.snapshotInvalid(sv, fmt.Errorf("block %d (%s): %w", bi.Height, short(bi.Hash), err))
+			n.mu.Unlock()
+			return true
+		}
+		batch.Put(database.BucketUndo, bi.Hash, undo.Serialize())
+		n.indexTransactions(batch, block)
+
+		n.mu.Lock()
+		sv.height = bi.Height
+		n.mu.Unlock()
+		last = bi
+		if pending++; pending >= reindexBatchBlocks && !flush() {
+			return false
+		}
+	}
+	if !flush() {
+		return false
+	}
+	if sv.height > start && sv.height < sv.state.Height {
+		fmt.Printf("⏳ [Snapshot] 背景驗證 %d/%d\n", sv.height, sv.state.Height)
+	}
+	if sv.height < sv.state.Height {
+		return false
+	}
+
+	hash, count := blockchain.UTXOSetHash(sv.utxo)
+
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if hash != sv.state.UTXOHash || count != sv.state.Count {
+		n.snapshotInvalid(sv, fmt.Errorf("replayed UTXO set at height %d has hash %s (%d entries), snapshot claims %s (%d)",
+			sv.height, short(hash), count, short(sv.state.UTXOHash), sv.state.Count))
+		return true
+	}
+
+	// ✅ 快照屬實：拿掉 AssumeValid，之後跟從頭同步的節點沒有兩樣
+	done := database.NewBatch()
+	for _, bi := range n.Chain.Slice(1, sv.state.Height) {
+		if bi.AssumeValid {
+			bi.AssumeValid = false
+			idxBytes, _ := json.Marshal(bi)
+			done.Put(database.BucketIndex, bi.Hash, idxBytes)
+		}
+	}
+	done.Delete(database.BucketMeta, "snapshot")
+	if err := n.DB.Write(done); err != nil {
+		log.Println("⚠️ [Snapshot] 驗證結果寫入失敗:", err)
+		return false
+	}
+	n.snapshot = nil
+	fmt.Printf("✅ [Snapshot] 背景驗證完成：高度 %d 的帳本與快照一致 (%d 筆 UTXO)\n", sv.state.Height, count)
+
+	if _, err := n.PruneBlocks(); err != nil {
+		log.Println("⚠️", err)
+	}
+	return true
+}
+
+// nextSnapshotBlock 背景帳本要接的下一個區塊；還沒下載 (或已經接到快照高度) 時 bi 是 nil
+func (n *Node) nextSnapshotBlock(sv *snapshotValidation) (*BlockIndex, *blockchain.Block, error) {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	if sv.height >= sv.state.Height {
+		return nil, nil, nil
+	}
+	bi := n.Chain.BlockByHeight(sv.height + 1)
+	if bi == nil || !bi.HaveData {
+		return nil, nil, nil
+	}
+	block, err := n.ReadBlock(bi)
+	return bi, block, err
+}
+
+// snapshotInvalid 重放結果跟快照對不上：快照不可信，記下來 (重啟時拒絕運行，要用 -reindex 重建)
+func (n *Node) snapshotInvalid(sv *snapshotValidation, err error) {
+	sv.state.Invalid = true
+	stateBytes, _ := json.Marshal(sv.state)
+	n.DB.Put(database.BucketMeta, "snapshot", stateBytes)
+	log.Printf("❌ [Snapshot] 背景驗證失敗，高度 %d 的快照帳本不可信: %v\n", sv.state.Height, err)
+}
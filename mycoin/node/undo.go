@@ -0,0 +1,132 @@
+package node
+
+import (
+	"errors"
+	"fmt"
+	"mycoin/blockchain"
+	"mycoin/database"
+)
+
+// ErrUTXOInconsistent 重組失敗後連回滾都失敗，記憶體裡的帳本已經半套；資料庫還停在重組前，只能重啟
+var ErrUTXOInconsistent = errors.New("UTXO set left inconsistent by a failed reorg rollback")
+
+// ConnectBlock 把 bi 的區塊套用到 utxo 上 (n.UTXO 或它的覆蓋層)
+// undo 紀錄與交易索引放進 batch，讓呼叫端跟區塊一起在同一個交易裡寫入
+func (n *Node) ConnectBlock(bi *BlockIndex, utxo *blockchain.UTXOSet, batch *database.Batch) error {
+	block, err := n.ReadBlock(bi)
+	if err != nil {
+		return err
+	}
+	undo, err := utxo.ConnectBlock(block, medianTimePast(bi.Parent))
+	if err != nil {
+		return err
+	}
+	batch.Put(database.BucketUndo, bi.Hash, undo.Serialize())
+	n.indexTransactions(batch, block)
+	return nil
+}
+
+func (n *Node) connectUTXO(bi *BlockIndex) (*blockchain.Block, *blockchain.BlockUndo, error) {
+	block, err := n.ReadBlock(bi)
+	if err != nil {
+		return nil, nil, err
+	}
+	undo, err := n.UTXO.ConnectBlock(block, medianTimePast(bi.Parent))
+	return block, undo, err
+}
+
+// DisconnectBlock 用 undo 紀錄把 bi 從帳本撤下，成本只跟區塊大小有關
+func (n *Node) DisconnectBlock(bi *BlockIndex, batch *database.Batch) error {
+	block, err := n.ReadBlock(bi)
+	if err != nil {
+		return err
+	}
+
+	data := n.DB.Get(database.BucketUndo, bi.Hash)
+	if data == nil {
+		// 🧳 升級前接上的區塊沒有 undo：退回舊做法，從 txindex 找回被花掉的輸出
+		fmt.Printf("⚠️ [Undo] 區塊 %d 沒有 undo 紀錄，改用 txindex 回滾\n", bi.Height)
+		for i := len(block.Transactions) - 1; i >= 0; i-- {
+			n.UTXO.Revert(block.Transactions[i], n.GetTransaction)
+		}
+	} else {
+		undo, err := blockchain.DeserializeBlockUndo(data)
+		if err != nil {
+			return fmt.Errorf("block %d undo: %w", bi.Height, err)
+		}
+		if err := n.UTXO.DisconnectBlock(block, undo); err != nil {
+			return err
+		}
+	}
+	n.removeTxIndex(batch, block)
+	return nil
+}
+
+// switchUTXO 撤下 oldChain (tip 在前)、接上 newChain (由舊到新)
+// 新鏈有任何區塊接不上時，把帳本恢復成原本的鏈並回傳錯誤；batch 只會多出驗證不過、標成失敗的區塊索引
+func (n *Node) switchUTXO(oldChain, newChain []*BlockIndex, batch *database.Batch) error {
+	staged := database.NewBatch()
+	for i, bi := range oldChain {
+		if err := n.DisconnectBlock(bi, staged); err != nil {
+			// 已撤下的再接回去 (由舊到新)
+			var rollback []error
+			for j := i - 1; j >= 0; j-- {
+				if _, _, rerr := n.connectUTXO(oldChain[j]); rerr != nil {
+					rollback = append(rollback, fmt.Errorf("reconnect %d: %w", oldChain[j].Height, rerr))
+				}
+			}
+			return rollbackError(fmt.Errorf("disconnect %d: %w", bi.Height, err), rollback)
+		}
+	}
+
+	blocks := make([]*blockchain.Block, 0, len(newChain))
+	undos := make([]*blockchain.BlockUndo, 0, len(newChain))
+	for i, bi := range newChain {
+		// 帳本已經退到分岔點，分岔上的區塊這時才能完整驗證
+		block, err := n.ReadBlock(bi)
+		if err == nil && (bi.Parent == nil || !bi.Parent.HasBody()) {
+			err = fmt.Errorf("block %d (%s) has no parent", bi.Height, short(bi.Hash))
+		}
+		var undo *blockchain.BlockUndo
+		invalid := false
+		if err == nil {
+			if err = VerifyBlockWithUTXO(block, bi.Parent, n.UTXO, n.Params, n.GetReward(bi.Height)); err == nil {
+				undo, err = n.UTXO.ConnectBlock(block, medianTimePast(bi.Parent))
+			}
+			invalid = err != nil
+		}
+		if err != nil {
+			// 新鏈接不上：撤下已接上的新塊，再把舊鏈接回去
+			var rollback []error
+			for j := i - 1; j >= 0; j-- {
+				if rerr := n.UTXO.DisconnectBlock(blocks[j], undos[j]); rerr != nil {
+					rollback = append(rollback, fmt.Errorf("disconnect %d: %w", newChain[j].Height, rerr))
+				}
+			}
+			for j := len(oldChain) - 1; j >= 0; j-- {
+				if _, _, rerr := n.connectUTXO(oldChain[j]); rerr != nil {
+					rollback = append(rollback, fmt.Errorf("reconnect %d: %w", oldChain[j].Height, rerr))
+				}
+			}
+			if invalid {
+				n.markFailed(bi, batch)
+			}
+			return rollbackError(fmt.Errorf("connect %d: %w", bi.Height, err), rollback)
+		}
+		blocks = append(blocks, block)
+		undos = append(undos, undo)
+		staged.Put(database.BucketUndo, bi.Hash, undo.Serialize())
+		n.indexTransactions(staged, block)
+	}
+
+	batch.Append(staged)
+	return nil
+}
+
+// rollbackError 回滾都成功就只回報原本的錯誤；回滾也失敗就標成 ErrUTXOInconsistent，讓呼叫端停機
+func rollbackError(cause error, rollback []error) error {
+	if len(rollback) == 0 {
+		return cause
+	}
+	return fmt.Errorf("%w: %v (rollback: %v)", ErrUTXOInconsistent, cause, errors.Join(rollback...))
+}
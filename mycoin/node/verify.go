@@ -1,7 +1,6 @@
 package node
 
 import (
-	"encoding/hex"
 	"errors"
 	"fmt"
 	"mycoin/blockchain"
@@ -10,26 +9,73 @@ import (
 // VerifyBlockWithUTXO 驗證整個區塊的合法性
 func VerifyBlockWithUTXO(
 	block *blockchain.Block,
-	parent *blockchain.Block,
+	parent *BlockIndex,
 	utxo *blockchain.UTXOSet,
+	params *blockchain.Params,
+	subsidy int,
 ) error {
 	// 1️⃣ 基礎驗證 (PoW, PrevHash)
-	if err := block.Verify(parent); err != nil {
+	if err := block.Verify(parent.Header()); err != nil {
 		return err
 	}
 
-	tmp := utxo.Clone()
+	// 2️⃣ 第一筆必須是承諾了正確高度的 Coinbase，後面不准再出現 Coinbase
+	if len(block.Transactions) == 0 {
+		return errors.New("區塊沒有任何交易 (缺少 Coinbase)")
+	}
+	if err := block.Transactions[0].CheckCoinbase(block.Height); err != nil {
+		return fmt.Errorf("coinbase 不合法: %v", err)
+	}
+	for _, tx := range block.Transactions[1:] {
+		if tx.IsCoinbase {
+			return fmt.Errorf("交易 %s 是多出來的 Coinbase", short(tx.ID))
+		}
+	}
+
+	// 🧳 過了 LegacyTxHeight 之後，區塊裡 (含 Coinbase) 不准再有舊版交易
+	for _, tx := range block.Transactions {
+		if err := tx.CheckVersion(block.Height, params); err != nil {
+			return fmt.Errorf("交易 %s: %v", short(tx.ID), err)
+		}
+	}
+
+	tmp := utxo.View()
 	var totalFees int = 0
+	sigOps := 0
+
+	// ⏳ 時間鎖與 Coinbase 成熟度以「這個區塊」的高度為準；時間用父鏈的 MTP，
+	// 不用區塊自己的時間戳 (礦工可以把時間戳往後填，提早解鎖交易)
+	spendCtx := blockchain.SpendContext{
+		Height:           block.Height,
+		Time:             medianTimePast(parent),
+		CoinbaseMaturity: params.CoinbaseMaturity,
+	}
 
 	// 4️⃣ 執行交易 (跳過 Coinbase)
 	for i, tx := range block.Transactions {
+		// ⏳ 每一筆 (含 Coinbase) 都必須已經 final 才能上鏈
+		if !tx.IsFinal(spendCtx) {
+			return fmt.Errorf("交易 %s 尚未 final (locktime %d)", short(tx.ID), tx.LockTime)
+		}
+
 		if i == 0 {
 			continue
 		}
 
 		// 🚀 區塊內驗證不需要 Mempool，因為依賴項必須在區塊內的前面幾筆或已入帳
-		if err := VerifyTx(tx, tmp, nil); err != nil {
-			return fmt.Errorf("交易 %s 驗證失敗: %v", tx.ID[:8], err)
+		if err := VerifyTx(tx, tmp, nil, spendCtx); err != nil {
+			return fmt.Errorf("交易 %s 驗證失敗: %v", short(tx.ID), err)
+		}
+
+		// ✍️ 整個區塊要驗的簽名數有上限 (一個多簽輸入就可能要驗 20 次)
+		for _, in := range tx.Inputs {
+			if prev, ok := tmp.Lookup(utxoKey(in.TxID, in.Index)); ok {
+				out := prev.Output()
+				sigOps += out.SigOpCount()
+			}
+		}
+		if sigOps > blockchain.MaxBlockSigOps {
+			return fmt.Errorf("區塊要驗的簽名太多: 超過 %d", blockchain.MaxBlockSigOps)
 		}
 
 		totalFees += tx.Fee(tmp, nil)
@@ -37,15 +83,18 @@ func VerifyBlockWithUTXO(
 		if err := tmp.Spend(tx); err != nil {
 			return fmt.Errorf("區塊內偵測到雙花或資金來源異常: %v", err)
 		}
-		tmp.Add(tx)
+		tmp.Add(tx, block.Height, spendCtx.Time)
 	}
 
 	// 5️⃣ 🕵️ 偵探嚴審：Coinbase 金額校驗
 	coinbaseTx := block.Transactions[0]
 
-	// 🚀 修正點：使用 500 (5.00 YiCoin) 而非 100
-	// 💡 最佳實踐：如果 block 裡面有 Reward 欄位，直接用 block.Reward
-	expectedReward := 500 + totalFees
+	// 💰 區塊宣稱的獎勵必須等於減半規則算出來的值
+	if block.Reward != subsidy {
+		return fmt.Errorf("區塊獎勵不符：預期 %.2f, 區塊宣稱 %.2f",
+			float64(subsidy)/100.0, float64(block.Reward)/100.0)
+	}
+	expectedReward := subsidy + totalFees
 
 	actualReward := 0
 	for _, out := range coinbaseTx.Outputs {
@@ -61,22 +110,33 @@ func VerifyBlockWithUTXO(
 }
 
 // 🕵️ 大偵探修改：第三個參數變成 map[string][]byte
-func VerifyTx(tx blockchain.Transaction, utxoSet *blockchain.UTXOSet, mempoolTxs map[string][]byte) error {
+// spendCtx 是這筆交易要進入的區塊 (高度 / 時間)，給相對時間鎖用
+func VerifyTx(tx blockchain.Transaction, utxoSet *blockchain.UTXOSet, mempoolTxs map[string][]byte, spendCtx blockchain.SpendContext) error {
 	// 1️⃣ coinbase 永远合法
 	if tx.IsCoinbase {
 		return nil
 	}
 
-	// 2️⃣ 直接呼叫 Transaction 內建的驗證，確保密碼學簽名絕對合法！
-	if !tx.Verify() {
-		return errors.New("signature verification failed (tx.Verify returned false)")
+	if err := tx.CheckInputs(); err != nil {
+		return err
+	}
+	if err := tx.CheckOutputs(); err != nil {
+		return err
 	}
 
 	totalIn := 0
+	prevs := make([]blockchain.UTXO, 0, len(tx.Inputs))
+	prevOuts := make([]blockchain.TxOutput, 0, len(tx.Inputs))
+	seen := make(map[string]bool, len(tx.Inputs))
 	for _, in := range tx.Inputs {
 		// 3️⃣ 检查 UTXO 是否存在
 		key := fmt.Sprintf("%s_%d", in.TxID, in.Index)
-		utxo, ok := utxoSet.Set[key]
+		// 🚫 同一個輸出花兩次：帳本裡只有一份，totalIn 卻會算兩次，手續費跟著灌水
+		if seen[key] {
+			return fmt.Errorf("duplicate input: %s", key)
+		}
+		seen[key] = true
+		utxo, ok := utxoSet.Lookup(key)
 
 		// ==========================================
 		// 🕵️ 大偵探的終極 CPFP 邏輯 (反序列化版)！
@@ -99,6 +159,10 @@ func VerifyTx(tx blockchain.Transaction, utxoSet *blockchain.UTXOSet, mempoolTxs
 							Index:  in.Index,
 							Amount: out.Amount,
 							To:     out.To,
+							Script: out.Script,
+							// 還沒上鏈，最快也是跟這筆一起進下一個區塊
+							Height: spendCtx.Height,
+							Time:   spendCtx.Time,
 						}
 						ok = true // 成功在 Mempool 找到了！
 						fmt.Printf("💡 [CPFP] 偵測到連鎖交易！父交易輸入: %s\n", key)
@@ -112,18 +176,24 @@ func VerifyTx(tx blockchain.Transaction, utxoSet *blockchain.UTXOSet, mempoolTxs
 		if !ok {
 			return fmt.Errorf("missing input utxo: %s (已確認帳本和 Mempool 都找不到)", key)
 		}
+		// ⛏️ 礦工獎勵還沒成熟，不能花
+		if !utxo.IsMature(spendCtx) {
+			return fmt.Errorf("immature coinbase spend: %s (高度 %d 挖出，需等 %d 個區塊)",
+				key, utxo.Height, spendCtx.CoinbaseMaturity)
+		}
 		totalIn += utxo.Amount
+		prevs = append(prevs, utxo)
+		prevOuts = append(prevOuts, blockchain.TxOutput{Amount: utxo.Amount, To: utxo.To, Script: utxo.Script})
+	}
 
-		// 4️⃣ 验证公钥是否匹配该 UTXO 的 owner
-		pubBytes, err := hex.DecodeString(in.PubKey)
-		if err != nil {
-			return errors.New("invalid pubkey hex")
-		}
+	// ⏳ 相對時間鎖：被花掉的 UTXO 放得夠不夠久
+	if err := tx.CheckSequenceLocks(prevs, spendCtx); err != nil {
+		return err
+	}
 
-		addr := blockchain.PubKeyToAddress(pubBytes)
-		if addr != utxo.To {
-			return fmt.Errorf("pubkey does not match utxo owner")
-		}
+	// 4️⃣ 📜 執行解鎖腳本 + 鎖定腳本 (P2PKH、多簽、Hash 鎖、時間鎖)
+	if err := tx.Verify(prevOuts); err != nil {
+		return fmt.Errorf("script verification failed: %w", err)
 	}
 
 	// 5️⃣ 检查出账金额
@@ -138,3 +208,15 @@ func VerifyTx(tx blockchain.Transaction, utxoSet *blockchain.UTXOSet, mempoolTxs
 
 	return nil
 }
+
+// MempoolSpendContext mempool 裡的交易預計進入下一個區塊
+func (n *Node) MempoolSpendContext() blockchain.SpendContext {
+	ctx := blockchain.SpendContext{
+		Time:             medianTimePast(n.Best),
+		CoinbaseMaturity: n.Params.CoinbaseMaturity,
+	}
+	if n.Best != nil {
+		ctx.Height = n.Best.Height + 1
+	}
+	return ctx
+}
@@ -3,14 +3,14 @@ package rpc
 import (
 	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"log"
 	"net/http"
-	"strconv"
-	"time"
 
 	"mycoin/blockchain"
 	"mycoin/network"
+	"mycoin/node"
 )
 
 // 启动 RPC 服务
@@ -69,14 +69,85 @@ func (s *RPCServer) handleRPC(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		h := int(height)
+		if height < 0 {
+			s.writeError(w, req.ID, "height out of range")
+			return
+		}
 
-		if h < 0 || h >= len(s.Node.Chain) {
+		bi := s.Node.BlockByHeight(uint64(height))
+		if bi == nil || !bi.HasBody() {
 			s.writeError(w, req.ID, "height out of range")
 			return
 		}
 
-		s.writeResult(w, req.ID, s.Node.Chain[h].Hash)
+		s.writeResult(w, req.ID, bi.Hash)
+
+	case "generate", "generatetoaddress":
+		// 🧪 regtest：同步挖 n 個區塊，回傳 Hash 列表
+		want := 1
+		if req.Method == "generatetoaddress" {
+			want = 2
+		}
+		if len(req.Params) != want {
+			s.writeError(w, req.ID, "usage: generate <n> | generatetoaddress <n> <address>")
+			return
+		}
+
+		count, ok := req.Params[0].(float64)
+		if !ok || count < 1 || count != float64(int(count)) {
+			s.writeError(w, req.ID, "invalid block count")
+			return
+		}
+
+		addr := s.Node.MiningAddress
+		if req.Method == "generatetoaddress" {
+			if addr, ok = req.Params[1].(string); !ok {
+				s.writeError(w, req.ID, "invalid address")
+				return
+			}
+		}
+
+		hashes, err := s.Node.Generate(int(count), addr)
+		if err != nil {
+			s.writeError(w, req.ID, err.Error())
+			return
+		}
+		s.writeResult(w, req.ID, hashes)
+
+	case "gettxoutsetinfo":
+		if s.Node == nil || s.Node.Best == nil {
+			s.writeError(w, req.ID, "node not ready")
+			return
+		}
+
+		// 💰 把整本 UTXO 帳加總，跟減半規則允許的發行量對帳
+		// (礦工少領的獎勵與手續費會永久消失，所以總額只能 <= 發行量)
+		s.Node.Lock()
+		height := s.Node.Best.Height
+		bestHash := s.Node.Best.Hash
+		txids := make(map[string]bool)
+		total := 0
+		txouts := 0
+		s.Node.UTXO.ForEach(func(_ string, u blockchain.UTXO) {
+			txids[u.TxID] = true
+			total += u.Amount
+			txouts++
+		})
+		issued := s.Node.Params.Subsidy.IssuedSupply(height)
+		maxSupply := s.Node.Params.Subsidy.MaxSupply()
+		s.Node.Unlock()
+
+		s.writeResult(w, req.ID, map[string]interface{}{
+			"height":       height,
+			"bestblock":    bestHash,
+			"transactions": len(txids),
+			"txouts":       txouts,
+			"total_amount": float64(total) / 100.0,
+			"issued":       float64(issued) / 100.0,
+			"unclaimed":    float64(issued-total) / 100.0,
+			"max_supply":   float64(maxSupply) / 100.0,
+			"consistent":   total <= issued,
+		})
 
 	case "getblock":
 		if len(req.Params) != 1 {
@@ -92,12 +163,16 @@ func (s *RPCServer) handleRPC(w http.ResponseWriter, r *http.Request) {
 
 		// 1️⃣ 先从 BlockIndex 查
 		bi, ok := s.Node.Blocks[hash]
-		if !ok || bi.Block == nil {
+		if !ok || !bi.HasBody() {
 			s.writeError(w, req.ID, "block not found")
 			return
 		}
 
-		b := bi.Block
+		b, err := s.Node.ReadBlock(bi)
+		if err != nil {
+			s.writeError(w, req.ID, err.Error())
+			return
+		}
 
 		// 2️⃣ 構造 RPC Block (建議增加 Reward 欄位)
 		rpcBlock := RPCBlock{
@@ -125,7 +200,7 @@ func (s *RPCServer) handleRPC(w http.ResponseWriter, r *http.Request) {
 					// 🕵️ 探長提醒：這裡如果 UTXO 沒了，會變 unknown。
 					// 暫時維持現狀，但 UI 上要有心裡準備
 					key := fmt.Sprintf("%s_%d", in.TxID, in.Index)
-					if utxo, ok := s.Node.UTXO.Set[key]; ok {
+					if utxo, ok := s.Node.UTXO.Lookup(key); ok {
 						fromAddr = utxo.To
 					} else {
 						fromAddr = "spent / unknown"
@@ -167,18 +242,14 @@ func (s *RPCServer) handleRPC(w http.ResponseWriter, r *http.Request) {
 		// 1️⃣ 查 mempool
 		txBytes, ok := s.Node.Mempool.Get(txid)
 		if ok {
-			s.writeResult(w, req.ID, string(txBytes))
+			s.writeResult(w, req.ID, hex.EncodeToString(txBytes))
 			return
 		}
 
-		// 2️⃣ 查区块链
-		for _, blk := range s.Node.Chain {
-			for _, tx := range blk.Transactions {
-				if tx.ID == txid {
-					s.writeResult(w, req.ID, string(tx.Serialize()))
-					return
-				}
-			}
+		// 2️⃣ 查区块链 (txindex → 区块档)
+		if tx, _, err := s.Node.GetTransaction(txid); err == nil {
+			s.writeResult(w, req.ID, hex.EncodeToString(tx.Serialize()))
+			return
 		}
 
 		s.writeError(w, req.ID, "tx not found")
@@ -233,6 +304,10 @@ func (s *RPCServer) handleRPC(w http.ResponseWriter, r *http.Request) {
 
 		// 1️⃣ Node查询 tx + block
 		tx, block, err := s.Node.GetTransaction(txid)
+		if errors.Is(err, node.ErrBlockPruned) {
+			s.writeError(w, req.ID, "This transaction is in a pruned block. Please query an archive node.")
+			return
+		}
 		if err != nil {
 			s.writeError(w, req.ID, err.Error())
 			return
@@ -245,11 +320,6 @@ func (s *RPCServer) handleRPC(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		if idx.Pruned {
-			s.writeError(w, req.ID, "This transaction is in a pruned block. Please query an archive node.")
-			return
-		}
-
 		var displayOutputs []TxOutputJSON
 		for _, out := range tx.Outputs {
 			displayOutputs = append(displayOutputs, TxOutputJSON{
@@ -287,71 +357,101 @@ func (s *RPCServer) handleRPC(w http.ResponseWriter, r *http.Request) {
 		// 1. 準備一個空陣列，這很重要！讓 Vue 收到 [] 而不是 null
 		mempoolList := make([]map[string]interface{}, 0)
 
-		// 2. 呼叫你 mempool.go 裡寫好的 GetAll() 方法
-		allTxs := s.Node.Mempool.GetAll()
+		// 2. 拿 Mempool 的快取 (手續費、大小、祖先 / 子孫整包都算好了)
+		entries := s.Node.Mempool.Entries()
 
 		// 3. 遍歷拿到的所有交易
-		// 3. 遍歷拿到的所有交易
-		// 3. 遍歷拿到的所有交易
-		for txid, txBytes := range allTxs {
-			// 🕵️ 探長防彈衣：先從記憶體拿拿看時間
-			enterTime := s.Node.Mempool.Times[txid]
-
-			// ==========================================
-			// 🕵️ 探長的「惰性載入 (Lazy Load)」與自我修復魔法
-			// ==========================================
-			// 如果發現時間是 0 (代表這是剛重啟，記憶體失憶了)，我們就去查資料庫！
-			if enterTime == 0 && s.Node.Mempool.DB != nil {
-				timeBytes := s.Node.Mempool.DB.Get("mempool_times", txid)
-				if len(timeBytes) > 0 {
-					// 情況 A：資料庫裡有紀錄！立刻解碼恢復
-					parsedTime, err := strconv.ParseInt(string(timeBytes), 10, 64)
-					if err == nil {
-						enterTime = parsedTime
-					} else {
-						enterTime = time.Now().Unix() // 防呆機制
-					}
-				} else {
-					// 情況 B：連資料庫都沒有 (這是最古老的那幾筆幽靈交易)
-					// 直接給它現在的時間，並且「永久封裝」進資料庫，以後就不會忘了！
-					enterTime = time.Now().Unix()
-					timeStr := strconv.FormatInt(enterTime, 10)
-					s.Node.Mempool.DB.Put("mempool_times", txid, []byte(timeStr))
-				}
+		for txid, e := range entries {
+			// 🕵️ 進池時間：重啟時 Mempool.Load 已經從資料庫找回來了
+			enterTime := s.Node.Mempool.EnterTime(txid)
 
-				// 🧠 記憶體修復：把找回來的時間寫回記憶體！
-				// 這樣 3 秒後 Vue 再來問的時候，就不用再查資料庫了，速度飛快！
-				s.Node.Mempool.Times[txid] = enterTime
+			displayAmount := 0.0
+			if len(e.Tx.Outputs) > 0 {
+				displayAmount = float64(e.Tx.Outputs[0].Amount) / 100.0
 			}
-			// ==========================================
 
-			// 解析 bytes 回交易物件
-			tx, err := blockchain.DeserializeTransaction(txBytes)
+			// 💸 手續費與費率 (YiCent/vB)，批次付款要跟別人比的是費率；
+			// 有未確認的祖先時，礦工看的是連祖先一起算的整包費率
+			mempoolList = append(mempoolList, map[string]interface{}{
+				"txid":              txid,
+				"amount":            displayAmount,
+				"time":              enterTime, // 👈 現在這個時間絕對不會是 0 了！
+				"fee":               float64(e.Fee) / 100.0,
+				"vsize":             e.Size,
+				"fee_rate":          float64(e.FeeRate()) / 1000.0,
+				"ancestor_count":    e.AncestorCount,
+				"ancestor_fee_rate": float64(e.AncestorFeeRate()) / 1000.0,
+				"descendant_count":  e.DescendantCount,
+			})
+		}
 
-			if err == nil {
-				// 成功解析時的處理
-				displayAmount := 0.0
-				if len(tx.Outputs) > 0 {
-					displayAmount = float64(tx.Outputs[0].Amount) / 100.0
-				}
+		// 4. 回傳精美的 JSON 給 Vue
+		s.writeResult(w, req.ID, mempoolList)
 
-				mempoolList = append(mempoolList, map[string]interface{}{
-					"txid":   txid,
-					"amount": displayAmount,
-					"time":   enterTime, // 👈 現在這個時間絕對不會是 0 了！
-				})
-			} else {
-				// 如果解析失敗，至少把 txid 跟時間傳給前端
-				mempoolList = append(mempoolList, map[string]interface{}{
-					"txid":   txid,
-					"amount": 0.0,
-					"time":   enterTime, // 👈 失敗時也能帶著修復好的時間
-				})
+	case "dumptxoutset":
+		// 📸 [name, blockhash (可省略 = 鏈頭)]；檔案寫在 <datadir>/snapshots/name，已經存在就不寫
+		if len(req.Params) < 1 || len(req.Params) > 2 {
+			s.writeError(w, req.ID, "name required")
+			return
+		}
+		name, ok := req.Params[0].(string)
+		if !ok {
+			s.writeError(w, req.ID, "invalid name")
+			return
+		}
+		path, err := s.Node.SnapshotPath(name)
+		if err != nil {
+			s.writeError(w, req.ID, err.Error())
+			return
+		}
+		blockHash := ""
+		if len(req.Params) == 2 {
+			if blockHash, ok = req.Params[1].(string); !ok {
+				s.writeError(w, req.ID, "invalid block hash")
+				return
 			}
 		}
 
-		// 4. 回傳精美的 JSON 給 Vue
-		s.writeResult(w, req.ID, mempoolList)
+		meta, err := s.Node.DumpUTXOSnapshot(path, blockHash)
+		if err != nil {
+			s.writeError(w, req.ID, err.Error())
+			return
+		}
+		s.writeResult(w, req.ID, map[string]interface{}{
+			"path":      path,
+			"blockhash": meta.BlockHash,
+			"height":    meta.Height,
+			"count":     meta.Count,
+			"utxo_hash": meta.UTXOHash,
+		})
+
+	case "loadtxoutset":
+		// 📸 [name]：讀 <datadir>/snapshots/name；快照的高度與內容雜湊必須釘在網路參數裡
+		if len(req.Params) != 1 {
+			s.writeError(w, req.ID, "name required")
+			return
+		}
+		name, ok := req.Params[0].(string)
+		if !ok {
+			s.writeError(w, req.ID, "invalid name")
+			return
+		}
+		path, err := s.Node.SnapshotPath(name)
+		if err != nil {
+			s.writeError(w, req.ID, err.Error())
+			return
+		}
+
+		meta, err := s.Node.LoadUTXOSnapshot(path)
+		if err != nil {
+			s.writeError(w, req.ID, err.Error())
+			return
+		}
+		s.writeResult(w, req.ID, meta)
+
+	case "getsnapshotinfo":
+		// 沒有從快照啟動 (或背景驗證已經完成) 時回傳 null
+		s.writeResult(w, req.ID, s.Node.SnapshotStatus())
 
 	default:
 		s.writeError(w, req.ID, fmt.Sprintf("unknown method: %s", req.Method))
@@ -3,8 +3,10 @@ package rpcwallet
 import (
 	"encoding/hex"
 	"encoding/json"
+	"fmt"
 	"log"
 	"mycoin/blockchain"
+	"mycoin/mempool"
 	"mycoin/network"
 	"mycoin/node"
 	"mycoin/wallet"
@@ -69,23 +71,36 @@ func (s *RPCServer) handleRPC(w http.ResponseWriter, r *http.Request) {
 	switch req.Method {
 
 	case "estimatefee":
-		// 🕵️ 大偵探的手續費預測雷達
-		baseFee := 1
-		mempoolSize := 0
+		// 🕵️ 大偵探的手續費預測雷達：[vsize (可省略)]
+		// 回傳費率 (YiCent/vB)；給了交易大小就順便換算成這筆交易該付的手續費 (YIC)
+		vsize := 0
+		if len(req.Params) >= 1 {
+			v, ok := req.Params[0].(float64)
+			if !ok || v <= 0 {
+				s.writeError(w, req.ID, "invalid vsize")
+				return
+			}
+			vsize = int(v)
+		}
 
+		mempoolSize := 0
 		// 這裡的 s.Node 是實體 struct，可以直接讀取 Mempool
 		if s.Node != nil && s.Node.Mempool != nil {
-			mempoolSize = len(s.Node.Mempool.Txs)
+			mempoolSize, _ = s.Node.Mempool.Size()
 		}
 
 		// 套用跟礦工一模一樣的「擁堵漲價公式」
-		congestionPremium := (mempoolSize / 5) * 2
-		recommendedFee := baseFee + congestionPremium
-
-		// 回報給前台
-		s.writeResult(w, req.ID, float64(recommendedFee)/100.0)
+		rate := mempool.CongestionFeeRate(mempoolSize)
+		result := map[string]interface{}{
+			"fee_rate": float64(rate) / 1000.0,
+		}
+		if vsize > 0 {
+			result["vsize"] = vsize
+			result["fee"] = float64(rate.FeeFor(vsize)) / 100.0
+		}
+		s.writeResult(w, req.ID, result)
 
-	case "getbalance":
+	case "getbalance", "getimmaturebalance":
 		if len(req.Params) != 1 {
 			s.writeError(w, req.ID, "address required")
 			return
@@ -97,20 +112,11 @@ func (s *RPCServer) handleRPC(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		// 1️⃣ 通过地址索引找到该地址的所有 utxo key
-		keys := s.Node.UTXO.AddrIndex[addr]
-		if keys == nil {
-			s.writeResult(w, req.ID, 0)
-			return
+		// getbalance 只回可以花的餘額 (維持回傳一個數字)；還沒成熟的挖礦獎勵看 getimmaturebalance
+		total, immature := s.balances(addr)
+		if req.Method == "getimmaturebalance" {
+			total = immature
 		}
-
-		// 2️⃣ 累加金额
-		total := 0
-		for _, key := range keys {
-			utxo := s.Node.UTXO.Set[key]
-			total += utxo.Amount
-		}
-
 		s.writeResult(w, req.ID, float64(total)/100.0)
 
 	case "getwallettransaction":
@@ -131,22 +137,17 @@ func (s *RPCServer) handleRPC(w http.ResponseWriter, r *http.Request) {
 
 		// 搜 Mempool
 		for _, txBytes := range s.Node.Mempool.Txs {
-			var mTx blockchain.Transaction
-			if err := json.Unmarshal(txBytes, &mTx); err == nil && mTx.ID == txID {
-				targetTx = &mTx
+			mTx, err := blockchain.DeserializeTransaction(txBytes)
+			if err == nil && mTx.ID == txID {
+				targetTx = mTx
 				break
 			}
 		}
 
 		// 搜 Chain
 		if targetTx == nil {
-			for _, block := range s.Node.Chain {
-				for _, bTx := range block.Transactions {
-					if bTx.ID == txID {
-						targetTx = &bTx
-						break
-					}
-				}
+			if bTx, _, err := s.Node.GetTransaction(txID); err == nil {
+				targetTx = bTx
 			}
 		}
 		s.Node.Unlock()
@@ -174,18 +175,14 @@ func (s *RPCServer) handleRPC(w http.ResponseWriter, r *http.Request) {
 			return
 		}
 
-		keys := s.Node.UTXO.AddrIndex[addr]
-		if keys == nil {
-			s.writeResult(w, req.ID, []RPCUTXO{})
-			return
-		}
+		s.Node.Lock()
+		utxos := s.Node.UTXO.GetUTXOs(addr)
+		s.Node.Unlock()
 
 		// 1️⃣ 将 UTXO 填入列表
-		var list []RPCUTXO
-
-		for _, key := range keys {
-			utxo := s.Node.UTXO.Set[key]
+		list := []RPCUTXO{}
 
+		for _, utxo := range utxos {
 			list = append(list, RPCUTXO{
 				TxID:   utxo.TxID,
 				Index:  utxo.Index,
@@ -228,11 +225,8 @@ func (s *RPCServer) handleRPC(w http.ResponseWriter, r *http.Request) {
 
 		var currentMempoolTxs []blockchain.Transaction
 		for _, txBytes := range s.Node.Mempool.Txs {
-			var tx blockchain.Transaction
-			// 這裡假設你有一個 Deserialize 方法，或者直接用 json.Unmarshal
-			// 如果你的 Transaction 支援 Gob 或 Json，請根據你的專案微調：
-			if err := json.Unmarshal(txBytes, &tx); err == nil {
-				currentMempoolTxs = append(currentMempoolTxs, tx)
+			if tx, err := blockchain.DeserializeTransaction(txBytes); err == nil {
+				currentMempoolTxs = append(currentMempoolTxs, *tx)
 			}
 		}
 
@@ -244,8 +238,14 @@ func (s *RPCServer) handleRPC(w http.ResponseWriter, r *http.Request) {
 			fee,
 			s.Node.UTXO,
 			currentMempoolTxs,
+			s.Node.MempoolSpendContext(),
 		)
 
+		var prevOuts []blockchain.TxOutput
+		if err == nil {
+			prevOuts, err = wallet.PrevOutputs(tx, s.Node.UTXO, currentMempoolTxs)
+		}
+
 		s.Node.Unlock() // 🔓 呼叫公開的 Unlock()
 
 		if err != nil {
@@ -254,7 +254,7 @@ func (s *RPCServer) handleRPC(w http.ResponseWriter, r *http.Request) {
 		}
 
 		// 2️⃣ 签名交易
-		if err := wallet.SignTransaction(tx, s.Wallet); err != nil {
+		if err := wallet.SignTransaction(tx, s.Wallet, prevOuts); err != nil {
 			s.writeError(w, req.ID, "sign tx failed: "+err.Error())
 			return
 		}
@@ -274,6 +274,98 @@ func (s *RPCServer) handleRPC(w http.ResponseWriter, r *http.Request) {
 		// 5️⃣ 返回 txid
 		s.writeResult(w, req.ID, tx.ID)
 
+	case "bumpfee":
+		// 🔁 卡在 Mempool 的交易加付手續費：同樣的輸入重簽一筆，RBF 替換掉原交易 (連同它的子孫)
+		// 參數: <txid> [fee]；沒給 fee 就付替換規則要求的最低手續費
+		if len(req.Params) < 1 {
+			s.writeError(w, req.ID, "usage: bumpfee <txid> [fee]")
+			return
+		}
+		origID, ok := req.Params[0].(string)
+		if !ok {
+			s.writeError(w, req.ID, "invalid txid")
+			return
+		}
+		newFee := 0
+		if len(req.Params) >= 2 {
+			feeFloat, ok := req.Params[1].(float64)
+			if !ok {
+				s.writeError(w, req.ID, "invalid fee")
+				return
+			}
+			newFee = int(feeFloat * 100)
+		}
+
+		s.Node.Lock()
+		origBytes, inPool := s.Node.Mempool.Get(origID)
+		var orig *blockchain.Transaction
+		var prevOuts []blockchain.TxOutput
+		err := mempool.ErrNotInMempool
+		if inPool {
+			orig, err = blockchain.DeserializeTransaction(origBytes)
+		}
+		if err == nil {
+			var currentMempoolTxs []blockchain.Transaction
+			for _, txBytes := range s.Node.Mempool.Txs {
+				if mTx, err := blockchain.DeserializeTransaction(txBytes); err == nil {
+					currentMempoolTxs = append(currentMempoolTxs, *mTx)
+				}
+			}
+			prevOuts, err = wallet.PrevOutputs(orig, s.Node.UTXO, currentMempoolTxs)
+		}
+		s.Node.Unlock()
+
+		if err != nil {
+			s.writeError(w, req.ID, err.Error())
+			return
+		}
+
+		// 只能重簽自己錢包的輸入
+		for _, out := range prevOuts {
+			if out.To != s.Wallet.Address || len(out.Script) != 0 {
+				s.writeError(w, req.ID, "transaction spends inputs this wallet cannot sign")
+				return
+			}
+		}
+
+		fee := newFee
+		if fee == 0 {
+			fee, err = s.Node.Mempool.MinReplacementFee(origID, orig.VSize())
+		}
+		var tx *blockchain.Transaction
+		if err == nil {
+			tx, err = s.signBumpFee(orig, prevOuts, fee)
+		}
+		// 簽完的大小可能差一兩個 byte，沒指定手續費時照簽好的大小再確認一次
+		if err == nil && newFee == 0 {
+			if minFee, _ := s.Node.Mempool.MinReplacementFee(origID, tx.VSize()); minFee > fee {
+				fee = minFee
+				tx, err = s.signBumpFee(orig, prevOuts, fee)
+			}
+		}
+		if err != nil {
+			s.writeError(w, req.ID, err.Error())
+			return
+		}
+
+		if ok := s.Node.AddTx(*tx, s.Node.NodeID); !ok {
+			s.writeError(w, req.ID, "replacement rejected: fee too low or replacement rules not met")
+			return
+		}
+		if s.Handler != nil {
+			s.Handler.BroadcastLocalTx(*tx)
+		}
+
+		totalIn := 0
+		for _, out := range prevOuts {
+			totalIn += out.Amount
+		}
+		s.writeResult(w, req.ID, map[string]interface{}{
+			"txid":    tx.ID,
+			"origfee": float64(totalIn-orig.GetTotalAmount()) / 100.0,
+			"fee":     float64(fee) / 100.0,
+		})
+
 	case "sendcpfpchild":
 		// 🕵️ 大偵探專屬外掛：手動指定要花費的未確認 UTXO！
 		// 參數: <to> <amount> <fee> <parentTxID> <parentIndex>
@@ -307,17 +399,31 @@ func (s *RPCServer) handleRPC(w http.ResponseWriter, r *http.Request) {
 		// 3️⃣ 組裝未簽名交易
 		tx := &blockchain.Transaction{
 			ID:         "",
+			Version:    blockchain.TxVersion,
 			Inputs:     []blockchain.TxInput{in},
 			Outputs:    []blockchain.TxOutput{out},
 			IsCoinbase: false,
 		}
 
-		// 產生 TxID (假設你有 Hash 方法，如果沒有，可以用 tx.SetID() 類似的方法)
-		// 如果這行報錯，請換成 tx.ID = hex.EncodeToString(tx.Hash()) 視你的代碼而定
-		tx.ID = tx.Hash()
+		// 產生 TxID (只看未簽名內容，簽名後也不會變)
+		tx.CalcID()
 
-		// 4️⃣ 簽名交易
-		if err := wallet.SignTransaction(tx, s.Wallet); err != nil {
+		// 4️⃣ 簽名交易 (父交易還在 Mempool，一起拿來查被花掉的輸出)
+		s.Node.Lock()
+		var currentMempoolTxs []blockchain.Transaction
+		for _, txBytes := range s.Node.Mempool.Txs {
+			if mTx, err := blockchain.DeserializeTransaction(txBytes); err == nil {
+				currentMempoolTxs = append(currentMempoolTxs, *mTx)
+			}
+		}
+		prevOuts, err := wallet.PrevOutputs(tx, s.Node.UTXO, currentMempoolTxs)
+		s.Node.Unlock()
+		if err != nil {
+			s.writeError(w, req.ID, err.Error())
+			return
+		}
+
+		if err := wallet.SignTransaction(tx, s.Wallet, prevOuts); err != nil {
 			s.writeError(w, req.ID, "sign tx failed: "+err.Error())
 			return
 		}
@@ -340,6 +446,36 @@ func (s *RPCServer) handleRPC(w http.ResponseWriter, r *http.Request) {
 	}
 }
 
+// signBumpFee 做出加付手續費的替換交易並簽名
+func (s *RPCServer) signBumpFee(orig *blockchain.Transaction, prevOuts []blockchain.TxOutput, fee int) (*blockchain.Transaction, error) {
+	tx, err := wallet.BumpFee(orig, s.Wallet.Address, prevOuts, fee)
+	if err != nil {
+		return nil, err
+	}
+	if err := wallet.SignTransaction(tx, s.Wallet, prevOuts); err != nil {
+		return nil, fmt.Errorf("sign tx failed: %w", err)
+	}
+	return tx, nil
+}
+
+// balances 地址的餘額，分成可以花的與還沒成熟的 Coinbase
+func (s *RPCServer) balances(addr string) (spendable, immature int) {
+	s.Node.Lock()
+	defer s.Node.Unlock()
+	spendCtx := s.Node.MempoolSpendContext()
+
+	// 1️⃣ 通过地址索引找到该地址的所有 utxo
+	// 2️⃣ 累加金额 (還沒成熟的挖礦獎勵另外算，不能花)
+	for _, utxo := range s.Node.UTXO.GetUTXOs(addr) {
+		if utxo.IsMature(spendCtx) {
+			spendable += utxo.Amount
+		} else {
+			immature += utxo.Amount
+		}
+	}
+	return spendable, immature
+}
+
 func (s *RPCServer) writeResult(w http.ResponseWriter, id interface{}, result interface{}) {
 	resp := RPCResponse{Result: result, ID: id}
 	out, _ := json.Marshal(resp)
@@ -386,14 +522,7 @@ func (s *RPCServer) ParseToHumanSummary(tx blockchain.Transaction) TxSummary {
 
 		// 簡單的尋找邏輯 (建議未來可以在 DB 加索引)
 		s.Node.Lock()
-		for _, block := range s.Node.Chain {
-			for _, bTx := range block.Transactions {
-				if bTx.ID == in.TxID {
-					prevTx = &bTx
-					break
-				}
-			}
-		}
+		prevTx, _, _ = s.Node.GetTransaction(in.TxID)
 		s.Node.Unlock()
 
 		if prevTx != nil && in.Index < len(prevTx.Outputs) {
@@ -1,6 +1,7 @@
 package wallet
 
 import (
+	"mycoin/blockchain"
 	"os"
 )
 
@@ -11,10 +12,10 @@ func SaveWallet(path string, w *Wallet) error {
 }
 
 // 加载钱包
-func LoadWallet(path string) (*Wallet, error) {
+func LoadWallet(path string, params *blockchain.Params) (*Wallet, error) {
 	raw, err := os.ReadFile(path)
 	if err != nil {
 		return nil, err
 	}
-	return ImportWIF(string(raw))
+	return ImportWIF(string(raw), params)
 }
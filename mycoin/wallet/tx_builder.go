@@ -7,7 +7,8 @@ import (
 
 // 从 UTXO 里选钱
 // 💡 注意：我們新增了 mempoolTxs 參數來進行比對
-func SelectUTXO(utxo *blockchain.UTXOSet, addr string, amount int, mempoolTxs []blockchain.Transaction) ([]blockchain.UTXO, int) {
+// spendCtx 用來判斷挖礦獎勵成熟了沒 (未成熟的不能選)
+func SelectUTXO(utxo *blockchain.UTXOSet, addr string, amount int, mempoolTxs []blockchain.Transaction, spendCtx blockchain.SpendContext) ([]blockchain.UTXO, int) {
 	var selected []blockchain.UTXO
 	total := 0
 
@@ -21,16 +22,15 @@ func SelectUTXO(utxo *blockchain.UTXOSet, addr string, amount int, mempoolTxs []
 		}
 	}
 
-	keys := utxo.AddrIndex[addr]
-	for _, key := range keys {
+	for _, u := range utxo.GetUTXOs(addr) {
 		// 🕵️ 關鍵過濾：如果這張錢在 Mempool 預訂名單裡，就跳過它！
+		key := u.TxID + "_" + fmt.Sprint(u.Index)
 		if spentInMempool[key] {
 			fmt.Printf("⚠️ [SelectUTXO] 發現鈔票 %s 正在 Mempool 排隊，跳過不使用。\n", key[:8])
 			continue
 		}
 
-		u, ok := utxo.Set[key]
-		if !ok {
+		if !u.IsMature(spendCtx) {
 			continue
 		}
 
@@ -57,6 +57,7 @@ func BuildTransaction(
 	utxoSet *blockchain.UTXOSet,
 	// 🚀 【新增參數】傳入目前 Mempool 中的交易列表，防止重覆選錢
 	mempoolTxs []blockchain.Transaction,
+	spendCtx blockchain.SpendContext,
 ) (*blockchain.Transaction, error) {
 
 	// 計算總共需要的錢 (匯給對方的錢 + 手續費)
@@ -64,7 +65,7 @@ func BuildTransaction(
 
 	// 1️⃣ 选 UTXO
 	// 🚀 【修改點】將 mempoolTxs 傳入 SelectUTXO
-	utxos, total := SelectUTXO(utxoSet, fromAddr, targetAmount, mempoolTxs)
+	utxos, total := SelectUTXO(utxoSet, fromAddr, targetAmount, mempoolTxs, spendCtx)
 
 	if utxos == nil {
 		// 在 wallet/wallet.go 裡
@@ -99,3 +100,57 @@ func BuildTransaction(
 	tx := blockchain.NewTransaction(inputs, outputs)
 	return tx, nil
 }
+
+// BumpFee 用同樣的輸入重做一筆手續費較高的交易 (RBF 替換原交易)
+// 多付的手續費從找零 (付回 fromAddr 的輸出) 扣；找零扣光就拿掉那個輸出
+// prevOuts[i] 是原交易第 i 個輸入花掉的輸出 (用 PrevOutputs 查)
+func BumpFee(orig *blockchain.Transaction, fromAddr string, prevOuts []blockchain.TxOutput, newFee int) (*blockchain.Transaction, error) {
+	totalIn := 0
+	for _, out := range prevOuts {
+		totalIn += out.Amount
+	}
+	oldFee := totalIn - orig.GetTotalAmount()
+	extra := newFee - oldFee
+	if extra <= 0 {
+		return nil, fmt.Errorf("新手續費 %.2f 必須高於原本的 %.2f", float64(newFee)/100.0, float64(oldFee)/100.0)
+	}
+
+	// 找零：最後一個付回自己的一般輸出
+	change := -1
+	for i, out := range orig.Outputs {
+		if out.To == fromAddr && len(out.Script) == 0 {
+			change = i
+		}
+	}
+	if change < 0 {
+		return nil, fmt.Errorf("原交易沒有找零，無法加付手續費")
+	}
+	if orig.Outputs[change].Amount < extra {
+		return nil, fmt.Errorf("找零只有 %.2f YiCoin，不夠加付 %.2f", float64(orig.Outputs[change].Amount)/100.0, float64(extra)/100.0)
+	}
+
+	var inputs []blockchain.TxInput
+	for _, in := range orig.Inputs {
+		inputs = append(inputs, blockchain.TxInput{
+			TxID:     in.TxID,
+			Index:    in.Index,
+			Sequence: in.Sequence,
+		})
+	}
+	var outputs []blockchain.TxOutput
+	for i, out := range orig.Outputs {
+		if i == change {
+			out.Amount -= extra
+			if out.Amount == 0 {
+				continue
+			}
+		}
+		outputs = append(outputs, out)
+	}
+
+	tx := blockchain.NewTransaction(inputs, outputs)
+	tx.Version = orig.Version
+	tx.LockTime = orig.LockTime
+	tx.CalcID()
+	return tx, nil
+}
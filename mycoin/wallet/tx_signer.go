@@ -1,12 +1,40 @@
 package wallet
 
 import (
+	"fmt"
 	"mycoin/blockchain"
 )
 
-func SignTransaction(tx *blockchain.Transaction, w *Wallet) error {
+// prevOuts[i] 是第 i 個輸入花掉的輸出 (簽名會承諾它的金額與鎖定腳本)，可以用 PrevOutputs 查
+func SignTransaction(tx *blockchain.Transaction, w *Wallet, prevOuts []blockchain.TxOutput) error {
 	// 🚀 直接呼叫交易本身內建的 Sign 方法！
-	// 我們剛剛已經在 transaction.go 裡面把公鑰寫入、Hash 防護都做好了，
-	// 這裡直接交給它處理，保證簽名與驗證 100% 同步！
-	return tx.Sign(w.PrivateKey)
+	// 公鑰寫入、SIGHASH_ALL 摘要都在 transaction.go 裡處理，
+	// 這裡直接交給它，保證簽名與驗證 100% 同步！
+	return tx.Sign(w.PrivateKey, prevOuts)
+}
+
+// PrevOutputs 依序找出每個輸入花掉的輸出：先查 UTXO，再查 Mempool 裡還沒確認的父交易 (CPFP)
+func PrevOutputs(tx *blockchain.Transaction, utxo *blockchain.UTXOSet, mempoolTxs []blockchain.Transaction) ([]blockchain.TxOutput, error) {
+	prevOuts := make([]blockchain.TxOutput, 0, len(tx.Inputs))
+
+	for _, in := range tx.Inputs {
+		if out, ok := utxo.Get(in.TxID, in.Index); ok {
+			prevOuts = append(prevOuts, *out)
+			continue
+		}
+
+		found := false
+		for _, parent := range mempoolTxs {
+			if parent.ID == in.TxID && in.Index >= 0 && in.Index < len(parent.Outputs) {
+				prevOuts = append(prevOuts, parent.Outputs[in.Index])
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, fmt.Errorf("找不到輸入 %s_%d 所花費的輸出", in.TxID, in.Index)
+		}
+	}
+
+	return prevOuts, nil
 }
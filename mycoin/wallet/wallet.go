@@ -12,21 +12,23 @@ type Wallet struct {
 	PrivateKey *btcec.PrivateKey
 	PublicKey  []byte
 	Address    string
+	Params     *blockchain.Params // 地址與 WIF 前綴屬於哪個網路
 }
 
-func NewWallet() (*Wallet, error) {
+func NewWallet(params *blockchain.Params) (*Wallet, error) {
 	priv, err := btcec.NewPrivateKey()
 	if err != nil {
 		return nil, err
 	}
 
 	pub := priv.PubKey().SerializeCompressed()
-	addr := blockchain.PubKeyToAddress(pub)
+	addr := params.PubKeyToAddress(pub)
 
 	return &Wallet{
 		PrivateKey: priv,
 		PublicKey:  pub,
 		Address:    addr,
+		Params:     params,
 	}, nil
 }
 
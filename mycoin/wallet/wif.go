@@ -3,17 +3,16 @@ package wallet
 import (
 	"crypto/sha256"
 	"errors"
+	"fmt"
 	"mycoin/blockchain"
 
 	"github.com/btcsuite/btcd/btcec/v2"
 	"github.com/btcsuite/btcutil/base58"
 )
 
-const wifPrefix = byte(0x80) // Bitcoin WIF mainnet
-
-// 导出 WIF
+// 导出 WIF (前綴依錢包所屬網路：主網 0x80，測試網 / regtest 0xef)
 func (w *Wallet) ExportWIF() string {
-	raw := append([]byte{wifPrefix}, w.PrivateKey.Serialize()...)
+	raw := append([]byte{w.Params.PrivateKeyID}, w.PrivateKey.Serialize()...)
 
 	// double SHA256
 	h1 := sha256.Sum256(raw)
@@ -23,23 +22,27 @@ func (w *Wallet) ExportWIF() string {
 	return base58.Encode(full)
 }
 
-// 从 WIF 导入
-func ImportWIF(wif string) (*Wallet, error) {
+// 从 WIF 导入 (別的網路的私鑰直接拒絕，避免主網私鑰被拿去 regtest 用)
+func ImportWIF(wif string, params *blockchain.Params) (*Wallet, error) {
 	raw := base58.Decode(wif)
 	if len(raw) != 1+32+4 {
 		return nil, errors.New("Invalid WIF")
 	}
+	if raw[0] != params.PrivateKeyID {
+		return nil, fmt.Errorf("WIF prefix 0x%02x does not belong to %s", raw[0], params.Name)
+	}
 
 	key := raw[1 : 1+32]
 
 	priv, _ := btcec.PrivKeyFromBytes(key)
 
 	pub := priv.PubKey().SerializeCompressed()
-	addr := blockchain.PubKeyToAddress(pub)
+	addr := params.PubKeyToAddress(pub)
 
 	return &Wallet{
 		PrivateKey: priv,
 		PublicKey:  pub,
 		Address:    addr,
+		Params:     params,
 	}, nil
 }